@@ -1,6 +1,10 @@
 package sasler
 
-import "github.com/xdg-go/stringprep"
+import (
+	"context"
+
+	"github.com/xdg-go/stringprep"
+)
 
 // AnonymousClient returns a ClientMech implementation for the ANONYMOUS
 // mechanism, as specified in [RFC 4505]. Return an
@@ -25,20 +29,52 @@ type AnonymousAuthenticator interface {
 	StoreTrace(trace string)
 }
 
+// AnonymousContextAuthenticator is the context-aware variant of
+// [AnonymousAuthenticator], which is supplied to [AnonymousServerContext]. The
+// context is the one passed to DataContext.
+type AnonymousContextAuthenticator interface {
+	// StoreTraceContext is the context-aware variant of StoreTrace.
+	StoreTraceContext(ctx context.Context, trace string)
+}
+
+// anonymousContextAdapter adapts an AnonymousAuthenticator to an
+// AnonymousContextAuthenticator that ignores the context.
+type anonymousContextAdapter struct {
+	auth AnonymousAuthenticator
+}
+
+func (a anonymousContextAdapter) StoreTraceContext(_ context.Context, trace string) {
+	a.auth.StoreTrace(trace)
+}
+
 // AnonymousServer returns a ServerMech implementation for the ANONYMOUS
 // mechanism, as specified in [RFC 4505].
 //
 // [RFC 4505]: https://tools.ietf.org/html/rfc4505
 func AnonymousServer(authz string, auth AnonymousAuthenticator) ServerMech {
-	cb := func(ir []byte) (string, error) {
+	return AnonymousServerContext(authz, anonymousContextAdapter{auth})
+}
+
+// AnonymousServerContext is like [AnonymousServer], but uses a context-aware
+// authenticator.
+func AnonymousServerContext(authz string, auth AnonymousContextAuthenticator) ServerMech {
+	cb := func(ctx context.Context, ir []byte, result *Result) error {
 		preppedTrace, err := tracePrep.Prepare(string(ir))
 		if err != nil {
-			return "", err
+			e := malformedAttribute("ANONYMOUS", "initial response", "trace")
+			e.Err = err
+			return e
 		}
 		if preppedTrace != "" {
-			auth.StoreTrace(preppedTrace)
+			auth.StoreTraceContext(ctx, preppedTrace)
+			// the trace may not have been stored if ctx was cancelled
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 		}
-		return authz, nil
+		result.Trace = preppedTrace
+		result.Authz = authz
+		return nil
 	}
 	return &singleMessageServer{name: "ANONYMOUS", cb: cb}
 }
@@ -2,6 +2,8 @@ package sasler_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/phedny/sasler"
@@ -29,7 +31,7 @@ func TestAnonymousClient(t *testing.T) {
 	}
 
 	_, err = auth.Data(nil)
-	if err != sasler.ErrInvalidState {
+	if !errors.Is(err, sasler.ErrInvalidState) {
 		t.Fatalf(`Data(nil) returned error: %v; expected ErrInvalidState`, err)
 	}
 }
@@ -53,6 +55,9 @@ func TestAnonymousServer_WithTrace(t *testing.T) {
 	if f.gotTrace != expectedTrace {
 		t.Fatalf(`Data("%s") did not call StoreTrace("%s"); gotTrace = "%s"`, ir, expectedTrace, f.gotTrace)
 	}
+	if result := auth.Result(); result == nil || result.Trace != expectedTrace {
+		t.Fatalf(`Result() returned %+v; expected trace "%s"`, result, expectedTrace)
+	}
 
 	gotCompleted, gotAuthz := auth.HasCompleted()
 	expectedAuthz := "the-authz"
@@ -88,6 +93,28 @@ func TestAnonymousServer_WithoutTrace(t *testing.T) {
 	}
 }
 
+func TestAnonymousServerContext(t *testing.T) {
+	f := FakeAnonymousContextAuthenticator{}
+	auth := sasler.AnonymousServerContext("the-authz", &f)
+
+	ctx := context.WithValue(context.Background(), fakeAnonymousContextKey{}, "C")
+	ir := []byte("user@example.com")
+	gotChallenge, err := auth.DataContext(ctx, ir)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`DataContext("%s") returned (%s, %v); expected (nil, nil)`, ir, gotChallenge, err)
+	}
+	expectedTrace := "user@example.comC"
+	if f.gotTrace != expectedTrace {
+		t.Fatalf(`DataContext("%s") did not call StoreTraceContext with suffix; gotTrace = "%s", expected "%s"`, ir, f.gotTrace, expectedTrace)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "the-authz"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
 type FakeAnonymousAuthenticator struct {
 	gotTrace string
 }
@@ -95,3 +122,16 @@ type FakeAnonymousAuthenticator struct {
 func (f *FakeAnonymousAuthenticator) StoreTrace(trace string) {
 	f.gotTrace = trace
 }
+
+// fakeAnonymousContextKey is the context key of the value that
+// FakeAnonymousContextAuthenticator appends to the trace.
+type fakeAnonymousContextKey struct{}
+
+type FakeAnonymousContextAuthenticator struct {
+	gotTrace string
+}
+
+func (f *FakeAnonymousContextAuthenticator) StoreTraceContext(ctx context.Context, trace string) {
+	suffix, _ := ctx.Value(fakeAnonymousContextKey{}).(string)
+	f.gotTrace = trace + suffix
+}
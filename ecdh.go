@@ -0,0 +1,341 @@
+package sasler
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+)
+
+// ecdhChallengeSize is the size of the salt and the challenge of the
+// ECDH-X25519-CHALLENGE mechanism, equal to the size of an X25519 public key.
+const ecdhChallengeSize = 32
+
+// ecdhClientMech is an implementation of the ECDH-X25519-CHALLENGE mechanism.
+type ecdhClientMech struct {
+	authz     string
+	authn     string
+	key       *ecdh.PrivateKey
+	completed bool
+	aborted   bool
+}
+
+// EcdhX25519ChallengeClient returns a ClientMech implementation for the
+// ECDH-X25519-CHALLENGE mechanism, as specified by [Atheme]. Returns
+// ErrWrongCurve if the private key doesn't use the curve returned by
+// ecdh.X25519().
+//
+// [Atheme]: https://github.com/atheme/atheme/blob/master/doc/SASL-ECDH-X25519-CHALLENGE
+func EcdhX25519ChallengeClient(authz, authn string, key *ecdh.PrivateKey) (ClientMech, error) {
+	if key.Curve() != ecdh.X25519() {
+		return nil, ErrWrongCurve
+	}
+	return &ecdhClientMech{authz: authz, authn: authn, key: key}, nil
+}
+
+// Mech returns name ECDH-X25519-CHALLENGE, and true for client-first.
+func (*ecdhClientMech) Mech() (string, bool) {
+	return "ECDH-X25519-CHALLENGE", true
+}
+
+// Data returns authcid, followed by authzid if present, on the first call; and
+// returns the decrypted challenge on the second call.
+func (m *ecdhClientMech) Data(challenge []byte) ([]byte, error) {
+	switch {
+	case m.aborted:
+		return nil, ErrAborted
+	case m.authn != "":
+		if len(challenge) > 0 {
+			m.authz = ""
+			m.authn = ""
+			m.key = nil
+			return nil, ErrInvalidMessage
+		}
+		var ir bytes.Buffer
+		ir.Write([]byte(m.authn))
+		m.authn = ""
+		if m.authz != "" {
+			ir.WriteByte(0)
+			ir.Write([]byte(m.authz))
+			m.authz = ""
+		}
+		return ir.Bytes(), nil
+	case m.key != nil:
+		key := m.key
+		m.key = nil
+		if len(challenge) != 3*ecdhChallengeSize {
+			return nil, ErrInvalidMessage
+		}
+		serverKey, err := ecdh.X25519().NewPublicKey(challenge[:ecdhChallengeSize])
+		if err != nil {
+			return nil, ErrInvalidMessage
+		}
+		salt := challenge[ecdhChallengeSize : 2*ecdhChallengeSize]
+		sessionKey, err := ecdhSessionKey(key, serverKey, key.PublicKey(), serverKey, salt)
+		if err != nil {
+			return nil, ErrInvalidMessage
+		}
+		response := make([]byte, ecdhChallengeSize)
+		subtle.XORBytes(response, challenge[2*ecdhChallengeSize:], sessionKey)
+		m.completed = true
+		return response, nil
+	}
+	return nil, ErrInvalidState
+}
+
+// Success accepts the success indication once the decrypted challenge has been
+// sent, and returns ErrInvalidState otherwise.
+func (m *ecdhClientMech) Success(data []byte) error {
+	if m.aborted {
+		return ErrAborted
+	}
+	return clientSuccess(data, m.Data, &m.completed)
+}
+
+// Abort drops the private key.
+func (m *ecdhClientMech) Abort() {
+	m.authz = ""
+	m.authn = ""
+	m.key = nil
+	m.completed = false
+	m.aborted = true
+}
+
+// EcdhAuthenticator is supplied to [EcdhX25519ChallengeServer] to implement
+// retrieving the public key for an authn, authz derivation, and authorization
+// checking.
+type EcdhAuthenticator interface {
+	// GetPublicKey returns the public key for an authn, or an error if the
+	// public key could not be retrieved.
+	GetPublicKey(authn string) (*ecdh.PublicKey, error)
+	// DeriveAuthz derives an authz from an authn. It is only called when no
+	// authz has been requested by the client. Return the empty string if no
+	// authz can be derived from the supplied authn.
+	DeriveAuthz(authn string) string
+	// Authorize verifies whether an authn is authorized to use the requested or
+	// derived authz. Return false to fail authorization.
+	Authorize(authz, authn string) bool
+}
+
+// EcdhContextAuthenticator is the context-aware variant of
+// [EcdhAuthenticator], which is supplied to [EcdhX25519ChallengeServerContext].
+// The context is the one passed to DataContext.
+type EcdhContextAuthenticator interface {
+	// GetPublicKeyContext is the context-aware variant of GetPublicKey.
+	GetPublicKeyContext(ctx context.Context, authn string) (*ecdh.PublicKey, error)
+	// DeriveAuthzContext is the context-aware variant of DeriveAuthz.
+	DeriveAuthzContext(ctx context.Context, authn string) string
+	// AuthorizeContext is the context-aware variant of Authorize.
+	AuthorizeContext(ctx context.Context, authz, authn string) bool
+}
+
+// ecdhContextAdapter adapts an EcdhAuthenticator to an
+// EcdhContextAuthenticator that ignores the context.
+type ecdhContextAdapter struct {
+	auth EcdhAuthenticator
+}
+
+func (a ecdhContextAdapter) GetPublicKeyContext(_ context.Context, authn string) (*ecdh.PublicKey, error) {
+	return a.auth.GetPublicKey(authn)
+}
+
+func (a ecdhContextAdapter) DeriveAuthzContext(_ context.Context, authn string) string {
+	return a.auth.DeriveAuthz(authn)
+}
+
+func (a ecdhContextAdapter) AuthorizeContext(_ context.Context, authz, authn string) bool {
+	return a.auth.Authorize(authz, authn)
+}
+
+// ecdhServerMech is an implementation of the ECDH-X25519-CHALLENGE mechanism.
+type ecdhServerMech struct {
+	authz     string
+	authn     string
+	challenge []byte
+	key       *ecdh.PublicKey
+	completed bool
+	succeeded bool
+	aborted   bool
+	auth      EcdhContextAuthenticator
+}
+
+// EcdhX25519ChallengeServer returns a ServerMech implementation for the
+// ECDH-X25519-CHALLENGE mechanism, as specified by [Atheme].
+//
+// [Atheme]: https://github.com/atheme/atheme/blob/master/doc/SASL-ECDH-X25519-CHALLENGE
+func EcdhX25519ChallengeServer(auth EcdhAuthenticator) ServerMech {
+	return EcdhX25519ChallengeServerContext(ecdhContextAdapter{auth})
+}
+
+// EcdhX25519ChallengeServerContext is like [EcdhX25519ChallengeServer], but
+// uses a context-aware authenticator.
+func EcdhX25519ChallengeServerContext(auth EcdhContextAuthenticator) ServerMech {
+	return &ecdhServerMech{auth: auth}
+}
+
+// Mech returns name ECDH-X25519-CHALLENGE, and true for client-first.
+func (*ecdhServerMech) Mech() (string, bool) {
+	return "ECDH-X25519-CHALLENGE", true
+}
+
+// Data stores an authn and optional authz on first call and returns the
+// ephemeral public key of the server, a salt and an encrypted challenge, and
+// on second call verifies the response.
+func (m *ecdhServerMech) Data(data []byte) ([]byte, error) {
+	return m.DataContext(context.Background(), data)
+}
+
+// DataContext is like Data, but passes ctx on to the authenticator.
+func (m *ecdhServerMech) DataContext(ctx context.Context, data []byte) ([]byte, error) {
+	return serverDataContext(ctx, m, m.step, data)
+}
+
+// step performs the step of the authentication process that matches the state
+// of the mechanism.
+func (m *ecdhServerMech) step(ctx context.Context, data []byte) ([]byte, error) {
+	switch {
+	case m.aborted:
+		return nil, ErrAborted
+	case m.completed:
+		return nil, ErrInvalidState
+	case m.authn == "":
+		m.completed = true
+		delim := bytes.IndexByte(data, 0)
+		if delim == -1 {
+			m.authn = string(data)
+		} else {
+			m.authn = string(data[:delim])
+			m.authz = string(data[delim+1:])
+		}
+		if m.authn == "" {
+			return nil, malformedAttribute("ECDH-X25519-CHALLENGE", "initial response", "authcid")
+		}
+		clientKey, err := m.auth.GetPublicKeyContext(ctx, m.authn)
+		if err != nil {
+			return nil, wrapError("ECDH-X25519-CHALLENGE", "initial response", ErrAuthenticationFailed, ReasonUnknownUser, err)
+		}
+		if clientKey == nil {
+			return nil, newError("ECDH-X25519-CHALLENGE", "initial response", ErrAuthenticationFailed, ReasonBadCredentials)
+		}
+		if clientKey.Curve() != ecdh.X25519() {
+			return nil, wrapError("ECDH-X25519-CHALLENGE", "initial response", ErrAuthenticationFailed, ReasonBadCredentials, ErrWrongCurve)
+		}
+		m.key = clientKey
+		serverKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		challenge := make([]byte, 3*ecdhChallengeSize)
+		copy(challenge, serverKey.PublicKey().Bytes())
+		salt := challenge[ecdhChallengeSize : 2*ecdhChallengeSize]
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		m.challenge = make([]byte, ecdhChallengeSize)
+		if _, err := rand.Read(m.challenge); err != nil {
+			return nil, err
+		}
+		sessionKey, err := ecdhSessionKey(serverKey, clientKey, clientKey, serverKey.PublicKey(), salt)
+		if err != nil {
+			m.challenge = nil
+			return nil, wrapError("ECDH-X25519-CHALLENGE", "initial response", ErrAuthenticationFailed, ReasonBadCredentials, err)
+		}
+		subtle.XORBytes(challenge[2*ecdhChallengeSize:], m.challenge, sessionKey)
+		m.completed = false
+		return challenge, nil
+	case m.challenge != nil:
+		m.completed = true
+		challenge := m.challenge
+		m.challenge = nil
+		if subtle.ConstantTimeCompare(challenge, data) != 1 {
+			return nil, newError("ECDH-X25519-CHALLENGE", "response", ErrAuthenticationFailed, ReasonBadProof)
+		}
+		if m.authz == "" {
+			m.authz = m.auth.DeriveAuthzContext(ctx, m.authn)
+			if m.authz == "" {
+				return nil, newError("ECDH-X25519-CHALLENGE", "response", ErrAuthenticationFailed, ReasonNoAuthz)
+			}
+		}
+		if !m.auth.AuthorizeContext(ctx, m.authz, m.authn) {
+			m.authz = ""
+			return nil, newError("ECDH-X25519-CHALLENGE", "response", ErrUnauthorized, ReasonUnauthorized)
+		}
+		m.succeeded = true
+		return nil, nil
+	}
+	return nil, ErrInvalidState
+}
+
+// HasCompleted returns true if authentication has completed, and if true, it
+// also returns the authorized authz, if any.
+func (m *ecdhServerMech) HasCompleted() (bool, string) {
+	switch {
+	case m.aborted:
+		return true, ""
+	case !m.completed:
+		return false, ""
+	case !m.succeeded:
+		return true, ""
+	}
+	return true, m.authz
+}
+
+// Result returns the authn, and the public key of the client if authentication
+// has succeeded.
+func (m *ecdhServerMech) Result() *Result {
+	completed, authz := m.HasCompleted()
+	if !completed {
+		return nil
+	}
+	result := &Result{Mech: "ECDH-X25519-CHALLENGE", Succeeded: m.succeeded, Aborted: m.aborted, Authn: m.authn, Authz: authz}
+	if m.succeeded {
+		result.PublicKey = m.key
+	}
+	return result
+}
+
+// Abort zeroes the challenge, if authentication is still in progress.
+func (m *ecdhServerMech) Abort() {
+	if completed, _ := m.HasCompleted(); completed {
+		return
+	}
+	zeroBytes(m.challenge)
+	m.challenge = nil
+	m.authz = ""
+	m.aborted = true
+}
+
+// ecdhSessionKey performs the X25519 key exchange between the private key and
+// the peer public key, and derives the session key from the shared secret
+// using HKDF-SHA-256, with the public keys of client and server as info.
+func ecdhSessionKey(key *ecdh.PrivateKey, peer, clientKey, serverKey *ecdh.PublicKey, salt []byte) ([]byte, error) {
+	secret, err := key.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	info := append(clientKey.Bytes(), serverKey.Bytes()...)
+	return hkdfSha256(secret, salt, info, ecdhChallengeSize), nil
+}
+
+// hkdfSha256 implements the HKDF extract-and-expand key derivation function, as
+// specified in [RFC 5869], using HMAC-SHA-256.
+//
+// [RFC 5869]: https://tools.ietf.org/html/rfc5869
+func hkdfSha256(secret, salt, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	var out, t []byte
+	for i := byte(1); len(out) < length; i++ {
+		expand.Reset()
+		expand.Write(t)
+		expand.Write(info)
+		expand.Write([]byte{i})
+		t = expand.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:length]
+}
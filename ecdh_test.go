@@ -0,0 +1,358 @@
+package sasler_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/phedny/sasler"
+)
+
+func TestEcdhClient(t *testing.T) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(rand.Reader) returned error: %v`, err)
+	}
+
+	client, err := sasler.EcdhX25519ChallengeClient("RequestedAuthz", "user", privateKey)
+	if err != nil {
+		t.Fatalf(`EcdhX25519ChallengeClient("RequestedAuthz", "user", privateKey) returned error: %v`, err)
+	}
+
+	gotName, gotClientFirst := client.Mech()
+	expectedName := "ECDH-X25519-CHALLENGE"
+	if gotName != expectedName || !gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", true)`, gotName, gotClientFirst, expectedName)
+	}
+
+	gotIR, err := client.Data(nil)
+	expectedIR := []byte("user\x00RequestedAuthz")
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.Equal(gotIR, expectedIR) {
+		t.Fatalf(`Data(nil) returned %q; expected %q`, gotIR, expectedIR)
+	}
+
+	server := sasler.EcdhX25519ChallengeServer(&fakeEcdhAuthenticator{key: privateKey.PublicKey()})
+	challenge, err := server.Data(gotIR)
+	if err != nil {
+		t.Fatalf(`Data(%q) returned error: %v`, gotIR, err)
+	}
+	if len(challenge) != 96 {
+		t.Fatalf(`Data(%q) returned %d bytes; expected 96`, gotIR, len(challenge))
+	}
+
+	gotResponse, err := client.Data(challenge)
+	if err != nil {
+		t.Fatalf(`Data(challenge) returned error: %v`, err)
+	}
+	if len(gotResponse) != 32 {
+		t.Fatalf(`Data(challenge) returned %d bytes; expected 32`, len(gotResponse))
+	}
+
+	gotChallenge, err := server.Data(gotResponse)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data(response) returned (%q, %v); expected (nil, nil)`, gotChallenge, err)
+	}
+
+	_, err = client.Data(nil)
+	if !errors.Is(err, sasler.ErrInvalidState) {
+		t.Fatalf(`Data returned error: %v; expected ErrInvalidState`, err)
+	}
+}
+
+func TestEcdhClient_WrongCurve(t *testing.T) {
+	privateKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(rand.Reader) returned error: %v`, err)
+	}
+
+	_, err = sasler.EcdhX25519ChallengeClient("", "user", privateKey)
+	if !errors.Is(err, sasler.ErrWrongCurve) {
+		t.Fatalf(`EcdhX25519ChallengeClient("", "user", privateKey) returned error: %v; expected ErrWrongCurve`, err)
+	}
+}
+
+func TestEcdhClient_InvalidChallenge(t *testing.T) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(rand.Reader) returned error: %v`, err)
+	}
+
+	client, err := sasler.EcdhX25519ChallengeClient("", "user", privateKey)
+	if err != nil {
+		t.Fatalf(`EcdhX25519ChallengeClient("", "user", privateKey) returned error: %v`, err)
+	}
+	if _, err := client.Data(nil); err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+
+	challenge := make([]byte, 64)
+	gotResponse, err := client.Data(challenge)
+	if gotResponse != nil || !errors.Is(err, sasler.ErrInvalidMessage) {
+		t.Fatalf(`Data(challenge) returned (%q, %v); expected (nil, ErrInvalidMessage)`, gotResponse, err)
+	}
+}
+
+func TestEcdhServer_DeriveAuthz(t *testing.T) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(rand.Reader) returned error: %v`, err)
+	}
+
+	auth := sasler.EcdhX25519ChallengeServer(&fakeEcdhAuthenticator{key: privateKey.PublicKey()})
+
+	gotName, gotClientFirst := auth.Mech()
+	expectedName := "ECDH-X25519-CHALLENGE"
+	if gotName != expectedName || !gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", true)`, gotName, gotClientFirst, expectedName)
+	}
+
+	ir := []byte("user")
+	challenge, err := auth.Data(ir)
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	gotCompleted, _ := auth.HasCompleted()
+	if gotCompleted {
+		t.Fatalf(`HasCompleted() returned true; expected false`)
+	}
+
+	response := ecdhResponse(t, privateKey, challenge)
+	gotChallenge, err := auth.Data(response)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data(response) returned (%q, %v); expected (nil, nil)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestEcdhServer_RequestedAuthz(t *testing.T) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(rand.Reader) returned error: %v`, err)
+	}
+
+	auth := sasler.EcdhX25519ChallengeServer(&fakeEcdhAuthenticator{key: privateKey.PublicKey()})
+
+	ir := []byte("user\x00RequestedAuthz")
+	challenge, err := auth.Data(ir)
+	if err != nil {
+		t.Fatalf(`Data(%q) returned error: %v`, ir, err)
+	}
+
+	response := ecdhResponse(t, privateKey, challenge)
+	gotChallenge, err := auth.Data(response)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data(response) returned (%q, %v); expected (nil, nil)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "RequestedAuthz"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestEcdhServer_Unauthorized(t *testing.T) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(rand.Reader) returned error: %v`, err)
+	}
+
+	auth := sasler.EcdhX25519ChallengeServer(&fakeEcdhAuthenticator{key: privateKey.PublicKey()})
+
+	ir := []byte("user\x00InvalidAuthz")
+	challenge, err := auth.Data(ir)
+	if err != nil {
+		t.Fatalf(`Data(%q) returned error: %v`, ir, err)
+	}
+
+	response := ecdhResponse(t, privateKey, challenge)
+	gotChallenge, err := auth.Data(response)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrUnauthorized) {
+		t.Fatalf(`Data(response) returned (%q, %v); expected (nil, ErrUnauthorized)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestEcdhServer_InvalidResponse(t *testing.T) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(rand.Reader) returned error: %v`, err)
+	}
+	otherKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(rand.Reader) returned error: %v`, err)
+	}
+
+	auth := sasler.EcdhX25519ChallengeServer(&fakeEcdhAuthenticator{key: privateKey.PublicKey()})
+
+	ir := []byte("user")
+	challenge, err := auth.Data(ir)
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	response := ecdhResponse(t, otherKey, challenge)
+	gotChallenge, err := auth.Data(response)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Data(response) returned (%q, %v); expected (nil, ErrAuthenticationFailed)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestEcdhServer_WrongCurve(t *testing.T) {
+	privateKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(rand.Reader) returned error: %v`, err)
+	}
+
+	auth := sasler.EcdhX25519ChallengeServer(&fakeEcdhAuthenticator{key: privateKey.PublicKey()})
+
+	ir := []byte("user")
+	gotChallenge, err := auth.Data(ir)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrWrongCurve) {
+		t.Fatalf(`Data("%s") returned (%q, %v); expected (nil, ErrWrongCurve)`, ir, gotChallenge, err)
+	}
+}
+
+func TestEcdhServer_NilKey(t *testing.T) {
+	auth := sasler.EcdhX25519ChallengeServer(&fakeEcdhAuthenticator{})
+
+	ir := []byte("user")
+	gotChallenge, err := auth.Data(ir)
+	var authErr *sasler.Error
+	if gotChallenge != nil || !errors.As(err, &authErr) || !errors.Is(err, sasler.ErrAuthenticationFailed) || authErr.Reason != sasler.ReasonBadCredentials {
+		t.Fatalf(`Data("%s") returned (%q, %v); expected (nil, ErrAuthenticationFailed) with ReasonBadCredentials`, ir, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	if !gotCompleted || gotAuthz != "" {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "")`, gotCompleted, gotAuthz)
+	}
+}
+
+func TestEcdhServer_EmptyAuthcid(t *testing.T) {
+	auth := sasler.EcdhX25519ChallengeServer(&fakeEcdhAuthenticator{})
+
+	ir := []byte("\x00RequestedAuthz")
+	gotChallenge, err := auth.Data(ir)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrInvalidMessage) {
+		t.Fatalf(`Data(%q) returned (%q, %v); expected (nil, ErrInvalidMessage)`, ir, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+
+	_, err = auth.Data([]byte("user"))
+	if !errors.Is(err, sasler.ErrInvalidState) {
+		t.Fatalf(`Data("user") returned error: %v; expected ErrInvalidState`, err)
+	}
+}
+
+func TestEcdhServerContext(t *testing.T) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(rand.Reader) returned error: %v`, err)
+	}
+
+	auth := sasler.EcdhX25519ChallengeServerContext(&fakeEcdhContextAuthenticator{key: privateKey.PublicKey()})
+
+	ctx := context.WithValue(context.Background(), fakeEcdhContextKey{}, "C")
+	ir := []byte("user")
+	challenge, err := auth.DataContext(ctx, ir)
+	if err != nil {
+		t.Fatalf(`DataContext("%s") returned error: %v`, ir, err)
+	}
+
+	response := ecdhResponse(t, privateKey, challenge)
+	gotChallenge, err := auth.DataContext(ctx, response)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`DataContext(response) returned (%q, %v); expected (nil, nil)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "userC"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+// ecdhResponse uses a client with the supplied private key to compute the
+// response to a challenge.
+func ecdhResponse(t *testing.T, key *ecdh.PrivateKey, challenge []byte) []byte {
+	t.Helper()
+	client, err := sasler.EcdhX25519ChallengeClient("", "user", key)
+	if err != nil {
+		t.Fatalf(`EcdhX25519ChallengeClient() returned error: %v`, err)
+	}
+	if _, err := client.Data(nil); err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	response, err := client.Data(challenge)
+	if err != nil {
+		t.Fatalf(`Data(challenge) returned error: %v`, err)
+	}
+	return response
+}
+
+type fakeEcdhAuthenticator struct {
+	key *ecdh.PublicKey
+}
+
+func (f *fakeEcdhAuthenticator) GetPublicKey(authn string) (*ecdh.PublicKey, error) {
+	return f.key, nil
+}
+
+func (*fakeEcdhAuthenticator) DeriveAuthz(authn string) string {
+	return authn + "Z"
+}
+
+func (*fakeEcdhAuthenticator) Authorize(authz, authn string) bool {
+	return authz == authn+"Z" || authz == "RequestedAuthz"
+}
+
+// fakeEcdhContextKey is the context key of the value that
+// fakeEcdhContextAuthenticator uses as authz suffix.
+type fakeEcdhContextKey struct{}
+
+type fakeEcdhContextAuthenticator struct {
+	key *ecdh.PublicKey
+}
+
+func (f *fakeEcdhContextAuthenticator) GetPublicKeyContext(ctx context.Context, authn string) (*ecdh.PublicKey, error) {
+	return f.key, nil
+}
+
+func (*fakeEcdhContextAuthenticator) DeriveAuthzContext(ctx context.Context, authn string) string {
+	suffix, _ := ctx.Value(fakeEcdhContextKey{}).(string)
+	return authn + suffix
+}
+
+func (*fakeEcdhContextAuthenticator) AuthorizeContext(ctx context.Context, authz, authn string) bool {
+	suffix, _ := ctx.Value(fakeEcdhContextKey{}).(string)
+	return authn+suffix == authz
+}
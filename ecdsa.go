@@ -2,9 +2,12 @@ package sasler
 
 import (
 	"bytes"
+	"context"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/x509"
 	"errors"
 )
 
@@ -15,9 +18,11 @@ var ErrWrongCurve = errors.New("sasler: wrong curve")
 // ecdsaClientMech is an implementation of the ECDSA-NIST256P-CHALLENGE
 // mechanisms.
 type ecdsaClientMech struct {
-	authz string
-	authn string
-	key   *ecdsa.PrivateKey
+	authz     string
+	authn     string
+	key       *ecdsa.PrivateKey
+	completed bool
+	aborted   bool
 }
 
 // EcdsaNist256pChallengeClient returns a SaslMech implementation for the
@@ -27,10 +32,10 @@ type ecdsaClientMech struct {
 //
 // [ecdsatool]: https://github.com/kaniini/ecdsatool#mechanism-spec
 func EcdsaNist256pChallengeClient(authz, authn string, key *ecdsa.PrivateKey) (ClientMech, error) {
-	if key.Curve != elliptic.P256() {
-		return nil, ErrWrongCurve
+	if _, err := ecdsaP256PublicKey(key.Public()); err != nil {
+		return nil, err
 	}
-	return &ecdsaClientMech{authz, authn, key}, nil
+	return &ecdsaClientMech{authz: authz, authn: authn, key: key}, nil
 }
 
 // Mech returns name ECDSA-NITS256P-CHALLENGE, and true for client-first.
@@ -42,6 +47,8 @@ func (*ecdsaClientMech) Mech() (string, bool) {
 // and returns a signature for the provided challenge on the second call.
 func (m *ecdsaClientMech) Data(challenge []byte) ([]byte, error) {
 	switch {
+	case m.aborted:
+		return nil, ErrAborted
 	case m.authn != "":
 		if len(challenge) > 0 {
 			m.authn = ""
@@ -61,11 +68,30 @@ func (m *ecdsaClientMech) Data(challenge []byte) ([]byte, error) {
 	case m.key != nil:
 		sig, err := ecdsa.SignASN1(rand.Reader, m.key, challenge)
 		m.key = nil
+		m.completed = err == nil
 		return sig, err
 	}
 	return nil, ErrInvalidState
 }
 
+// Success accepts the success indication once the signature has been sent, and
+// returns ErrInvalidState otherwise.
+func (m *ecdsaClientMech) Success(data []byte) error {
+	if m.aborted {
+		return ErrAborted
+	}
+	return clientSuccess(data, m.Data, &m.completed)
+}
+
+// Abort drops the private key.
+func (m *ecdsaClientMech) Abort() {
+	m.authz = ""
+	m.authn = ""
+	m.key = nil
+	m.completed = false
+	m.aborted = true
+}
+
 // EcdsaAuthenticator is supplied to [EcdsaNist256pChallengeServer] to
 // implement retrieving the public key for an authn, authz derivation, and
 // authorization checking.
@@ -82,14 +108,127 @@ type EcdsaAuthenticator interface {
 	Authorize(authz, authn string) bool
 }
 
+// EcdsaMultiKeyAuthenticator is supplied to
+// [EcdsaNist256pChallengeMultiKeyServer] to implement retrieving the public
+// keys for an authn, reporting the key that verified the signature, authz
+// derivation, and authorization checking.
+type EcdsaMultiKeyAuthenticator interface {
+	// GetPublicKeys returns all public keys registered for an authn, or an
+	// error if the public keys could not be retrieved.
+	GetPublicKeys(authn string) ([]*ecdsa.PublicKey, error)
+	// KeyMatched is called with the public key that verified the signature of
+	// the client, before authz derivation and authorization checking. It can be
+	// used to track the use of individual keys.
+	KeyMatched(authn string, key *ecdsa.PublicKey)
+	// DeriveAuthz derives an authz from an authn. It is only called when no
+	// authz has been requested by the client. Return the empty string if no
+	// authz can be derived from the supplied authn.
+	DeriveAuthz(authn string) string
+	// Authorize verifies whether an authn is authorized to use the requested or
+	// derived authz. Return false to fail authorization.
+	Authorize(authz, authn string) bool
+}
+
+// singleKeyEcdsaAuthenticator adapts an EcdsaAuthenticator to an
+// EcdsaMultiKeyAuthenticator.
+type singleKeyEcdsaAuthenticator struct {
+	EcdsaAuthenticator
+}
+
+// GetPublicKeys returns the public key returned by GetPublicKey.
+func (a singleKeyEcdsaAuthenticator) GetPublicKeys(authn string) ([]*ecdsa.PublicKey, error) {
+	key, err := a.GetPublicKey(authn)
+	if err != nil {
+		return nil, err
+	}
+	return []*ecdsa.PublicKey{key}, nil
+}
+
+// KeyMatched does nothing.
+func (singleKeyEcdsaAuthenticator) KeyMatched(string, *ecdsa.PublicKey) {}
+
+// EcdsaContextAuthenticator is the context-aware variant of
+// [EcdsaAuthenticator], which is supplied to
+// [EcdsaNist256pChallengeServerContext]. The context is the one passed to
+// DataContext.
+type EcdsaContextAuthenticator interface {
+	// GetPublicKeyContext is the context-aware variant of GetPublicKey.
+	GetPublicKeyContext(ctx context.Context, authn string) (*ecdsa.PublicKey, error)
+	// DeriveAuthzContext is the context-aware variant of DeriveAuthz.
+	DeriveAuthzContext(ctx context.Context, authn string) string
+	// AuthorizeContext is the context-aware variant of Authorize.
+	AuthorizeContext(ctx context.Context, authz, authn string) bool
+}
+
+// EcdsaMultiKeyContextAuthenticator is the context-aware variant of
+// [EcdsaMultiKeyAuthenticator], which is supplied to
+// [EcdsaNist256pChallengeMultiKeyServerContext]. The context is the one
+// passed to DataContext.
+type EcdsaMultiKeyContextAuthenticator interface {
+	// GetPublicKeysContext is the context-aware variant of GetPublicKeys.
+	GetPublicKeysContext(ctx context.Context, authn string) ([]*ecdsa.PublicKey, error)
+	// KeyMatchedContext is the context-aware variant of KeyMatched.
+	KeyMatchedContext(ctx context.Context, authn string, key *ecdsa.PublicKey)
+	// DeriveAuthzContext is the context-aware variant of DeriveAuthz.
+	DeriveAuthzContext(ctx context.Context, authn string) string
+	// AuthorizeContext is the context-aware variant of Authorize.
+	AuthorizeContext(ctx context.Context, authz, authn string) bool
+}
+
+// singleKeyEcdsaContextAuthenticator adapts an EcdsaContextAuthenticator to an
+// EcdsaMultiKeyContextAuthenticator.
+type singleKeyEcdsaContextAuthenticator struct {
+	EcdsaContextAuthenticator
+}
+
+// GetPublicKeysContext returns the public key returned by
+// GetPublicKeyContext.
+func (a singleKeyEcdsaContextAuthenticator) GetPublicKeysContext(ctx context.Context, authn string) ([]*ecdsa.PublicKey, error) {
+	key, err := a.GetPublicKeyContext(ctx, authn)
+	if err != nil {
+		return nil, err
+	}
+	return []*ecdsa.PublicKey{key}, nil
+}
+
+// KeyMatchedContext does nothing.
+func (singleKeyEcdsaContextAuthenticator) KeyMatchedContext(context.Context, string, *ecdsa.PublicKey) {
+}
+
+// ecdsaContextAdapter adapts an EcdsaMultiKeyAuthenticator to an
+// EcdsaMultiKeyContextAuthenticator that ignores the context.
+type ecdsaContextAdapter struct {
+	auth EcdsaMultiKeyAuthenticator
+}
+
+func (a ecdsaContextAdapter) GetPublicKeysContext(_ context.Context, authn string) ([]*ecdsa.PublicKey, error) {
+	return a.auth.GetPublicKeys(authn)
+}
+
+func (a ecdsaContextAdapter) KeyMatchedContext(_ context.Context, authn string, key *ecdsa.PublicKey) {
+	a.auth.KeyMatched(authn, key)
+}
+
+func (a ecdsaContextAdapter) DeriveAuthzContext(_ context.Context, authn string) string {
+	return a.auth.DeriveAuthz(authn)
+}
+
+func (a ecdsaContextAdapter) AuthorizeContext(_ context.Context, authz, authn string) bool {
+	return a.auth.Authorize(authz, authn)
+}
+
 // ecdsaServerMech is an implementation of the ECDSA-NIST256P-CHALLENGE
 // mechanisms.
 type ecdsaServerMech struct {
 	authz     string
 	authn     string
 	challenge []byte
-	key       *ecdsa.PublicKey
-	auth      EcdsaAuthenticator
+	keys      []*ecdsa.PublicKey
+	matched   *ecdsa.PublicKey
+	completed bool
+	succeeded bool
+	aborted   bool
+	auth      EcdsaMultiKeyContextAuthenticator
 }
 
 // EcdsaNist256pChallengeServer returns a SaslMech implementation for the
@@ -97,6 +236,28 @@ type ecdsaServerMech struct {
 //
 // [ecdsatool]: https://github.com/kaniini/ecdsatool#mechanism-spec
 func EcdsaNist256pChallengeServer(auth EcdsaAuthenticator) ServerMech {
+	return EcdsaNist256pChallengeMultiKeyServer(singleKeyEcdsaAuthenticator{auth})
+}
+
+// EcdsaNist256pChallengeServerContext is like [EcdsaNist256pChallengeServer],
+// but uses a context-aware authenticator.
+func EcdsaNist256pChallengeServerContext(auth EcdsaContextAuthenticator) ServerMech {
+	return EcdsaNist256pChallengeMultiKeyServerContext(singleKeyEcdsaContextAuthenticator{auth})
+}
+
+// EcdsaNist256pChallengeMultiKeyServer returns a SaslMech implementation for
+// the ECDSA-NIST256P-CHALLENGE mechanism, as specified in [ecdsatool], that
+// accepts a signature by any of the public keys registered for an authn.
+//
+// [ecdsatool]: https://github.com/kaniini/ecdsatool#mechanism-spec
+func EcdsaNist256pChallengeMultiKeyServer(auth EcdsaMultiKeyAuthenticator) ServerMech {
+	return EcdsaNist256pChallengeMultiKeyServerContext(ecdsaContextAdapter{auth})
+}
+
+// EcdsaNist256pChallengeMultiKeyServerContext is like
+// [EcdsaNist256pChallengeMultiKeyServer], but uses a context-aware
+// authenticator.
+func EcdsaNist256pChallengeMultiKeyServerContext(auth EcdsaMultiKeyContextAuthenticator) ServerMech {
 	return &ecdsaServerMech{auth: auth}
 }
 
@@ -108,8 +269,24 @@ func (*ecdsaServerMech) Mech() (string, bool) {
 // Data stores an authn and optional authz on first call and returns a
 // challenge, and on second call verifies the response.
 func (m *ecdsaServerMech) Data(data []byte) ([]byte, error) {
+	return m.DataContext(context.Background(), data)
+}
+
+// DataContext is like Data, but passes ctx on to the authenticator.
+func (m *ecdsaServerMech) DataContext(ctx context.Context, data []byte) ([]byte, error) {
+	return serverDataContext(ctx, m, m.step, data)
+}
+
+// step performs the step of the authentication process that matches the state
+// of the mechanism.
+func (m *ecdsaServerMech) step(ctx context.Context, data []byte) ([]byte, error) {
 	switch {
+	case m.aborted:
+		return nil, ErrAborted
+	case m.completed:
+		return nil, ErrInvalidState
 	case m.authn == "":
+		m.completed = true
 		delim := bytes.IndexByte(data, 0)
 		if delim == -1 {
 			m.authn = string(data)
@@ -117,35 +294,55 @@ func (m *ecdsaServerMech) Data(data []byte) ([]byte, error) {
 			m.authz = string(data[:delim])
 			m.authn = string(data[delim+1:])
 		}
-		publicKey, err := m.auth.GetPublicKey(m.authn)
-		if err != nil {
-			return nil, ErrAuthenticationFailed
+		if m.authn == "" {
+			return nil, malformedAttribute("ECDSA-NIST256P-CHALLENGE", "initial response", "authcid")
 		}
-		if publicKey.Curve != elliptic.P256() {
-			return nil, ErrWrongCurve
+		publicKeys, err := m.auth.GetPublicKeysContext(ctx, m.authn)
+		if err != nil || len(publicKeys) == 0 {
+			return nil, wrapError("ECDSA-NIST256P-CHALLENGE", "initial response", ErrAuthenticationFailed, ReasonUnknownUser, err)
+		}
+		for _, publicKey := range publicKeys {
+			if publicKey == nil {
+				return nil, newError("ECDSA-NIST256P-CHALLENGE", "initial response", ErrAuthenticationFailed, ReasonBadCredentials)
+			}
+			if _, err := ecdsaP256PublicKey(publicKey); err != nil {
+				return nil, wrapError("ECDSA-NIST256P-CHALLENGE", "initial response", ErrAuthenticationFailed, ReasonBadCredentials, err)
+			}
 		}
-		m.key = publicKey
+		m.keys = publicKeys
 		m.challenge = make([]byte, 30)
 		if _, err := rand.Read(m.challenge); err != nil {
 			return nil, err
 		}
+		m.completed = false
 		return m.challenge, nil
-	case m.key != nil:
-		key := m.key
-		m.key = nil
-		if !ecdsa.VerifyASN1(key, m.challenge, data) {
-			return nil, ErrAuthenticationFailed
+	case m.keys != nil:
+		m.completed = true
+		keys := m.keys
+		m.keys = nil
+		var matched *ecdsa.PublicKey
+		for _, key := range keys {
+			if ecdsa.VerifyASN1(key, m.challenge, data) {
+				matched = key
+				break
+			}
 		}
+		if matched == nil {
+			return nil, newError("ECDSA-NIST256P-CHALLENGE", "response", ErrAuthenticationFailed, ReasonBadProof)
+		}
+		m.matched = matched
+		m.auth.KeyMatchedContext(ctx, m.authn, matched)
 		if m.authz == "" {
-			m.authz = m.auth.DeriveAuthz(m.authn)
+			m.authz = m.auth.DeriveAuthzContext(ctx, m.authn)
 			if m.authz == "" {
-				return nil, ErrAuthenticationFailed
+				return nil, newError("ECDSA-NIST256P-CHALLENGE", "response", ErrAuthenticationFailed, ReasonNoAuthz)
 			}
 		}
-		if !m.auth.Authorize(m.authz, m.authn) {
+		if !m.auth.AuthorizeContext(ctx, m.authz, m.authn) {
 			m.authz = ""
-			return nil, ErrUnauthorized
+			return nil, newError("ECDSA-NIST256P-CHALLENGE", "response", ErrUnauthorized, ReasonUnauthorized)
 		}
+		m.succeeded = true
 		return nil, nil
 	}
 	return nil, ErrInvalidState
@@ -154,8 +351,118 @@ func (m *ecdsaServerMech) Data(data []byte) ([]byte, error) {
 // HasCompleted returns true if authentication has completed, and if true, it
 // also returns the authorized authz, if any.
 func (m *ecdsaServerMech) HasCompleted() (bool, string) {
-	if m.authn == "" || m.key != nil {
+	switch {
+	case m.aborted:
+		return true, ""
+	case !m.completed:
 		return false, ""
+	case !m.succeeded:
+		return true, ""
 	}
 	return true, m.authz
 }
+
+// Result returns the authn, and the public key that matched the signature of
+// the client if authentication has succeeded.
+func (m *ecdsaServerMech) Result() *Result {
+	completed, authz := m.HasCompleted()
+	if !completed {
+		return nil
+	}
+	result := &Result{Mech: "ECDSA-NIST256P-CHALLENGE", Succeeded: m.succeeded, Aborted: m.aborted, Authn: m.authn, Authz: authz}
+	if m.succeeded {
+		result.PublicKey = m.matched
+	}
+	return result
+}
+
+// Abort drops the challenge and the public keys, if authentication is still in
+// progress.
+func (m *ecdsaServerMech) Abort() {
+	if completed, _ := m.HasCompleted(); completed {
+		return
+	}
+	m.challenge = nil
+	m.keys = nil
+	m.authz = ""
+	m.aborted = true
+}
+
+// ecdsaP256PublicKey returns the supplied public key as ECDSA public key.
+// Returns ErrWrongCurve if it's nil or not an ECDSA public key, or if it doesn't
+// use the curve returned by elliptic.P256().
+func ecdsaP256PublicKey(key crypto.PublicKey) (*ecdsa.PublicKey, error) {
+	publicKey, ok := key.(*ecdsa.PublicKey)
+	if !ok || publicKey == nil || publicKey.Curve != elliptic.P256() {
+		return nil, ErrWrongCurve
+	}
+	return publicKey, nil
+}
+
+// exportState returns the identities, the challenge and the public keys of
+// the authn, once the challenge has been sent.
+func (m *ecdsaServerMech) exportState() ([]byte, error) {
+	if completed, _ := m.HasCompleted(); completed {
+		return nil, ErrInvalidState
+	}
+	if m.keys == nil {
+		return []byte{statePhaseInitial}, nil
+	}
+	state := []byte{statePhaseInProgress}
+	state = appendStateField(state, []byte(m.authz))
+	state = appendStateField(state, []byte(m.authn))
+	state = appendStateField(state, m.challenge)
+	for _, key := range m.keys {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return nil, err
+		}
+		state = appendStateField(state, der)
+	}
+	return state, nil
+}
+
+// importState restores a state returned by exportState, after which the
+// mechanism expects the signed challenge.
+func (m *ecdsaServerMech) importState(state []byte) error {
+	if m.authn != "" || m.keys != nil || m.completed || m.aborted {
+		return ErrInvalidState
+	}
+	if len(state) == 0 {
+		return ErrInvalidSealedState
+	}
+	switch state[0] {
+	case statePhaseInitial:
+		if len(state) > 1 {
+			return ErrInvalidSealedState
+		}
+		return nil
+	case statePhaseInProgress:
+	default:
+		return ErrInvalidSealedState
+	}
+	d := stateDecoder{state: state[1:]}
+	authz := d.field()
+	authn := d.field()
+	challenge := d.field()
+	var keys []*ecdsa.PublicKey
+	for d.more() {
+		key, err := x509.ParsePKIXPublicKey(d.field())
+		if err != nil {
+			return ErrInvalidSealedState
+		}
+		publicKey, err := ecdsaP256PublicKey(key)
+		if err != nil {
+			return ErrInvalidSealedState
+		}
+		keys = append(keys, publicKey)
+	}
+	if d.err != nil || len(keys) == 0 {
+		return ErrInvalidSealedState
+	}
+	m.authz = string(authz)
+	m.authn = string(authn)
+	m.challenge = challenge
+	m.keys = keys
+	return nil
+}
@@ -26,19 +26,18 @@ func ExampleEcdsaAuthenticator() {
 			fmt.Println(err)
 			return
 		}
-		// Relay data from mech to client
-		if data != nil {
-			conn.Write(data)
-		}
 		// Test if the conversation has completed and show the authz
 		completed, authz := mech.HasCompleted()
 		if completed {
 			// Success() represents a function that uses a protocol-specific way to
-			// signal to the client that authentication has succeeded.
-			conn.Success()
+			// signal to the client that authentication has succeeded, along with
+			// the additional data returned by mech, if any.
+			conn.Success(data)
 			fmt.Println("Authorized identity:", authz)
 			return
 		}
+		// Relay data from mech to client
+		conn.Write(data)
 	}
 
 	// Output: Authorized identity: user
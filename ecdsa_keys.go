@@ -0,0 +1,416 @@
+package sasler
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidKey is returned when a key can't be parsed, or is encoded in a
+// format that is not supported.
+var ErrInvalidKey = errors.New("sasler: invalid key")
+
+// EcdsaKeyFormat is an encoding of ECDSA keys.
+type EcdsaKeyFormat int
+
+const (
+	// EcdsaKeyBase64 is the base64 encoded compressed point that is used for
+	// public keys by ecdsatool. Private keys can't be encoded in this format.
+	EcdsaKeyBase64 EcdsaKeyFormat = iota
+	// EcdsaKeyPEM is a PEM block that contains a PKIX public key, or a SEC 1
+	// private key.
+	EcdsaKeyPEM
+	// EcdsaKeyOpenSSH is the OpenSSH authorized_keys format for public keys,
+	// and the unencrypted OpenSSH format for private keys.
+	EcdsaKeyOpenSSH
+)
+
+// OpenSSH names of the ecdsa-sha2-nistp256 key type.
+const (
+	opensshEcdsaKeyType = "ecdsa-sha2-nistp256"
+	opensshEcdsaCurve   = "nistp256"
+	opensshKeyMagic     = "openssh-key-v1\x00"
+)
+
+// GenerateEcdsaKey returns a new private key for use with the
+// ECDSA-NIST256P-CHALLENGE mechanism.
+func GenerateEcdsaKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// ParseEcdsaPublicKey parses a public key that is encoded in any of the
+// formats of EcdsaKeyFormat. Returns ErrWrongCurve if the key doesn't use the
+// curve returned by elliptic.P256().
+func ParseEcdsaPublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	data = bytes.TrimSpace(data)
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "PUBLIC KEY" {
+			return nil, ErrInvalidKey
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		return ecdsaP256PublicKey(key)
+	}
+	if fields := strings.Fields(string(data)); len(fields) >= 2 && strings.HasPrefix(fields[0], "ecdsa-sha2-") {
+		blob, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		key, rest, err := parseOpensshPublicKey(blob)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) > 0 {
+			return nil, ErrInvalidKey
+		}
+		return key, nil
+	}
+	point, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	return unmarshalEcdsaPoint(point)
+}
+
+// MarshalEcdsaPublicKey encodes a public key in the requested format. Returns
+// ErrWrongCurve if the key doesn't use the curve returned by elliptic.P256().
+func MarshalEcdsaPublicKey(key *ecdsa.PublicKey, format EcdsaKeyFormat) ([]byte, error) {
+	if _, err := ecdsaP256PublicKey(key); err != nil {
+		return nil, err
+	}
+	switch format {
+	case EcdsaKeyBase64:
+		point := elliptic.MarshalCompressed(key.Curve, key.X, key.Y)
+		return []byte(base64.StdEncoding.EncodeToString(point)), nil
+	case EcdsaKeyPEM:
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+	case EcdsaKeyOpenSSH:
+		blob := marshalOpensshPublicKey(key)
+		return []byte(opensshEcdsaKeyType + " " + base64.StdEncoding.EncodeToString(blob)), nil
+	}
+	return nil, ErrInvalidKey
+}
+
+// ParseEcdsaPrivateKey parses a private key that is encoded as PEM block, which
+// contains a SEC 1 or PKCS #8 private key, or in the unencrypted OpenSSH
+// format. Returns ErrWrongCurve if the key doesn't use the curve returned by
+// elliptic.P256().
+func ParseEcdsaPrivateKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidKey
+	}
+	var key *ecdsa.PrivateKey
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		k, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		key = k
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		ecdsaKey, ok := k.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, ErrWrongCurve
+		}
+		key = ecdsaKey
+	case "OPENSSH PRIVATE KEY":
+		k, err := parseOpensshPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = k
+	default:
+		return nil, ErrInvalidKey
+	}
+	if _, err := ecdsaP256PublicKey(key.Public()); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// MarshalEcdsaPrivateKey encodes a private key in the requested format, which
+// must be EcdsaKeyPEM or EcdsaKeyOpenSSH. Returns ErrWrongCurve if the key
+// doesn't use the curve returned by elliptic.P256().
+func MarshalEcdsaPrivateKey(key *ecdsa.PrivateKey, format EcdsaKeyFormat) ([]byte, error) {
+	if _, err := ecdsaP256PublicKey(key.Public()); err != nil {
+		return nil, err
+	}
+	switch format {
+	case EcdsaKeyPEM:
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	case EcdsaKeyOpenSSH:
+		der, err := marshalOpensshPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: der}), nil
+	}
+	return nil, ErrInvalidKey
+}
+
+// unmarshalEcdsaPoint parses a compressed or uncompressed P-256 point.
+func unmarshalEcdsaPoint(point []byte) (*ecdsa.PublicKey, error) {
+	curve := elliptic.P256()
+	var x, y *big.Int
+	if len(point) > 0 && point[0] == 4 {
+		x, y = elliptic.Unmarshal(curve, point)
+	} else {
+		x, y = elliptic.UnmarshalCompressed(curve, point)
+	}
+	if x == nil {
+		return nil, ErrInvalidKey
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// readSSHString reads a string, as described in [RFC 4251, section 5].
+//
+// [RFC 4251, section 5]: https://tools.ietf.org/html/rfc4251#section-5
+func readSSHString(b []byte) ([]byte, []byte, error) {
+	if len(b) < 4 {
+		return nil, nil, ErrInvalidKey
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(n) > uint64(len(b)-4) {
+		return nil, nil, ErrInvalidKey
+	}
+	return b[4 : 4+n], b[4+n:], nil
+}
+
+// appendSSHString appends s as string, as described in [RFC 4251, section 5].
+//
+// [RFC 4251, section 5]: https://tools.ietf.org/html/rfc4251#section-5
+func appendSSHString(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// parseOpensshPublicKey parses the key type, curve name and point of an
+// ecdsa-sha2-nistp256 public key, as described in [RFC 5656, section 3.1].
+//
+// [RFC 5656, section 3.1]: https://tools.ietf.org/html/rfc5656#section-3.1
+func parseOpensshPublicKey(b []byte) (*ecdsa.PublicKey, []byte, error) {
+	keyType, b, err := readSSHString(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	curve, b, err := readSSHString(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if string(keyType) != opensshEcdsaKeyType || string(curve) != opensshEcdsaCurve {
+		return nil, nil, ErrWrongCurve
+	}
+	point, b, err := readSSHString(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := unmarshalEcdsaPoint(point)
+	return key, b, err
+}
+
+// marshalOpensshPublicKey returns the ecdsa-sha2-nistp256 public key, as
+// described in [RFC 5656, section 3.1].
+//
+// [RFC 5656, section 3.1]: https://tools.ietf.org/html/rfc5656#section-3.1
+func marshalOpensshPublicKey(key *ecdsa.PublicKey) []byte {
+	b := appendSSHString(nil, []byte(opensshEcdsaKeyType))
+	b = appendSSHString(b, []byte(opensshEcdsaCurve))
+	return appendSSHString(b, elliptic.Marshal(key.Curve, key.X, key.Y))
+}
+
+// parseOpensshPrivateKey parses an unencrypted private key in the
+// openssh-key-v1 format.
+func parseOpensshPrivateKey(b []byte) (*ecdsa.PrivateKey, error) {
+	if !bytes.HasPrefix(b, []byte(opensshKeyMagic)) {
+		return nil, ErrInvalidKey
+	}
+	b = b[len(opensshKeyMagic):]
+	var fields [3][]byte
+	for i := range fields {
+		field, rest, err := readSSHString(b)
+		if err != nil {
+			return nil, err
+		}
+		fields[i], b = field, rest
+	}
+	if string(fields[0]) != "none" || string(fields[1]) != "none" {
+		return nil, ErrInvalidKey
+	}
+	if len(b) < 4 || binary.BigEndian.Uint32(b) != 1 {
+		return nil, ErrInvalidKey
+	}
+	_, b, err := readSSHString(b[4:])
+	if err != nil {
+		return nil, err
+	}
+	private, _, err := readSSHString(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(private) < 8 || binary.BigEndian.Uint32(private) != binary.BigEndian.Uint32(private[4:]) {
+		return nil, ErrInvalidKey
+	}
+	publicKey, private, err := parseOpensshPublicKey(private[8:])
+	if err != nil {
+		return nil, err
+	}
+	d, _, err := readSSHString(private)
+	if err != nil {
+		return nil, err
+	}
+	key := &ecdsa.PrivateKey{PublicKey: *publicKey, D: new(big.Int).SetBytes(d)}
+	x, y := key.Curve.ScalarBaseMult(key.D.Bytes())
+	if x.Cmp(key.X) != 0 || y.Cmp(key.Y) != 0 {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// marshalOpensshPrivateKey returns the private key in the unencrypted
+// openssh-key-v1 format.
+func marshalOpensshPrivateKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	check := make([]byte, 4)
+	if _, err := rand.Read(check); err != nil {
+		return nil, err
+	}
+	private := append(check, check...)
+	private = append(private, marshalOpensshPublicKey(&key.PublicKey)...)
+	d := key.D.Bytes()
+	if len(d) > 0 && d[0]&0x80 != 0 {
+		d = append([]byte{0}, d...)
+	}
+	private = appendSSHString(private, d)
+	private = appendSSHString(private, nil)
+	for i := byte(1); len(private)%8 != 0; i++ {
+		private = append(private, i)
+	}
+
+	b := []byte(opensshKeyMagic)
+	b = appendSSHString(b, []byte("none"))
+	b = appendSSHString(b, []byte("none"))
+	b = appendSSHString(b, nil)
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = appendSSHString(b, marshalOpensshPublicKey(&key.PublicKey))
+	return appendSSHString(b, private), nil
+}
+
+// EcdsaFileAuthenticator is an EcdsaAuthenticator and
+// EcdsaMultiKeyAuthenticator that reads the public keys of users from a file.
+// Each line of the file contains an authn, followed by whitespace and a public
+// key in any of the formats of EcdsaKeyFormat, except EcdsaKeyPEM. An authn
+// can have multiple public keys on separate lines, which are all accepted by
+// [EcdsaNist256pChallengeMultiKeyServer]. [EcdsaNist256pChallengeServer] only
+// accepts the first public key of an authn, as returned by GetPublicKey. Empty
+// lines and lines that start with # are ignored. Each authn is authorized for
+// its own authz only.
+type EcdsaFileAuthenticator struct {
+	path string
+	mu   sync.RWMutex
+	keys map[string][]*ecdsa.PublicKey
+}
+
+// NewEcdsaFileAuthenticator returns an EcdsaFileAuthenticator that reads the
+// public keys from the file at path. Returns an error if the file can't be
+// read or contains an invalid line.
+func NewEcdsaFileAuthenticator(path string) (*EcdsaFileAuthenticator, error) {
+	a := &EcdsaFileAuthenticator{path: path}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the public keys from the file again. If an error is returned,
+// the previously read public keys are retained.
+func (a *EcdsaFileAuthenticator) Reload() error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	keys := make(map[string][]*ecdsa.PublicKey)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		delim := strings.IndexAny(line, " \t")
+		if delim == -1 {
+			return ErrInvalidKey
+		}
+		key, err := ParseEcdsaPublicKey([]byte(line[delim+1:]))
+		if err != nil {
+			return err
+		}
+		keys[line[:delim]] = append(keys[line[:delim]], key)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.keys = keys
+	a.mu.Unlock()
+	return nil
+}
+
+// GetPublicKey returns the first public key of an authn. Any further public
+// keys are only returned by GetPublicKeys.
+func (a *EcdsaFileAuthenticator) GetPublicKey(authn string) (*ecdsa.PublicKey, error) {
+	keys, err := a.GetPublicKeys(authn)
+	if err != nil {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+// GetPublicKeys returns all public keys of an authn.
+func (a *EcdsaFileAuthenticator) GetPublicKeys(authn string) ([]*ecdsa.PublicKey, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	keys := a.keys[authn]
+	if len(keys) == 0 {
+		return nil, ErrAuthenticationFailed
+	}
+	return keys, nil
+}
+
+// KeyMatched does nothing.
+func (a *EcdsaFileAuthenticator) KeyMatched(authn string, key *ecdsa.PublicKey) {}
+
+// DeriveAuthz returns authn.
+func (a *EcdsaFileAuthenticator) DeriveAuthz(authn string) string {
+	return authn
+}
+
+// Authorize verifies whether authz equals authn.
+func (a *EcdsaFileAuthenticator) Authorize(authz, authn string) bool {
+	return authz == authn
+}
@@ -2,9 +2,11 @@ package sasler_test
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"errors"
 	"testing"
 
 	"github.com/phedny/sasler"
@@ -145,7 +147,7 @@ func TestEcdsaServer_Unauthorized(t *testing.T) {
 		t.Fatalf(`SignASN1() returned error: %v`, err)
 	}
 	gotChallenge, err := auth.Data(sig)
-	if gotChallenge != nil || err != sasler.ErrUnauthorized {
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrUnauthorized) {
 		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, ErrUnauthorized)`, ir, gotChallenge, err)
 	}
 
@@ -182,7 +184,7 @@ func TestEcdsaServer_InvalidSignature(t *testing.T) {
 	}
 	sig[0] += 1
 	gotChallenge, err := auth.Data(sig)
-	if gotChallenge != nil || err != sasler.ErrAuthenticationFailed {
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrAuthenticationFailed) {
 		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, ErrAuthenticationFailed)`, ir, gotChallenge, err)
 	}
 
@@ -193,6 +195,276 @@ func TestEcdsaServer_InvalidSignature(t *testing.T) {
 	}
 }
 
+func TestEcdsaServer_EmptyAuthcid(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(elliptic.P256(), rand.Reader) returned error: %v`, err)
+	}
+
+	auth := sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{key: &privateKey.PublicKey})
+
+	ir := []byte("RequestedAuthz\x00")
+	gotChallenge, err := auth.Data(ir)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrInvalidMessage) {
+		t.Fatalf(`Data(%q) returned (%q, %v); expected (nil, ErrInvalidMessage)`, ir, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+
+	_, err = auth.Data([]byte("user"))
+	if !errors.Is(err, sasler.ErrInvalidState) {
+		t.Fatalf(`Data("user") returned error: %v; expected ErrInvalidState`, err)
+	}
+}
+
+func TestEcdsaMultiKeyServer(t *testing.T) {
+	laptopKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(elliptic.P256(), rand.Reader) returned error: %v`, err)
+	}
+	phoneKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(elliptic.P256(), rand.Reader) returned error: %v`, err)
+	}
+
+	fake := &fakeEcdsaMultiKeyAuthenticator{keys: []*ecdsa.PublicKey{&laptopKey.PublicKey, &phoneKey.PublicKey}}
+	auth := sasler.EcdsaNist256pChallengeMultiKeyServer(fake)
+
+	ir := []byte("user")
+	challenge, err := auth.Data(ir)
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	sig, err := ecdsa.SignASN1(rand.Reader, phoneKey, challenge)
+	if err != nil {
+		t.Fatalf(`SignASN1() returned error: %v`, err)
+	}
+	gotChallenge, err := auth.Data(sig)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data(sig) returned ("%s", %v); expected (nil, nil)`, gotChallenge, err)
+	}
+	if fake.matched != &phoneKey.PublicKey {
+		t.Fatalf(`KeyMatched() was called with %v; expected phone key`, fake.matched)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+
+	result := auth.Result()
+	if result == nil || !result.Succeeded || result.Authn != "user" || result.Authz != expectedAuthz || result.PublicKey != &phoneKey.PublicKey {
+		t.Fatalf(`Result() returned %+v; expected authn "user", authz "%s" and phone key`, result, expectedAuthz)
+	}
+}
+
+func TestEcdsaMultiKeyServer_UnknownKey(t *testing.T) {
+	registeredKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(elliptic.P256(), rand.Reader) returned error: %v`, err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(elliptic.P256(), rand.Reader) returned error: %v`, err)
+	}
+
+	fake := &fakeEcdsaMultiKeyAuthenticator{keys: []*ecdsa.PublicKey{&registeredKey.PublicKey}}
+	auth := sasler.EcdsaNist256pChallengeMultiKeyServer(fake)
+
+	ir := []byte("user")
+	challenge, err := auth.Data(ir)
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	sig, err := ecdsa.SignASN1(rand.Reader, otherKey, challenge)
+	if err != nil {
+		t.Fatalf(`SignASN1() returned error: %v`, err)
+	}
+	if result := auth.Result(); result != nil {
+		t.Fatalf(`Result() returned %+v; expected nil`, result)
+	}
+	gotChallenge, err := auth.Data(sig)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Data(sig) returned ("%s", %v); expected (nil, ErrAuthenticationFailed)`, gotChallenge, err)
+	}
+	if fake.matched != nil {
+		t.Fatalf(`KeyMatched() was called with %v; expected no call`, fake.matched)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+
+	result := auth.Result()
+	if result == nil || result.Succeeded || result.Authn != "user" || result.PublicKey != nil {
+		t.Fatalf(`Result() returned %+v; expected failed result for authn "user"`, result)
+	}
+}
+
+func TestEcdsaMultiKeyServer_NilKey(t *testing.T) {
+	registeredKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(elliptic.P256(), rand.Reader) returned error: %v`, err)
+	}
+
+	fake := &fakeEcdsaMultiKeyAuthenticator{keys: []*ecdsa.PublicKey{&registeredKey.PublicKey, nil}}
+	auth := sasler.EcdsaNist256pChallengeMultiKeyServer(fake)
+
+	ir := []byte("user")
+	gotChallenge, err := auth.Data(ir)
+	var authErr *sasler.Error
+	if gotChallenge != nil || !errors.As(err, &authErr) || !errors.Is(err, sasler.ErrAuthenticationFailed) || authErr.Reason != sasler.ReasonBadCredentials {
+		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, ErrAuthenticationFailed) with ReasonBadCredentials`, ir, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	if !gotCompleted || gotAuthz != "" {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "")`, gotCompleted, gotAuthz)
+	}
+}
+
+func TestEcdsaMultiKeyServer_WrongCurve(t *testing.T) {
+	wrongCurveKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(elliptic.P384(), rand.Reader) returned error: %v`, err)
+	}
+
+	fake := &fakeEcdsaMultiKeyAuthenticator{keys: []*ecdsa.PublicKey{&wrongCurveKey.PublicKey}}
+	auth := sasler.EcdsaNist256pChallengeMultiKeyServer(fake)
+
+	ir := []byte("user")
+	gotChallenge, err := auth.Data(ir)
+	var authErr *sasler.Error
+	if gotChallenge != nil || !errors.As(err, &authErr) || !errors.Is(err, sasler.ErrAuthenticationFailed) || !errors.Is(err, sasler.ErrWrongCurve) {
+		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, ErrAuthenticationFailed) wrapping ErrWrongCurve`, ir, gotChallenge, err)
+	}
+	if authErr.Mech != "ECDSA-NIST256P-CHALLENGE" || authErr.Step != "initial response" || authErr.Reason != sasler.ReasonBadCredentials {
+		t.Fatalf(`Data("%s") returned %+v; expected ReasonBadCredentials in initial response of ECDSA-NIST256P-CHALLENGE`, ir, authErr)
+	}
+}
+
+func TestEcdsaServer_InvalidSignatureRequestedAuthz(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(elliptic.P256(), rand.Reader) returned error: %v`, err)
+	}
+
+	auth := sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{key: &privateKey.PublicKey})
+
+	ir := []byte("RequestedAuthz\x00user")
+	challenge, err := auth.Data(ir)
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	gotChallenge, err := auth.Data(challenge)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Data(challenge) returned ("%s", %v); expected (nil, ErrAuthenticationFailed)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestEcdsaServerContext(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(elliptic.P256(), rand.Reader) returned error: %v`, err)
+	}
+
+	auth := sasler.EcdsaNist256pChallengeServerContext(&fakeEcdsaContextAuthenticator{key: &privateKey.PublicKey})
+
+	ctx := context.WithValue(context.Background(), fakeEcdsaContextKey{}, "C")
+	ir := []byte("user")
+	challenge, err := auth.DataContext(ctx, ir)
+	if err != nil {
+		t.Fatalf(`DataContext("%s") returned error: %v`, ir, err)
+	}
+
+	sig, err := ecdsa.SignASN1(rand.Reader, privateKey, challenge)
+	if err != nil {
+		t.Fatalf(`SignASN1() returned error: %v`, err)
+	}
+	gotChallenge, err := auth.DataContext(ctx, sig)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`DataContext(sig) returned ("%s", %v); expected (nil, nil)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "userC"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestEcdsaMultiKeyServerContext(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(elliptic.P256(), rand.Reader) returned error: %v`, err)
+	}
+
+	fake := &fakeEcdsaContextAuthenticator{key: &privateKey.PublicKey}
+	auth := sasler.EcdsaNist256pChallengeMultiKeyServerContext(fake)
+
+	ctx := context.WithValue(context.Background(), fakeEcdsaContextKey{}, "C")
+	ir := []byte("user")
+	challenge, err := auth.DataContext(ctx, ir)
+	if err != nil {
+		t.Fatalf(`DataContext("%s") returned error: %v`, ir, err)
+	}
+
+	sig, err := ecdsa.SignASN1(rand.Reader, privateKey, challenge)
+	if err != nil {
+		t.Fatalf(`SignASN1() returned error: %v`, err)
+	}
+	gotChallenge, err := auth.DataContext(ctx, sig)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`DataContext(sig) returned ("%s", %v); expected (nil, nil)`, gotChallenge, err)
+	}
+	if fake.matchedSuffix != "C" {
+		t.Fatalf(`KeyMatchedContext() was called with suffix "%s"; expected "C"`, fake.matchedSuffix)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "userC"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+type fakeEcdsaMultiKeyAuthenticator struct {
+	keys    []*ecdsa.PublicKey
+	matched *ecdsa.PublicKey
+}
+
+func (f *fakeEcdsaMultiKeyAuthenticator) GetPublicKeys(authn string) ([]*ecdsa.PublicKey, error) {
+	return f.keys, nil
+}
+
+func (f *fakeEcdsaMultiKeyAuthenticator) KeyMatched(authn string, key *ecdsa.PublicKey) {
+	f.matched = key
+}
+
+func (*fakeEcdsaMultiKeyAuthenticator) DeriveAuthz(authn string) string {
+	return authn + "Z"
+}
+
+func (*fakeEcdsaMultiKeyAuthenticator) Authorize(authz, authn string) bool {
+	return authz == authn+"Z"
+}
+
 type fakeEcdsaAuthenticator struct {
 	key *ecdsa.PublicKey
 }
@@ -208,3 +480,38 @@ func (*fakeEcdsaAuthenticator) DeriveAuthz(authn string) string {
 func (*fakeEcdsaAuthenticator) Authorize(authz, authn string) bool {
 	return authz == authn+"Z" || authz == "RequestedAuthz"
 }
+
+// fakeEcdsaContextKey is the context key of the value that
+// fakeEcdsaContextAuthenticator uses as authz suffix.
+type fakeEcdsaContextKey struct{}
+
+type fakeEcdsaContextAuthenticator struct {
+	key           *ecdsa.PublicKey
+	matchedSuffix string
+}
+
+func (f *fakeEcdsaContextAuthenticator) GetPublicKeyContext(ctx context.Context, authn string) (*ecdsa.PublicKey, error) {
+	return f.key, nil
+}
+
+func (f *fakeEcdsaContextAuthenticator) GetPublicKeysContext(ctx context.Context, authn string) ([]*ecdsa.PublicKey, error) {
+	key, err := f.GetPublicKeyContext(ctx, authn)
+	if err != nil {
+		return nil, err
+	}
+	return []*ecdsa.PublicKey{key}, nil
+}
+
+func (f *fakeEcdsaContextAuthenticator) KeyMatchedContext(ctx context.Context, authn string, key *ecdsa.PublicKey) {
+	f.matchedSuffix, _ = ctx.Value(fakeEcdsaContextKey{}).(string)
+}
+
+func (*fakeEcdsaContextAuthenticator) DeriveAuthzContext(ctx context.Context, authn string) string {
+	suffix, _ := ctx.Value(fakeEcdsaContextKey{}).(string)
+	return authn + suffix
+}
+
+func (*fakeEcdsaContextAuthenticator) AuthorizeContext(ctx context.Context, authz, authn string) bool {
+	suffix, _ := ctx.Value(fakeEcdsaContextKey{}).(string)
+	return authn+suffix == authz
+}
@@ -0,0 +1,96 @@
+package sasler
+
+// Reason is a machine-readable reason for a failed authentication, as carried
+// by an [Error].
+type Reason string
+
+const (
+	// ReasonMalformedMessage is used when a message can't be parsed.
+	ReasonMalformedMessage Reason = "malformed message"
+	// ReasonMalformedAttribute is used when an attribute of a message is
+	// missing or invalid. The Attribute field of the Error names the attribute.
+	ReasonMalformedAttribute Reason = "malformed attribute"
+	// ReasonChannelBinding is used when the channel binding requested or sent
+	// by the client doesn't match the channel binding of the server.
+	ReasonChannelBinding Reason = "channel binding mismatch"
+	// ReasonNonceMismatch is used when the client doesn't echo the nonce or
+	// random number of the server.
+	ReasonNonceMismatch Reason = "nonce mismatch"
+	// ReasonUnknownUser is used when the authenticator can't supply the
+	// credentials of the authn.
+	ReasonUnknownUser Reason = "unknown user"
+	// ReasonBadCredentials is used when the authenticator rejects the password,
+	// token, certificate or assertion of the client.
+	ReasonBadCredentials Reason = "bad credentials"
+	// ReasonBadProof is used when the client fails to prove possession of its
+	// credentials, such as with a wrong proof, signature or one-time password.
+	ReasonBadProof Reason = "bad proof"
+	// ReasonExpiredToken is used when the credentials of the client are no
+	// longer usable, such as an exhausted sequence of one-time passwords.
+	ReasonExpiredToken Reason = "expired token"
+	// ReasonNoAuthz is used when no authz has been requested by the client, and
+	// no authz can be derived from the authn.
+	ReasonNoAuthz Reason = "no authz"
+	// ReasonUnauthorized is used when the authn is not authorized to use the
+	// authz.
+	ReasonUnauthorized Reason = "unauthorized"
+)
+
+// Error describes why a server-side mechanism failed the authentication. The
+// server-side mechanisms of this package return an *Error from Data when
+// authentication fails. It satisfies errors.Is against Kind, which is one of
+// the sentinel errors of this package, and against the wrapped cause.
+type Error struct {
+	// Mech is the name of the mechanism.
+	Mech string
+	// Step is the message of the exchange that was processed when the error
+	// occurred, such as "client-final-message".
+	Step string
+	// Reason is the machine-readable reason of the failure.
+	Reason Reason
+	// Attribute is the name of the attribute that is missing or invalid, if
+	// Reason is ReasonMalformedAttribute.
+	Attribute string
+	// Kind is ErrInvalidMessage, ErrAuthenticationFailed or ErrUnauthorized.
+	Kind error
+	// Err is the wrapped cause, such as an error returned by the
+	// authenticator, or nil.
+	Err error
+}
+
+// Error returns the mechanism, step, reason and cause of the failure.
+func (e *Error) Error() string {
+	s := "sasler: " + e.Mech + " " + e.Step + ": " + string(e.Reason)
+	if e.Attribute != "" {
+		s += " " + e.Attribute
+	}
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+// Unwrap returns Kind, and the wrapped cause if there is one.
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// newError returns an *Error of the supplied kind for a failure of mech in
+// step.
+func newError(mech, step string, kind error, reason Reason) *Error {
+	return &Error{Mech: mech, Step: step, Reason: reason, Kind: kind}
+}
+
+// malformedAttribute returns an *Error of kind ErrInvalidMessage for a missing
+// or invalid attribute in step.
+func malformedAttribute(mech, step, attr string) *Error {
+	return &Error{Mech: mech, Step: step, Reason: ReasonMalformedAttribute, Attribute: attr, Kind: ErrInvalidMessage}
+}
+
+// wrapError is like newError, but also wraps the cause err.
+func wrapError(mech, step string, kind error, reason Reason, err error) *Error {
+	return &Error{Mech: mech, Step: step, Reason: reason, Kind: kind, Err: err}
+}
@@ -1,5 +1,7 @@
 package sasler
 
+import "context"
+
 // ExternalClient returns a ClientMech implementation for the EXTERNAL
 // mechanism, as specified in [RFC 4422, appendix A].
 //
@@ -24,23 +26,74 @@ type ExternalAuthenticator interface {
 	Authorize(authz string) bool
 }
 
+// ExternalIdentifier can be implemented by an ExternalAuthenticator or
+// ExternalContextAuthenticator to report the identity that has been
+// established by external sources, which is included as authn in the Result
+// of the ServerMech.
+type ExternalIdentifier interface {
+	// Authn returns the identity established by external sources, or the empty
+	// string if there is none.
+	Authn() string
+}
+
+// ExternalContextAuthenticator is the context-aware variant of
+// [ExternalAuthenticator], which is supplied to [ExternalServerContext]. The
+// context is the one passed to DataContext.
+type ExternalContextAuthenticator interface {
+	// DeriveAuthzContext is the context-aware variant of DeriveAuthz.
+	DeriveAuthzContext(ctx context.Context) string
+	// AuthorizeContext is the context-aware variant of Authorize.
+	AuthorizeContext(ctx context.Context, authz string) bool
+}
+
+// externalContextAdapter adapts an ExternalAuthenticator to an
+// ExternalContextAuthenticator that ignores the context.
+type externalContextAdapter struct {
+	auth ExternalAuthenticator
+}
+
+func (a externalContextAdapter) DeriveAuthzContext(context.Context) string {
+	return a.auth.DeriveAuthz()
+}
+
+func (a externalContextAdapter) AuthorizeContext(_ context.Context, authz string) bool {
+	return a.auth.Authorize(authz)
+}
+
+func (a externalContextAdapter) Authn() string {
+	if identifier, ok := a.auth.(ExternalIdentifier); ok {
+		return identifier.Authn()
+	}
+	return ""
+}
+
 // ExternalServer returns a ServerMech implementation for the EXTERNAL
 // mechanism, as specified in [RFC 4422, appendix A].
 //
 // [RFC 4422, appendix A]: https://tools.ietf.org/html/rfc4422#appendix-A
 func ExternalServer(auth ExternalAuthenticator) ServerMech {
-	cb := func(ir []byte) (string, error) {
+	return ExternalServerContext(externalContextAdapter{auth})
+}
+
+// ExternalServerContext is like [ExternalServer], but uses a context-aware
+// authenticator.
+func ExternalServerContext(auth ExternalContextAuthenticator) ServerMech {
+	cb := func(ctx context.Context, ir []byte, result *Result) error {
+		if identifier, ok := auth.(ExternalIdentifier); ok {
+			result.Authn = identifier.Authn()
+		}
 		authz := string(ir)
 		if authz == "" {
-			authz = auth.DeriveAuthz()
+			authz = auth.DeriveAuthzContext(ctx)
 			if authz == "" {
-				return "", ErrAuthenticationFailed
+				return newError("EXTERNAL", "initial response", ErrAuthenticationFailed, ReasonNoAuthz)
 			}
 		}
-		if !auth.Authorize(authz) {
-			return "", ErrUnauthorized
+		if !auth.AuthorizeContext(ctx, authz) {
+			return newError("EXTERNAL", "initial response", ErrUnauthorized, ReasonUnauthorized)
 		}
-		return authz, nil
+		result.Authz = authz
+		return nil
 	}
 	return &singleMessageServer{name: "EXTERNAL", cb: cb}
 }
@@ -2,6 +2,7 @@ package sasler_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"testing"
 
@@ -27,7 +28,7 @@ func TestExternalClient(t *testing.T) {
 	}
 
 	_, err = auth.Data(nil)
-	if err != sasler.ErrInvalidState {
+	if !errors.Is(err, sasler.ErrInvalidState) {
 		t.Fatalf(`Data(nil) returned error: %v; expected ErrInvalidState`, err)
 	}
 }
@@ -98,6 +99,23 @@ func TestExternalServer_Unauthorized(t *testing.T) {
 	}
 }
 
+func TestExternalServerContext(t *testing.T) {
+	auth := sasler.ExternalServerContext(&FakeExternalContextAuthenticator{})
+
+	ctx := context.WithValue(context.Background(), fakeExternalContextKey{}, "ctx-authz")
+	ir := []byte("")
+	gotChallenge, err := auth.DataContext(ctx, ir)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`DataContext("%s") returned (%s, %v); expected (nil, nil)`, ir, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "ctx-authz"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
 type FakeExternalAuthenticator struct{}
 
 func (*FakeExternalAuthenticator) DeriveAuthz() string {
@@ -107,3 +125,19 @@ func (*FakeExternalAuthenticator) DeriveAuthz() string {
 func (*FakeExternalAuthenticator) Authorize(authz string) bool {
 	return authz == "derived-authz" || authz == "requested-authz"
 }
+
+// fakeExternalContextKey is the context key of the value that
+// FakeExternalContextAuthenticator derives as authz.
+type fakeExternalContextKey struct{}
+
+type FakeExternalContextAuthenticator struct{}
+
+func (*FakeExternalContextAuthenticator) DeriveAuthzContext(ctx context.Context) string {
+	authz, _ := ctx.Value(fakeExternalContextKey{}).(string)
+	return authz
+}
+
+func (*FakeExternalContextAuthenticator) AuthorizeContext(ctx context.Context, authz string) bool {
+	derived, _ := ctx.Value(fakeExternalContextKey{}).(string)
+	return authz == derived
+}
@@ -0,0 +1,152 @@
+package sasler
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"strings"
+)
+
+// CertMapping selects the attribute of a client certificate that is used as
+// the identity of the client.
+type CertMapping int
+
+const (
+	// CertCommonName maps the common name of the subject of the certificate.
+	CertCommonName CertMapping = iota
+	// CertEmail maps the first e-mail address in the subject alternative names
+	// of the certificate.
+	CertEmail
+	// CertDNSName maps the first DNS name in the subject alternative names of
+	// the certificate.
+	CertDNSName
+	// CertURI maps the first URI in the subject alternative names of the
+	// certificate.
+	CertURI
+	// CertDistinguishedName maps the full distinguished name of the subject of
+	// the certificate, formatted as described in RFC 2253.
+	CertDistinguishedName
+	// CertFingerprint maps the SHA-256 fingerprint of the certificate, using the
+	// Fingerprints table of [ExternalTLSConfig].
+	CertFingerprint
+)
+
+// ExternalTLSConfig configures how [ExternalTLSServer] derives the identity of
+// a client from its TLS client certificate, and which authz it may use.
+type ExternalTLSConfig struct {
+	// Mappings lists the certificate attributes that are tried in order, until
+	// one results in a non-empty identity. If empty, CertCommonName is used.
+	Mappings []CertMapping
+	// Fingerprints maps SHA-256 fingerprints of certificates to identities,
+	// for use with CertFingerprint. Fingerprints are hex encoded, and may
+	// contain colons.
+	Fingerprints map[string]string
+	// AuthzRules maps an identity to the list of authz it may use in addition
+	// to the identity itself. The authz "*" allows the use of any authz.
+	AuthzRules map[string][]string
+}
+
+// ExternalTLSServer returns a ServerMech implementation for the EXTERNAL
+// mechanism, as specified in [RFC 4422, appendix A], that derives the
+// identity of the client from the TLS client certificate in state, as
+// configured by config. Only the CertFingerprint mapping accepts a certificate
+// that has not been verified during the TLS handshake, as the fingerprint
+// table pins the certificate itself. The identity is reported as authn in the
+// Result, and if no authz is requested, it's also used as authz. A nil config
+// is treated as the zero value.
+//
+// [RFC 4422, appendix A]: https://tools.ietf.org/html/rfc4422#appendix-A
+func ExternalTLSServer(state tls.ConnectionState, config *ExternalTLSConfig) ServerMech {
+	if config == nil {
+		config = &ExternalTLSConfig{}
+	}
+	auth := &tlsExternalAuthenticator{config: config}
+	if len(state.PeerCertificates) > 0 {
+		auth.identity = config.identity(state.PeerCertificates[0], len(state.VerifiedChains) > 0)
+	}
+	return ExternalServer(auth)
+}
+
+// identity returns the identity of the first mapping that results in a
+// non-empty identity for cert.
+func (c *ExternalTLSConfig) identity(cert *x509.Certificate, verified bool) string {
+	mappings := c.Mappings
+	if len(mappings) == 0 {
+		mappings = []CertMapping{CertCommonName}
+	}
+	for _, mapping := range mappings {
+		if mapping != CertFingerprint && !verified {
+			continue
+		}
+		if identity := c.mapCert(cert, mapping); identity != "" {
+			return identity
+		}
+	}
+	return ""
+}
+
+// mapCert returns the identity for cert using mapping, or the empty string if
+// the certificate doesn't contain the mapped attribute.
+func (c *ExternalTLSConfig) mapCert(cert *x509.Certificate, mapping CertMapping) string {
+	switch mapping {
+	case CertCommonName:
+		return cert.Subject.CommonName
+	case CertEmail:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case CertDNSName:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case CertURI:
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	case CertDistinguishedName:
+		return cert.Subject.String()
+	case CertFingerprint:
+		fingerprint := sha256.Sum256(cert.Raw)
+		for f, identity := range c.Fingerprints {
+			if strings.EqualFold(strings.ReplaceAll(f, ":", ""), hex.EncodeToString(fingerprint[:])) {
+				return identity
+			}
+		}
+	}
+	return ""
+}
+
+// tlsExternalAuthenticator is the ExternalAuthenticator used by
+// ExternalTLSServer.
+type tlsExternalAuthenticator struct {
+	identity string
+	config   *ExternalTLSConfig
+}
+
+// Authn returns the identity of the client.
+func (a *tlsExternalAuthenticator) Authn() string {
+	return a.identity
+}
+
+// DeriveAuthz returns the identity of the client.
+func (a *tlsExternalAuthenticator) DeriveAuthz() string {
+	return a.identity
+}
+
+// Authorize verifies whether the identity of the client equals authz, or is
+// allowed to use authz by the authorization rules.
+func (a *tlsExternalAuthenticator) Authorize(authz string) bool {
+	if a.identity == "" {
+		return false
+	}
+	if authz == a.identity {
+		return true
+	}
+	for _, rule := range a.config.AuthzRules[a.identity] {
+		if rule == "*" || rule == authz {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package sasler_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/phedny/sasler"
+)
+
+func TestExternalTLSServer_Mappings(t *testing.T) {
+	cert := externalTLSCertificate(t)
+	fingerprint := sha256.Sum256(cert.Raw)
+	tests := []struct {
+		mappings []sasler.CertMapping
+		authz    string
+	}{
+		{nil, "user"},
+		{[]sasler.CertMapping{sasler.CertEmail}, "user@example.com"},
+		{[]sasler.CertMapping{sasler.CertDNSName}, "client.example.com"},
+		{[]sasler.CertMapping{sasler.CertURI}, "spiffe://example.com/user"},
+		{[]sasler.CertMapping{sasler.CertDistinguishedName}, "CN=user,O=Example"},
+		{[]sasler.CertMapping{sasler.CertFingerprint}, "pinned-user"},
+		{[]sasler.CertMapping{sasler.CertFingerprint, sasler.CertEmail}, "pinned-user"},
+	}
+	for _, test := range tests {
+		config := &sasler.ExternalTLSConfig{
+			Mappings:     test.mappings,
+			Fingerprints: map[string]string{hex.EncodeToString(fingerprint[:]): "pinned-user"},
+		}
+		state := tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert},
+			VerifiedChains:   [][]*x509.Certificate{{cert}},
+		}
+		auth := sasler.ExternalTLSServer(state, config)
+
+		gotChallenge, err := auth.Data([]byte(""))
+		if gotChallenge != nil || err != nil {
+			t.Fatalf(`Data("") with mappings %v returned (%s, %v); expected (nil, nil)`, test.mappings, gotChallenge, err)
+		}
+
+		gotCompleted, gotAuthz := auth.HasCompleted()
+		if !gotCompleted || gotAuthz != test.authz {
+			t.Fatalf(`HasCompleted() with mappings %v returned (%v, "%s"); expected (true, "%s")`, test.mappings, gotCompleted, gotAuthz, test.authz)
+		}
+	}
+}
+
+func TestExternalTLSServer_AuthzRules(t *testing.T) {
+	cert := externalTLSCertificate(t)
+	state := tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+	config := &sasler.ExternalTLSConfig{
+		AuthzRules: map[string][]string{"user": {"RequestedAuthz"}},
+	}
+	tests := []struct {
+		ir    string
+		authz string
+		err   error
+	}{
+		{"user", "user", nil},
+		{"RequestedAuthz", "RequestedAuthz", nil},
+		{"InvalidAuthz", "", sasler.ErrUnauthorized},
+	}
+	for _, test := range tests {
+		auth := sasler.ExternalTLSServer(state, config)
+		if _, err := auth.Data([]byte(test.ir)); !errors.Is(err, test.err) {
+			t.Fatalf(`Data("%s") returned error: %v; expected %v`, test.ir, err, test.err)
+		}
+		gotCompleted, gotAuthz := auth.HasCompleted()
+		if !gotCompleted || gotAuthz != test.authz {
+			t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, test.authz)
+		}
+		if result := auth.Result(); result == nil || result.Authn != "user" {
+			t.Fatalf(`Result() returned %+v; expected authn "user"`, result)
+		}
+	}
+
+	config.AuthzRules["user"] = []string{"*"}
+	auth := sasler.ExternalTLSServer(state, config)
+	if _, err := auth.Data([]byte("AnyAuthz")); err != nil {
+		t.Fatalf(`Data("AnyAuthz") returned error: %v`, err)
+	}
+}
+
+func TestExternalTLSServer_Unverified(t *testing.T) {
+	cert := externalTLSCertificate(t)
+	fingerprint := sha256.Sum256(cert.Raw)
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+
+	auth := sasler.ExternalTLSServer(state, &sasler.ExternalTLSConfig{})
+	if _, err := auth.Data([]byte("")); !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Data("") returned error: %v; expected ErrAuthenticationFailed`, err)
+	}
+
+	auth = sasler.ExternalTLSServer(state, &sasler.ExternalTLSConfig{})
+	if _, err := auth.Data([]byte("user")); !errors.Is(err, sasler.ErrUnauthorized) {
+		t.Fatalf(`Data("user") returned error: %v; expected ErrUnauthorized`, err)
+	}
+
+	hexFingerprint := hex.EncodeToString(fingerprint[:])
+	colonFingerprint := ""
+	for i := 0; i < len(hexFingerprint); i += 2 {
+		if i > 0 {
+			colonFingerprint += ":"
+		}
+		colonFingerprint += hexFingerprint[i : i+2]
+	}
+	config := &sasler.ExternalTLSConfig{
+		Mappings:     []sasler.CertMapping{sasler.CertCommonName, sasler.CertFingerprint},
+		Fingerprints: map[string]string{colonFingerprint: "pinned-user"},
+	}
+	auth = sasler.ExternalTLSServer(state, config)
+	if _, err := auth.Data([]byte("")); err != nil {
+		t.Fatalf(`Data("") returned error: %v`, err)
+	}
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "pinned-user"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestExternalTLSServer_NoCertificate(t *testing.T) {
+	auth := sasler.ExternalTLSServer(tls.ConnectionState{}, &sasler.ExternalTLSConfig{})
+	if _, err := auth.Data([]byte("")); !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Data("") returned error: %v; expected ErrAuthenticationFailed`, err)
+	}
+}
+
+func TestExternalTLSServer_NilConfig(t *testing.T) {
+	cert := externalTLSCertificate(t)
+	state := tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+
+	auth := sasler.ExternalTLSServer(state, nil)
+	gotChallenge, err := auth.Data([]byte(""))
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data("") returned (%s, %v); expected (nil, nil)`, gotChallenge, err)
+	}
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "user"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+
+	auth = sasler.ExternalTLSServer(state, nil)
+	if _, err := auth.Data([]byte("admin")); !errors.Is(err, sasler.ErrUnauthorized) {
+		t.Fatalf(`Data("admin") returned error: %v; expected ErrUnauthorized`, err)
+	}
+}
+
+// externalTLSCertificate returns a self-signed client certificate with a
+// common name, organization, and e-mail, DNS and URI subject alternative
+// names.
+func externalTLSCertificate(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(elliptic.P256(), rand.Reader) returned error: %v`, err)
+	}
+	uri, _ := url.Parse("spiffe://example.com/user")
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(1),
+		Subject:        pkix.Name{CommonName: "user", Organization: []string{"Example"}},
+		EmailAddresses: []string{"user@example.com"},
+		DNSNames:       []string{"client.example.com"},
+		URIs:           []*url.URL{uri},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf(`CreateCertificate() returned error: %v`, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf(`ParseCertificate() returned error: %v`, err)
+	}
+	return cert
+}
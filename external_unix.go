@@ -0,0 +1,129 @@
+//go:build linux
+
+package sasler
+
+import (
+	"errors"
+	"net"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// UnixPeerRules configures which authz local clients may use, in addition to
+// their own username. The authz "*" allows the use of any authz.
+type UnixPeerRules struct {
+	// UIDs maps user IDs to the list of authz they may use.
+	UIDs map[uint32][]string
+	// Groups maps group names to the list of authz their members may use,
+	// including members for which it's the primary group.
+	Groups map[string][]string
+}
+
+// UnixPeerAuthenticator is an ExternalAuthenticator that derives the identity
+// of a local client from the credentials of the peer process of a Unix domain
+// socket, as reported by SO_PEERCRED.
+type UnixPeerAuthenticator struct {
+	cred     syscall.Ucred
+	username string
+	groups   []string
+	rules    *UnixPeerRules
+}
+
+// NewUnixPeerAuthenticator returns a UnixPeerAuthenticator for the peer of
+// conn, for use with [ExternalServer]. The user ID of the peer is resolved to
+// a username, which is used as authz if no authz is requested. If the user ID
+// has no user account, such as in a container, the decimal user ID is used as
+// username. The rules configure which other authz may be requested. Returns an
+// error if the peer credentials or username could not be retrieved.
+func NewUnixPeerAuthenticator(conn *net.UnixConn, rules *UnixPeerRules) (*UnixPeerAuthenticator, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+	return newUnixPeerAuthenticator(*cred, rules)
+}
+
+// newUnixPeerAuthenticator returns a UnixPeerAuthenticator for a peer with the
+// credentials cred, resolving its username and groups.
+func newUnixPeerAuthenticator(cred syscall.Ucred, rules *UnixPeerRules) (*UnixPeerAuthenticator, error) {
+	uid := strconv.FormatUint(uint64(cred.Uid), 10)
+	a := &UnixPeerAuthenticator{cred: cred, username: uid, rules: rules}
+	var gids []string
+	u, err := user.LookupId(uid)
+	var unknownErr user.UnknownUserIdError
+	switch {
+	case err == nil:
+		a.username = u.Username
+		if gids, err = u.GroupIds(); err != nil {
+			gids = nil
+		}
+	case !errors.As(err, &unknownErr):
+		return nil, err
+	}
+	gids = append(gids, strconv.FormatUint(uint64(cred.Gid), 10))
+	for _, gid := range gids {
+		if g, err := user.LookupGroupId(gid); err == nil {
+			a.groups = append(a.groups, g.Name)
+		}
+	}
+	return a, nil
+}
+
+// PeerCredentials returns the process ID, user ID and group ID of the peer.
+func (a *UnixPeerAuthenticator) PeerCredentials() (pid int32, uid, gid uint32) {
+	return a.cred.Pid, a.cred.Uid, a.cred.Gid
+}
+
+// Authn returns the username of the peer, which implements
+// [ExternalIdentifier].
+func (a *UnixPeerAuthenticator) Authn() string {
+	return a.username
+}
+
+// DeriveAuthz returns the username of the peer.
+func (a *UnixPeerAuthenticator) DeriveAuthz() string {
+	return a.username
+}
+
+// Authorize verifies whether authz equals the username of the peer, or is
+// allowed by the rules for the user ID or any of the groups of the peer.
+func (a *UnixPeerAuthenticator) Authorize(authz string) bool {
+	if authz == a.username {
+		return true
+	}
+	if a.rules == nil {
+		return false
+	}
+	if unixPeerRuleAllows(a.rules.UIDs[a.cred.Uid], authz) {
+		return true
+	}
+	for _, group := range a.groups {
+		if unixPeerRuleAllows(a.rules.Groups[group], authz) {
+			return true
+		}
+	}
+	return false
+}
+
+// unixPeerRuleAllows returns whether the list of authz of a rule contains authz
+// or "*".
+func unixPeerRuleAllows(rule []string, authz string) bool {
+	for _, r := range rule {
+		if r == "*" || r == authz {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+//go:build linux
+
+package sasler
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestUnixPeerAuthenticator_UnknownUID(t *testing.T) {
+	uid := uint32(2147483646)
+	rules := &UnixPeerRules{UIDs: map[uint32][]string{uid: {"UIDAuthz"}}}
+	auth, err := newUnixPeerAuthenticator(syscall.Ucred{Pid: 1, Uid: uid, Gid: uid}, rules)
+	if err != nil {
+		t.Fatalf(`newUnixPeerAuthenticator() returned error: %v`, err)
+	}
+
+	tests := []struct {
+		ir    string
+		authz string
+		err   error
+	}{
+		{"", "2147483646", nil},
+		{"UIDAuthz", "UIDAuthz", nil},
+		{"InvalidAuthz", "", ErrUnauthorized},
+	}
+	for _, test := range tests {
+		mech := ExternalServer(auth)
+		if _, err := mech.Data([]byte(test.ir)); !errors.Is(err, test.err) {
+			t.Fatalf(`Data("%s") returned error: %v; expected %v`, test.ir, err, test.err)
+		}
+		gotCompleted, gotAuthz := mech.HasCompleted()
+		if !gotCompleted || gotAuthz != test.authz {
+			t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, test.authz)
+		}
+	}
+}
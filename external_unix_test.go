@@ -0,0 +1,88 @@
+//go:build linux
+
+package sasler_test
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/phedny/sasler"
+)
+
+func TestUnixPeerAuthenticator(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf(`user.Current() returned error: %v`, err)
+	}
+	group, err := user.LookupGroupId(strconv.Itoa(os.Getgid()))
+	if err != nil {
+		t.Skipf(`user.LookupGroupId() returned error: %v`, err)
+	}
+	conn := unixPeerConn(t)
+
+	rules := &sasler.UnixPeerRules{
+		UIDs:   map[uint32][]string{uint32(os.Getuid()): {"UIDAuthz"}},
+		Groups: map[string][]string{group.Name: {"GroupAuthz"}},
+	}
+	auth, err := sasler.NewUnixPeerAuthenticator(conn, rules)
+	if err != nil {
+		t.Fatalf(`NewUnixPeerAuthenticator() returned error: %v`, err)
+	}
+
+	gotPid, gotUid, gotGid := auth.PeerCredentials()
+	if int(gotPid) != os.Getpid() || int(gotUid) != os.Getuid() || int(gotGid) != os.Getgid() {
+		t.Fatalf(`PeerCredentials() returned (%d, %d, %d); expected (%d, %d, %d)`, gotPid, gotUid, gotGid, os.Getpid(), os.Getuid(), os.Getgid())
+	}
+
+	tests := []struct {
+		ir    string
+		authz string
+		err   error
+	}{
+		{"", current.Username, nil},
+		{"UIDAuthz", "UIDAuthz", nil},
+		{"GroupAuthz", "GroupAuthz", nil},
+		{"InvalidAuthz", "", sasler.ErrUnauthorized},
+	}
+	for _, test := range tests {
+		mech := sasler.ExternalServer(auth)
+		if _, err := mech.Data([]byte(test.ir)); !errors.Is(err, test.err) {
+			t.Fatalf(`Data("%s") returned error: %v; expected %v`, test.ir, err, test.err)
+		}
+		gotCompleted, gotAuthz := mech.HasCompleted()
+		if !gotCompleted || gotAuthz != test.authz {
+			t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, test.authz)
+		}
+		if result := mech.Result(); result == nil || result.Authn != current.Username {
+			t.Fatalf(`Result() returned %+v; expected authn "%s"`, result, current.Username)
+		}
+	}
+}
+
+// unixPeerConn returns the server side of a Unix domain socket connection
+// with this process.
+func unixPeerConn(t *testing.T) *net.UnixConn {
+	t.Helper()
+	addr := &net.UnixAddr{Name: filepath.Join(t.TempDir(), "sasler.sock"), Net: "unix"}
+	listener, err := net.ListenUnix("unix", addr)
+	if err != nil {
+		t.Fatalf(`ListenUnix() returned error: %v`, err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	client, err := net.DialUnix("unix", nil, addr)
+	if err != nil {
+		t.Fatalf(`DialUnix() returned error: %v`, err)
+	}
+	t.Cleanup(func() { client.Close() })
+	conn, err := listener.AcceptUnix()
+	if err != nil {
+		t.Fatalf(`AcceptUnix() returned error: %v`, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
@@ -0,0 +1,551 @@
+package sasler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/base32"
+	"strings"
+)
+
+// ChannelBinding contains the channel binding data for a secure channel,
+// typically a TLS connection, as described in [RFC 5056]. Type is the name of
+// the channel binding type, such as "tls-unique", "tls-server-end-point" or
+// "tls-exporter", and Data contains the channel binding data.
+//
+// [RFC 5056]: https://tools.ietf.org/html/rfc5056
+type ChannelBinding struct {
+	Type string
+	Data []byte
+}
+
+// Values for the gs2-cb-flag of a GS2 header.
+const (
+	gs2NoChannelBinding          = 'n'
+	gs2ChannelBindingUnsupported = 'y'
+	gs2ChannelBinding            = 'p'
+)
+
+// gs2Header contains the fields of a GS2 header, as described in
+// [RFC 5801, section 4].
+//
+// [RFC 5801, section 4]: https://tools.ietf.org/html/rfc5801#section-4
+type gs2Header struct {
+	nonStd bool
+	cbFlag byte
+	cbName string
+	authz  string
+}
+
+// marshal returns the encoded GS2 header, including the trailing comma.
+func (h *gs2Header) marshal() []byte {
+	var b bytes.Buffer
+	if h.nonStd {
+		b.WriteString("F,")
+	}
+	b.WriteByte(h.cbFlag)
+	if h.cbFlag == gs2ChannelBinding {
+		b.WriteByte('=')
+		b.WriteString(h.cbName)
+	}
+	b.WriteByte(',')
+	if h.authz != "" {
+		b.WriteString("a=")
+		b.WriteString(escapeSaslname(h.authz))
+	}
+	b.WriteByte(',')
+	return b.Bytes()
+}
+
+// channelBindingInput returns the value that is used for channel binding by
+// mechanisms of the GS2 family, being the GS2 header without the non-standard
+// flag, followed by the channel binding data if the client uses channel
+// binding.
+func (h *gs2Header) channelBindingInput(cb *ChannelBinding) []byte {
+	std := *h
+	std.nonStd = false
+	b := std.marshal()
+	if h.cbFlag == gs2ChannelBinding {
+		b = append(b, cb.Data...)
+	}
+	return b
+}
+
+// parseGs2Header parses the GS2 header at the start of b, and returns the
+// parsed header and the remainder of b.
+func parseGs2Header(b []byte) (gs2Header, []byte, error) {
+	var h gs2Header
+	if len(b) >= 2 && b[0] == 'F' && b[1] == ',' {
+		h.nonStd = true
+		b = b[2:]
+	}
+	if len(b) < 2 {
+		return h, nil, ErrInvalidMessage
+	}
+	h.cbFlag = b[0]
+	switch h.cbFlag {
+	case gs2NoChannelBinding, gs2ChannelBindingUnsupported:
+		b = b[1:]
+	case gs2ChannelBinding:
+		if b[1] != '=' {
+			return h, nil, ErrInvalidMessage
+		}
+		b = b[2:]
+		comma := bytes.IndexByte(b, ',')
+		if comma < 1 {
+			return h, nil, ErrInvalidMessage
+		}
+		h.cbName = string(b[:comma])
+		b = b[comma:]
+	default:
+		return h, nil, ErrInvalidMessage
+	}
+	if len(b) < 2 || b[0] != ',' {
+		return h, nil, ErrInvalidMessage
+	}
+	b = b[1:]
+	if b[0] == 'a' {
+		if len(b) < 2 || b[1] != '=' {
+			return h, nil, ErrInvalidMessage
+		}
+		b = b[2:]
+		comma := bytes.IndexByte(b, ',')
+		if comma == -1 {
+			return h, nil, ErrInvalidMessage
+		}
+		h.authz = unescapeSaslname(string(b[:comma]))
+		b = b[comma:]
+	}
+	if len(b) < 1 || b[0] != ',' {
+		return h, nil, ErrInvalidMessage
+	}
+	return h, b[1:], nil
+}
+
+// checkChannelBinding verifies whether the gs2-cb-flag sent by the client is
+// acceptable, given the channel binding supported by the server. The plus
+// argument is true if the client selected the -PLUS variant of a mechanism.
+func (h *gs2Header) checkChannelBinding(cb *ChannelBinding, plus bool) error {
+	switch {
+	case plus:
+		if h.cbFlag != gs2ChannelBinding || h.cbName != cb.Type {
+			return ErrInvalidMessage
+		}
+	case h.cbFlag == gs2ChannelBinding:
+		return ErrInvalidMessage
+	case h.cbFlag == gs2ChannelBindingUnsupported && cb != nil:
+		// The client supports channel binding, but thinks the server doesn't. As
+		// the server does support it, this indicates a downgrade attack.
+		return ErrAuthenticationFailed
+	}
+	return nil
+}
+
+// escapeSaslname escapes a string value, so it can be included in a
+// comma-separated message.
+func escapeSaslname(s string) string {
+	s = strings.ReplaceAll(s, "=", "=3D")
+	s = strings.ReplaceAll(s, ",", "=2C")
+	return s
+}
+
+// unescapeSaslname unescapes a string value after it was extracted from a
+// comma-separated message.
+func unescapeSaslname(s string) string {
+	s = strings.ReplaceAll(s, "=2C", ",")
+	s = strings.ReplaceAll(s, "=3D", "=")
+	return s
+}
+
+// gs2MechNames contains the mechanism names of GSS-API mechanisms that have a
+// registered name, instead of one derived from the hash of the OID.
+var gs2MechNames = map[string]string{
+	"1.2.840.113554.1.2.2": "GS2-KRB5",
+}
+
+// Gs2MechName returns the name of the SASL mechanism of the GS2 family for the
+// GSS-API mechanism identified by oid, as described in
+// [RFC 5801, section 3.1]. The name of the variant that uses channel binding
+// is the returned name followed by "-PLUS".
+//
+// [RFC 5801, section 3.1]: https://tools.ietf.org/html/rfc5801#section-3.1
+func Gs2MechName(oid asn1.ObjectIdentifier) (string, error) {
+	if name, ok := gs2MechNames[oid.String()]; ok {
+		return name, nil
+	}
+	der, err := asn1.Marshal(oid)
+	if err != nil {
+		return "", err
+	}
+	h := sha1.Sum(der)
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(h[:7])
+	return "GS2-" + encoded[:11], nil
+}
+
+// Gs2Initiator is a GSS-API security context on the client-side, as used by
+// [Gs2Client].
+type Gs2Initiator interface {
+	GssInitiator
+	// SetChannelBindings sets the application data of the channel bindings that
+	// are used when establishing the security context. It is called once,
+	// before the first call to InitSecContext.
+	SetChannelBindings(appData []byte)
+}
+
+// Gs2Acceptor is a GSS-API security context on the server-side, as used by
+// [Gs2Server].
+type Gs2Acceptor interface {
+	GssAcceptor
+	// SetChannelBindings sets the application data of the channel bindings that
+	// must be used by the initiator. It is called once, before the first call
+	// to AcceptSecContext.
+	SetChannelBindings(appData []byte)
+}
+
+// gs2ClientMech is a ClientMech implementation of the GS2 family of
+// mechanisms.
+type gs2ClientMech struct {
+	name      string
+	oid       []byte
+	header    gs2Header
+	cb        *ChannelBinding
+	ctx       Gs2Initiator
+	dataFn    func([]byte) ([]byte, error)
+	completed bool
+	aborted   bool
+}
+
+// Gs2Client returns a ClientMech implementation for the mechanism of the GS2
+// family, as specified in [RFC 5801], that bridges the GSS-API mechanism
+// identified by oid. The cb argument contains the channel binding of the
+// connection with the server, or nil if channel binding is not available. If
+// plus is true, the -PLUS variant of the mechanism is used, which requires cb
+// to be non-nil. Returns an error if no mechanism name can be derived for
+// oid.
+//
+// [RFC 5801]: https://tools.ietf.org/html/rfc5801
+func Gs2Client(oid asn1.ObjectIdentifier, authz string, ctx Gs2Initiator, cb *ChannelBinding, plus bool) (ClientMech, error) {
+	name, err := Gs2MechName(oid)
+	if err != nil {
+		return nil, err
+	}
+	der, err := asn1.Marshal(oid)
+	if err != nil {
+		return nil, err
+	}
+	m := &gs2ClientMech{name: name, oid: der, cb: cb, ctx: ctx}
+	m.header.authz = authz
+	switch {
+	case plus:
+		if cb == nil {
+			return nil, ErrInvalidState
+		}
+		m.name += "-PLUS"
+		m.header.cbFlag = gs2ChannelBinding
+		m.header.cbName = cb.Type
+	case cb != nil:
+		m.header.cbFlag = gs2ChannelBindingUnsupported
+	default:
+		m.header.cbFlag = gs2NoChannelBinding
+	}
+	m.dataFn = m.initialResponse
+	return m, nil
+}
+
+// Mech returns the name of the mechanism, and true for client-first.
+func (m *gs2ClientMech) Mech() (string, bool) {
+	return m.name, true
+}
+
+// Data relays tokens between the server and the GSS-API security context,
+// until it has been established.
+func (m *gs2ClientMech) Data(challenge []byte) ([]byte, error) {
+	if m.dataFn == nil {
+		return nil, ErrInvalidState
+	}
+	return m.dataFn(challenge)
+}
+
+// Success passes the final context token of the server to the GSS-API security
+// context if it's sent along with the success indication. Returns
+// ErrInvalidState if the security context hasn't been established.
+func (m *gs2ClientMech) Success(data []byte) error {
+	if m.aborted {
+		return ErrAborted
+	}
+	return clientSuccess(data, m.Data, &m.completed)
+}
+
+// Abort drops the security context.
+func (m *gs2ClientMech) Abort() {
+	m.ctx = nil
+	m.dataFn = clientAborted
+	m.completed = false
+	m.aborted = true
+}
+
+// initialResponse returns the GS2 header, followed by the initial context
+// token without its token header.
+func (m *gs2ClientMech) initialResponse(challenge []byte) ([]byte, error) {
+	if len(challenge) > 0 {
+		m.dataFn = m.failed
+		return nil, ErrInvalidMessage
+	}
+	m.ctx.SetChannelBindings(m.header.channelBindingInput(m.cb))
+	token, established, err := m.ctx.InitSecContext(nil)
+	if err != nil {
+		m.dataFn = m.failed
+		return nil, err
+	}
+	if established {
+		// Mutual authentication is required, so the acceptor must respond.
+		m.dataFn = m.failed
+		return nil, ErrAuthenticationFailed
+	}
+	m.dataFn = m.initSecContext
+	innerToken, ok := stripTokenHeader(token, m.oid)
+	if !ok {
+		m.header.nonStd = true
+		innerToken = token
+	}
+	return append(m.header.marshal(), innerToken...), nil
+}
+
+// initSecContext passes the challenge to the security context, and returns its
+// output token.
+func (m *gs2ClientMech) initSecContext(challenge []byte) ([]byte, error) {
+	out, established, err := m.ctx.InitSecContext(challenge)
+	if err != nil {
+		m.dataFn = m.failed
+		return nil, err
+	}
+	if established {
+		m.dataFn = m.failed
+		m.completed = true
+		if len(out) == 0 {
+			return nil, nil
+		}
+		return out, nil
+	}
+	if out == nil {
+		out = []byte{}
+	}
+	return out, nil
+}
+
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *gs2ClientMech) failed(challenge []byte) ([]byte, error) {
+	return nil, ErrInvalidState
+}
+
+// gs2ServerMech is a ServerMech implementation of the GS2 family of
+// mechanisms.
+type gs2ServerMech struct {
+	name      string
+	oid       []byte
+	plus      bool
+	cb        *ChannelBinding
+	authz     string
+	authn     string
+	completed bool
+	succeeded bool
+	aborted   bool
+	ctx       Gs2Acceptor
+	auth      GssapiContextAuthenticator
+	dataFn    func(context.Context, []byte) ([]byte, error)
+}
+
+// Gs2Server returns a ServerMech implementation for the mechanism of the GS2
+// family, as specified in [RFC 5801], that bridges the GSS-API mechanism
+// identified by oid. The cb argument contains the channel binding of the
+// connection with the client, or nil if channel binding is not available. If
+// plus is true, the -PLUS variant of the mechanism is used, which requires cb
+// to be non-nil. Returns an error if no mechanism name can be derived for
+// oid.
+//
+// [RFC 5801]: https://tools.ietf.org/html/rfc5801
+func Gs2Server(oid asn1.ObjectIdentifier, ctx Gs2Acceptor, auth GssapiAuthenticator, cb *ChannelBinding, plus bool) (ServerMech, error) {
+	return Gs2ServerContext(oid, ctx, gssapiContextAdapter{auth}, cb, plus)
+}
+
+// Gs2ServerContext is like [Gs2Server], but uses a context-aware
+// authenticator.
+func Gs2ServerContext(oid asn1.ObjectIdentifier, ctx Gs2Acceptor, auth GssapiContextAuthenticator, cb *ChannelBinding, plus bool) (ServerMech, error) {
+	name, err := Gs2MechName(oid)
+	if err != nil {
+		return nil, err
+	}
+	der, err := asn1.Marshal(oid)
+	if err != nil {
+		return nil, err
+	}
+	if plus {
+		if cb == nil {
+			return nil, ErrInvalidState
+		}
+		name += "-PLUS"
+	}
+	m := &gs2ServerMech{name: name, oid: der, plus: plus, cb: cb, ctx: ctx, auth: auth}
+	m.dataFn = m.initialResponse
+	return m, nil
+}
+
+// Mech returns the name of the mechanism, and true for client-first.
+func (m *gs2ServerMech) Mech() (string, bool) {
+	return m.name, true
+}
+
+// Data relays tokens between the client and the GSS-API security context,
+// until it has been established.
+func (m *gs2ServerMech) Data(data []byte) ([]byte, error) {
+	return m.DataContext(context.Background(), data)
+}
+
+// DataContext is like Data, but passes ctx on to the authenticator.
+func (m *gs2ServerMech) DataContext(ctx context.Context, data []byte) ([]byte, error) {
+	return serverDataContext(ctx, m, m.dataFn, data)
+}
+
+// initialResponse parses the GS2 header, and passes the initial context token
+// to the security context.
+func (m *gs2ServerMech) initialResponse(ctx context.Context, ir []byte) ([]byte, error) {
+	header, token, err := parseGs2Header(ir)
+	if err != nil {
+		m.dataFn = m.failed
+		m.completed = true
+		return nil, malformedAttribute(m.name, "initial response", "gs2-header")
+	}
+	if err := header.checkChannelBinding(m.cb, m.plus); err != nil {
+		m.dataFn = m.failed
+		m.completed = true
+		return nil, newError(m.name, "initial response", err, ReasonChannelBinding)
+	}
+	m.authz = header.authz
+	if !header.nonStd {
+		token = addTokenHeader(token, m.oid)
+	}
+	m.ctx.SetChannelBindings(header.channelBindingInput(m.cb))
+	m.dataFn = m.acceptSecContext
+	return m.acceptSecContext(ctx, token)
+}
+
+// acceptSecContext passes the response to the security context, and returns
+// its output token. Once the security context has been established, the authz
+// is checked.
+func (m *gs2ServerMech) acceptSecContext(ctx context.Context, data []byte) ([]byte, error) {
+	out, established, err := m.ctx.AcceptSecContext(data)
+	if err != nil {
+		m.dataFn = m.failed
+		m.completed = true
+		return nil, wrapError(m.name, "context token", ErrAuthenticationFailed, ReasonBadCredentials, err)
+	}
+	if !established {
+		if out == nil {
+			out = []byte{}
+		}
+		return out, nil
+	}
+	m.dataFn = m.failed
+	m.completed = true
+	m.authn = m.ctx.SrcName()
+	if m.authz == "" {
+		m.authz = m.auth.DeriveAuthzContext(ctx, m.authn)
+		if m.authz == "" {
+			return nil, newError(m.name, "context token", ErrAuthenticationFailed, ReasonNoAuthz)
+		}
+	}
+	if !m.auth.AuthorizeContext(ctx, m.authz, m.authn) {
+		m.authz = ""
+		return nil, newError(m.name, "context token", ErrUnauthorized, ReasonUnauthorized)
+	}
+	m.succeeded = true
+	if len(out) > 0 {
+		m.dataFn = m.ignoreOneMessage
+		return out, nil
+	}
+	return nil, nil
+}
+
+// ignoreOneMessage accepts the empty response the client sends after receiving
+// the final token of the context establishment.
+func (m *gs2ServerMech) ignoreOneMessage(ctx context.Context, data []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	if len(data) > 0 {
+		return nil, newError(m.name, "empty response", ErrInvalidMessage, ReasonMalformedMessage)
+	}
+	return nil, nil
+}
+
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *gs2ServerMech) failed(ctx context.Context, data []byte) ([]byte, error) {
+	return nil, ErrInvalidState
+}
+
+// HasCompleted returns true if authentication has finished, and if true, it
+// also returns the authorized authz, if any.
+func (m *gs2ServerMech) HasCompleted() (bool, string) {
+	switch {
+	case !m.completed:
+		return false, ""
+	case !m.succeeded:
+		return true, ""
+	}
+	return true, m.authz
+}
+
+// Result returns the name of the authenticated initiator as authn, and reports
+// channel binding for the -PLUS variant.
+func (m *gs2ServerMech) Result() *Result {
+	completed, authz := m.HasCompleted()
+	if !completed {
+		return nil
+	}
+	return &Result{Mech: m.name, Succeeded: m.succeeded, Aborted: m.aborted, Authn: m.authn, Authz: authz, ChannelBinding: m.plus}
+}
+
+// Abort drops the security context, if authentication is still in progress.
+func (m *gs2ServerMech) Abort() {
+	if m.completed {
+		return
+	}
+	m.ctx = nil
+	m.dataFn = serverAborted
+	m.completed = true
+	m.aborted = true
+	m.authz = ""
+}
+
+// stripTokenHeader removes the token header, as described in
+// [RFC 2743, section 3.1], from an initial context token. Returns false if the
+// token has no header, or if the header references a different mechanism.
+//
+// [RFC 2743, section 3.1]: https://tools.ietf.org/html/rfc2743#section-3.1
+func stripTokenHeader(token, oid []byte) ([]byte, bool) {
+	var raw asn1.RawValue
+	rest, err := asn1.Unmarshal(token, &raw)
+	if err != nil || len(rest) > 0 || raw.Class != asn1.ClassApplication || raw.Tag != 0 || !raw.IsCompound {
+		return nil, false
+	}
+	if !bytes.HasPrefix(raw.Bytes, oid) {
+		return nil, false
+	}
+	return raw.Bytes[len(oid):], true
+}
+
+// addTokenHeader restores the token header, as described in
+// [RFC 2743, section 3.1], that was removed from an initial context token.
+//
+// [RFC 2743, section 3.1]: https://tools.ietf.org/html/rfc2743#section-3.1
+func addTokenHeader(innerToken, oid []byte) []byte {
+	raw := asn1.RawValue{
+		Class:      asn1.ClassApplication,
+		Tag:        0,
+		IsCompound: true,
+		Bytes:      append(append([]byte{}, oid...), innerToken...),
+	}
+	token, _ := asn1.Marshal(raw)
+	return token
+}
@@ -0,0 +1,270 @@
+package sasler_test
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"testing"
+
+	"github.com/phedny/sasler"
+)
+
+// fakeGs2OID is the OID used by the fake GSS-API mechanism in these tests.
+var fakeGs2OID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 1, 1}
+
+// fakeGs2InitialToken is the initial context token of the fake GSS-API
+// mechanism, including the token header.
+var fakeGs2InitialToken = []byte("\x60\x12\x06\x07\x2b\x06\x01\x05\x05\x01\x01initiator")
+
+func TestGs2MechName(t *testing.T) {
+	tests := []struct {
+		oid  asn1.ObjectIdentifier
+		name string
+	}{
+		{asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 1, 1}, "GS2-DT4PIK22T6A"},
+		{asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}, "GS2-KRB5"},
+	}
+	for _, test := range tests {
+		gotName, err := sasler.Gs2MechName(test.oid)
+		if err != nil {
+			t.Fatalf(`Gs2MechName(%v) returned error: %v`, test.oid, err)
+		}
+		if gotName != test.name {
+			t.Fatalf(`Gs2MechName(%v) returned "%s"; expected "%s"`, test.oid, gotName, test.name)
+		}
+	}
+}
+
+func TestGs2Client(t *testing.T) {
+	ctx := &fakeGs2Initiator{}
+	auth, err := sasler.Gs2Client(fakeGs2OID, "", ctx, nil, false)
+	if err != nil {
+		t.Fatalf(`Gs2Client() returned error: %v`, err)
+	}
+
+	gotName, gotClientFirst := auth.Mech()
+	expectedName := "GS2-DT4PIK22T6A"
+	if gotName != expectedName || !gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", true)`, gotName, gotClientFirst, expectedName)
+	}
+
+	gotIR, err := auth.Data(nil)
+	expectedIR := []byte("n,,initiator")
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.Equal(gotIR, expectedIR) {
+		t.Fatalf(`Data(nil) returned %q; expected %q`, gotIR, expectedIR)
+	}
+	expectedBindings := []byte("n,,")
+	if !bytes.Equal(ctx.appData, expectedBindings) {
+		t.Fatalf(`SetChannelBindings() called with %q; expected %q`, ctx.appData, expectedBindings)
+	}
+
+	challenge := []byte("acceptor")
+	gotResponse, err := auth.Data(challenge)
+	if gotResponse != nil || err != nil {
+		t.Fatalf(`Data("%s") returned (%q, %v); expected (nil, nil)`, challenge, gotResponse, err)
+	}
+}
+
+func TestGs2Client_Plus(t *testing.T) {
+	ctx := &fakeGs2Initiator{}
+	cb := &sasler.ChannelBinding{Type: "tls-exporter", Data: []byte("cbdata")}
+	auth, err := sasler.Gs2Client(fakeGs2OID, "Requested,Authz", ctx, cb, true)
+	if err != nil {
+		t.Fatalf(`Gs2Client() returned error: %v`, err)
+	}
+
+	gotName, _ := auth.Mech()
+	expectedName := "GS2-DT4PIK22T6A-PLUS"
+	if gotName != expectedName {
+		t.Fatalf(`Name() returned "%s"; expected "%s"`, gotName, expectedName)
+	}
+
+	gotIR, err := auth.Data(nil)
+	expectedIR := []byte("p=tls-exporter,a=Requested=2CAuthz,initiator")
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.Equal(gotIR, expectedIR) {
+		t.Fatalf(`Data(nil) returned %q; expected %q`, gotIR, expectedIR)
+	}
+	expectedBindings := []byte("p=tls-exporter,a=Requested=2CAuthz,cbdata")
+	if !bytes.Equal(ctx.appData, expectedBindings) {
+		t.Fatalf(`SetChannelBindings() called with %q; expected %q`, ctx.appData, expectedBindings)
+	}
+}
+
+func TestGs2Client_NonStandardToken(t *testing.T) {
+	ctx := &fakeGs2Initiator{token: []byte("initiator")}
+	auth, err := sasler.Gs2Client(fakeGs2OID, "", ctx, &sasler.ChannelBinding{Type: "tls-unique"}, false)
+	if err != nil {
+		t.Fatalf(`Gs2Client() returned error: %v`, err)
+	}
+
+	gotIR, err := auth.Data(nil)
+	expectedIR := []byte("F,y,,initiator")
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.Equal(gotIR, expectedIR) {
+		t.Fatalf(`Data(nil) returned %q; expected %q`, gotIR, expectedIR)
+	}
+	expectedBindings := []byte("y,,")
+	if !bytes.Equal(ctx.appData, expectedBindings) {
+		t.Fatalf(`SetChannelBindings() called with %q; expected %q`, ctx.appData, expectedBindings)
+	}
+}
+
+func TestGs2Server_DeriveAuthz(t *testing.T) {
+	ctx := &fakeGs2Acceptor{}
+	auth, err := sasler.Gs2Server(fakeGs2OID, ctx, &fakeGssapiAuthenticator{}, nil, false)
+	if err != nil {
+		t.Fatalf(`Gs2Server() returned error: %v`, err)
+	}
+
+	gotName, gotClientFirst := auth.Mech()
+	expectedName := "GS2-DT4PIK22T6A"
+	if gotName != expectedName || !gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", true)`, gotName, gotClientFirst, expectedName)
+	}
+
+	ir := []byte("n,,initiator")
+	gotChallenge, err := auth.Data(ir)
+	expectedChallenge := []byte("acceptor")
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+	if !bytes.Equal(gotChallenge, expectedChallenge) {
+		t.Fatalf(`Data("%s") returned %q; expected %q`, ir, gotChallenge, expectedChallenge)
+	}
+	if !bytes.Equal(ctx.token, fakeGs2InitialToken) {
+		t.Fatalf(`AcceptSecContext() called with %q; expected %q`, ctx.token, fakeGs2InitialToken)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+
+	gotChallenge, err = auth.Data(nil)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data(nil) returned (%q, %v); expected (nil, nil)`, gotChallenge, err)
+	}
+}
+
+func TestGs2Server_Plus(t *testing.T) {
+	ctx := &fakeGs2Acceptor{}
+	cb := &sasler.ChannelBinding{Type: "tls-exporter", Data: []byte("cbdata")}
+	auth, err := sasler.Gs2Server(fakeGs2OID, ctx, &fakeGssapiAuthenticator{}, cb, true)
+	if err != nil {
+		t.Fatalf(`Gs2Server() returned error: %v`, err)
+	}
+
+	gotName, _ := auth.Mech()
+	expectedName := "GS2-DT4PIK22T6A-PLUS"
+	if gotName != expectedName {
+		t.Fatalf(`Name() returned "%s"; expected "%s"`, gotName, expectedName)
+	}
+
+	ir := []byte("p=tls-exporter,a=RequestedAuthz,initiator")
+	if _, err := auth.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+	expectedBindings := []byte("p=tls-exporter,a=RequestedAuthz,cbdata")
+	if !bytes.Equal(ctx.appData, expectedBindings) {
+		t.Fatalf(`SetChannelBindings() called with %q; expected %q`, ctx.appData, expectedBindings)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "RequestedAuthz"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestGs2Server_Downgrade(t *testing.T) {
+	cb := &sasler.ChannelBinding{Type: "tls-exporter", Data: []byte("cbdata")}
+	auth, err := sasler.Gs2Server(fakeGs2OID, &fakeGs2Acceptor{}, &fakeGssapiAuthenticator{}, cb, false)
+	if err != nil {
+		t.Fatalf(`Gs2Server() returned error: %v`, err)
+	}
+
+	ir := []byte("y,,initiator")
+	gotChallenge, err := auth.Data(ir)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Data("%s") returned (%q, %v); expected (nil, ErrAuthenticationFailed)`, ir, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestGs2Server_Unauthorized(t *testing.T) {
+	auth, err := sasler.Gs2Server(fakeGs2OID, &fakeGs2Acceptor{}, &fakeGssapiAuthenticator{}, nil, false)
+	if err != nil {
+		t.Fatalf(`Gs2Server() returned error: %v`, err)
+	}
+
+	ir := []byte("n,a=InvalidAuthz,initiator")
+	gotChallenge, err := auth.Data(ir)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrUnauthorized) {
+		t.Fatalf(`Data("%s") returned (%q, %v); expected (nil, ErrUnauthorized)`, ir, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+// fakeGs2Initiator is a Gs2Initiator that sends a single token, and expects a
+// single token in return.
+type fakeGs2Initiator struct {
+	fakeGssInitiator
+	token   []byte
+	appData []byte
+}
+
+func (f *fakeGs2Initiator) SetChannelBindings(appData []byte) {
+	f.appData = appData
+}
+
+func (f *fakeGs2Initiator) InitSecContext(token []byte) ([]byte, bool, error) {
+	if token == nil {
+		if f.token != nil {
+			return f.token, false, nil
+		}
+		return fakeGs2InitialToken, false, nil
+	}
+	if string(token) != "acceptor" {
+		return nil, false, errors.New("unexpected token")
+	}
+	return nil, true, nil
+}
+
+// fakeGs2Acceptor is a Gs2Acceptor that accepts a single token, and returns a
+// single token to complete the mutual authentication.
+type fakeGs2Acceptor struct {
+	fakeGssAcceptor
+	token   []byte
+	appData []byte
+}
+
+func (f *fakeGs2Acceptor) SetChannelBindings(appData []byte) {
+	f.appData = appData
+}
+
+func (f *fakeGs2Acceptor) AcceptSecContext(token []byte) ([]byte, bool, error) {
+	f.token = token
+	if !bytes.Equal(token, fakeGs2InitialToken) {
+		return nil, false, errors.New("unexpected token")
+	}
+	return []byte("acceptor"), true, nil
+}
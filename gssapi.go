@@ -0,0 +1,474 @@
+package sasler
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrNoSecurityLayer is returned by the client-side implementation of the
+// GSSAPI mechanism if none of the security layers offered by the server is
+// acceptable.
+var ErrNoSecurityLayer = errors.New("sasler: no acceptable security layer")
+
+// Security layers that can be negotiated by the GSSAPI mechanism, as described
+// in [RFC 4752, section 3.3]. They can be combined to offer or accept multiple
+// security layers.
+//
+// [RFC 4752, section 3.3]: https://tools.ietf.org/html/rfc4752#section-3.3
+const (
+	GssapiNoSecurityLayer byte = 1 << iota
+	GssapiIntegrity
+	GssapiConfidentiality
+)
+
+// gssapiMaxBufSize is the maximum size of a wrapped message that is announced
+// to the other party when a security layer is offered or selected.
+const gssapiMaxBufSize = 0x10000
+
+// GssContext describes the per-message functions of an established GSS-API
+// security context, as described in [RFC 2743, section 2.3].
+//
+// [RFC 2743, section 2.3]: https://tools.ietf.org/html/rfc2743#section-2.3
+type GssContext interface {
+	// Wrap implements GSS_Wrap. It returns a token that contains msg, protected
+	// for integrity and, if conf is true, for confidentiality.
+	Wrap(msg []byte, conf bool) ([]byte, error)
+	// Unwrap implements GSS_Unwrap. It returns the message contained in token,
+	// and whether confidentiality was applied to it.
+	Unwrap(token []byte) (msg []byte, conf bool, err error)
+}
+
+// GssWrapSizeLimiter can be implemented by a GssContext to implement
+// GSS_Wrap_size_limit, as described in [RFC 2743, section 2.2.7]. It is used
+// to compute the maximum buffer size of the negotiated security layer. If it's
+// not implemented, the maximum buffer size announced by the other party is
+// used, without accounting for the overhead of wrapping.
+//
+// [RFC 2743, section 2.2.7]: https://tools.ietf.org/html/rfc2743#section-2.2.7
+type GssWrapSizeLimiter interface {
+	// WrapSizeLimit returns the maximum size of a message that can be passed
+	// to Wrap, such that the token doesn't exceed maxSize.
+	WrapSizeLimit(conf bool, maxSize int) int
+}
+
+// gssapiSecurityLayer is the SecurityLayer negotiated by the GSSAPI
+// mechanism.
+type gssapiSecurityLayer struct {
+	ctx        GssContext
+	conf       bool
+	maxBufSize int
+}
+
+// newGssapiSecurityLayer returns the SecurityLayer for the negotiated layer,
+// or nil if no security layer has been negotiated. The maxBufSize argument is
+// the maximum buffer size announced by the other party.
+func newGssapiSecurityLayer(ctx GssContext, layer byte, maxBufSize int) SecurityLayer {
+	if layer != GssapiIntegrity && layer != GssapiConfidentiality {
+		return nil
+	}
+	conf := layer == GssapiConfidentiality
+	if l, ok := ctx.(GssWrapSizeLimiter); ok {
+		maxBufSize = l.WrapSizeLimit(conf, maxBufSize)
+	}
+	return &gssapiSecurityLayer{ctx: ctx, conf: conf, maxBufSize: maxBufSize}
+}
+
+// Wrap wraps msg, applying confidentiality if it has been negotiated.
+func (l *gssapiSecurityLayer) Wrap(msg []byte) ([]byte, error) {
+	return l.ctx.Wrap(msg, l.conf)
+}
+
+// Unwrap unwraps buf, and returns ErrInvalidMessage if confidentiality has
+// been negotiated, but wasn't applied to buf.
+func (l *gssapiSecurityLayer) Unwrap(buf []byte) ([]byte, error) {
+	msg, conf, err := l.ctx.Unwrap(buf)
+	if err != nil {
+		return nil, err
+	}
+	if l.conf && !conf {
+		return nil, ErrInvalidMessage
+	}
+	return msg, nil
+}
+
+// MaxBufSize returns the maximum size of a message that can be passed to Wrap.
+func (l *gssapiSecurityLayer) MaxBufSize() int {
+	return l.maxBufSize
+}
+
+// GssInitiator is a GSS-API security context on the client-side, as used by
+// [GssapiClient]. It allows a Kerberos V5 implementation, or a fake in tests,
+// to be plugged into the GSSAPI mechanism.
+type GssInitiator interface {
+	GssContext
+	// InitSecContext implements GSS_Init_sec_context. It is first called with a
+	// nil token, and subsequently with each token received from the acceptor.
+	// It returns the token that must be sent to the acceptor, if any, and true
+	// once the security context has been established. Mutual authentication
+	// must be requested, and the context must support integrity protection.
+	InitSecContext(token []byte) (out []byte, established bool, err error)
+}
+
+// GssAcceptor is a GSS-API security context on the server-side, as used by
+// [GssapiServer]. It allows a Kerberos V5 implementation, or a fake in tests,
+// to be plugged into the GSSAPI mechanism.
+type GssAcceptor interface {
+	GssContext
+	// AcceptSecContext implements GSS_Accept_sec_context. It is called with each
+	// token received from the initiator. It returns the token that must be sent
+	// to the initiator, if any, and true once the security context has been
+	// established.
+	AcceptSecContext(token []byte) (out []byte, established bool, err error)
+	// SrcName returns the name of the authenticated initiator, such as a
+	// Kerberos principal. It is only called after the security context has been
+	// established.
+	SrcName() string
+}
+
+// gssapiClientMech is a ClientMech implementation of the GSSAPI mechanism.
+type gssapiClientMech struct {
+	authz     string
+	ctx       GssInitiator
+	layers    byte
+	layer     byte
+	maxBuf    int
+	dataFn    func([]byte) ([]byte, error)
+	completed bool
+	aborted   bool
+}
+
+// GssapiClient returns a ClientMech implementation for the GSSAPI mechanism,
+// as specified in [RFC 4752]. The layers argument is a combination of the
+// security layers the client is willing to use. The strongest security layer
+// offered by the server, and accepted by the client is selected.
+//
+// [RFC 4752]: https://tools.ietf.org/html/rfc4752
+func GssapiClient(authz string, ctx GssInitiator, layers byte) ClientMech {
+	m := &gssapiClientMech{authz: authz, ctx: ctx, layers: layers}
+	m.dataFn = m.initSecContext
+	return m
+}
+
+// Mech returns name GSSAPI, and true for client-first.
+func (*gssapiClientMech) Mech() (string, bool) {
+	return "GSSAPI", true
+}
+
+// Data relays tokens between the server and the GSS-API security context
+// until it has been established, and then responds to the security layer
+// negotiation of the server.
+func (m *gssapiClientMech) Data(challenge []byte) ([]byte, error) {
+	if m.dataFn == nil {
+		return nil, ErrInvalidState
+	}
+	return m.dataFn(challenge)
+}
+
+// Success accepts the success indication once the selected security layer has
+// been sent, and returns ErrInvalidState otherwise.
+func (m *gssapiClientMech) Success(data []byte) error {
+	if m.aborted {
+		return ErrAborted
+	}
+	return clientSuccess(data, m.Data, &m.completed)
+}
+
+// Abort drops the security context.
+func (m *gssapiClientMech) Abort() {
+	m.ctx = nil
+	m.dataFn = clientAborted
+	m.completed = false
+	m.aborted = true
+}
+
+// initSecContext passes the challenge to the security context, and returns its
+// output token.
+func (m *gssapiClientMech) initSecContext(challenge []byte) ([]byte, error) {
+	out, established, err := m.ctx.InitSecContext(challenge)
+	if err != nil {
+		m.dataFn = m.failed
+		return nil, err
+	}
+	if established {
+		m.dataFn = m.negotiate
+	}
+	if out == nil {
+		out = []byte{}
+	}
+	return out, nil
+}
+
+// negotiate unwraps the security layers offered by the server, and returns a
+// wrapped message containing the selected security layer and the authz.
+func (m *gssapiClientMech) negotiate(challenge []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	msg, _, err := m.ctx.Unwrap(challenge)
+	if err != nil {
+		return nil, ErrAuthenticationFailed
+	}
+	if len(msg) != 4 {
+		return nil, ErrInvalidMessage
+	}
+	offered := msg[0] & m.layers
+	switch {
+	case offered&GssapiConfidentiality != 0:
+		m.layer = GssapiConfidentiality
+	case offered&GssapiIntegrity != 0:
+		m.layer = GssapiIntegrity
+	case offered&GssapiNoSecurityLayer != 0:
+		m.layer = GssapiNoSecurityLayer
+	default:
+		return nil, ErrNoSecurityLayer
+	}
+	m.maxBuf = int(binary.BigEndian.Uint32(msg) & 0xffffff)
+	resp := make([]byte, 4+len(m.authz))
+	resp[0] = m.layer
+	if m.layer != GssapiNoSecurityLayer {
+		binary.BigEndian.PutUint32(resp[:4], uint32(m.layer)<<24|gssapiMaxBufSize)
+	}
+	copy(resp[4:], m.authz)
+	wrapped, err := m.ctx.Wrap(resp, false)
+	m.completed = err == nil
+	return wrapped, err
+}
+
+// SecurityLayer returns the selected security layer once the response to the
+// security layer negotiation has been sent, or nil if no security layer has
+// been selected.
+func (m *gssapiClientMech) SecurityLayer() SecurityLayer {
+	if !m.completed {
+		return nil
+	}
+	return newGssapiSecurityLayer(m.ctx, m.layer, m.maxBuf)
+}
+
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *gssapiClientMech) failed(challenge []byte) ([]byte, error) {
+	return nil, ErrInvalidState
+}
+
+// GssapiAuthenticator is supplied to [GssapiServer] to implement authz
+// derivation and authorization checking.
+type GssapiAuthenticator interface {
+	// DeriveAuthz derives an authz from an authn, which is the name of the
+	// authenticated initiator. It is only called when no authz has been
+	// requested by the client. Return the empty string if no authz can be
+	// derived from the supplied authn.
+	DeriveAuthz(authn string) string
+	// Authorize verifies whether an authn is authorized to use the requested or
+	// derived authz. Return false to fail authorization.
+	Authorize(authz, authn string) bool
+}
+
+// GssapiContextAuthenticator is the context-aware variant of
+// [GssapiAuthenticator], which is supplied to [GssapiServerContext] and
+// [Gs2ServerContext]. The context is the one passed to DataContext.
+type GssapiContextAuthenticator interface {
+	// DeriveAuthzContext is the context-aware variant of DeriveAuthz.
+	DeriveAuthzContext(ctx context.Context, authn string) string
+	// AuthorizeContext is the context-aware variant of Authorize.
+	AuthorizeContext(ctx context.Context, authz, authn string) bool
+}
+
+// gssapiContextAdapter adapts a GssapiAuthenticator to a
+// GssapiContextAuthenticator that ignores the context.
+type gssapiContextAdapter struct {
+	auth GssapiAuthenticator
+}
+
+func (a gssapiContextAdapter) DeriveAuthzContext(_ context.Context, authn string) string {
+	return a.auth.DeriveAuthz(authn)
+}
+
+func (a gssapiContextAdapter) AuthorizeContext(_ context.Context, authz, authn string) bool {
+	return a.auth.Authorize(authz, authn)
+}
+
+// gssapiServerMech is a ServerMech implementation of the GSSAPI mechanism.
+type gssapiServerMech struct {
+	authz     string
+	authn     string
+	ctx       GssAcceptor
+	layers    byte
+	layer     byte
+	maxBuf    int
+	completed bool
+	succeeded bool
+	aborted   bool
+	auth      GssapiContextAuthenticator
+	dataFn    func(context.Context, []byte) ([]byte, error)
+}
+
+// GssapiServer returns a ServerMech implementation for the GSSAPI mechanism,
+// as specified in [RFC 4752]. The layers argument is a combination of the
+// security layers that are offered to the client.
+//
+// [RFC 4752]: https://tools.ietf.org/html/rfc4752
+func GssapiServer(ctx GssAcceptor, layers byte, auth GssapiAuthenticator) ServerMech {
+	return GssapiServerContext(ctx, layers, gssapiContextAdapter{auth})
+}
+
+// GssapiServerContext is like [GssapiServer], but uses a context-aware
+// authenticator.
+func GssapiServerContext(ctx GssAcceptor, layers byte, auth GssapiContextAuthenticator) ServerMech {
+	m := &gssapiServerMech{ctx: ctx, layers: layers, auth: auth}
+	m.dataFn = m.acceptSecContext
+	return m
+}
+
+// Mech returns name GSSAPI, and true for client-first.
+func (*gssapiServerMech) Mech() (string, bool) {
+	return "GSSAPI", true
+}
+
+// Data relays tokens between the client and the GSS-API security context
+// until it has been established, and then negotiates the security layer and
+// authz with the client.
+func (m *gssapiServerMech) Data(data []byte) ([]byte, error) {
+	return m.DataContext(context.Background(), data)
+}
+
+// DataContext is like Data, but passes ctx on to the authenticator.
+func (m *gssapiServerMech) DataContext(ctx context.Context, data []byte) ([]byte, error) {
+	return serverDataContext(ctx, m, m.dataFn, data)
+}
+
+// acceptSecContext passes the response to the security context, and returns
+// its output token, or the security layer challenge once the context has been
+// established.
+func (m *gssapiServerMech) acceptSecContext(ctx context.Context, data []byte) ([]byte, error) {
+	out, established, err := m.ctx.AcceptSecContext(data)
+	if err != nil {
+		m.dataFn = m.failed
+		m.completed = true
+		return nil, wrapError("GSSAPI", "context token", ErrAuthenticationFailed, ReasonBadCredentials, err)
+	}
+	if !established {
+		if out == nil {
+			out = []byte{}
+		}
+		return out, nil
+	}
+	m.authn = m.ctx.SrcName()
+	if len(out) > 0 {
+		m.dataFn = m.emptyResponse
+		return out, nil
+	}
+	return m.offerSecurityLayers()
+}
+
+// emptyResponse accepts the empty response the client sends after receiving the
+// final token of the context establishment.
+func (m *gssapiServerMech) emptyResponse(ctx context.Context, data []byte) ([]byte, error) {
+	if len(data) > 0 {
+		m.dataFn = m.failed
+		m.completed = true
+		return nil, newError("GSSAPI", "empty response", ErrInvalidMessage, ReasonMalformedMessage)
+	}
+	return m.offerSecurityLayers()
+}
+
+// offerSecurityLayers returns a wrapped message containing the security layers
+// offered to the client.
+func (m *gssapiServerMech) offerSecurityLayers() ([]byte, error) {
+	offer := uint32(m.layers) << 24
+	if m.layers&^GssapiNoSecurityLayer != 0 {
+		offer |= gssapiMaxBufSize
+	}
+	var msg [4]byte
+	binary.BigEndian.PutUint32(msg[:], offer)
+	challenge, err := m.ctx.Wrap(msg[:], false)
+	if err != nil {
+		m.dataFn = m.failed
+		m.completed = true
+		return nil, err
+	}
+	m.dataFn = m.verifySecurityLayer
+	return challenge, nil
+}
+
+// verifySecurityLayer unwraps the response of the client, verifies that the
+// selected security layer was offered and that the maximum buffer size is zero
+// when no security layer was selected, and checks the authz.
+func (m *gssapiServerMech) verifySecurityLayer(ctx context.Context, data []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	m.completed = true
+	msg, _, err := m.ctx.Unwrap(data)
+	if err != nil {
+		return nil, wrapError("GSSAPI", "security layer", ErrAuthenticationFailed, ReasonBadProof, err)
+	}
+	if len(msg) < 4 {
+		return nil, newError("GSSAPI", "security layer", ErrInvalidMessage, ReasonMalformedMessage)
+	}
+	m.layer = msg[0]
+	if m.layer&m.layers == 0 || m.layer&(m.layer-1) != 0 {
+		return nil, malformedAttribute("GSSAPI", "security layer", "security-layer")
+	}
+	m.maxBuf = int(binary.BigEndian.Uint32(msg) & 0xffffff)
+	if m.layer == GssapiNoSecurityLayer && m.maxBuf != 0 {
+		return nil, malformedAttribute("GSSAPI", "security layer", "max-buffer-size")
+	}
+	m.authz = string(msg[4:])
+	if m.authz == "" {
+		m.authz = m.auth.DeriveAuthzContext(ctx, m.authn)
+		if m.authz == "" {
+			return nil, newError("GSSAPI", "security layer", ErrAuthenticationFailed, ReasonNoAuthz)
+		}
+	}
+	if !m.auth.AuthorizeContext(ctx, m.authz, m.authn) {
+		return nil, newError("GSSAPI", "security layer", ErrUnauthorized, ReasonUnauthorized)
+	}
+	m.succeeded = true
+	return nil, nil
+}
+
+// SecurityLayer returns the security layer selected by the client once
+// authentication has succeeded, or nil if no security layer has been
+// selected.
+func (m *gssapiServerMech) SecurityLayer() SecurityLayer {
+	if !m.succeeded {
+		return nil
+	}
+	return newGssapiSecurityLayer(m.ctx, m.layer, m.maxBuf)
+}
+
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *gssapiServerMech) failed(ctx context.Context, data []byte) ([]byte, error) {
+	return nil, ErrInvalidState
+}
+
+// HasCompleted returns true if authentication has finished, and if true, it
+// also returns the authorized authz, if any.
+func (m *gssapiServerMech) HasCompleted() (bool, string) {
+	switch {
+	case !m.completed:
+		return false, ""
+	case !m.succeeded:
+		return true, ""
+	}
+	return true, m.authz
+}
+
+// Result returns the name of the authenticated initiator as authn.
+func (m *gssapiServerMech) Result() *Result {
+	completed, authz := m.HasCompleted()
+	if !completed {
+		return nil
+	}
+	return &Result{Mech: "GSSAPI", Succeeded: m.succeeded, Aborted: m.aborted, Authn: m.authn, Authz: authz}
+}
+
+// Abort drops the security context, if authentication is still in progress.
+func (m *gssapiServerMech) Abort() {
+	if m.completed {
+		return
+	}
+	m.ctx = nil
+	m.dataFn = serverAborted
+	m.completed = true
+	m.aborted = true
+	m.authz = ""
+}
@@ -0,0 +1,419 @@
+package sasler_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/phedny/sasler"
+)
+
+func TestGssapiClient(t *testing.T) {
+	auth := sasler.GssapiClient("", &fakeGssInitiator{}, sasler.GssapiNoSecurityLayer)
+
+	gotName, gotClientFirst := auth.Mech()
+	expectedName := "GSSAPI"
+	if gotName != expectedName || !gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", true)`, gotName, gotClientFirst, expectedName)
+	}
+
+	gotIR, err := auth.Data(nil)
+	expectedIR := []byte("initiator-token")
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.Equal(gotIR, expectedIR) {
+		t.Fatalf(`Data(nil) returned %s; expected %s`, gotIR, expectedIR)
+	}
+
+	challenge := []byte("acceptor-token")
+	gotResponse, err := auth.Data(challenge)
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, challenge, err)
+	}
+	if gotResponse == nil || len(gotResponse) != 0 {
+		t.Fatalf(`Data("%s") returned %v; expected empty response`, challenge, gotResponse)
+	}
+
+	challenge = []byte("wrapped:\x07\x01\x00\x00")
+	gotResponse, err = auth.Data(challenge)
+	expectedResponse := []byte("wrapped:\x01\x00\x00\x00")
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, challenge, err)
+	}
+	if !bytes.Equal(gotResponse, expectedResponse) {
+		t.Fatalf(`Data("%s") returned %q; expected %q`, challenge, gotResponse, expectedResponse)
+	}
+
+	_, err = auth.Data(nil)
+	if !errors.Is(err, sasler.ErrInvalidState) {
+		t.Fatalf(`Data returned error: %v; expected ErrInvalidState`, err)
+	}
+}
+
+func TestGssapiClient_SelectsConfidentiality(t *testing.T) {
+	layers := sasler.GssapiIntegrity | sasler.GssapiConfidentiality
+	auth := sasler.GssapiClient("RequestedAuthz", &fakeGssInitiator{}, layers)
+
+	for _, challenge := range [][]byte{nil, []byte("acceptor-token")} {
+		if _, err := auth.Data(challenge); err != nil {
+			t.Fatalf(`Data("%s") returned error: %v`, challenge, err)
+		}
+	}
+
+	challenge := []byte("wrapped:\x07\x01\x00\x00")
+	gotResponse, err := auth.Data(challenge)
+	expectedResponse := []byte("wrapped:\x04\x01\x00\x00RequestedAuthz")
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, challenge, err)
+	}
+	if !bytes.Equal(gotResponse, expectedResponse) {
+		t.Fatalf(`Data("%s") returned %q; expected %q`, challenge, gotResponse, expectedResponse)
+	}
+}
+
+func TestGssapiClient_NoSecurityLayer(t *testing.T) {
+	auth := sasler.GssapiClient("", &fakeGssInitiator{}, sasler.GssapiConfidentiality)
+
+	for _, challenge := range [][]byte{nil, []byte("acceptor-token")} {
+		if _, err := auth.Data(challenge); err != nil {
+			t.Fatalf(`Data("%s") returned error: %v`, challenge, err)
+		}
+	}
+
+	challenge := []byte("wrapped:\x01\x00\x00\x00")
+	gotResponse, err := auth.Data(challenge)
+	if gotResponse != nil || !errors.Is(err, sasler.ErrNoSecurityLayer) {
+		t.Fatalf(`Data("%s") returned (%q, %v); expected (nil, ErrNoSecurityLayer)`, challenge, gotResponse, err)
+	}
+}
+
+func TestGssapiServer_DeriveAuthz(t *testing.T) {
+	auth := sasler.GssapiServer(&fakeGssAcceptor{}, sasler.GssapiNoSecurityLayer, &fakeGssapiAuthenticator{})
+
+	gotName, gotClientFirst := auth.Mech()
+	expectedName := "GSSAPI"
+	if gotName != expectedName || !gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", true)`, gotName, gotClientFirst, expectedName)
+	}
+
+	ir := []byte("initiator-token")
+	gotChallenge, err := auth.Data(ir)
+	expectedChallenge := []byte("acceptor-token")
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+	if !bytes.Equal(gotChallenge, expectedChallenge) {
+		t.Fatalf(`Data("%s") returned %s; expected %s`, ir, gotChallenge, expectedChallenge)
+	}
+
+	gotChallenge, err = auth.Data(nil)
+	expectedChallenge = []byte("wrapped:\x01\x00\x00\x00")
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.Equal(gotChallenge, expectedChallenge) {
+		t.Fatalf(`Data(nil) returned %q; expected %q`, gotChallenge, expectedChallenge)
+	}
+
+	gotCompleted, _ := auth.HasCompleted()
+	if gotCompleted {
+		t.Fatalf(`HasCompleted() returned true; expected false`)
+	}
+
+	response := []byte("wrapped:\x01\x00\x00\x00")
+	gotChallenge, err = auth.Data(response)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, nil)`, response, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestGssapiServer_RequestedAuthz(t *testing.T) {
+	layers := sasler.GssapiNoSecurityLayer | sasler.GssapiIntegrity
+	auth := sasler.GssapiServer(&fakeGssAcceptor{}, layers, &fakeGssapiAuthenticator{})
+
+	for _, response := range [][]byte{[]byte("initiator-token"), nil} {
+		if _, err := auth.Data(response); err != nil {
+			t.Fatalf(`Data("%s") returned error: %v`, response, err)
+		}
+	}
+
+	response := []byte("wrapped:\x02\x01\x00\x00RequestedAuthz")
+	gotChallenge, err := auth.Data(response)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, nil)`, response, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "RequestedAuthz"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestGssapiServer_LayerNotOffered(t *testing.T) {
+	auth := sasler.GssapiServer(&fakeGssAcceptor{}, sasler.GssapiNoSecurityLayer, &fakeGssapiAuthenticator{})
+
+	for _, response := range [][]byte{[]byte("initiator-token"), nil} {
+		if _, err := auth.Data(response); err != nil {
+			t.Fatalf(`Data("%s") returned error: %v`, response, err)
+		}
+	}
+
+	response := []byte("wrapped:\x04\x01\x00\x00")
+	gotChallenge, err := auth.Data(response)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrInvalidMessage) {
+		t.Fatalf(`Data("%s") returned (%q, %v); expected (nil, ErrInvalidMessage)`, response, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestGssapiServer_MaxBufWithoutLayer(t *testing.T) {
+	auth := sasler.GssapiServer(&fakeGssAcceptor{}, sasler.GssapiNoSecurityLayer, &fakeGssapiAuthenticator{})
+
+	for _, response := range [][]byte{[]byte("initiator-token"), nil} {
+		if _, err := auth.Data(response); err != nil {
+			t.Fatalf(`Data("%s") returned error: %v`, response, err)
+		}
+	}
+
+	response := []byte("wrapped:\x01\x00\x10\x00")
+	gotChallenge, err := auth.Data(response)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrInvalidMessage) {
+		t.Fatalf(`Data(%q) returned (%q, %v); expected (nil, ErrInvalidMessage)`, response, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestGssapiServer_Unauthorized(t *testing.T) {
+	auth := sasler.GssapiServer(&fakeGssAcceptor{}, sasler.GssapiNoSecurityLayer, &fakeGssapiAuthenticator{})
+
+	for _, response := range [][]byte{[]byte("initiator-token"), nil} {
+		if _, err := auth.Data(response); err != nil {
+			t.Fatalf(`Data("%s") returned error: %v`, response, err)
+		}
+	}
+
+	response := []byte("wrapped:\x01\x00\x00\x00InvalidAuthz")
+	gotChallenge, err := auth.Data(response)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrUnauthorized) {
+		t.Fatalf(`Data("%s") returned (%q, %v); expected (nil, ErrUnauthorized)`, response, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestGssapiServerContext(t *testing.T) {
+	auth := sasler.GssapiServerContext(&fakeGssAcceptor{}, sasler.GssapiNoSecurityLayer, &fakeGssapiContextAuthenticator{})
+
+	ctx := context.WithValue(context.Background(), fakeGssapiContextKey{}, "C")
+	for _, response := range [][]byte{[]byte("initiator-token"), nil} {
+		if _, err := auth.DataContext(ctx, response); err != nil {
+			t.Fatalf(`DataContext("%s") returned error: %v`, response, err)
+		}
+	}
+
+	response := []byte("wrapped:\x01\x00\x00\x00")
+	gotChallenge, err := auth.DataContext(ctx, response)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`DataContext("%s") returned ("%s", %v); expected (nil, nil)`, response, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "userC"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestGssapi_ClientServer(t *testing.T) {
+	client := sasler.GssapiClient("", &fakeGssInitiator{}, sasler.GssapiIntegrity)
+	server := sasler.GssapiServer(&fakeGssAcceptor{}, sasler.GssapiNoSecurityLayer|sasler.GssapiIntegrity, &fakeGssapiAuthenticator{})
+
+	var data []byte
+	var err error
+	for {
+		data, err = client.Data(data)
+		if err != nil {
+			t.Fatalf(`client.Data() returned error: %v`, err)
+		}
+		data, err = server.Data(data)
+		if err != nil {
+			t.Fatalf(`server.Data() returned error: %v`, err)
+		}
+		if completed, _ := server.HasCompleted(); completed {
+			break
+		}
+	}
+
+	gotCompleted, gotAuthz := server.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestGssapi_SecurityLayer(t *testing.T) {
+	client := sasler.GssapiClient("", &fakeGssInitiator{}, sasler.GssapiIntegrity)
+	server := sasler.GssapiServer(&fakeGssAcceptor{}, sasler.GssapiNoSecurityLayer|sasler.GssapiIntegrity, &fakeGssapiAuthenticator{})
+
+	if layer := sasler.SecurityLayerOf(server); layer != nil {
+		t.Fatalf(`SecurityLayerOf(server) returned %v before completion; expected nil`, layer)
+	}
+
+	var data []byte
+	var err error
+	for {
+		data, err = client.Data(data)
+		if err != nil {
+			t.Fatalf(`client.Data() returned error: %v`, err)
+		}
+		data, err = server.Data(data)
+		if err != nil {
+			t.Fatalf(`server.Data() returned error: %v`, err)
+		}
+		if completed, _ := server.HasCompleted(); completed {
+			break
+		}
+	}
+
+	clientLayer := sasler.SecurityLayerOf(client)
+	serverLayer := sasler.SecurityLayerOf(server)
+	if clientLayer == nil || serverLayer == nil {
+		t.Fatalf(`SecurityLayerOf() returned (%v, %v); expected security layers`, clientLayer, serverLayer)
+	}
+	if clientLayer.MaxBufSize() != 0x10000 || serverLayer.MaxBufSize() != 0x10000 {
+		t.Fatalf(`MaxBufSize() returned (%d, %d); expected (65536, 65536)`, clientLayer.MaxBufSize(), serverLayer.MaxBufSize())
+	}
+
+	msg := []byte("protected")
+	buf, err := clientLayer.Wrap(msg)
+	if err != nil {
+		t.Fatalf(`Wrap("%s") returned error: %v`, msg, err)
+	}
+	got, err := serverLayer.Unwrap(buf)
+	if err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf(`Unwrap(%q) returned (%q, %v); expected (%q, nil)`, buf, got, err, msg)
+	}
+}
+
+func TestGssapi_NoSecurityLayer(t *testing.T) {
+	auth := sasler.GssapiServer(&fakeGssAcceptor{}, sasler.GssapiNoSecurityLayer, &fakeGssapiAuthenticator{})
+
+	for _, response := range [][]byte{[]byte("initiator-token"), nil, []byte("wrapped:\x01\x00\x00\x00")} {
+		if _, err := auth.Data(response); err != nil {
+			t.Fatalf(`Data("%s") returned error: %v`, response, err)
+		}
+	}
+
+	if layer := sasler.SecurityLayerOf(auth); layer != nil {
+		t.Fatalf(`SecurityLayerOf() returned %v; expected nil`, layer)
+	}
+}
+
+// fakeGssInitiator is a GssInitiator that sends a single token, and expects a
+// single token in return.
+type fakeGssInitiator struct {
+	sent bool
+}
+
+func (f *fakeGssInitiator) InitSecContext(token []byte) ([]byte, bool, error) {
+	if !f.sent {
+		f.sent = true
+		return []byte("initiator-token"), false, nil
+	}
+	if string(token) != "acceptor-token" {
+		return nil, false, errors.New("unexpected token")
+	}
+	return nil, true, nil
+}
+
+func (*fakeGssInitiator) Wrap(msg []byte, conf bool) ([]byte, error) {
+	return fakeGssWrap(msg, conf)
+}
+
+func (*fakeGssInitiator) Unwrap(token []byte) ([]byte, bool, error) {
+	return fakeGssUnwrap(token)
+}
+
+// fakeGssAcceptor is a GssAcceptor that accepts a single token, and returns a
+// single token to complete the mutual authentication.
+type fakeGssAcceptor struct{}
+
+func (*fakeGssAcceptor) AcceptSecContext(token []byte) ([]byte, bool, error) {
+	if string(token) != "initiator-token" {
+		return nil, false, errors.New("unexpected token")
+	}
+	return []byte("acceptor-token"), true, nil
+}
+
+func (*fakeGssAcceptor) SrcName() string {
+	return "user"
+}
+
+func (*fakeGssAcceptor) Wrap(msg []byte, conf bool) ([]byte, error) {
+	return fakeGssWrap(msg, conf)
+}
+
+func (*fakeGssAcceptor) Unwrap(token []byte) ([]byte, bool, error) {
+	return fakeGssUnwrap(token)
+}
+
+func fakeGssWrap(msg []byte, conf bool) ([]byte, error) {
+	return append([]byte("wrapped:"), msg...), nil
+}
+
+func fakeGssUnwrap(token []byte) ([]byte, bool, error) {
+	msg, found := bytes.CutPrefix(token, []byte("wrapped:"))
+	if !found {
+		return nil, false, errors.New("invalid token")
+	}
+	return msg, false, nil
+}
+
+type fakeGssapiAuthenticator struct{}
+
+func (*fakeGssapiAuthenticator) DeriveAuthz(authn string) string {
+	return authn + "Z"
+}
+
+func (*fakeGssapiAuthenticator) Authorize(authz, authn string) bool {
+	return authz == authn+"Z" || authz == "RequestedAuthz"
+}
+
+// fakeGssapiContextKey is the context key of the value that
+// fakeGssapiContextAuthenticator uses as authz suffix.
+type fakeGssapiContextKey struct{}
+
+type fakeGssapiContextAuthenticator struct{}
+
+func (*fakeGssapiContextAuthenticator) DeriveAuthzContext(ctx context.Context, authn string) string {
+	suffix, _ := ctx.Value(fakeGssapiContextKey{}).(string)
+	return authn + suffix
+}
+
+func (*fakeGssapiContextAuthenticator) AuthorizeContext(ctx context.Context, authz, authn string) bool {
+	suffix, _ := ctx.Value(fakeGssapiContextKey{}).(string)
+	return authn+suffix == authz
+}
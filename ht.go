@@ -0,0 +1,431 @@
+package sasler
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrUnknownChannelBinding is returned when a mechanism is created with a
+// channel binding type that the mechanism doesn't support.
+var ErrUnknownChannelBinding = errors.New("sasler: unknown channel binding type")
+
+// htTokenSize is the size of the tokens issued by MemoryHtTokenStore.
+const htTokenSize = 32
+
+// htChannelBindings maps the channel binding types to the suffixes of the names
+// of the HT mechanisms.
+var htChannelBindings = map[string]string{
+	"tls-unique":           "UNIQ",
+	"tls-server-end-point": "ENDP",
+	"tls-exporter":         "EXPR",
+}
+
+// htMechName returns the name of the HT-SHA-256 mechanism that uses the
+// channel binding, or the NONE variant if cb is nil.
+func htMechName(cb *ChannelBinding) (string, error) {
+	if cb == nil {
+		return "HT-SHA-256-NONE", nil
+	}
+	suffix, ok := htChannelBindings[cb.Type]
+	if !ok {
+		return "", ErrUnknownChannelBinding
+	}
+	return "HT-SHA-256-" + suffix, nil
+}
+
+// htHashedToken returns the hashed token of the initiator or responder, as
+// described in [draft-schmaus-kitten-sasl-ht, section 3.1].
+//
+// [draft-schmaus-kitten-sasl-ht, section 3.1]: https://datatracker.ietf.org/doc/html/draft-schmaus-kitten-sasl-ht#section-3.1
+func htHashedToken(token []byte, role string, cb *ChannelBinding) []byte {
+	mac := hmac.New(sha256.New, token)
+	mac.Write([]byte(role))
+	if cb != nil {
+		mac.Write(cb.Data)
+	}
+	return mac.Sum(nil)
+}
+
+// htClientMech is an implementation of the HT-SHA-256 mechanisms.
+type htClientMech struct {
+	name      string
+	authn     string
+	token     []byte
+	cb        *ChannelBinding
+	dataFn    func([]byte) ([]byte, error)
+	completed bool
+	aborted   bool
+}
+
+// HtSha256Client returns a ClientMech implementation for the HT-SHA-256
+// mechanisms, as specified in [draft-schmaus-kitten-sasl-ht]. The token has
+// been issued by the server in an earlier session. The cb argument contains
+// the channel binding data of the connection with the server, and selects the
+// HT-SHA-256-UNIQ, HT-SHA-256-ENDP or HT-SHA-256-EXPR mechanism, based on its
+// type. If cb is nil, the HT-SHA-256-NONE mechanism is used. Returns
+// ErrUnknownChannelBinding if the channel binding type is not supported.
+//
+// [draft-schmaus-kitten-sasl-ht]: https://datatracker.ietf.org/doc/html/draft-schmaus-kitten-sasl-ht
+func HtSha256Client(authn string, token []byte, cb *ChannelBinding) (ClientMech, error) {
+	name, err := htMechName(cb)
+	if err != nil {
+		return nil, err
+	}
+	m := &htClientMech{name: name, authn: authn, token: token, cb: cb}
+	m.dataFn = m.initialResponse
+	return m, nil
+}
+
+// Mech returns the name of the mechanism, and true for client-first.
+func (m *htClientMech) Mech() (string, bool) {
+	return m.name, true
+}
+
+// Data returns authn and the hashed token of the initiator on the first call,
+// and verifies the hashed token of the responder on the second call.
+func (m *htClientMech) Data(challenge []byte) ([]byte, error) {
+	if m.dataFn == nil {
+		return nil, ErrInvalidState
+	}
+	return m.dataFn(challenge)
+}
+
+// Success verifies the hashed token of the responder if it's sent along with
+// the success indication. Returns ErrInvalidState if the hashed token of the
+// responder hasn't been verified.
+func (m *htClientMech) Success(data []byte) error {
+	if m.aborted {
+		return ErrAborted
+	}
+	return clientSuccess(data, m.Data, &m.completed)
+}
+
+// Abort drops the token.
+func (m *htClientMech) Abort() {
+	m.token = nil
+	m.dataFn = clientAborted
+	m.completed = false
+	m.aborted = true
+}
+
+// initialResponse returns authn and the hashed token of the initiator.
+func (m *htClientMech) initialResponse(challenge []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	if len(challenge) > 0 {
+		return nil, ErrInvalidMessage
+	}
+	var ir bytes.Buffer
+	ir.WriteString(m.authn)
+	ir.WriteByte(0)
+	ir.Write(htHashedToken(m.token, "Initiator", m.cb))
+	m.dataFn = m.verifyResponder
+	return ir.Bytes(), nil
+}
+
+// verifyResponder verifies that the server has proven possession of the token.
+func (m *htClientMech) verifyResponder(challenge []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	if !hmac.Equal(challenge, htHashedToken(m.token, "Responder", m.cb)) {
+		return nil, ErrAuthenticationFailed
+	}
+	m.completed = true
+	return nil, nil
+}
+
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *htClientMech) failed(challenge []byte) ([]byte, error) {
+	return nil, ErrInvalidState
+}
+
+// HtTokenStore stores the tokens that are issued to clients for use with the
+// HT-SHA-256 mechanisms. Implementations must be safe for concurrent use.
+type HtTokenStore interface {
+	// Issue generates, stores and returns a new token for an authn.
+	Issue(authn string) ([]byte, error)
+	// Rotate replaces a token of an authn with a new token, and returns the new
+	// token. Returns an error if the token is not valid for the authn.
+	Rotate(authn string, token []byte) ([]byte, error)
+	// Revoke removes a token of an authn, so it can no longer be used.
+	Revoke(authn string, token []byte) error
+	// Tokens returns the tokens that are currently valid for an authn.
+	Tokens(authn string) ([][]byte, error)
+}
+
+// HtAuthenticator is supplied to [HtSha256Server] to implement token retrieval
+// and authz derivation. The HT-SHA-256 mechanisms don't allow the client to
+// request an authz.
+type HtAuthenticator interface {
+	HtTokenStore
+	// DeriveAuthz derives an authz from an authn. Return the empty string if no
+	// authz can be derived from the supplied authn.
+	DeriveAuthz(authn string) string
+}
+
+// HtContextAuthenticator is the context-aware variant of [HtAuthenticator],
+// which is supplied to [HtSha256ServerContext]. Only the methods of
+// HtTokenStore that are used during authentication are included. The context
+// is the one passed to DataContext.
+type HtContextAuthenticator interface {
+	// TokensContext is the context-aware variant of Tokens.
+	TokensContext(ctx context.Context, authn string) ([][]byte, error)
+	// DeriveAuthzContext is the context-aware variant of DeriveAuthz.
+	DeriveAuthzContext(ctx context.Context, authn string) string
+}
+
+// htContextAdapter adapts an HtAuthenticator to an HtContextAuthenticator that
+// ignores the context.
+type htContextAdapter struct {
+	auth HtAuthenticator
+}
+
+func (a htContextAdapter) TokensContext(_ context.Context, authn string) ([][]byte, error) {
+	return a.auth.Tokens(authn)
+}
+
+func (a htContextAdapter) DeriveAuthzContext(_ context.Context, authn string) string {
+	return a.auth.DeriveAuthz(authn)
+}
+
+// htServerMech is an implementation of the HT-SHA-256 mechanisms.
+type htServerMech struct {
+	name      string
+	authz     string
+	authn     string
+	cb        *ChannelBinding
+	completed bool
+	aborted   bool
+	auth      HtContextAuthenticator
+	dataFn    func(context.Context, []byte) ([]byte, error)
+}
+
+// HtSha256Server returns a ServerMech implementation for the HT-SHA-256
+// mechanisms, as specified in [draft-schmaus-kitten-sasl-ht]. The cb argument
+// contains the channel binding data of the connection with the client, and
+// selects the mechanism as with [HtSha256Client]. Returns
+// ErrUnknownChannelBinding if the channel binding type is not supported.
+//
+// [draft-schmaus-kitten-sasl-ht]: https://datatracker.ietf.org/doc/html/draft-schmaus-kitten-sasl-ht
+func HtSha256Server(auth HtAuthenticator, cb *ChannelBinding) (ServerMech, error) {
+	return HtSha256ServerContext(htContextAdapter{auth}, cb)
+}
+
+// HtSha256ServerContext is like [HtSha256Server], but uses a context-aware
+// authenticator.
+func HtSha256ServerContext(auth HtContextAuthenticator, cb *ChannelBinding) (ServerMech, error) {
+	name, err := htMechName(cb)
+	if err != nil {
+		return nil, err
+	}
+	m := &htServerMech{name: name, cb: cb, auth: auth}
+	m.dataFn = m.verifyInitiator
+	return m, nil
+}
+
+// Mech returns the name of the mechanism, and true for client-first.
+func (m *htServerMech) Mech() (string, bool) {
+	return m.name, true
+}
+
+// Data verifies the hashed token of the initiator on the first call, and
+// returns the hashed token of the responder when authentication has completed
+// successfully.
+func (m *htServerMech) Data(data []byte) ([]byte, error) {
+	return m.DataContext(context.Background(), data)
+}
+
+// DataContext is like Data, but passes ctx on to the authenticator.
+func (m *htServerMech) DataContext(ctx context.Context, data []byte) ([]byte, error) {
+	return serverDataContext(ctx, m, m.dataFn, data)
+}
+
+// verifyInitiator verifies that the client has proven possession of a valid
+// token for the authn, and returns the hashed token of the responder.
+func (m *htServerMech) verifyInitiator(ctx context.Context, ir []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	m.completed = true
+	delim := bytes.IndexByte(ir, 0)
+	if delim < 1 || len(ir)-delim-1 != sha256.Size {
+		return nil, newError(m.name, "initial response", ErrInvalidMessage, ReasonMalformedMessage)
+	}
+	authn := string(ir[:delim])
+	m.authn = authn
+	tokens, err := m.auth.TokensContext(ctx, authn)
+	if err != nil {
+		return nil, wrapError(m.name, "initial response", ErrAuthenticationFailed, ReasonUnknownUser, err)
+	}
+	var token []byte
+	for _, t := range tokens {
+		if hmac.Equal(ir[delim+1:], htHashedToken(t, "Initiator", m.cb)) {
+			token = t
+			break
+		}
+	}
+	if token == nil {
+		return nil, newError(m.name, "initial response", ErrAuthenticationFailed, ReasonBadProof)
+	}
+	authz := m.auth.DeriveAuthzContext(ctx, authn)
+	if authz == "" {
+		return nil, newError(m.name, "initial response", ErrAuthenticationFailed, ReasonNoAuthz)
+	}
+	m.authz = authz
+	m.dataFn = m.ignoreOneMessage
+	return htHashedToken(token, "Responder", m.cb), nil
+}
+
+// ignoreOneMessage accepts the empty response the client sends after receiving
+// the hashed token of the responder.
+func (m *htServerMech) ignoreOneMessage(ctx context.Context, data []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	if len(data) > 0 {
+		return nil, newError(m.name, "empty response", ErrInvalidMessage, ReasonMalformedMessage)
+	}
+	return nil, nil
+}
+
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *htServerMech) failed(ctx context.Context, data []byte) ([]byte, error) {
+	return nil, ErrInvalidState
+}
+
+// HasCompleted returns true if authentication has finished, and if true, it
+// also returns the authorized authz, if any.
+func (m *htServerMech) HasCompleted() (bool, string) {
+	return m.completed, m.authz
+}
+
+// Result returns the authn whose token was verified, and reports channel
+// binding unless the mechanism is HT-SHA-256-NONE.
+func (m *htServerMech) Result() *Result {
+	if !m.completed {
+		return nil
+	}
+	return &Result{Mech: m.name, Succeeded: m.authz != "", Aborted: m.aborted, Authn: m.authn, Authz: m.authz, ChannelBinding: m.cb != nil}
+}
+
+// Abort fails the authentication process if Data hasn't been called yet.
+func (m *htServerMech) Abort() {
+	if m.completed {
+		return
+	}
+	m.dataFn = serverAborted
+	m.completed = true
+	m.aborted = true
+}
+
+// MemoryHtTokenStore is an HtTokenStore that keeps tokens in memory. Tokens
+// expire after the TTL that is passed to [NewMemoryHtTokenStore].
+type MemoryHtTokenStore struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	tokens map[string][]memoryHtToken
+}
+
+// memoryHtToken is a token stored by MemoryHtTokenStore.
+type memoryHtToken struct {
+	token  []byte
+	expiry time.Time
+}
+
+// NewMemoryHtTokenStore returns an empty MemoryHtTokenStore. Tokens expire
+// after ttl, or never expire if ttl is zero.
+func NewMemoryHtTokenStore(ttl time.Duration) *MemoryHtTokenStore {
+	return &MemoryHtTokenStore{ttl: ttl, tokens: make(map[string][]memoryHtToken)}
+}
+
+// Issue generates, stores and returns a new random token for an authn.
+func (s *MemoryHtTokenStore) Issue(authn string) ([]byte, error) {
+	token := make([]byte, htTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[authn] = append(s.valid(authn), s.newToken(token))
+	return token, nil
+}
+
+// Rotate replaces a token of an authn with a new random token, and returns the
+// new token. Returns ErrAuthenticationFailed if the token is not valid for the
+// authn.
+func (s *MemoryHtTokenStore) Rotate(authn string, token []byte) ([]byte, error) {
+	newToken := make([]byte, htTokenSize)
+	if _, err := rand.Read(newToken); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := s.valid(authn)
+	for i, t := range tokens {
+		if hmac.Equal(t.token, token) {
+			tokens[i] = s.newToken(newToken)
+			return newToken, nil
+		}
+	}
+	return nil, ErrAuthenticationFailed
+}
+
+// Revoke removes a token of an authn. Revoking a token that is not valid is not
+// an error.
+func (s *MemoryHtTokenStore) Revoke(authn string, token []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := s.valid(authn)
+	for i, t := range tokens {
+		if hmac.Equal(t.token, token) {
+			if len(tokens) == 1 {
+				delete(s.tokens, authn)
+			} else {
+				s.tokens[authn] = append(tokens[:i], tokens[i+1:]...)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// Tokens returns the tokens of an authn that have not expired.
+func (s *MemoryHtTokenStore) Tokens(authn string) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := s.valid(authn)
+	result := make([][]byte, len(tokens))
+	for i, t := range tokens {
+		result[i] = t.token
+	}
+	return result, nil
+}
+
+// newToken returns token with the expiry set according to the TTL.
+func (s *MemoryHtTokenStore) newToken(token []byte) memoryHtToken {
+	t := memoryHtToken{token: token}
+	if s.ttl != 0 {
+		t.expiry = time.Now().Add(s.ttl)
+	}
+	return t
+}
+
+// valid removes the expired tokens of an authn, and returns the tokens that
+// remain. It must be called with the mutex locked.
+func (s *MemoryHtTokenStore) valid(authn string) []memoryHtToken {
+	now := time.Now()
+	tokens := s.tokens[authn][:0]
+	for _, t := range s.tokens[authn] {
+		if t.expiry.IsZero() || now.Before(t.expiry) {
+			tokens = append(tokens, t)
+		}
+	}
+	if len(tokens) == 0 {
+		delete(s.tokens, authn)
+		return nil
+	}
+	s.tokens[authn] = tokens
+	return tokens
+}
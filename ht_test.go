@@ -0,0 +1,291 @@
+package sasler_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/phedny/sasler"
+)
+
+func TestHtClient(t *testing.T) {
+	token := []byte("secret-token")
+	auth, err := sasler.HtSha256Client("user", token, nil)
+	if err != nil {
+		t.Fatalf(`HtSha256Client() returned error: %v`, err)
+	}
+
+	gotName, gotClientFirst := auth.Mech()
+	expectedName := "HT-SHA-256-NONE"
+	if gotName != expectedName || !gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", true)`, gotName, gotClientFirst, expectedName)
+	}
+
+	gotIR, err := auth.Data(nil)
+	expectedIR := append([]byte("user\x00"), htHmac(token, "Initiator")...)
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.Equal(gotIR, expectedIR) {
+		t.Fatalf(`Data(nil) returned %q; expected %q`, gotIR, expectedIR)
+	}
+
+	challenge := htHmac(token, "Responder")
+	gotResponse, err := auth.Data(challenge)
+	if gotResponse != nil || err != nil {
+		t.Fatalf(`Data(challenge) returned (%q, %v); expected (nil, nil)`, gotResponse, err)
+	}
+}
+
+func TestHtClient_ChannelBinding(t *testing.T) {
+	token := []byte("secret-token")
+	cb := &sasler.ChannelBinding{Type: "tls-exporter", Data: []byte("cbdata")}
+	auth, err := sasler.HtSha256Client("user", token, cb)
+	if err != nil {
+		t.Fatalf(`HtSha256Client() returned error: %v`, err)
+	}
+
+	gotName, _ := auth.Mech()
+	expectedName := "HT-SHA-256-EXPR"
+	if gotName != expectedName {
+		t.Fatalf(`Name() returned "%s"; expected "%s"`, gotName, expectedName)
+	}
+
+	gotIR, err := auth.Data(nil)
+	expectedIR := append([]byte("user\x00"), htHmac(token, "Initiatorcbdata")...)
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.Equal(gotIR, expectedIR) {
+		t.Fatalf(`Data(nil) returned %q; expected %q`, gotIR, expectedIR)
+	}
+
+	challenge := htHmac(token, "Responder")
+	gotResponse, err := auth.Data(challenge)
+	if gotResponse != nil || !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Data(challenge) returned (%q, %v); expected (nil, ErrAuthenticationFailed)`, gotResponse, err)
+	}
+}
+
+func TestHtClient_MechNames(t *testing.T) {
+	tests := []struct {
+		cbType string
+		name   string
+	}{
+		{"tls-unique", "HT-SHA-256-UNIQ"},
+		{"tls-server-end-point", "HT-SHA-256-ENDP"},
+		{"tls-exporter", "HT-SHA-256-EXPR"},
+	}
+	for _, test := range tests {
+		auth, err := sasler.HtSha256Client("user", nil, &sasler.ChannelBinding{Type: test.cbType})
+		if err != nil {
+			t.Fatalf(`HtSha256Client() with type "%s" returned error: %v`, test.cbType, err)
+		}
+		gotName, _ := auth.Mech()
+		if gotName != test.name {
+			t.Fatalf(`Name() with type "%s" returned "%s"; expected "%s"`, test.cbType, gotName, test.name)
+		}
+	}
+
+	_, err := sasler.HtSha256Client("user", nil, &sasler.ChannelBinding{Type: "tls-foo"})
+	if !errors.Is(err, sasler.ErrUnknownChannelBinding) {
+		t.Fatalf(`HtSha256Client() returned error: %v; expected ErrUnknownChannelBinding`, err)
+	}
+}
+
+func TestHtServer(t *testing.T) {
+	store := &fakeHtAuthenticator{sasler.NewMemoryHtTokenStore(0)}
+	token, err := store.Issue("user")
+	if err != nil {
+		t.Fatalf(`Issue("user") returned error: %v`, err)
+	}
+	cb := &sasler.ChannelBinding{Type: "tls-server-end-point", Data: []byte("cbdata")}
+	client, err := sasler.HtSha256Client("user", token, cb)
+	if err != nil {
+		t.Fatalf(`HtSha256Client() returned error: %v`, err)
+	}
+	server, err := sasler.HtSha256Server(store, cb)
+	if err != nil {
+		t.Fatalf(`HtSha256Server() returned error: %v`, err)
+	}
+
+	gotName, gotClientFirst := server.Mech()
+	expectedName := "HT-SHA-256-ENDP"
+	if gotName != expectedName || !gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", true)`, gotName, gotClientFirst, expectedName)
+	}
+
+	ir, _ := client.Data(nil)
+	gotChallenge, err := server.Data(ir)
+	if err != nil {
+		t.Fatalf(`Data(%q) returned error: %v`, ir, err)
+	}
+
+	gotCompleted, gotAuthz := server.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+
+	gotResponse, err := client.Data(gotChallenge)
+	if gotResponse != nil || err != nil {
+		t.Fatalf(`Data(%q) returned (%q, %v); expected (nil, nil)`, gotChallenge, gotResponse, err)
+	}
+
+	gotChallenge, err = server.Data(nil)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data(nil) returned (%q, %v); expected (nil, nil)`, gotChallenge, err)
+	}
+}
+
+func TestHtServer_ChannelBindingMismatch(t *testing.T) {
+	store := &fakeHtAuthenticator{sasler.NewMemoryHtTokenStore(0)}
+	token, _ := store.Issue("user")
+	client, _ := sasler.HtSha256Client("user", token, &sasler.ChannelBinding{Type: "tls-exporter", Data: []byte("client")})
+	server, err := sasler.HtSha256Server(store, &sasler.ChannelBinding{Type: "tls-exporter", Data: []byte("server")})
+	if err != nil {
+		t.Fatalf(`HtSha256Server() returned error: %v`, err)
+	}
+
+	ir, _ := client.Data(nil)
+	gotChallenge, err := server.Data(ir)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Data(%q) returned (%q, %v); expected (nil, ErrAuthenticationFailed)`, ir, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := server.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestHtServer_RotatedToken(t *testing.T) {
+	store := &fakeHtAuthenticator{sasler.NewMemoryHtTokenStore(0)}
+	token, _ := store.Issue("user")
+	newToken, err := store.Rotate("user", token)
+	if err != nil {
+		t.Fatalf(`Rotate("user", token) returned error: %v`, err)
+	}
+
+	for _, test := range []struct {
+		token []byte
+		err   error
+	}{
+		{token, sasler.ErrAuthenticationFailed},
+		{newToken, nil},
+	} {
+		client, _ := sasler.HtSha256Client("user", test.token, nil)
+		server, _ := sasler.HtSha256Server(store, nil)
+		ir, _ := client.Data(nil)
+		if _, err := server.Data(ir); !errors.Is(err, test.err) {
+			t.Fatalf(`Data(%q) returned error: %v; expected %v`, ir, err, test.err)
+		}
+	}
+}
+
+func TestHtServerContext(t *testing.T) {
+	store := &fakeHtContextAuthenticator{sasler.NewMemoryHtTokenStore(0)}
+	token, err := store.Issue("user")
+	if err != nil {
+		t.Fatalf(`Issue("user") returned error: %v`, err)
+	}
+	client, err := sasler.HtSha256Client("user", token, nil)
+	if err != nil {
+		t.Fatalf(`HtSha256Client() returned error: %v`, err)
+	}
+	server, err := sasler.HtSha256ServerContext(store, nil)
+	if err != nil {
+		t.Fatalf(`HtSha256ServerContext() returned error: %v`, err)
+	}
+
+	ctx := context.WithValue(context.Background(), fakeHtContextKey{}, "C")
+	ir, _ := client.Data(nil)
+	if _, err := server.DataContext(ctx, ir); err != nil {
+		t.Fatalf(`DataContext(%q) returned error: %v`, ir, err)
+	}
+
+	gotCompleted, gotAuthz := server.HasCompleted()
+	expectedAuthz := "userC"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestMemoryHtTokenStore(t *testing.T) {
+	store := sasler.NewMemoryHtTokenStore(0)
+	token1, _ := store.Issue("user")
+	token2, _ := store.Issue("user")
+	if bytes.Equal(token1, token2) {
+		t.Fatalf(`Issue("user") returned the same token twice`)
+	}
+
+	tokens, err := store.Tokens("user")
+	if err != nil || len(tokens) != 2 {
+		t.Fatalf(`Tokens("user") returned (%d tokens, %v); expected (2 tokens, nil)`, len(tokens), err)
+	}
+
+	if err := store.Revoke("user", token1); err != nil {
+		t.Fatalf(`Revoke("user", token1) returned error: %v`, err)
+	}
+	tokens, _ = store.Tokens("user")
+	if len(tokens) != 1 || !bytes.Equal(tokens[0], token2) {
+		t.Fatalf(`Tokens("user") returned %q; expected [%q]`, tokens, token2)
+	}
+
+	if _, err := store.Rotate("user", token1); !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Rotate("user", token1) returned error: %v; expected ErrAuthenticationFailed`, err)
+	}
+	if _, err := store.Rotate("other", token2); !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Rotate("other", token2) returned error: %v; expected ErrAuthenticationFailed`, err)
+	}
+}
+
+func TestMemoryHtTokenStore_Expiry(t *testing.T) {
+	store := sasler.NewMemoryHtTokenStore(time.Nanosecond)
+	if _, err := store.Issue("user"); err != nil {
+		t.Fatalf(`Issue("user") returned error: %v`, err)
+	}
+	time.Sleep(time.Millisecond)
+
+	tokens, err := store.Tokens("user")
+	if err != nil || len(tokens) != 0 {
+		t.Fatalf(`Tokens("user") returned (%d tokens, %v); expected (0 tokens, nil)`, len(tokens), err)
+	}
+}
+
+// htHmac returns HMAC-SHA-256 of the message, keyed with the token.
+func htHmac(token []byte, message string) []byte {
+	mac := hmac.New(sha256.New, token)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+type fakeHtAuthenticator struct {
+	*sasler.MemoryHtTokenStore
+}
+
+func (*fakeHtAuthenticator) DeriveAuthz(authn string) string {
+	return authn + "Z"
+}
+
+// fakeHtContextKey is the context key of the value that
+// fakeHtContextAuthenticator uses as authz suffix.
+type fakeHtContextKey struct{}
+
+type fakeHtContextAuthenticator struct {
+	*sasler.MemoryHtTokenStore
+}
+
+func (f *fakeHtContextAuthenticator) TokensContext(ctx context.Context, authn string) ([][]byte, error) {
+	return f.Tokens(authn)
+}
+
+func (*fakeHtContextAuthenticator) DeriveAuthzContext(ctx context.Context, authn string) string {
+	suffix, _ := ctx.Value(fakeHtContextKey{}).(string)
+	return authn + suffix
+}
@@ -0,0 +1,692 @@
+package sasler
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+)
+
+// iso9798RandomSize is the size of the random numbers generated by client and
+// server.
+const iso9798RandomSize = 16
+
+// Context-specific tags of the optional and tagged fields of the tokens.
+const (
+	iso9798TagEntity = 0
+	iso9798TagCert   = 1
+	iso9798TagAuthID = 2
+)
+
+// iso9798Rfc822Name is the context-specific tag of the rfc822Name choice of a
+// GeneralName, used to carry the authz.
+const iso9798Rfc822Name = 1
+
+// oidSignatureECDSAWithSHA256 is the OID of the ecdsa-with-SHA256 signature
+// algorithm, as specified in RFC 5758.
+var oidSignatureECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+
+// iso9798Signature is the SIGNATURE type of RFC 3163.
+type iso9798Signature struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Signature asn1.BitString
+}
+
+// iso9798ClientMech is an implementation of the 9798-U-ECDSA-SHA256 and
+// 9798-M-ECDSA-SHA256 mechanisms.
+type iso9798ClientMech struct {
+	authz     string
+	key       *ecdsa.PrivateKey
+	certs     []*x509.Certificate
+	verify    func(certs []*x509.Certificate) error
+	randomA   []byte
+	randomB   []byte
+	dataFn    func([]byte) ([]byte, error)
+	completed bool
+	aborted   bool
+}
+
+// Iso9798UEcdsaSha256Client returns a ClientMech implementation for the
+// 9798-U-ECDSA-SHA256 mechanism, which provides unilateral authentication of
+// the client, as specified in [RFC 3163]. The certs argument contains the
+// certificate of the client, followed by any intermediate certificates, and
+// key is the private key of the client certificate. Returns ErrWrongCurve if
+// the private key doesn't use the curve returned by elliptic.P256().
+//
+// [RFC 3163]: https://tools.ietf.org/html/rfc3163
+func Iso9798UEcdsaSha256Client(authz string, key *ecdsa.PrivateKey, certs []*x509.Certificate) (ClientMech, error) {
+	return newIso9798Client(authz, key, certs, nil)
+}
+
+// Iso9798MEcdsaSha256Client returns a ClientMech implementation for the
+// 9798-M-ECDSA-SHA256 mechanism, which provides mutual authentication of
+// client and server, as specified in [RFC 3163]. The certs and key arguments
+// are used as in [Iso9798UEcdsaSha256Client]. The verify function is called
+// with the certificates supplied by the server, and must return an error if
+// they are not trusted to identify the server. Returns ErrWrongCurve if the
+// private key doesn't use the curve returned by elliptic.P256().
+//
+// [RFC 3163]: https://tools.ietf.org/html/rfc3163
+func Iso9798MEcdsaSha256Client(authz string, key *ecdsa.PrivateKey, certs []*x509.Certificate, verify func(certs []*x509.Certificate) error) (ClientMech, error) {
+	return newIso9798Client(authz, key, certs, verify)
+}
+
+// newIso9798Client returns a client for the mutual mechanism if verify is not
+// nil, or for the unilateral mechanism otherwise.
+func newIso9798Client(authz string, key *ecdsa.PrivateKey, certs []*x509.Certificate, verify func(certs []*x509.Certificate) error) (ClientMech, error) {
+	if _, err := ecdsaP256PublicKey(key.Public()); err != nil {
+		return nil, err
+	}
+	m := &iso9798ClientMech{authz: authz, key: key, certs: certs, verify: verify}
+	m.dataFn = m.tokenAB
+	return m, nil
+}
+
+// Mech returns name 9798-U-ECDSA-SHA256 or 9798-M-ECDSA-SHA256, and false for
+// server-first.
+func (m *iso9798ClientMech) Mech() (string, bool) {
+	if m.verify != nil {
+		return "9798-M-ECDSA-SHA256", false
+	}
+	return "9798-U-ECDSA-SHA256", false
+}
+
+// Data returns TokenAB in response to TokenBA1 on the first call, and verifies
+// TokenBA2 on the second call of the mutual mechanism.
+func (m *iso9798ClientMech) Data(challenge []byte) ([]byte, error) {
+	if m.dataFn == nil {
+		return nil, ErrInvalidState
+	}
+	return m.dataFn(challenge)
+}
+
+// Success verifies TokenBA2 if it's sent along with the success indication.
+// Returns ErrInvalidState if TokenAB hasn't been sent, or if TokenBA2 of the
+// mutual mechanism hasn't been verified.
+func (m *iso9798ClientMech) Success(data []byte) error {
+	if m.aborted {
+		return ErrAborted
+	}
+	return clientSuccess(data, m.Data, &m.completed)
+}
+
+// Abort drops the private key.
+func (m *iso9798ClientMech) Abort() {
+	m.key = nil
+	m.randomA = nil
+	m.randomB = nil
+	m.dataFn = clientAborted
+	m.completed = false
+	m.aborted = true
+}
+
+// tokenAB parses TokenBA1, and returns TokenAB that proves possession of the
+// private key of the client.
+func (m *iso9798ClientMech) tokenAB(challenge []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	fields, err := parseIso9798Token(challenge)
+	if err != nil || len(fields) == 0 || !isIso9798Random(fields[0]) {
+		return nil, ErrInvalidMessage
+	}
+	m.randomB = fields[0].Bytes
+	if m.randomA, err = iso9798Random(); err != nil {
+		return nil, err
+	}
+	signed := [][]byte{
+		marshalIso9798Random(m.randomA),
+		marshalIso9798Random(m.randomB),
+	}
+	if m.authz != "" {
+		authID, err := marshalIso9798AuthID(m.authz)
+		if err != nil {
+			return nil, err
+		}
+		signed = append(signed, authID)
+	}
+	signature, err := iso9798Sign(m.key, signed...)
+	if err != nil {
+		return nil, err
+	}
+	certA, err := marshalIso9798Certs(m.certs)
+	if err != nil {
+		return nil, err
+	}
+	token := append(signed[:2:2], certA)
+	token = append(token, signed[2:]...)
+	token = append(token, signature)
+	if m.verify != nil {
+		m.dataFn = m.verifyTokenBA2
+	} else {
+		m.completed = true
+	}
+	return marshalIso9798Token(token...)
+}
+
+// verifyTokenBA2 verifies that TokenBA2 proves possession of the private key of
+// a server certificate that is trusted by the verify function.
+func (m *iso9798ClientMech) verifyTokenBA2(challenge []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	fields, err := parseIso9798Token(challenge)
+	if err != nil || len(fields) < 4 {
+		return nil, ErrInvalidMessage
+	}
+	if !isIso9798Random(fields[0]) || !isIso9798Random(fields[1]) {
+		return nil, ErrInvalidMessage
+	}
+	if !bytes.Equal(fields[0].Bytes, m.randomB) || !bytes.Equal(fields[1].Bytes, m.randomA) {
+		return nil, ErrAuthenticationFailed
+	}
+	signed := []asn1.RawValue{fields[0], fields[1]}
+	fields = fields[2:]
+	if isIso9798Tagged(fields[0], iso9798TagEntity) {
+		signed = append(signed, fields[0])
+		fields = fields[1:]
+	}
+	if len(fields) != 2 || !isIso9798Tagged(fields[0], iso9798TagCert) {
+		return nil, ErrInvalidMessage
+	}
+	certs, err := parseIso9798Certs(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdsaP256PublicKey(certs[0].PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !iso9798Verify(key, fields[1], signed...) {
+		return nil, ErrAuthenticationFailed
+	}
+	if err := m.verify(certs); err != nil {
+		return nil, ErrAuthenticationFailed
+	}
+	m.completed = true
+	return nil, nil
+}
+
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *iso9798ClientMech) failed(challenge []byte) ([]byte, error) {
+	return nil, ErrInvalidState
+}
+
+// Iso9798Authenticator is supplied to [Iso9798UEcdsaSha256Server] and
+// [Iso9798MEcdsaSha256Server] to implement certificate verification, authz
+// derivation, and authorization checking.
+type Iso9798Authenticator interface {
+	// VerifyCertificate verifies the certificates supplied by the client, where
+	// the first certificate is the client certificate, and any subsequent
+	// certificates are intermediate certificates. It returns the authn that is
+	// identified by the client certificate, or an error to fail authentication.
+	VerifyCertificate(certs []*x509.Certificate) (authn string, err error)
+	// DeriveAuthz derives an authz from an authn. It is only called when no
+	// authz has been requested by the client. Return the empty string if no
+	// authz can be derived from the supplied authn.
+	DeriveAuthz(authn string) string
+	// Authorize verifies whether an authn is authorized to use the requested or
+	// derived authz. Return false to fail authorization.
+	Authorize(authz, authn string) bool
+}
+
+// Iso9798ContextAuthenticator is the context-aware variant of
+// [Iso9798Authenticator], which is supplied to
+// [Iso9798UEcdsaSha256ServerContext] and [Iso9798MEcdsaSha256ServerContext].
+// The context is the one passed to DataContext.
+type Iso9798ContextAuthenticator interface {
+	// VerifyCertificateContext is the context-aware variant of
+	// VerifyCertificate.
+	VerifyCertificateContext(ctx context.Context, certs []*x509.Certificate) (authn string, err error)
+	// DeriveAuthzContext is the context-aware variant of DeriveAuthz.
+	DeriveAuthzContext(ctx context.Context, authn string) string
+	// AuthorizeContext is the context-aware variant of Authorize.
+	AuthorizeContext(ctx context.Context, authz, authn string) bool
+}
+
+// iso9798ContextAdapter adapts an Iso9798Authenticator to an
+// Iso9798ContextAuthenticator that ignores the context.
+type iso9798ContextAdapter struct {
+	auth Iso9798Authenticator
+}
+
+func (a iso9798ContextAdapter) VerifyCertificateContext(_ context.Context, certs []*x509.Certificate) (string, error) {
+	return a.auth.VerifyCertificate(certs)
+}
+
+func (a iso9798ContextAdapter) DeriveAuthzContext(_ context.Context, authn string) string {
+	return a.auth.DeriveAuthz(authn)
+}
+
+func (a iso9798ContextAdapter) AuthorizeContext(_ context.Context, authz, authn string) bool {
+	return a.auth.Authorize(authz, authn)
+}
+
+// iso9798ServerMech is an implementation of the 9798-U-ECDSA-SHA256 and
+// 9798-M-ECDSA-SHA256 mechanisms.
+type iso9798ServerMech struct {
+	authz     string
+	authn     string
+	mutual    bool
+	key       *ecdsa.PrivateKey
+	certs     []*x509.Certificate
+	randomB   []byte
+	clientKey *ecdsa.PublicKey
+	completed bool
+	succeeded bool
+	aborted   bool
+	auth      Iso9798ContextAuthenticator
+	dataFn    func(context.Context, []byte) ([]byte, error)
+}
+
+// Iso9798UEcdsaSha256Server returns a ServerMech implementation for the
+// 9798-U-ECDSA-SHA256 mechanism, as specified in [RFC 3163].
+//
+// [RFC 3163]: https://tools.ietf.org/html/rfc3163
+func Iso9798UEcdsaSha256Server(auth Iso9798Authenticator) ServerMech {
+	return Iso9798UEcdsaSha256ServerContext(iso9798ContextAdapter{auth})
+}
+
+// Iso9798UEcdsaSha256ServerContext is like [Iso9798UEcdsaSha256Server], but
+// uses a context-aware authenticator.
+func Iso9798UEcdsaSha256ServerContext(auth Iso9798ContextAuthenticator) ServerMech {
+	m := &iso9798ServerMech{auth: auth}
+	m.dataFn = m.tokenBA1
+	return m
+}
+
+// Iso9798MEcdsaSha256Server returns a ServerMech implementation for the
+// 9798-M-ECDSA-SHA256 mechanism, as specified in [RFC 3163]. The certs
+// argument contains the certificate of the server, followed by any
+// intermediate certificates, and key is the private key of the server
+// certificate. Returns ErrWrongCurve if the private key doesn't use the curve
+// returned by elliptic.P256().
+//
+// [RFC 3163]: https://tools.ietf.org/html/rfc3163
+func Iso9798MEcdsaSha256Server(auth Iso9798Authenticator, key *ecdsa.PrivateKey, certs []*x509.Certificate) (ServerMech, error) {
+	return Iso9798MEcdsaSha256ServerContext(iso9798ContextAdapter{auth}, key, certs)
+}
+
+// Iso9798MEcdsaSha256ServerContext is like [Iso9798MEcdsaSha256Server], but
+// uses a context-aware authenticator.
+func Iso9798MEcdsaSha256ServerContext(auth Iso9798ContextAuthenticator, key *ecdsa.PrivateKey, certs []*x509.Certificate) (ServerMech, error) {
+	if _, err := ecdsaP256PublicKey(key.Public()); err != nil {
+		return nil, err
+	}
+	m := &iso9798ServerMech{auth: auth, mutual: true, key: key, certs: certs}
+	m.dataFn = m.tokenBA1
+	return m, nil
+}
+
+// Mech returns name 9798-U-ECDSA-SHA256 or 9798-M-ECDSA-SHA256, and false for
+// server-first.
+func (m *iso9798ServerMech) Mech() (string, bool) {
+	if m.mutual {
+		return "9798-M-ECDSA-SHA256", false
+	}
+	return "9798-U-ECDSA-SHA256", false
+}
+
+// Data returns TokenBA1 on the first call, and verifies TokenAB on the second
+// call. The mutual mechanism returns TokenBA2 when authentication has
+// completed successfully.
+func (m *iso9798ServerMech) Data(data []byte) ([]byte, error) {
+	return m.DataContext(context.Background(), data)
+}
+
+// DataContext is like Data, but passes ctx on to the authenticator.
+func (m *iso9798ServerMech) DataContext(ctx context.Context, data []byte) ([]byte, error) {
+	return serverDataContext(ctx, m, m.dataFn, data)
+}
+
+// tokenBA1 returns TokenBA1 that contains the random number of the server.
+func (m *iso9798ServerMech) tokenBA1(ctx context.Context, data []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	if len(data) > 0 {
+		m.completed = true
+		name, _ := m.Mech()
+		return nil, newError(name, "initial response", ErrInvalidMessage, ReasonMalformedMessage)
+	}
+	randomB, err := iso9798Random()
+	if err != nil {
+		m.completed = true
+		return nil, err
+	}
+	m.randomB = randomB
+	m.dataFn = m.verifyTokenAB
+	return marshalIso9798Token(marshalIso9798Random(randomB))
+}
+
+// verifyTokenAB verifies that TokenAB proves possession of the private key of
+// the client certificate, and authorizes the authz. The mutual mechanism
+// returns TokenBA2 that proves possession of the private key of the server.
+func (m *iso9798ServerMech) verifyTokenAB(ctx context.Context, data []byte) ([]byte, error) {
+	name, _ := m.Mech()
+	m.dataFn = m.failed
+	m.completed = true
+	fields, err := parseIso9798Token(data)
+	if err != nil || len(fields) < 4 {
+		return nil, newError(name, "TokenAB", ErrInvalidMessage, ReasonMalformedMessage)
+	}
+	if !isIso9798Random(fields[0]) || !isIso9798Random(fields[1]) {
+		return nil, malformedAttribute(name, "TokenAB", "randomNumber")
+	}
+	if !bytes.Equal(fields[1].Bytes, m.randomB) {
+		return nil, newError(name, "TokenAB", ErrAuthenticationFailed, ReasonNonceMismatch)
+	}
+	randomA := fields[0]
+	signed := []asn1.RawValue{fields[0], fields[1]}
+	fields = fields[2:]
+	if isIso9798Tagged(fields[0], iso9798TagEntity) {
+		signed = append(signed, fields[0])
+		fields = fields[1:]
+	}
+	if len(fields) < 2 || !isIso9798Tagged(fields[0], iso9798TagCert) {
+		return nil, malformedAttribute(name, "TokenAB", "certificate")
+	}
+	certs, err := parseIso9798Certs(fields[0])
+	if err != nil {
+		return nil, malformedAttribute(name, "TokenAB", "certificate")
+	}
+	fields = fields[1:]
+	if isIso9798Tagged(fields[0], iso9798TagAuthID) {
+		authz, err := parseIso9798AuthID(fields[0])
+		if err != nil {
+			return nil, malformedAttribute(name, "TokenAB", "authID")
+		}
+		m.authz = authz
+		signed = append(signed, fields[0])
+		fields = fields[1:]
+	}
+	if len(fields) != 1 {
+		return nil, malformedAttribute(name, "TokenAB", "signature")
+	}
+	key, err := ecdsaP256PublicKey(certs[0].PublicKey)
+	if err != nil {
+		return nil, wrapError(name, "TokenAB", ErrAuthenticationFailed, ReasonBadCredentials, err)
+	}
+	if !iso9798Verify(key, fields[0], signed...) {
+		return nil, newError(name, "TokenAB", ErrAuthenticationFailed, ReasonBadProof)
+	}
+	authn, err := m.auth.VerifyCertificateContext(ctx, certs)
+	if err != nil || authn == "" {
+		return nil, wrapError(name, "TokenAB", ErrAuthenticationFailed, ReasonBadCredentials, err)
+	}
+	m.authn = authn
+	m.clientKey = key
+	if m.authz == "" {
+		m.authz = m.auth.DeriveAuthzContext(ctx, m.authn)
+		if m.authz == "" {
+			return nil, newError(name, "TokenAB", ErrAuthenticationFailed, ReasonNoAuthz)
+		}
+	}
+	if !m.auth.AuthorizeContext(ctx, m.authz, m.authn) {
+		m.authz = ""
+		return nil, newError(name, "TokenAB", ErrUnauthorized, ReasonUnauthorized)
+	}
+	if !m.mutual {
+		m.succeeded = true
+		return nil, nil
+	}
+	token, err := m.tokenBA2(randomA)
+	if err != nil {
+		m.authz = ""
+		return nil, err
+	}
+	m.succeeded = true
+	m.dataFn = m.ignoreOneMessage
+	return token, nil
+}
+
+// tokenBA2 returns TokenBA2 that proves possession of the private key of the
+// server certificate.
+func (m *iso9798ServerMech) tokenBA2(randomA asn1.RawValue) ([]byte, error) {
+	signed := [][]byte{marshalIso9798Random(m.randomB), randomA.FullBytes}
+	signature, err := iso9798Sign(m.key, signed...)
+	if err != nil {
+		return nil, err
+	}
+	certB, err := marshalIso9798Certs(m.certs)
+	if err != nil {
+		return nil, err
+	}
+	return marshalIso9798Token(signed[0], signed[1], certB, signature)
+}
+
+// ignoreOneMessage accepts the empty response the client sends after receiving
+// TokenBA2.
+func (m *iso9798ServerMech) ignoreOneMessage(ctx context.Context, data []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	if len(data) > 0 {
+		name, _ := m.Mech()
+		return nil, newError(name, "empty response", ErrInvalidMessage, ReasonMalformedMessage)
+	}
+	return nil, nil
+}
+
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *iso9798ServerMech) failed(ctx context.Context, data []byte) ([]byte, error) {
+	return nil, ErrInvalidState
+}
+
+// HasCompleted returns true if authentication has finished, and if true, it
+// also returns the authorized authz, if any.
+func (m *iso9798ServerMech) HasCompleted() (bool, string) {
+	switch {
+	case !m.completed:
+		return false, ""
+	case !m.succeeded:
+		return true, ""
+	}
+	return true, m.authz
+}
+
+// Result returns the authn identified by the client certificate, and the
+// public key of the client certificate if authentication has succeeded.
+func (m *iso9798ServerMech) Result() *Result {
+	completed, authz := m.HasCompleted()
+	if !completed {
+		return nil
+	}
+	name, _ := m.Mech()
+	result := &Result{Mech: name, Succeeded: m.succeeded, Aborted: m.aborted, Authn: m.authn, Authz: authz}
+	if m.succeeded {
+		result.PublicKey = m.clientKey
+	}
+	return result
+}
+
+// Abort drops the random number of the server, if authentication is still in
+// progress.
+func (m *iso9798ServerMech) Abort() {
+	if m.completed {
+		return
+	}
+	m.randomB = nil
+	m.dataFn = serverAborted
+	m.completed = true
+	m.aborted = true
+}
+
+// iso9798Random returns a new random number.
+func iso9798Random() ([]byte, error) {
+	random := make([]byte, iso9798RandomSize)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	return random, nil
+}
+
+// marshalIso9798Random returns the DER encoding of a random number.
+func marshalIso9798Random(random []byte) []byte {
+	b, _ := asn1.Marshal(random)
+	return b
+}
+
+// isIso9798Random returns whether the value is an OCTET STRING that contains a
+// random number of at least 8 bytes.
+func isIso9798Random(v asn1.RawValue) bool {
+	return v.Class == asn1.ClassUniversal && v.Tag == asn1.TagOctetString && !v.IsCompound && len(v.Bytes) >= 8
+}
+
+// isIso9798Tagged returns whether the value has the context-specific tag.
+func isIso9798Tagged(v asn1.RawValue, tag int) bool {
+	return v.Class == asn1.ClassContextSpecific && v.Tag == tag && v.IsCompound
+}
+
+// marshalIso9798Token returns a SEQUENCE of the DER encoded fields.
+func marshalIso9798Token(fields ...[]byte) ([]byte, error) {
+	return asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        asn1.TagSequence,
+		IsCompound: true,
+		Bytes:      bytes.Join(fields, nil),
+	})
+}
+
+// parseIso9798Token parses a SEQUENCE and returns its fields.
+func parseIso9798Token(b []byte) ([]asn1.RawValue, error) {
+	var token asn1.RawValue
+	if rest, err := asn1.Unmarshal(b, &token); err != nil || len(rest) > 0 {
+		return nil, ErrInvalidMessage
+	}
+	if token.Class != asn1.ClassUniversal || token.Tag != asn1.TagSequence || !token.IsCompound {
+		return nil, ErrInvalidMessage
+	}
+	return parseIso9798Values(token.Bytes)
+}
+
+// parseIso9798Values parses the concatenated DER encoded values.
+func parseIso9798Values(b []byte) ([]asn1.RawValue, error) {
+	var values []asn1.RawValue
+	for len(b) > 0 {
+		var v asn1.RawValue
+		rest, err := asn1.Unmarshal(b, &v)
+		if err != nil {
+			return nil, ErrInvalidMessage
+		}
+		values = append(values, v)
+		b = rest
+	}
+	return values, nil
+}
+
+// marshalIso9798AuthID returns the authID field that contains the authz as
+// rfc822Name.
+func marshalIso9798AuthID(authz string) ([]byte, error) {
+	name, err := asn1.Marshal(asn1.RawValue{
+		Class: asn1.ClassContextSpecific,
+		Tag:   iso9798Rfc822Name,
+		Bytes: []byte(authz),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        iso9798TagAuthID,
+		IsCompound: true,
+		Bytes:      name,
+	})
+}
+
+// parseIso9798AuthID returns the authz from the first rfc822Name in the authID
+// field.
+func parseIso9798AuthID(v asn1.RawValue) (string, error) {
+	names, err := parseIso9798Values(v.Bytes)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		if name.Class == asn1.ClassContextSpecific && name.Tag == iso9798Rfc822Name && !name.IsCompound {
+			return string(name.Bytes), nil
+		}
+	}
+	return "", ErrInvalidMessage
+}
+
+// marshalIso9798Certs returns the certificate field that contains the
+// certificates as certificateSet.
+func marshalIso9798Certs(certs []*x509.Certificate) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, ErrInvalidState
+	}
+	var set bytes.Buffer
+	for _, cert := range certs {
+		set.Write(cert.Raw)
+	}
+	certData, err := asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        asn1.TagSet,
+		IsCompound: true,
+		Bytes:      set.Bytes(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        iso9798TagCert,
+		IsCompound: true,
+		Bytes:      certData,
+	})
+}
+
+// parseIso9798Certs returns the certificates from the certificateSet in the
+// certificate field. The certURL alternative is not supported.
+func parseIso9798Certs(v asn1.RawValue) ([]*x509.Certificate, error) {
+	var certData asn1.RawValue
+	if rest, err := asn1.Unmarshal(v.Bytes, &certData); err != nil || len(rest) > 0 {
+		return nil, ErrInvalidMessage
+	}
+	if certData.Class != asn1.ClassUniversal || certData.Tag != asn1.TagSet || !certData.IsCompound {
+		return nil, ErrInvalidMessage
+	}
+	certs, err := x509.ParseCertificates(certData.Bytes)
+	if err != nil || len(certs) == 0 {
+		return nil, ErrInvalidMessage
+	}
+	return certs, nil
+}
+
+// iso9798Sign returns the SIGNATURE of the SEQUENCE of the DER encoded fields,
+// using ecdsa-with-SHA256.
+func iso9798Sign(key *ecdsa.PrivateKey, fields ...[]byte) ([]byte, error) {
+	tbs, err := marshalIso9798Token(fields...)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(tbs)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(iso9798Signature{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSignatureECDSAWithSHA256},
+		Signature: asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	})
+}
+
+// iso9798Verify verifies that the SIGNATURE is a valid ecdsa-with-SHA256
+// signature of the SEQUENCE of the fields.
+func iso9798Verify(key *ecdsa.PublicKey, signature asn1.RawValue, fields ...asn1.RawValue) bool {
+	var s iso9798Signature
+	if rest, err := asn1.Unmarshal(signature.FullBytes, &s); err != nil || len(rest) > 0 {
+		return false
+	}
+	if !s.Algorithm.Algorithm.Equal(oidSignatureECDSAWithSHA256) {
+		return false
+	}
+	tbs := make([][]byte, len(fields))
+	for i, field := range fields {
+		tbs[i] = field.FullBytes
+	}
+	b, err := marshalIso9798Token(tbs...)
+	if err != nil {
+		return false
+	}
+	digest := sha256.Sum256(b)
+	return ecdsa.VerifyASN1(key, digest[:], s.Signature.RightAlign())
+}
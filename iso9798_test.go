@@ -0,0 +1,336 @@
+package sasler_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/phedny/sasler"
+)
+
+func TestIso9798UClient(t *testing.T) {
+	key, cert := iso9798Certificate(t, "user")
+	client, err := sasler.Iso9798UEcdsaSha256Client("", key, []*x509.Certificate{cert})
+	if err != nil {
+		t.Fatalf(`Iso9798UEcdsaSha256Client() returned error: %v`, err)
+	}
+	server := sasler.Iso9798UEcdsaSha256Server(&fakeIso9798Authenticator{})
+
+	gotName, gotClientFirst := client.Mech()
+	expectedName := "9798-U-ECDSA-SHA256"
+	if gotName != expectedName || gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", false)`, gotName, gotClientFirst, expectedName)
+	}
+	gotName, gotClientFirst = server.Mech()
+	if gotName != expectedName || gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", false)`, gotName, gotClientFirst, expectedName)
+	}
+
+	tokenBA1, err := server.Data(nil)
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	tokenAB, err := client.Data(tokenBA1)
+	if err != nil {
+		t.Fatalf(`Data(TokenBA1) returned error: %v`, err)
+	}
+	gotChallenge, err := server.Data(tokenAB)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data(TokenAB) returned (%q, %v); expected (nil, nil)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := server.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+
+	_, err = client.Data(nil)
+	if !errors.Is(err, sasler.ErrInvalidState) {
+		t.Fatalf(`Data returned error: %v; expected ErrInvalidState`, err)
+	}
+}
+
+func TestIso9798MClient(t *testing.T) {
+	clientKey, clientCert := iso9798Certificate(t, "user")
+	serverKey, serverCert := iso9798Certificate(t, "server")
+	var gotCerts []*x509.Certificate
+	verify := func(certs []*x509.Certificate) error {
+		gotCerts = certs
+		return nil
+	}
+	client, err := sasler.Iso9798MEcdsaSha256Client("RequestedAuthz", clientKey, []*x509.Certificate{clientCert}, verify)
+	if err != nil {
+		t.Fatalf(`Iso9798MEcdsaSha256Client() returned error: %v`, err)
+	}
+	server, err := sasler.Iso9798MEcdsaSha256Server(&fakeIso9798Authenticator{}, serverKey, []*x509.Certificate{serverCert})
+	if err != nil {
+		t.Fatalf(`Iso9798MEcdsaSha256Server() returned error: %v`, err)
+	}
+
+	gotName, gotClientFirst := client.Mech()
+	expectedName := "9798-M-ECDSA-SHA256"
+	if gotName != expectedName || gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", false)`, gotName, gotClientFirst, expectedName)
+	}
+	gotName, gotClientFirst = server.Mech()
+	if gotName != expectedName || gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", false)`, gotName, gotClientFirst, expectedName)
+	}
+
+	tokenBA1, err := server.Data(nil)
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	tokenAB, err := client.Data(tokenBA1)
+	if err != nil {
+		t.Fatalf(`Data(TokenBA1) returned error: %v`, err)
+	}
+	tokenBA2, err := server.Data(tokenAB)
+	if err != nil {
+		t.Fatalf(`Data(TokenAB) returned error: %v`, err)
+	}
+
+	gotCompleted, gotAuthz := server.HasCompleted()
+	expectedAuthz := "RequestedAuthz"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+
+	gotResponse, err := client.Data(tokenBA2)
+	if gotResponse != nil || err != nil {
+		t.Fatalf(`Data(TokenBA2) returned (%q, %v); expected (nil, nil)`, gotResponse, err)
+	}
+	if len(gotCerts) != 1 || !gotCerts[0].Equal(serverCert) {
+		t.Fatalf(`verify() called with %v; expected server certificate`, gotCerts)
+	}
+
+	gotChallenge, err := server.Data(nil)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data(nil) returned (%q, %v); expected (nil, nil)`, gotChallenge, err)
+	}
+}
+
+func TestIso9798MClient_UntrustedServer(t *testing.T) {
+	clientKey, clientCert := iso9798Certificate(t, "user")
+	serverKey, serverCert := iso9798Certificate(t, "server")
+	verify := func(certs []*x509.Certificate) error {
+		return errors.New("untrusted")
+	}
+	client, err := sasler.Iso9798MEcdsaSha256Client("", clientKey, []*x509.Certificate{clientCert}, verify)
+	if err != nil {
+		t.Fatalf(`Iso9798MEcdsaSha256Client() returned error: %v`, err)
+	}
+	server, err := sasler.Iso9798MEcdsaSha256Server(&fakeIso9798Authenticator{}, serverKey, []*x509.Certificate{serverCert})
+	if err != nil {
+		t.Fatalf(`Iso9798MEcdsaSha256Server() returned error: %v`, err)
+	}
+
+	tokenBA1, _ := server.Data(nil)
+	tokenAB, _ := client.Data(tokenBA1)
+	tokenBA2, err := server.Data(tokenAB)
+	if err != nil {
+		t.Fatalf(`Data(TokenAB) returned error: %v`, err)
+	}
+
+	gotResponse, err := client.Data(tokenBA2)
+	if gotResponse != nil || !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Data(TokenBA2) returned (%q, %v); expected (nil, ErrAuthenticationFailed)`, gotResponse, err)
+	}
+}
+
+func TestIso9798MClient_ImpersonatedServer(t *testing.T) {
+	clientKey, clientCert := iso9798Certificate(t, "user")
+	serverKey, _ := iso9798Certificate(t, "server")
+	_, otherCert := iso9798Certificate(t, "server")
+	verify := func(certs []*x509.Certificate) error {
+		return nil
+	}
+	client, err := sasler.Iso9798MEcdsaSha256Client("", clientKey, []*x509.Certificate{clientCert}, verify)
+	if err != nil {
+		t.Fatalf(`Iso9798MEcdsaSha256Client() returned error: %v`, err)
+	}
+	server, err := sasler.Iso9798MEcdsaSha256Server(&fakeIso9798Authenticator{}, serverKey, []*x509.Certificate{otherCert})
+	if err != nil {
+		t.Fatalf(`Iso9798MEcdsaSha256Server() returned error: %v`, err)
+	}
+
+	tokenBA1, _ := server.Data(nil)
+	tokenAB, _ := client.Data(tokenBA1)
+	tokenBA2, err := server.Data(tokenAB)
+	if err != nil {
+		t.Fatalf(`Data(TokenAB) returned error: %v`, err)
+	}
+
+	gotResponse, err := client.Data(tokenBA2)
+	if gotResponse != nil || !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Data(TokenBA2) returned (%q, %v); expected (nil, ErrAuthenticationFailed)`, gotResponse, err)
+	}
+}
+
+func TestIso9798Server_Unauthorized(t *testing.T) {
+	key, cert := iso9798Certificate(t, "user")
+	client, err := sasler.Iso9798UEcdsaSha256Client("InvalidAuthz", key, []*x509.Certificate{cert})
+	if err != nil {
+		t.Fatalf(`Iso9798UEcdsaSha256Client() returned error: %v`, err)
+	}
+	server := sasler.Iso9798UEcdsaSha256Server(&fakeIso9798Authenticator{})
+
+	tokenBA1, _ := server.Data(nil)
+	tokenAB, _ := client.Data(tokenBA1)
+	gotChallenge, err := server.Data(tokenAB)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrUnauthorized) {
+		t.Fatalf(`Data(TokenAB) returned (%q, %v); expected (nil, ErrUnauthorized)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := server.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestIso9798ServerContext(t *testing.T) {
+	key, cert := iso9798Certificate(t, "user")
+	client, err := sasler.Iso9798UEcdsaSha256Client("", key, []*x509.Certificate{cert})
+	if err != nil {
+		t.Fatalf(`Iso9798UEcdsaSha256Client() returned error: %v`, err)
+	}
+	server := sasler.Iso9798UEcdsaSha256ServerContext(&fakeIso9798ContextAuthenticator{})
+
+	ctx := context.WithValue(context.Background(), fakeIso9798ContextKey{}, "C")
+	tokenBA1, err := server.DataContext(ctx, nil)
+	if err != nil {
+		t.Fatalf(`DataContext(nil) returned error: %v`, err)
+	}
+	tokenAB, _ := client.Data(tokenBA1)
+	gotChallenge, err := server.DataContext(ctx, tokenAB)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`DataContext(TokenAB) returned (%q, %v); expected (nil, nil)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := server.HasCompleted()
+	expectedAuthz := "userC"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestIso9798Server_ReplayedToken(t *testing.T) {
+	key, cert := iso9798Certificate(t, "user")
+	client, err := sasler.Iso9798UEcdsaSha256Client("", key, []*x509.Certificate{cert})
+	if err != nil {
+		t.Fatalf(`Iso9798UEcdsaSha256Client() returned error: %v`, err)
+	}
+	tokenBA1, _ := sasler.Iso9798UEcdsaSha256Server(&fakeIso9798Authenticator{}).Data(nil)
+	tokenAB, _ := client.Data(tokenBA1)
+
+	server := sasler.Iso9798UEcdsaSha256Server(&fakeIso9798Authenticator{})
+	if _, err := server.Data(nil); err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	gotChallenge, err := server.Data(tokenAB)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Data(TokenAB) returned (%q, %v); expected (nil, ErrAuthenticationFailed)`, gotChallenge, err)
+	}
+}
+
+func TestIso9798Server_InvalidSignature(t *testing.T) {
+	key, _ := iso9798Certificate(t, "user")
+	_, otherCert := iso9798Certificate(t, "user")
+	client, err := sasler.Iso9798UEcdsaSha256Client("", key, []*x509.Certificate{otherCert})
+	if err != nil {
+		t.Fatalf(`Iso9798UEcdsaSha256Client() returned error: %v`, err)
+	}
+	server := sasler.Iso9798UEcdsaSha256Server(&fakeIso9798Authenticator{})
+
+	tokenBA1, _ := server.Data(nil)
+	tokenAB, _ := client.Data(tokenBA1)
+	gotChallenge, err := server.Data(tokenAB)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Data(TokenAB) returned (%q, %v); expected (nil, ErrAuthenticationFailed)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := server.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestIso9798Client_WrongCurve(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(elliptic.P384(), rand.Reader) returned error: %v`, err)
+	}
+	_, err = sasler.Iso9798UEcdsaSha256Client("", key, nil)
+	if !errors.Is(err, sasler.ErrWrongCurve) {
+		t.Fatalf(`Iso9798UEcdsaSha256Client() returned error: %v; expected ErrWrongCurve`, err)
+	}
+}
+
+// iso9798Certificate returns a new P-256 private key and a self-signed
+// certificate for it with the common name.
+func iso9798Certificate(t *testing.T, cn string) (*ecdsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(elliptic.P256(), rand.Reader) returned error: %v`, err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf(`CreateCertificate() returned error: %v`, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf(`ParseCertificate() returned error: %v`, err)
+	}
+	return key, cert
+}
+
+type fakeIso9798Authenticator struct{}
+
+func (*fakeIso9798Authenticator) VerifyCertificate(certs []*x509.Certificate) (string, error) {
+	return certs[0].Subject.CommonName, nil
+}
+
+func (*fakeIso9798Authenticator) DeriveAuthz(authn string) string {
+	return authn + "Z"
+}
+
+func (*fakeIso9798Authenticator) Authorize(authz, authn string) bool {
+	return authz == authn+"Z" || authz == "RequestedAuthz"
+}
+
+// fakeIso9798ContextKey is the context key of the value that
+// fakeIso9798ContextAuthenticator uses as authz suffix.
+type fakeIso9798ContextKey struct{}
+
+type fakeIso9798ContextAuthenticator struct{}
+
+func (*fakeIso9798ContextAuthenticator) VerifyCertificateContext(ctx context.Context, certs []*x509.Certificate) (string, error) {
+	return (&fakeIso9798Authenticator{}).VerifyCertificate(certs)
+}
+
+func (*fakeIso9798ContextAuthenticator) DeriveAuthzContext(ctx context.Context, authn string) string {
+	suffix, _ := ctx.Value(fakeIso9798ContextKey{}).(string)
+	return authn + suffix
+}
+
+func (*fakeIso9798ContextAuthenticator) AuthorizeContext(ctx context.Context, authz, authn string) bool {
+	suffix, _ := ctx.Value(fakeIso9798ContextKey{}).(string)
+	return authn+suffix == authz
+}
@@ -0,0 +1,148 @@
+package sasler
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrNoMechanism is returned by a [Negotiator] when no acceptable mechanism
+// is left to try.
+var ErrNoMechanism = errors.New("sasler: no acceptable mechanism")
+
+// DefaultMechPreference lists mechanism names from most to least preferred.
+// Mechanisms that bind to the TLS channel are preferred over those that don't,
+// and mechanisms that prove possession of a secret are preferred over those
+// that send the secret to the server.
+var DefaultMechPreference = []string{
+	"EXTERNAL",
+	"GS2-KRB5-PLUS",
+	"HT-SHA-256-EXPR",
+	"HT-SHA-256-ENDP",
+	"HT-SHA-256-UNIQ",
+	"SCRAM-SHA-256",
+	"SCRAM-SHA-1",
+	"GS2-KRB5",
+	"GSSAPI",
+	"9798-M-ECDSA-SHA256",
+	"9798-U-ECDSA-SHA256",
+	"ECDSA-NIST256P-CHALLENGE",
+	"ECDH-X25519-CHALLENGE",
+	"HT-SHA-256-NONE",
+	"OAUTHBEARER",
+	"SAML20",
+	"OPENID20",
+	"OTP",
+	"NTLM",
+	"PLAIN",
+	"ANONYMOUS",
+}
+
+// DefaultRequireTLS lists the mechanisms that send reusable credentials to the
+// server, and are therefore only selected on connections protected by TLS.
+var DefaultRequireTLS = []string{
+	"OAUTHBEARER",
+	"PLAIN",
+}
+
+// NegotiationPolicy configures which mechanisms a [Negotiator] selects, and in
+// which order.
+type NegotiationPolicy struct {
+	// Preference lists the mechanism names that may be selected, from most to
+	// least preferred. If nil, DefaultMechPreference is used. Third-party
+	// mechanisms are only selected if they are listed.
+	Preference []string
+	// RequireTLS lists the mechanism names that are only selected if TLS is
+	// true. If nil, DefaultRequireTLS is used.
+	RequireTLS []string
+	// TLS reports whether the connection with the server is protected by TLS.
+	TLS bool
+	// Required contains the security flags that a mechanism must have to be
+	// selected, as returned by MechSecurityFlags. Third-party mechanisms have
+	// no flags, so they are not selected if Required is non-zero.
+	Required SecurityFlags
+}
+
+// Negotiator selects the client-side mechanisms to use for authentication,
+// based on the mechanisms advertised by the server, the mechanisms for which a
+// factory has been registered, and a policy. When authentication with a
+// mechanism fails, the next candidate can be tried.
+type Negotiator struct {
+	registry   *Registry
+	candidates []string
+}
+
+// NewNegotiator returns a Negotiator that selects mechanisms from advertised,
+// that have a client-side factory in registry, and that are acceptable by
+// policy, ordered by the preference of policy. Mechanism names are compared
+// case-insensitively. If policy is nil, the zero NegotiationPolicy is used.
+func NewNegotiator(registry *Registry, advertised []string, policy *NegotiationPolicy) *Negotiator {
+	if policy == nil {
+		policy = &NegotiationPolicy{}
+	}
+	preference := policy.Preference
+	if preference == nil {
+		preference = DefaultMechPreference
+	}
+	requireTLS := policy.RequireTLS
+	if requireTLS == nil {
+		requireTLS = DefaultRequireTLS
+	}
+	available := make(map[string]bool)
+	for _, name := range registry.ClientMechs() {
+		available[strings.ToUpper(name)] = true
+	}
+	offered := make(map[string]bool)
+	for _, name := range advertised {
+		offered[strings.ToUpper(name)] = true
+	}
+	n := &Negotiator{registry: registry}
+	for _, name := range preference {
+		name = strings.ToUpper(name)
+		if !offered[name] || !available[name] || (!policy.TLS && containsFold(requireTLS, name)) {
+			continue
+		}
+		if flags, _ := MechSecurityFlags(name); !flags.Has(policy.Required) {
+			continue
+		}
+		if !containsFold(n.candidates, name) {
+			n.candidates = append(n.candidates, name)
+		}
+	}
+	return n
+}
+
+// Candidates returns the names of the mechanisms that haven't been tried yet,
+// from most to least preferred.
+func (n *Negotiator) Candidates() []string {
+	return append([]string(nil), n.candidates...)
+}
+
+// Next returns a ClientMech for the most preferred mechanism that hasn't been
+// tried yet. Call Next again to fall back to the next candidate after
+// authentication with the returned mechanism has failed. Returns
+// ErrNoMechanism if no candidates are left, or the error returned by the
+// factory of the candidate, in which case the candidate is skipped on the next
+// call.
+func (n *Negotiator) Next() (ClientMech, error) {
+	if len(n.candidates) == 0 {
+		return nil, ErrNoMechanism
+	}
+	name := n.candidates[0]
+	n.candidates = n.candidates[1:]
+	for _, registered := range n.registry.ClientMechs() {
+		if strings.EqualFold(registered, name) {
+			return n.registry.NewClient(registered)
+		}
+	}
+	return nil, ErrUnknownMechanism
+}
+
+// containsFold returns whether names contains name, ignoring case.
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package sasler_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/phedny/sasler"
+)
+
+func negotiateRegistry() *sasler.Registry {
+	var registry sasler.Registry
+	registry.RegisterClient("PLAIN", func() (sasler.ClientMech, error) {
+		return sasler.PlainClient("", "user", []byte("password")), nil
+	})
+	registry.RegisterClient("SCRAM-SHA-1", func() (sasler.ClientMech, error) {
+		return sasler.ScramSha1Client("", "user", []byte("password"))
+	})
+	registry.RegisterClient("SCRAM-SHA-256", func() (sasler.ClientMech, error) {
+		return sasler.ScramSha256Client("", "user", []byte("password"))
+	})
+	return &registry
+}
+
+func TestNegotiator(t *testing.T) {
+	advertised := []string{"PLAIN", "scram-sha-1", "SCRAM-SHA-256", "GSSAPI"}
+	n := sasler.NewNegotiator(negotiateRegistry(), advertised, &sasler.NegotiationPolicy{TLS: true})
+
+	gotCandidates := n.Candidates()
+	expectedCandidates := []string{"SCRAM-SHA-256", "SCRAM-SHA-1", "PLAIN"}
+	if !reflect.DeepEqual(gotCandidates, expectedCandidates) {
+		t.Fatalf(`Candidates() returned %v; expected %v`, gotCandidates, expectedCandidates)
+	}
+
+	for _, expectedName := range expectedCandidates {
+		mech, err := n.Next()
+		if err != nil {
+			t.Fatalf(`Next() returned error: %v`, err)
+		}
+		if gotName, _ := mech.Mech(); gotName != expectedName {
+			t.Fatalf(`Next() returned mechanism %s; expected %s`, gotName, expectedName)
+		}
+	}
+
+	if _, err := n.Next(); !errors.Is(err, sasler.ErrNoMechanism) {
+		t.Fatalf(`Next() returned error: %v; expected ErrNoMechanism`, err)
+	}
+}
+
+func TestNegotiator_RequireTLS(t *testing.T) {
+	advertised := []string{"PLAIN", "SCRAM-SHA-1"}
+	n := sasler.NewNegotiator(negotiateRegistry(), advertised, nil)
+
+	gotCandidates := n.Candidates()
+	expectedCandidates := []string{"SCRAM-SHA-1"}
+	if !reflect.DeepEqual(gotCandidates, expectedCandidates) {
+		t.Fatalf(`Candidates() returned %v; expected %v`, gotCandidates, expectedCandidates)
+	}
+}
+
+func TestNegotiator_Preference(t *testing.T) {
+	advertised := []string{"PLAIN", "SCRAM-SHA-1", "SCRAM-SHA-256"}
+	policy := &sasler.NegotiationPolicy{
+		Preference: []string{"SCRAM-SHA-1", "PLAIN"},
+		RequireTLS: []string{},
+	}
+	n := sasler.NewNegotiator(negotiateRegistry(), advertised, policy)
+
+	gotCandidates := n.Candidates()
+	expectedCandidates := []string{"SCRAM-SHA-1", "PLAIN"}
+	if !reflect.DeepEqual(gotCandidates, expectedCandidates) {
+		t.Fatalf(`Candidates() returned %v; expected %v`, gotCandidates, expectedCandidates)
+	}
+}
+
+func TestNegotiator_Required(t *testing.T) {
+	advertised := []string{"PLAIN", "SCRAM-SHA-1", "SCRAM-SHA-256"}
+	policy := &sasler.NegotiationPolicy{
+		TLS:      true,
+		Required: sasler.SecNoPlaintext | sasler.SecMutualAuth,
+	}
+	n := sasler.NewNegotiator(negotiateRegistry(), advertised, policy)
+
+	gotCandidates := n.Candidates()
+	expectedCandidates := []string{"SCRAM-SHA-256", "SCRAM-SHA-1"}
+	if !reflect.DeepEqual(gotCandidates, expectedCandidates) {
+		t.Fatalf(`Candidates() returned %v; expected %v`, gotCandidates, expectedCandidates)
+	}
+}
@@ -0,0 +1,587 @@
+package sasler
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// ntlmSignature is the signature that starts each NTLM message.
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// Message types of NTLM messages.
+const (
+	ntlmNegotiate    = 1
+	ntlmChallenge    = 2
+	ntlmAuthenticate = 3
+)
+
+// NTLM negotiate flags, as described in [MS-NLMP, section 2.2.2.5].
+//
+// [MS-NLMP, section 2.2.2.5]: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/99d90ff4-957f-4c8a-80e4-5bfe5a9a9832
+const (
+	ntlmNegotiateUnicode          = 0x00000001
+	ntlmRequestTarget             = 0x00000004
+	ntlmNegotiateNTLM             = 0x00000200
+	ntlmNegotiateAlwaysSign       = 0x00008000
+	ntlmTargetTypeDomain          = 0x00010000
+	ntlmNegotiateExtendedSecurity = 0x00080000
+	ntlmNegotiateTargetInfo       = 0x00800000
+	ntlmNegotiate128              = 0x20000000
+	ntlmNegotiate56               = 0x80000000
+)
+
+// ntlmClientFlags are the flags requested by the client.
+const ntlmClientFlags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateNTLM |
+	ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSecurity | ntlmNegotiateTargetInfo |
+	ntlmNegotiate128 | ntlmNegotiate56
+
+// AV pair IDs of the target info, as described in [MS-NLMP, section 2.2.2.1].
+//
+// [MS-NLMP, section 2.2.2.1]: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/83f5e789-660d-4781-8491-5f8c6641f75e
+const (
+	ntlmAvEOL             = 0
+	ntlmAvNbComputerName  = 1
+	ntlmAvNbDomainName    = 2
+	ntlmAvFlags           = 6
+	ntlmAvTimestamp       = 7
+	ntlmAvFlagMICProvided = 0x00000002
+)
+
+// Offsets within the NTLM messages.
+const (
+	ntlmChallengeHeaderSize    = 56
+	ntlmAuthenticateMICOffset  = 72
+	ntlmAuthenticateHeaderSize = 88
+)
+
+// ntlmAvPair is an AV pair of the target info.
+type ntlmAvPair struct {
+	id    uint16
+	value []byte
+}
+
+// parseNtlmAvPairs parses the AV pairs of the target info, excluding the
+// terminating MsvAvEOL.
+func parseNtlmAvPairs(b []byte) ([]ntlmAvPair, error) {
+	var pairs []ntlmAvPair
+	for {
+		if len(b) < 4 {
+			return nil, ErrInvalidMessage
+		}
+		id := binary.LittleEndian.Uint16(b)
+		n := int(binary.LittleEndian.Uint16(b[2:]))
+		if id == ntlmAvEOL {
+			return pairs, nil
+		}
+		if len(b) < 4+n {
+			return nil, ErrInvalidMessage
+		}
+		pairs = append(pairs, ntlmAvPair{id, b[4 : 4+n]})
+		b = b[4+n:]
+	}
+}
+
+// marshalNtlmAvPairs returns the AV pairs, terminated with MsvAvEOL.
+func marshalNtlmAvPairs(pairs []ntlmAvPair) []byte {
+	var b []byte
+	for _, pair := range pairs {
+		b = binary.LittleEndian.AppendUint16(b, pair.id)
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(pair.value)))
+		b = append(b, pair.value...)
+	}
+	return append(b, 0, 0, 0, 0)
+}
+
+// ntlmField returns the payload referenced by the field at offset in msg.
+func ntlmField(msg []byte, offset int) ([]byte, error) {
+	if len(msg) < offset+8 {
+		return nil, ErrInvalidMessage
+	}
+	n := int(binary.LittleEndian.Uint16(msg[offset:]))
+	start := int(binary.LittleEndian.Uint32(msg[offset+4:]))
+	if start > len(msg) || n > len(msg)-start {
+		return nil, ErrInvalidMessage
+	}
+	return msg[start : start+n], nil
+}
+
+// ntlmMessage builds an NTLM message from a header and payloads. The header
+// must contain the signature and message type, and space for a field for each
+// payload at the offsets in fields. The payloads are appended to the header,
+// and the fields are set to reference them.
+func ntlmMessage(header []byte, fields []int, payloads ...[]byte) []byte {
+	msg := header
+	for i, payload := range payloads {
+		binary.LittleEndian.PutUint16(msg[fields[i]:], uint16(len(payload)))
+		binary.LittleEndian.PutUint16(msg[fields[i]+2:], uint16(len(payload)))
+		binary.LittleEndian.PutUint32(msg[fields[i]+4:], uint32(len(msg)))
+		msg = append(msg, payload...)
+	}
+	return msg
+}
+
+// ntlmHeader returns a zeroed header of size n with the signature and message
+// type set.
+func ntlmHeader(n int, msgType uint32) []byte {
+	header := make([]byte, n)
+	copy(header, ntlmSignature)
+	binary.LittleEndian.PutUint32(header[8:], msgType)
+	return header
+}
+
+// checkNtlmHeader verifies the signature and message type of msg.
+func checkNtlmHeader(msg []byte, n int, msgType uint32) error {
+	if len(msg) < n || !bytes.Equal(msg[:8], ntlmSignature) || binary.LittleEndian.Uint32(msg[8:]) != msgType {
+		return ErrInvalidMessage
+	}
+	return nil
+}
+
+// ntlmUnicode returns s encoded as UTF-16LE.
+func ntlmUnicode(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, c)
+	}
+	return b
+}
+
+// ntlmString decodes UTF-16LE encoded b.
+func ntlmString(b []byte) string {
+	s := make([]uint16, len(b)/2)
+	for i := range s {
+		s[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(s))
+}
+
+// ntlmHmacMd5 returns HMAC-MD5 of the concatenated data.
+func ntlmHmacMd5(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// NtlmHash returns the NT hash of a password, which is the MD4 digest of the
+// UTF-16LE encoded password. It is returned by NtlmAuthenticator.GetNtHash.
+func NtlmHash(password []byte) []byte {
+	sum := md4Sum(ntlmUnicode(string(password)))
+	return sum[:]
+}
+
+// ntowfv2 returns the NTLMv2 response key for a user, as described in
+// [MS-NLMP, section 3.3.2].
+//
+// [MS-NLMP, section 3.3.2]: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/5e550938-91d4-459f-b67d-75d70009e3f3
+func ntowfv2(ntHash []byte, username, domain string) []byte {
+	return ntlmHmacMd5(ntHash, ntlmUnicode(strings.ToUpper(username)+domain))
+}
+
+// ntlmv2Response returns the NTLMv2 NtChallengeResponse and the session base
+// key, as described in [MS-NLMP, section 3.3.2].
+//
+// [MS-NLMP, section 3.3.2]: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/5e550938-91d4-459f-b67d-75d70009e3f3
+func ntlmv2Response(responseKey, serverChallenge, clientChallenge, timestamp, targetInfo []byte) ([]byte, []byte) {
+	temp := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	temp = append(temp, timestamp...)
+	temp = append(temp, clientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+	proof := ntlmHmacMd5(responseKey, serverChallenge, temp)
+	return append(proof, temp...), ntlmHmacMd5(responseKey, proof)
+}
+
+// ntlmFiletime returns t as little endian Windows FILETIME.
+func ntlmFiletime(t time.Time) []byte {
+	const epochDelta = 116444736000000000
+	return binary.LittleEndian.AppendUint64(nil, uint64(t.UnixNano()/100+epochDelta))
+}
+
+// ntlmClientMech is an implementation of the NTLM mechanism.
+type ntlmClientMech struct {
+	domain      string
+	username    string
+	workstation string
+	ntHash      []byte
+	negotiate   []byte
+	dataFn      func([]byte) ([]byte, error)
+	completed   bool
+	aborted     bool
+}
+
+// NtlmClient returns a ClientMech implementation for the NTLM mechanism, as
+// specified in [MS-NLMP]. Only NTLMv2 authentication is supported. If domain
+// is empty, the domain announced by the server in its target info is used.
+// The NTLM mechanism doesn't support requesting an authz.
+//
+// [MS-NLMP]: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp
+func NtlmClient(domain, username string, password []byte, workstation string) ClientMech {
+	m := &ntlmClientMech{
+		domain:      domain,
+		username:    username,
+		workstation: workstation,
+		ntHash:      NtlmHash(password),
+	}
+	m.dataFn = m.negotiateMessage
+	return m
+}
+
+// Mech returns name NTLM, and true for client-first.
+func (*ntlmClientMech) Mech() (string, bool) {
+	return "NTLM", true
+}
+
+// Data returns the NEGOTIATE_MESSAGE on the first call, and returns the
+// AUTHENTICATE_MESSAGE in response to the CHALLENGE_MESSAGE on the second call.
+func (m *ntlmClientMech) Data(challenge []byte) ([]byte, error) {
+	if m.dataFn == nil {
+		return nil, ErrInvalidState
+	}
+	return m.dataFn(challenge)
+}
+
+// Success accepts the success indication once the AUTHENTICATE_MESSAGE has been
+// sent, and returns ErrInvalidState otherwise.
+func (m *ntlmClientMech) Success(data []byte) error {
+	if m.aborted {
+		return ErrAborted
+	}
+	return clientSuccess(data, m.Data, &m.completed)
+}
+
+// Abort zeroes the NT hash of the password.
+func (m *ntlmClientMech) Abort() {
+	zeroBytes(m.ntHash)
+	m.ntHash = nil
+	m.dataFn = clientAborted
+	m.completed = false
+	m.aborted = true
+}
+
+// negotiateMessage returns the NEGOTIATE_MESSAGE.
+func (m *ntlmClientMech) negotiateMessage(challenge []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	if len(challenge) > 0 {
+		return nil, ErrInvalidMessage
+	}
+	m.negotiate = ntlmHeader(40, ntlmNegotiate)
+	binary.LittleEndian.PutUint32(m.negotiate[12:], ntlmClientFlags)
+	m.dataFn = m.authenticateMessage
+	return m.negotiate, nil
+}
+
+// authenticateMessage parses the CHALLENGE_MESSAGE, and returns the
+// AUTHENTICATE_MESSAGE that contains the NTLMv2 response and the MIC.
+func (m *ntlmClientMech) authenticateMessage(challenge []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	if err := checkNtlmHeader(challenge, ntlmChallengeHeaderSize-8, ntlmChallenge); err != nil {
+		return nil, err
+	}
+	flags := binary.LittleEndian.Uint32(challenge[20:])
+	if flags&ntlmNegotiateUnicode == 0 || flags&ntlmNegotiateTargetInfo == 0 {
+		return nil, ErrInvalidMessage
+	}
+	serverChallenge := challenge[24:32]
+	targetInfo, err := ntlmField(challenge, 40)
+	if err != nil {
+		return nil, err
+	}
+	pairs, err := parseNtlmAvPairs(targetInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	domain := m.domain
+	var timestamp []byte
+	var avFlags uint32
+	var clientPairs []ntlmAvPair
+	for _, pair := range pairs {
+		switch pair.id {
+		case ntlmAvNbDomainName:
+			if domain == "" {
+				domain = ntlmString(pair.value)
+			}
+		case ntlmAvTimestamp:
+			timestamp = pair.value
+		case ntlmAvFlags:
+			if len(pair.value) == 4 {
+				avFlags = binary.LittleEndian.Uint32(pair.value)
+			}
+			continue
+		}
+		clientPairs = append(clientPairs, pair)
+	}
+	mic := timestamp != nil
+	if mic {
+		avFlags |= ntlmAvFlagMICProvided
+	}
+	if avFlags != 0 {
+		clientPairs = append(clientPairs, ntlmAvPair{ntlmAvFlags, binary.LittleEndian.AppendUint32(nil, avFlags)})
+	}
+	if timestamp == nil {
+		timestamp = ntlmFiletime(time.Now())
+	}
+
+	clientChallenge := make([]byte, 8)
+	if _, err := rand.Read(clientChallenge); err != nil {
+		return nil, err
+	}
+	responseKey := ntowfv2(m.ntHash, m.username, domain)
+	ntResponse, sessionKey := ntlmv2Response(responseKey, serverChallenge, clientChallenge, timestamp, marshalNtlmAvPairs(clientPairs))
+	lmResponse := make([]byte, 24)
+	if !mic {
+		lmResponse = append(ntlmHmacMd5(responseKey, serverChallenge, clientChallenge), clientChallenge...)
+	}
+
+	header := ntlmHeader(ntlmAuthenticateHeaderSize, ntlmAuthenticate)
+	binary.LittleEndian.PutUint32(header[60:], ntlmClientFlags&flags)
+	msg := ntlmMessage(header, []int{12, 20, 28, 36, 44, 52},
+		lmResponse,
+		ntResponse,
+		ntlmUnicode(domain),
+		ntlmUnicode(m.username),
+		ntlmUnicode(m.workstation),
+		nil,
+	)
+	if mic {
+		copy(msg[ntlmAuthenticateMICOffset:], ntlmHmacMd5(sessionKey, m.negotiate, challenge, msg))
+	}
+	m.completed = true
+	return msg, nil
+}
+
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *ntlmClientMech) failed(challenge []byte) ([]byte, error) {
+	return nil, ErrInvalidState
+}
+
+// NtlmAuthenticator is supplied to [NtlmServer] to implement retrieving the NT
+// hash of a user, authz derivation and authorization checking.
+type NtlmAuthenticator interface {
+	// GetNtHash returns the NT hash of the password of a user, as returned by
+	// NtlmHash, or an error if the NT hash could not be retrieved. The domain is
+	// the domain supplied by the client.
+	GetNtHash(domain, username string) ([]byte, error)
+	// DeriveAuthz derives an authz from an authn, which is formatted as
+	// domain\username, or as username if the client supplied no domain. Return
+	// the empty string if no authz can be derived from the supplied authn.
+	DeriveAuthz(authn string) string
+	// Authorize verifies whether an authn is authorized to use the derived authz.
+	// Return false to fail authorization.
+	Authorize(authz, authn string) bool
+}
+
+// NtlmContextAuthenticator is the context-aware variant of
+// [NtlmAuthenticator], which is supplied to [NtlmServerContext]. The context is
+// the one passed to DataContext.
+type NtlmContextAuthenticator interface {
+	// GetNtHashContext is the context-aware variant of GetNtHash.
+	GetNtHashContext(ctx context.Context, domain, username string) ([]byte, error)
+	// DeriveAuthzContext is the context-aware variant of DeriveAuthz.
+	DeriveAuthzContext(ctx context.Context, authn string) string
+	// AuthorizeContext is the context-aware variant of Authorize.
+	AuthorizeContext(ctx context.Context, authz, authn string) bool
+}
+
+// ntlmContextAdapter adapts an NtlmAuthenticator to an
+// NtlmContextAuthenticator that ignores the context.
+type ntlmContextAdapter struct {
+	auth NtlmAuthenticator
+}
+
+func (a ntlmContextAdapter) GetNtHashContext(_ context.Context, domain, username string) ([]byte, error) {
+	return a.auth.GetNtHash(domain, username)
+}
+
+func (a ntlmContextAdapter) DeriveAuthzContext(_ context.Context, authn string) string {
+	return a.auth.DeriveAuthz(authn)
+}
+
+func (a ntlmContextAdapter) AuthorizeContext(_ context.Context, authz, authn string) bool {
+	return a.auth.Authorize(authz, authn)
+}
+
+// ntlmServerMech is an implementation of the NTLM mechanism.
+type ntlmServerMech struct {
+	target    string
+	authz     string
+	authn     string
+	negotiate []byte
+	challenge []byte
+	completed bool
+	aborted   bool
+	auth      NtlmContextAuthenticator
+	dataFn    func(context.Context, []byte) ([]byte, error)
+}
+
+// NtlmServer returns a ServerMech implementation for the NTLM mechanism, as
+// specified in [MS-NLMP]. It is intended for testing NTLM clients, as it only
+// supports NTLMv2 authentication against locally known NT hashes, without
+// session security. The target is announced to the client as NetBIOS domain
+// name.
+//
+// [MS-NLMP]: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp
+func NtlmServer(target string, auth NtlmAuthenticator) ServerMech {
+	return NtlmServerContext(target, ntlmContextAdapter{auth})
+}
+
+// NtlmServerContext is like [NtlmServer], but uses a context-aware
+// authenticator.
+func NtlmServerContext(target string, auth NtlmContextAuthenticator) ServerMech {
+	m := &ntlmServerMech{target: target, auth: auth}
+	m.dataFn = m.challengeMessage
+	return m
+}
+
+// Mech returns name NTLM, and true for client-first.
+func (*ntlmServerMech) Mech() (string, bool) {
+	return "NTLM", true
+}
+
+// Data returns the CHALLENGE_MESSAGE in response to the NEGOTIATE_MESSAGE on
+// the first call, and verifies the AUTHENTICATE_MESSAGE on the second call.
+func (m *ntlmServerMech) Data(data []byte) ([]byte, error) {
+	return m.DataContext(context.Background(), data)
+}
+
+// DataContext is like Data, but passes ctx on to the authenticator.
+func (m *ntlmServerMech) DataContext(ctx context.Context, data []byte) ([]byte, error) {
+	return serverDataContext(ctx, m, m.dataFn, data)
+}
+
+// challengeMessage parses the NEGOTIATE_MESSAGE, and returns the
+// CHALLENGE_MESSAGE.
+func (m *ntlmServerMech) challengeMessage(ctx context.Context, data []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	m.completed = true
+	if err := checkNtlmHeader(data, 16, ntlmNegotiate); err != nil {
+		return nil, newError("NTLM", "NEGOTIATE_MESSAGE", ErrInvalidMessage, ReasonMalformedMessage)
+	}
+	flags := binary.LittleEndian.Uint32(data[12:])
+	if flags&ntlmNegotiateUnicode == 0 || flags&ntlmNegotiateNTLM == 0 {
+		return nil, malformedAttribute("NTLM", "NEGOTIATE_MESSAGE", "NegotiateFlags")
+	}
+	m.negotiate = append([]byte{}, data...)
+
+	serverChallenge := make([]byte, 8)
+	if _, err := rand.Read(serverChallenge); err != nil {
+		return nil, err
+	}
+	targetInfo := marshalNtlmAvPairs([]ntlmAvPair{
+		{ntlmAvNbDomainName, ntlmUnicode(m.target)},
+		{ntlmAvNbComputerName, ntlmUnicode(m.target)},
+		{ntlmAvTimestamp, ntlmFiletime(time.Now())},
+	})
+	header := ntlmHeader(ntlmChallengeHeaderSize, ntlmChallenge)
+	binary.LittleEndian.PutUint32(header[20:], ntlmClientFlags&flags|ntlmTargetTypeDomain|ntlmNegotiateTargetInfo)
+	copy(header[24:], serverChallenge)
+	m.challenge = ntlmMessage(header, []int{12, 40}, ntlmUnicode(m.target), targetInfo)
+	m.dataFn = m.verifyAuthenticate
+	m.completed = false
+	return m.challenge, nil
+}
+
+// verifyAuthenticate verifies the NTLMv2 response and MIC in the
+// AUTHENTICATE_MESSAGE.
+func (m *ntlmServerMech) verifyAuthenticate(ctx context.Context, data []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	m.completed = true
+	if err := checkNtlmHeader(data, ntlmAuthenticateHeaderSize, ntlmAuthenticate); err != nil {
+		return nil, newError("NTLM", "AUTHENTICATE_MESSAGE", ErrInvalidMessage, ReasonMalformedMessage)
+	}
+	var fields [3][]byte
+	for i, offset := range []int{20, 28, 36} {
+		field, err := ntlmField(data, offset)
+		if err != nil {
+			return nil, newError("NTLM", "AUTHENTICATE_MESSAGE", ErrInvalidMessage, ReasonMalformedMessage)
+		}
+		fields[i] = field
+	}
+	ntResponse := fields[0]
+	domain, username := ntlmString(fields[1]), ntlmString(fields[2])
+	if len(ntResponse) < 16+28 || username == "" {
+		return nil, malformedAttribute("NTLM", "AUTHENTICATE_MESSAGE", "NtChallengeResponse")
+	}
+	m.authn = username
+	if domain != "" {
+		m.authn = domain + `\` + username
+	}
+	clientPairs, err := parseNtlmAvPairs(ntResponse[16+28:])
+	if err != nil {
+		return nil, malformedAttribute("NTLM", "AUTHENTICATE_MESSAGE", "NtChallengeResponse")
+	}
+
+	ntHash, err := m.auth.GetNtHashContext(ctx, domain, username)
+	if err != nil {
+		return nil, wrapError("NTLM", "AUTHENTICATE_MESSAGE", ErrAuthenticationFailed, ReasonUnknownUser, err)
+	}
+	responseKey := ntowfv2(ntHash, username, domain)
+	proof := ntlmHmacMd5(responseKey, m.challenge[24:32], ntResponse[16:])
+	if !hmac.Equal(proof, ntResponse[:16]) {
+		return nil, newError("NTLM", "AUTHENTICATE_MESSAGE", ErrAuthenticationFailed, ReasonBadProof)
+	}
+	sessionKey := ntlmHmacMd5(responseKey, proof)
+	for _, pair := range clientPairs {
+		if pair.id == ntlmAvFlags && len(pair.value) == 4 && binary.LittleEndian.Uint32(pair.value)&ntlmAvFlagMICProvided != 0 {
+			msg := append([]byte{}, data...)
+			copy(msg[ntlmAuthenticateMICOffset:ntlmAuthenticateHeaderSize], make([]byte, 16))
+			mic := ntlmHmacMd5(sessionKey, m.negotiate, m.challenge, msg)
+			if !hmac.Equal(mic, data[ntlmAuthenticateMICOffset:ntlmAuthenticateHeaderSize]) {
+				return nil, newError("NTLM", "AUTHENTICATE_MESSAGE", ErrAuthenticationFailed, ReasonBadProof)
+			}
+		}
+	}
+
+	authz := m.auth.DeriveAuthzContext(ctx, m.authn)
+	if authz == "" {
+		return nil, newError("NTLM", "AUTHENTICATE_MESSAGE", ErrAuthenticationFailed, ReasonNoAuthz)
+	}
+	if !m.auth.AuthorizeContext(ctx, authz, m.authn) {
+		return nil, newError("NTLM", "AUTHENTICATE_MESSAGE", ErrUnauthorized, ReasonUnauthorized)
+	}
+	m.authz = authz
+	return nil, nil
+}
+
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *ntlmServerMech) failed(ctx context.Context, data []byte) ([]byte, error) {
+	return nil, ErrInvalidState
+}
+
+// HasCompleted returns true if authentication has finished, and if true, it
+// also returns the authorized authz, if any.
+func (m *ntlmServerMech) HasCompleted() (bool, string) {
+	return m.completed, m.authz
+}
+
+// Result returns the authn, formatted as domain\username, or as username if
+// the client supplied no domain.
+func (m *ntlmServerMech) Result() *Result {
+	if !m.completed {
+		return nil
+	}
+	return &Result{Mech: "NTLM", Succeeded: m.authz != "", Aborted: m.aborted, Authn: m.authn, Authz: m.authz}
+}
+
+// Abort drops the server challenge, if authentication is still in progress.
+func (m *ntlmServerMech) Abort() {
+	if m.completed {
+		return
+	}
+	m.challenge = nil
+	m.dataFn = serverAborted
+	m.completed = true
+	m.aborted = true
+}
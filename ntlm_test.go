@@ -0,0 +1,247 @@
+package sasler
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestMd4Sum(t *testing.T) {
+	tests := []struct {
+		data   string
+		digest string
+	}{
+		{"", "31d6cfe0d16ae931b73c59d7e0c089c0"},
+		{"abc", "a448017aaf21d8525fc10ae87aa6729d"},
+		{"message digest", "d9130a8164549fe818874806e1c7014b"},
+		{"12345678901234567890123456789012345678901234567890123456789012345678901234567890", "e33b4ddc9c38f2199c3e7b164fcc0536"},
+	}
+	for _, test := range tests {
+		digest := md4Sum([]byte(test.data))
+		if hex.EncodeToString(digest[:]) != test.digest {
+			t.Fatalf(`md4Sum("%s") returned %x; expected %s`, test.data, digest, test.digest)
+		}
+	}
+}
+
+// TestNtlmv2Response uses the test vectors of [MS-NLMP, section 4.2.4].
+//
+// [MS-NLMP, section 4.2.4]: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/7795bd0e-fd5e-43ec-bd9c-994704d8ee26
+func TestNtlmv2Response(t *testing.T) {
+	responseKey := ntowfv2(NtlmHash([]byte("Password")), "User", "Domain")
+	expectedKey, _ := hex.DecodeString("0c868a403bfd7a93a3001ef22ef02e3f")
+	if !bytes.Equal(responseKey, expectedKey) {
+		t.Fatalf(`ntowfv2() returned %x; expected %x`, responseKey, expectedKey)
+	}
+
+	serverChallenge, _ := hex.DecodeString("0123456789abcdef")
+	clientChallenge, _ := hex.DecodeString("aaaaaaaaaaaaaaaa")
+	targetInfo := marshalNtlmAvPairs([]ntlmAvPair{
+		{ntlmAvNbDomainName, ntlmUnicode("Domain")},
+		{ntlmAvNbComputerName, ntlmUnicode("Server")},
+	})
+	ntResponse, sessionKey := ntlmv2Response(responseKey, serverChallenge, clientChallenge, make([]byte, 8), targetInfo)
+	expectedProof, _ := hex.DecodeString("68cd0ab851e51c96aabc927bebef6a1c")
+	if !bytes.Equal(ntResponse[:16], expectedProof) {
+		t.Fatalf(`ntlmv2Response() returned NTProofStr %x; expected %x`, ntResponse[:16], expectedProof)
+	}
+	expectedSessionKey, _ := hex.DecodeString("8de40ccadbc14a82f15cb0ad0de95ca3")
+	if !bytes.Equal(sessionKey, expectedSessionKey) {
+		t.Fatalf(`ntlmv2Response() returned session key %x; expected %x`, sessionKey, expectedSessionKey)
+	}
+
+	lmResponse := ntlmHmacMd5(responseKey, serverChallenge, clientChallenge)
+	expectedLmResponse, _ := hex.DecodeString("86c35097ac9cec102554764a57cccc19")
+	if !bytes.Equal(lmResponse, expectedLmResponse) {
+		t.Fatalf(`LMv2 response is %x; expected %x`, lmResponse, expectedLmResponse)
+	}
+}
+
+func TestNtlmClient(t *testing.T) {
+	client := NtlmClient("", "user", []byte("SecretPassword"), "WORKSTATION")
+	server := NtlmServer("EXAMPLE", &fakeNtlmAuthenticator{})
+
+	gotName, gotClientFirst := client.Mech()
+	expectedName := "NTLM"
+	if gotName != expectedName || !gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", true)`, gotName, gotClientFirst, expectedName)
+	}
+
+	negotiate, err := client.Data(nil)
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.HasPrefix(negotiate, []byte("NTLMSSP\x00\x01\x00\x00\x00")) || len(negotiate) != 40 {
+		t.Fatalf(`Data(nil) returned %x; expected NEGOTIATE_MESSAGE`, negotiate)
+	}
+
+	challenge, err := server.Data(negotiate)
+	if err != nil {
+		t.Fatalf(`Data(NEGOTIATE_MESSAGE) returned error: %v`, err)
+	}
+
+	authenticate, err := client.Data(challenge)
+	if err != nil {
+		t.Fatalf(`Data(CHALLENGE_MESSAGE) returned error: %v`, err)
+	}
+	if bytes.Equal(authenticate[ntlmAuthenticateMICOffset:ntlmAuthenticateHeaderSize], make([]byte, 16)) {
+		t.Fatalf(`Data(CHALLENGE_MESSAGE) returned AUTHENTICATE_MESSAGE without MIC`)
+	}
+	domain, _ := ntlmField(authenticate, 28)
+	if ntlmString(domain) != "EXAMPLE" {
+		t.Fatalf(`AUTHENTICATE_MESSAGE contains domain "%s"; expected "EXAMPLE"`, ntlmString(domain))
+	}
+	workstation, _ := ntlmField(authenticate, 44)
+	if ntlmString(workstation) != "WORKSTATION" {
+		t.Fatalf(`AUTHENTICATE_MESSAGE contains workstation "%s"; expected "WORKSTATION"`, ntlmString(workstation))
+	}
+
+	gotChallenge, err := server.Data(authenticate)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data(AUTHENTICATE_MESSAGE) returned (%x, %v); expected (nil, nil)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := server.HasCompleted()
+	expectedAuthz := `EXAMPLE\userZ`
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+
+	_, err = client.Data(nil)
+	if !errors.Is(err, ErrInvalidState) {
+		t.Fatalf(`Data returned error: %v; expected ErrInvalidState`, err)
+	}
+}
+
+func TestNtlmServer_WrongPassword(t *testing.T) {
+	client := NtlmClient("EXAMPLE", "user", []byte("WrongPassword"), "")
+	server := NtlmServer("EXAMPLE", &fakeNtlmAuthenticator{})
+
+	negotiate, _ := client.Data(nil)
+	challenge, _ := server.Data(negotiate)
+	authenticate, _ := client.Data(challenge)
+	gotChallenge, err := server.Data(authenticate)
+	if gotChallenge != nil || !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf(`Data(AUTHENTICATE_MESSAGE) returned (%x, %v); expected (nil, ErrAuthenticationFailed)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := server.HasCompleted()
+	if !gotCompleted || gotAuthz != "" {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "")`, gotCompleted, gotAuthz)
+	}
+}
+
+func TestNtlmServer_TamperedMIC(t *testing.T) {
+	client := NtlmClient("", "user", []byte("SecretPassword"), "WORKSTATION")
+	server := NtlmServer("EXAMPLE", &fakeNtlmAuthenticator{})
+
+	negotiate, _ := client.Data(nil)
+	challenge, _ := server.Data(negotiate)
+	authenticate, _ := client.Data(challenge)
+	flags := binary.LittleEndian.Uint32(authenticate[60:])
+	binary.LittleEndian.PutUint32(authenticate[60:], flags&^ntlmNegotiate56)
+	gotChallenge, err := server.Data(authenticate)
+	if gotChallenge != nil || !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf(`Data(AUTHENTICATE_MESSAGE) returned (%x, %v); expected (nil, ErrAuthenticationFailed)`, gotChallenge, err)
+	}
+}
+
+func TestNtlmServerContext(t *testing.T) {
+	client := NtlmClient("", "user", []byte("SecretPassword"), "")
+	server := NtlmServerContext("EXAMPLE", &fakeNtlmContextAuthenticator{})
+
+	ctx := context.WithValue(context.Background(), fakeNtlmContextKey{}, "C")
+	negotiate, _ := client.Data(nil)
+	challenge, err := server.DataContext(ctx, negotiate)
+	if err != nil {
+		t.Fatalf(`DataContext(NEGOTIATE_MESSAGE) returned error: %v`, err)
+	}
+	authenticate, _ := client.Data(challenge)
+	gotChallenge, err := server.DataContext(ctx, authenticate)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`DataContext(AUTHENTICATE_MESSAGE) returned (%x, %v); expected (nil, nil)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := server.HasCompleted()
+	expectedAuthz := `EXAMPLE\userC`
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestNtlmClient_NoTimestamp(t *testing.T) {
+	client := NtlmClient("", "user", []byte("SecretPassword"), "")
+	if _, err := client.Data(nil); err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+
+	header := ntlmHeader(ntlmChallengeHeaderSize, ntlmChallenge)
+	binary.LittleEndian.PutUint32(header[20:], ntlmClientFlags)
+	targetInfo := marshalNtlmAvPairs([]ntlmAvPair{{ntlmAvNbDomainName, ntlmUnicode("EXAMPLE")}})
+	challenge := ntlmMessage(header, []int{12, 40}, ntlmUnicode("EXAMPLE"), targetInfo)
+
+	authenticate, err := client.Data(challenge)
+	if err != nil {
+		t.Fatalf(`Data(CHALLENGE_MESSAGE) returned error: %v`, err)
+	}
+	if !bytes.Equal(authenticate[ntlmAuthenticateMICOffset:ntlmAuthenticateHeaderSize], make([]byte, 16)) {
+		t.Fatalf(`Data(CHALLENGE_MESSAGE) returned AUTHENTICATE_MESSAGE with MIC`)
+	}
+	lmResponse, _ := ntlmField(authenticate, 12)
+	if len(lmResponse) != 24 || bytes.Equal(lmResponse, make([]byte, 24)) {
+		t.Fatalf(`AUTHENTICATE_MESSAGE contains LmChallengeResponse %x; expected LMv2 response`, lmResponse)
+	}
+}
+
+func TestNtlmClient_InvalidChallenge(t *testing.T) {
+	client := NtlmClient("", "user", []byte("SecretPassword"), "")
+	if _, err := client.Data(nil); err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+
+	challenge := []byte("NTLMSSP\x00\x02\x00\x00\x00")
+	gotResponse, err := client.Data(challenge)
+	if gotResponse != nil || !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf(`Data(%q) returned (%x, %v); expected (nil, ErrInvalidMessage)`, challenge, gotResponse, err)
+	}
+}
+
+type fakeNtlmAuthenticator struct{}
+
+func (*fakeNtlmAuthenticator) GetNtHash(domain, username string) ([]byte, error) {
+	if username != "user" {
+		return nil, errors.New("unknown user")
+	}
+	return NtlmHash([]byte("SecretPassword")), nil
+}
+
+func (*fakeNtlmAuthenticator) DeriveAuthz(authn string) string {
+	return authn + "Z"
+}
+
+func (*fakeNtlmAuthenticator) Authorize(authz, authn string) bool {
+	return authz == authn+"Z"
+}
+
+// fakeNtlmContextKey is the context key of the value that
+// fakeNtlmContextAuthenticator uses as authz suffix.
+type fakeNtlmContextKey struct{}
+
+type fakeNtlmContextAuthenticator struct{}
+
+func (*fakeNtlmContextAuthenticator) GetNtHashContext(ctx context.Context, domain, username string) ([]byte, error) {
+	return (&fakeNtlmAuthenticator{}).GetNtHash(domain, username)
+}
+
+func (*fakeNtlmContextAuthenticator) DeriveAuthzContext(ctx context.Context, authn string) string {
+	suffix, _ := ctx.Value(fakeNtlmContextKey{}).(string)
+	return authn + suffix
+}
+
+func (*fakeNtlmContextAuthenticator) AuthorizeContext(ctx context.Context, authz, authn string) bool {
+	suffix, _ := ctx.Value(fakeNtlmContextKey{}).(string)
+	return authn+suffix == authz
+}
@@ -2,6 +2,7 @@ package sasler
 
 import (
 	"bytes"
+	"context"
 	"strconv"
 )
 
@@ -47,33 +48,86 @@ type OAuthBearerAuthenticator interface {
 	Authorize(authz string, token []byte) bool
 }
 
+// OAuthBearerClaimer can be implemented by an OAuthBearerAuthenticator or
+// OAuthBearerContextAuthenticator to report the claims of a verified token,
+// which are included in the Result of the ServerMech.
+type OAuthBearerClaimer interface {
+	// TokenClaims returns the claims of a token that has been verified by
+	// VerifyToken, such as the claims of a JWT or the response of token
+	// introspection.
+	TokenClaims(token []byte) map[string]interface{}
+}
+
+// OAuthBearerContextAuthenticator is the context-aware variant of
+// [OAuthBearerAuthenticator], which is supplied to [OAuthBearerServerContext].
+// The context is the one passed to DataContext.
+type OAuthBearerContextAuthenticator interface {
+	// VerifyTokenContext is the context-aware variant of VerifyToken.
+	VerifyTokenContext(ctx context.Context, token []byte, host string, port int) bool
+	// DeriveAuthzContext is the context-aware variant of DeriveAuthz.
+	DeriveAuthzContext(ctx context.Context, token []byte) string
+	// AuthorizeContext is the context-aware variant of Authorize.
+	AuthorizeContext(ctx context.Context, authz string, token []byte) bool
+}
+
+// oauthBearerContextAdapter adapts an OAuthBearerAuthenticator to an
+// OAuthBearerContextAuthenticator that ignores the context.
+type oauthBearerContextAdapter struct {
+	auth OAuthBearerAuthenticator
+}
+
+func (a oauthBearerContextAdapter) VerifyTokenContext(_ context.Context, token []byte, host string, port int) bool {
+	return a.auth.VerifyToken(token, host, port)
+}
+
+func (a oauthBearerContextAdapter) DeriveAuthzContext(_ context.Context, token []byte) string {
+	return a.auth.DeriveAuthz(token)
+}
+
+func (a oauthBearerContextAdapter) AuthorizeContext(_ context.Context, authz string, token []byte) bool {
+	return a.auth.Authorize(authz, token)
+}
+
+func (a oauthBearerContextAdapter) TokenClaims(token []byte) map[string]interface{} {
+	if claimer, ok := a.auth.(OAuthBearerClaimer); ok {
+		return claimer.TokenClaims(token)
+	}
+	return nil
+}
+
 // OAuthBearerServer returns a ServerMech implementation for the OAUTHBEARER
 // mechanism, as specified in [RFC 7628].
 //
 // [RFC 7628]: https://tools.ietf.org/html/rfc7628.
 func OAuthBearerServer(auth OAuthBearerAuthenticator) ServerMech {
-	cb := func(ir []byte) (string, error) {
+	return OAuthBearerServerContext(oauthBearerContextAdapter{auth})
+}
+
+// OAuthBearerServerContext is like [OAuthBearerServer], but uses a
+// context-aware authenticator.
+func OAuthBearerServerContext(auth OAuthBearerContextAuthenticator) ServerMech {
+	cb := func(ctx context.Context, ir []byte, result *Result) error {
 		if len(ir) < 2 || ir[0] != 'n' || ir[1] != ',' {
-			return "", ErrInvalidMessage
+			return malformedAttribute("OAUTHBEARER", "initial response", "gs2-header")
 		}
 		ir = ir[2:]
 		if len(ir) < 6 {
-			return "", ErrInvalidMessage
+			return newError("OAUTHBEARER", "initial response", ErrInvalidMessage, ReasonMalformedMessage)
 		}
 		authz := ""
 		if ir[0] == 'a' {
 			if len(ir) < 2 || ir[1] != '=' {
-				return "", ErrInvalidMessage
+				return malformedAttribute("OAUTHBEARER", "initial response", "gs2-header")
 			}
 			ir = ir[2:]
 			comma := bytes.IndexByte(ir, ',')
 			if comma == -1 {
-				return "", ErrInvalidMessage
+				return malformedAttribute("OAUTHBEARER", "initial response", "gs2-header")
 			}
 			authz = string(ir[:comma])
 			ir = ir[comma+1:]
 			if len(ir) < 6 {
-				return "", ErrInvalidMessage
+				return newError("OAUTHBEARER", "initial response", ErrInvalidMessage, ReasonMalformedMessage)
 			}
 		}
 		host := ""
@@ -81,12 +135,12 @@ func OAuthBearerServer(auth OAuthBearerAuthenticator) ServerMech {
 			ir = ir[6:]
 			delim := bytes.IndexByte(ir, 1)
 			if delim == -1 {
-				return "", ErrInvalidMessage
+				return malformedAttribute("OAUTHBEARER", "initial response", "host")
 			}
 			host = string(ir[:delim])
 			ir = ir[delim:]
 			if len(ir) < 6 {
-				return "", ErrInvalidMessage
+				return newError("OAUTHBEARER", "initial response", ErrInvalidMessage, ReasonMalformedMessage)
 			}
 		}
 		port := 0
@@ -94,49 +148,53 @@ func OAuthBearerServer(auth OAuthBearerAuthenticator) ServerMech {
 			ir = ir[6:]
 			delim := bytes.IndexByte(ir, 1)
 			if delim == -1 {
-				return "", ErrInvalidMessage
+				return malformedAttribute("OAUTHBEARER", "initial response", "port")
 			}
 			portS := string(ir[:delim])
 			portI, err := strconv.Atoi(portS)
 			if err != nil {
-				return "", ErrInvalidMessage
+				return malformedAttribute("OAUTHBEARER", "initial response", "port")
 			}
 			port = portI
 			ir = ir[delim:]
 			if len(ir) < 6 {
-				return "", ErrInvalidMessage
+				return newError("OAUTHBEARER", "initial response", ErrInvalidMessage, ReasonMalformedMessage)
 			}
 		}
 		if string(ir[:6]) != "\x01auth=" {
-			return "", ErrInvalidMessage
+			return malformedAttribute("OAUTHBEARER", "initial response", "auth")
 		}
 		ir = ir[6:]
 		if len(ir) < 7 || string(ir[:7]) != "Bearer " {
-			return "", ErrWrongCurve
+			return malformedAttribute("OAUTHBEARER", "initial response", "auth")
 		}
 		ir = ir[7:]
 		delim := bytes.IndexByte(ir, 1)
 		if delim == -1 {
-			return "", ErrInvalidMessage
+			return malformedAttribute("OAUTHBEARER", "initial response", "auth")
 		}
 		token := ir[:delim]
 		ir = ir[delim:]
 		if len(ir) != 2 || ir[0] != 1 || ir[1] != 1 {
-			return "", ErrInvalidMessage
+			return newError("OAUTHBEARER", "initial response", ErrInvalidMessage, ReasonMalformedMessage)
+		}
+		if !auth.VerifyTokenContext(ctx, token, host, port) {
+			return newError("OAUTHBEARER", "initial response", ErrAuthenticationFailed, ReasonBadCredentials)
 		}
-		if !auth.VerifyToken(token, host, port) {
-			return "", ErrAuthenticationFailed
+		if claimer, ok := auth.(OAuthBearerClaimer); ok {
+			result.Claims = claimer.TokenClaims(token)
 		}
 		if authz == "" {
-			authz = auth.DeriveAuthz(token)
+			authz = auth.DeriveAuthzContext(ctx, token)
 			if authz == "" {
-				return "", ErrAuthenticationFailed
+				return newError("OAUTHBEARER", "initial response", ErrAuthenticationFailed, ReasonNoAuthz)
 			}
 		}
-		if !auth.Authorize(authz, token) {
-			return "", ErrUnauthorized
+		if !auth.AuthorizeContext(ctx, authz, token) {
+			return newError("OAUTHBEARER", "initial response", ErrUnauthorized, ReasonUnauthorized)
 		}
-		return authz, nil
+		result.Authz = authz
+		return nil
 	}
 	return &singleMessageServer{name: "OAUTHBEARER", cb: cb}
 }
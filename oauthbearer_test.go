@@ -2,6 +2,7 @@ package sasler_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"strings"
 	"testing"
@@ -28,7 +29,7 @@ func TestOAuthBearerClient(t *testing.T) {
 	}
 
 	_, err = auth.Data(nil)
-	if err != sasler.ErrInvalidState {
+	if !errors.Is(err, sasler.ErrInvalidState) {
 		t.Fatalf(`Data(nil) returned error: %v; expected ErrInvalidState`, err)
 	}
 }
@@ -165,6 +166,38 @@ func TestOAuthBearerServer_Unauthorized(t *testing.T) {
 	}
 }
 
+func TestOAuthBearerServer_ResultClaims(t *testing.T) {
+	auth := sasler.OAuthBearerServer(&FakeOAuthBearerClaimer{})
+
+	ir := []byte("n,\x01auth=Bearer NoHost,NoPort,Derive:the-authz,Authz:the-authz\x01\x01")
+	_, err := auth.Data(ir)
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	result := auth.Result()
+	if result == nil || !result.Succeeded || result.Authz != "the-authz" || result.Claims["sub"] != "the-authz" {
+		t.Fatalf(`Result() returned %+v; expected authz "the-authz" and claim sub "the-authz"`, result)
+	}
+}
+
+func TestOAuthBearerServerContext(t *testing.T) {
+	auth := sasler.OAuthBearerServerContext(&FakeOAuthBearerContextAuthenticator{})
+
+	ctx := context.WithValue(context.Background(), fakeOAuthBearerContextKey{}, "-ctx")
+	ir := []byte("n,\x01auth=Bearer NoHost,NoPort,Derive:the-authz\x01\x01")
+	gotChallenge, err := auth.DataContext(ctx, ir)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`DataContext("%s") returned (%s, %v); expected (nil, nil)`, ir, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "the-authz-ctx"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
 type FakeOAuthBearerAuthenticator struct{}
 
 func (*FakeOAuthBearerAuthenticator) VerifyToken(token []byte, host string, port int) bool {
@@ -209,3 +242,31 @@ func (*FakeOAuthBearerAuthenticator) Authorize(authz string, token []byte) bool
 	}
 	return false
 }
+
+type FakeOAuthBearerClaimer struct {
+	FakeOAuthBearerAuthenticator
+}
+
+func (f *FakeOAuthBearerClaimer) TokenClaims(token []byte) map[string]interface{} {
+	return map[string]interface{}{"sub": f.DeriveAuthz(token)}
+}
+
+// fakeOAuthBearerContextKey is the context key of the value that
+// FakeOAuthBearerContextAuthenticator appends to the derived authz.
+type fakeOAuthBearerContextKey struct{}
+
+type FakeOAuthBearerContextAuthenticator struct{}
+
+func (*FakeOAuthBearerContextAuthenticator) VerifyTokenContext(ctx context.Context, token []byte, host string, port int) bool {
+	return (&FakeOAuthBearerAuthenticator{}).VerifyToken(token, host, port)
+}
+
+func (*FakeOAuthBearerContextAuthenticator) DeriveAuthzContext(ctx context.Context, token []byte) string {
+	suffix, _ := ctx.Value(fakeOAuthBearerContextKey{}).(string)
+	return (&FakeOAuthBearerAuthenticator{}).DeriveAuthz(token) + suffix
+}
+
+func (*FakeOAuthBearerContextAuthenticator) AuthorizeContext(ctx context.Context, authz string, token []byte) bool {
+	suffix, _ := ctx.Value(fakeOAuthBearerContextKey{}).(string)
+	return authz == (&FakeOAuthBearerAuthenticator{}).DeriveAuthz(token)+suffix
+}
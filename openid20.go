@@ -0,0 +1,261 @@
+package sasler
+
+import (
+	"context"
+	"errors"
+)
+
+// openid20ErrorPrefix is the prefix of the message the server sends to report
+// a failed authentication.
+const openid20ErrorPrefix = "openid.error="
+
+// Openid20Client returns a ClientMech implementation for the OPENID20
+// mechanism, as specified in [RFC 6616]. The identifier argument is the
+// OpenID identifier of the user, either as a URI or as an XRI. The redirect
+// function is called with the URL the server redirects the user agent to. It
+// must return after the user agent has completed authentication with the
+// OpenID provider, or return an error to abort authentication.
+//
+// [RFC 6616]: https://tools.ietf.org/html/rfc6616
+func Openid20Client(authz, identifier string, redirect func(redirectURL string) error) ClientMech {
+	header := gs2Header{cbFlag: gs2NoChannelBinding, authz: authz}
+	m := &redirectClient{
+		name:        "OPENID20",
+		ir:          append(header.marshal(), identifier...),
+		errorPrefix: openid20ErrorPrefix,
+		redirect:    redirect,
+	}
+	m.dataFn = m.initialResponse
+	return m
+}
+
+// Openid20Authenticator is supplied to [Openid20Server] to implement OpenID
+// discovery, verification of positive assertions, authz derivation and
+// authorization checking.
+type Openid20Authenticator interface {
+	// Discover performs discovery on the OpenID identifier supplied by the
+	// client, and creates an authentication request for the OpenID provider that
+	// has been found. It returns the URL the user agent of the client is
+	// redirected to, and an identifier of the request that is later passed to
+	// VerifyAssertion. Return an error to fail authentication.
+	Discover(identifier string) (redirectURL, requestID string, err error)
+	// VerifyAssertion is called when the client indicates that its user agent
+	// has completed authentication with the OpenID provider. It returns the
+	// authn from the verified positive assertion in response to the request
+	// identified by requestID, or an error if no valid positive assertion has
+	// been received.
+	VerifyAssertion(requestID string) (authn string, err error)
+	// DeriveAuthz derives an authz from an authn. It is only called when no
+	// authz has been requested by the client. Return the empty string if no
+	// authz can be derived from the supplied authn.
+	DeriveAuthz(authn string) string
+	// Authorize verifies whether an authn is authorized to use the requested or
+	// derived authz. Return false to fail authorization.
+	Authorize(authz, authn string) bool
+}
+
+// Openid20ContextAuthenticator is the context-aware variant of
+// [Openid20Authenticator], which is supplied to [Openid20ServerContext]. The
+// context is the one passed to DataContext.
+type Openid20ContextAuthenticator interface {
+	// DiscoverContext is the context-aware variant of Discover.
+	DiscoverContext(ctx context.Context, identifier string) (redirectURL, requestID string, err error)
+	// VerifyAssertionContext is the context-aware variant of VerifyAssertion.
+	VerifyAssertionContext(ctx context.Context, requestID string) (authn string, err error)
+	// DeriveAuthzContext is the context-aware variant of DeriveAuthz.
+	DeriveAuthzContext(ctx context.Context, authn string) string
+	// AuthorizeContext is the context-aware variant of Authorize.
+	AuthorizeContext(ctx context.Context, authz, authn string) bool
+}
+
+// openid20ContextAdapter adapts an Openid20Authenticator to an
+// Openid20ContextAuthenticator that ignores the context.
+type openid20ContextAdapter struct {
+	auth Openid20Authenticator
+}
+
+func (a openid20ContextAdapter) DiscoverContext(_ context.Context, identifier string) (string, string, error) {
+	return a.auth.Discover(identifier)
+}
+
+func (a openid20ContextAdapter) VerifyAssertionContext(_ context.Context, requestID string) (string, error) {
+	return a.auth.VerifyAssertion(requestID)
+}
+
+func (a openid20ContextAdapter) DeriveAuthzContext(_ context.Context, authn string) string {
+	return a.auth.DeriveAuthz(authn)
+}
+
+func (a openid20ContextAdapter) AuthorizeContext(_ context.Context, authz, authn string) bool {
+	return a.auth.Authorize(authz, authn)
+}
+
+// openid20ServerMech is a ServerMech implementation of the OPENID20 mechanism.
+type openid20ServerMech struct {
+	authz     string
+	authn     string
+	requestID string
+	err       error
+	completed bool
+	succeeded bool
+	aborted   bool
+	auth      Openid20ContextAuthenticator
+	dataFn    func(context.Context, []byte) ([]byte, error)
+}
+
+// Openid20Server returns a ServerMech implementation for the OPENID20
+// mechanism, as specified in [RFC 6616].
+//
+// [RFC 6616]: https://tools.ietf.org/html/rfc6616
+func Openid20Server(auth Openid20Authenticator) ServerMech {
+	return Openid20ServerContext(openid20ContextAdapter{auth})
+}
+
+// Openid20ServerContext is like [Openid20Server], but uses a context-aware
+// authenticator.
+func Openid20ServerContext(auth Openid20ContextAuthenticator) ServerMech {
+	m := &openid20ServerMech{auth: auth}
+	m.dataFn = m.discover
+	return m
+}
+
+// Mech returns name OPENID20, and true for client-first.
+func (*openid20ServerMech) Mech() (string, bool) {
+	return "OPENID20", true
+}
+
+// Data returns the redirect URL on the first call, and verifies that a valid
+// positive assertion has been received on the second call. If it has not, an
+// error message is returned, and the authentication fails on the third call.
+func (m *openid20ServerMech) Data(data []byte) ([]byte, error) {
+	return m.DataContext(context.Background(), data)
+}
+
+// DataContext is like Data, but passes ctx on to the authenticator.
+func (m *openid20ServerMech) DataContext(ctx context.Context, data []byte) ([]byte, error) {
+	return serverDataContext(ctx, m, m.dataFn, data)
+}
+
+// discover parses the initial response, and returns the URL the user agent of
+// the client must be redirected to.
+func (m *openid20ServerMech) discover(ctx context.Context, ir []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	m.completed = true
+	header, identifier, err := parseGs2Header(ir)
+	if err != nil {
+		return nil, malformedAttribute("OPENID20", "initial response", "gs2-header")
+	}
+	if header.nonStd || len(identifier) == 0 {
+		return nil, malformedAttribute("OPENID20", "initial response", "identifier")
+	}
+	if err := header.checkChannelBinding(nil, false); err != nil {
+		return nil, newError("OPENID20", "initial response", err, ReasonChannelBinding)
+	}
+	m.authz = header.authz
+	redirectURL, requestID, err := m.auth.DiscoverContext(ctx, string(identifier))
+	if err != nil {
+		return nil, wrapError("OPENID20", "initial response", ErrAuthenticationFailed, ReasonBadCredentials, err)
+	}
+	m.requestID = requestID
+	m.dataFn = m.verifyAssertion
+	m.completed = false
+	return []byte(redirectURL), nil
+}
+
+// verifyAssertion accepts the empty response of the client, and checks the
+// positive assertion that has been received for the request. When this fails,
+// the failure is reported to the client in an error message.
+func (m *openid20ServerMech) verifyAssertion(ctx context.Context, data []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	if len(data) > 0 {
+		m.completed = true
+		return nil, newError("OPENID20", "empty response", ErrInvalidMessage, ReasonMalformedMessage)
+	}
+	if m.err = m.authorize(ctx); m.err != nil {
+		m.authz = ""
+		if ctx.Err() != nil {
+			// the client can't be told about a failure caused by cancellation
+			m.completed = true
+			return nil, m.err
+		}
+		m.dataFn = m.reportError
+		reason := "authentication failed"
+		if errors.Is(m.err, ErrUnauthorized) {
+			reason = "unauthorized"
+		}
+		return []byte(openid20ErrorPrefix + reason), nil
+	}
+	m.completed = true
+	m.succeeded = true
+	return nil, nil
+}
+
+// authorize verifies the positive assertion, and derives and authorizes the
+// authz.
+func (m *openid20ServerMech) authorize(ctx context.Context) error {
+	authn, err := m.auth.VerifyAssertionContext(ctx, m.requestID)
+	if err != nil || authn == "" {
+		return wrapError("OPENID20", "empty response", ErrAuthenticationFailed, ReasonBadCredentials, err)
+	}
+	m.authn = authn
+	if m.authz == "" {
+		m.authz = m.auth.DeriveAuthzContext(ctx, m.authn)
+		if m.authz == "" {
+			return newError("OPENID20", "empty response", ErrAuthenticationFailed, ReasonNoAuthz)
+		}
+	}
+	if !m.auth.AuthorizeContext(ctx, m.authz, m.authn) {
+		return newError("OPENID20", "empty response", ErrUnauthorized, ReasonUnauthorized)
+	}
+	return nil
+}
+
+// reportError accepts the empty response of the client to the error message,
+// and fails the authentication.
+func (m *openid20ServerMech) reportError(ctx context.Context, data []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	m.completed = true
+	if len(data) > 0 {
+		return nil, newError("OPENID20", "error response", ErrInvalidMessage, ReasonMalformedMessage)
+	}
+	return nil, m.err
+}
+
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *openid20ServerMech) failed(ctx context.Context, data []byte) ([]byte, error) {
+	return nil, ErrInvalidState
+}
+
+// HasCompleted returns true if authentication has finished, and if true, it
+// also returns the authorized authz, if any.
+func (m *openid20ServerMech) HasCompleted() (bool, string) {
+	switch {
+	case !m.completed:
+		return false, ""
+	case !m.succeeded:
+		return true, ""
+	}
+	return true, m.authz
+}
+
+// Result returns the authn from the positive assertion.
+func (m *openid20ServerMech) Result() *Result {
+	completed, authz := m.HasCompleted()
+	if !completed {
+		return nil
+	}
+	return &Result{Mech: "OPENID20", Succeeded: m.succeeded, Aborted: m.aborted, Authn: m.authn, Authz: authz}
+}
+
+// Abort drops the request ID, if authentication is still in progress.
+func (m *openid20ServerMech) Abort() {
+	if m.completed {
+		return
+	}
+	m.requestID = ""
+	m.authz = ""
+	m.dataFn = serverAborted
+	m.completed = true
+	m.aborted = true
+}
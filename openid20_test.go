@@ -0,0 +1,260 @@
+package sasler_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/phedny/sasler"
+)
+
+func TestOpenid20Client(t *testing.T) {
+	var gotURL string
+	redirect := func(redirectURL string) error {
+		gotURL = redirectURL
+		return nil
+	}
+	auth := sasler.Openid20Client("", "https://openid.example/", redirect)
+
+	gotName, gotClientFirst := auth.Mech()
+	expectedName := "OPENID20"
+	if gotName != expectedName || !gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", true)`, gotName, gotClientFirst, expectedName)
+	}
+
+	gotIR, err := auth.Data(nil)
+	expectedIR := []byte("n,,https://openid.example/")
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.Equal(gotIR, expectedIR) {
+		t.Fatalf(`Data(nil) returned %s; expected %s`, gotIR, expectedIR)
+	}
+
+	challenge := []byte("https://openid.example/server?openid.mode=checkid_setup")
+	gotResponse, err := auth.Data(challenge)
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, challenge, err)
+	}
+	if gotResponse == nil || len(gotResponse) != 0 {
+		t.Fatalf(`Data("%s") returned %v; expected empty response`, challenge, gotResponse)
+	}
+	if gotURL != string(challenge) {
+		t.Fatalf(`redirect() called with "%s"; expected "%s"`, gotURL, challenge)
+	}
+}
+
+func TestOpenid20Client_ServerError(t *testing.T) {
+	redirect := func(redirectURL string) error {
+		return nil
+	}
+	auth := sasler.Openid20Client("RequestedAuthz", "https://openid.example/", redirect)
+
+	gotIR, err := auth.Data(nil)
+	expectedIR := []byte("n,a=RequestedAuthz,https://openid.example/")
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.Equal(gotIR, expectedIR) {
+		t.Fatalf(`Data(nil) returned %s; expected %s`, gotIR, expectedIR)
+	}
+
+	challenge := []byte("https://openid.example/server?openid.mode=checkid_setup")
+	if _, err := auth.Data(challenge); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, challenge, err)
+	}
+
+	challenge = []byte("openid.error=unauthorized")
+	gotResponse, err := auth.Data(challenge)
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, challenge, err)
+	}
+	if gotResponse == nil || len(gotResponse) != 0 {
+		t.Fatalf(`Data("%s") returned %v; expected empty response`, challenge, gotResponse)
+	}
+
+	_, err = auth.Data(nil)
+	if !errors.Is(err, sasler.ErrInvalidState) {
+		t.Fatalf(`Data returned error: %v; expected ErrInvalidState`, err)
+	}
+}
+
+func TestOpenid20Server_DeriveAuthz(t *testing.T) {
+	auth := sasler.Openid20Server(&fakeOpenid20Authenticator{})
+
+	gotName, gotClientFirst := auth.Mech()
+	expectedName := "OPENID20"
+	if gotName != expectedName || !gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", true)`, gotName, gotClientFirst, expectedName)
+	}
+
+	ir := []byte("n,,https://openid.example/")
+	gotChallenge, err := auth.Data(ir)
+	expectedChallenge := []byte("https://openid.example/server?openid.assoc_handle=request-1")
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+	if !bytes.Equal(gotChallenge, expectedChallenge) {
+		t.Fatalf(`Data("%s") returned %s; expected %s`, ir, gotChallenge, expectedChallenge)
+	}
+
+	gotChallenge, err = auth.Data(nil)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data(nil) returned ("%s", %v); expected (nil, nil)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestOpenid20Server_RequestedAuthz(t *testing.T) {
+	auth := sasler.Openid20Server(&fakeOpenid20Authenticator{})
+
+	ir := []byte("n,a=RequestedAuthz,https://openid.example/")
+	if _, err := auth.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	gotChallenge, err := auth.Data(nil)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data(nil) returned ("%s", %v); expected (nil, nil)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "RequestedAuthz"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestOpenid20Server_Unauthorized(t *testing.T) {
+	auth := sasler.Openid20Server(&fakeOpenid20Authenticator{})
+
+	ir := []byte("n,a=InvalidAuthz,https://openid.example/")
+	if _, err := auth.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	gotChallenge, err := auth.Data(nil)
+	expectedChallenge := []byte("openid.error=unauthorized")
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.Equal(gotChallenge, expectedChallenge) {
+		t.Fatalf(`Data(nil) returned %s; expected %s`, gotChallenge, expectedChallenge)
+	}
+
+	gotCompleted, _ := auth.HasCompleted()
+	if gotCompleted {
+		t.Fatalf(`HasCompleted() returned true; expected false`)
+	}
+
+	gotChallenge, err = auth.Data(nil)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrUnauthorized) {
+		t.Fatalf(`Data(nil) returned ("%s", %v); expected (nil, ErrUnauthorized)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestOpenid20Server_NoAssertion(t *testing.T) {
+	auth := sasler.Openid20Server(&fakeOpenid20Authenticator{})
+
+	ir := []byte("n,,https://other.example/")
+	if _, err := auth.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	gotChallenge, err := auth.Data(nil)
+	expectedChallenge := []byte("openid.error=authentication failed")
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.Equal(gotChallenge, expectedChallenge) {
+		t.Fatalf(`Data(nil) returned %s; expected %s`, gotChallenge, expectedChallenge)
+	}
+
+	gotChallenge, err = auth.Data(nil)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Data(nil) returned ("%s", %v); expected (nil, ErrAuthenticationFailed)`, gotChallenge, err)
+	}
+}
+
+func TestOpenid20ServerContext(t *testing.T) {
+	auth := sasler.Openid20ServerContext(&fakeOpenid20ContextAuthenticator{})
+
+	ctx := context.WithValue(context.Background(), fakeOpenid20ContextKey{}, "C")
+	ir := []byte("n,,https://openid.example/")
+	if _, err := auth.DataContext(ctx, ir); err != nil {
+		t.Fatalf(`DataContext("%s") returned error: %v`, ir, err)
+	}
+
+	gotChallenge, err := auth.DataContext(ctx, nil)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`DataContext(nil) returned ("%s", %v); expected (nil, nil)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "userC"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+type fakeOpenid20Authenticator struct {
+	identifier string
+}
+
+func (f *fakeOpenid20Authenticator) Discover(identifier string) (string, string, error) {
+	f.identifier = identifier
+	return identifier + "server?openid.assoc_handle=request-1", "request-1", nil
+}
+
+func (f *fakeOpenid20Authenticator) VerifyAssertion(requestID string) (string, error) {
+	if f.identifier != "https://openid.example/" || requestID != "request-1" {
+		return "", errors.New("no positive assertion received")
+	}
+	return "user", nil
+}
+
+func (*fakeOpenid20Authenticator) DeriveAuthz(authn string) string {
+	return authn + "Z"
+}
+
+func (*fakeOpenid20Authenticator) Authorize(authz, authn string) bool {
+	return authz == authn+"Z" || authz == "RequestedAuthz"
+}
+
+// fakeOpenid20ContextKey is the context key of the value that
+// fakeOpenid20ContextAuthenticator uses as authz suffix.
+type fakeOpenid20ContextKey struct{}
+
+type fakeOpenid20ContextAuthenticator struct {
+	fakeOpenid20Authenticator
+}
+
+func (f *fakeOpenid20ContextAuthenticator) DiscoverContext(ctx context.Context, identifier string) (string, string, error) {
+	return f.Discover(identifier)
+}
+
+func (f *fakeOpenid20ContextAuthenticator) VerifyAssertionContext(ctx context.Context, requestID string) (string, error) {
+	return f.VerifyAssertion(requestID)
+}
+
+func (*fakeOpenid20ContextAuthenticator) DeriveAuthzContext(ctx context.Context, authn string) string {
+	suffix, _ := ctx.Value(fakeOpenid20ContextKey{}).(string)
+	return authn + suffix
+}
+
+func (*fakeOpenid20ContextAuthenticator) AuthorizeContext(ctx context.Context, authz, authn string) bool {
+	suffix, _ := ctx.Value(fakeOpenid20ContextKey{}).(string)
+	return authn+suffix == authz
+}
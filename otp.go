@@ -0,0 +1,540 @@
+package sasler
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidPassphrase is returned when an OTP passphrase doesn't have the
+	// length required by [RFC 2289, section 6.0].
+	//
+	// [RFC 2289, section 6.0]: https://tools.ietf.org/html/rfc2289#section-6.0
+	ErrInvalidPassphrase = errors.New("sasler: invalid OTP passphrase")
+	// ErrInvalidOtpParams is returned when an OTP algorithm, sequence number or
+	// seed is invalid.
+	ErrInvalidOtpParams = errors.New("sasler: invalid OTP parameters")
+)
+
+// OtpEncoding selects how a one-time password is encoded in the response sent
+// by [OtpClient].
+type OtpEncoding int
+
+const (
+	// OtpHex encodes a one-time password as hexadecimal digits.
+	OtpHex OtpEncoding = iota
+	// OtpWords encodes a one-time password as six words from the standard
+	// dictionary.
+	OtpWords
+)
+
+// otpFold computes a hash over data using the named algorithm, and folds the
+// result to 64 bits, as described in [RFC 2289, appendix A].
+//
+// [RFC 2289, appendix A]: https://tools.ietf.org/html/rfc2289#appendix-A
+func otpFold(alg string, data []byte) ([8]byte, error) {
+	var otp [8]byte
+	switch alg {
+	case "md5":
+		h := md5.Sum(data)
+		for i := range otp {
+			otp[i] = h[i] ^ h[i+8]
+		}
+	case "sha1":
+		h := sha1.Sum(data)
+		w0 := binary.BigEndian.Uint32(h[0:]) ^ binary.BigEndian.Uint32(h[8:]) ^ binary.BigEndian.Uint32(h[16:])
+		w1 := binary.BigEndian.Uint32(h[4:]) ^ binary.BigEndian.Uint32(h[12:])
+		binary.LittleEndian.PutUint32(otp[0:], w0)
+		binary.LittleEndian.PutUint32(otp[4:], w1)
+	default:
+		return otp, ErrInvalidOtpParams
+	}
+	return otp, nil
+}
+
+// otpCompute computes the one-time password with sequence number seq, for the
+// passphrase and seed, as described in [RFC 2289, section 5].
+//
+// [RFC 2289, section 5]: https://tools.ietf.org/html/rfc2289#section-5
+func otpCompute(alg string, passphrase []byte, seed string, seq int) ([8]byte, error) {
+	if len(passphrase) < 10 || len(passphrase) > 63 {
+		return [8]byte{}, ErrInvalidPassphrase
+	}
+	if !validOtpSeed(seed) || seq < 0 {
+		return [8]byte{}, ErrInvalidOtpParams
+	}
+	otp, err := otpFold(alg, append([]byte(strings.ToLower(seed)), passphrase...))
+	if err != nil {
+		return otp, err
+	}
+	for i := 0; i < seq; i++ {
+		otp, _ = otpFold(alg, otp[:])
+	}
+	return otp, nil
+}
+
+// validOtpSeed returns true if seed consists of 1 to 16 alphanumeric
+// characters.
+func validOtpSeed(seed string) bool {
+	if len(seed) < 1 || len(seed) > 16 {
+		return false
+	}
+	for _, c := range seed {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// otpToWords encodes a one-time password as six words, as described in
+// [RFC 2289, appendix D].
+//
+// [RFC 2289, appendix D]: https://tools.ietf.org/html/rfc2289#appendix-D
+func otpToWords(otp [8]byte) string {
+	v := binary.BigEndian.Uint64(otp[:])
+	var parity uint64
+	for x := v; x != 0; x >>= 2 {
+		parity += x & 3
+	}
+	words := make([]string, 6)
+	for i := range words {
+		shift := 53 - 11*i
+		var idx uint64
+		if shift >= 0 {
+			idx = v >> shift
+		} else {
+			idx = v<<-shift | parity&3
+		}
+		words[i] = otpWords[idx&0x7ff]
+	}
+	return strings.Join(words, " ")
+}
+
+// otpFromWords decodes a one-time password that is encoded as six words.
+// Returns false if a word isn't in the dictionary, or if the checksum is
+// invalid.
+func otpFromWords(s string) ([8]byte, bool) {
+	var otp [8]byte
+	words := strings.Fields(s)
+	if len(words) != 6 {
+		return otp, false
+	}
+	var v uint64
+	var checksum uint64
+	for i, word := range words {
+		idx := otpWordIndex(strings.ToUpper(word))
+		if idx == -1 {
+			return otp, false
+		}
+		if i < 5 {
+			v = v<<11 | uint64(idx)
+		} else {
+			v = v<<9 | uint64(idx)>>2
+			checksum = uint64(idx) & 3
+		}
+	}
+	var parity uint64
+	for x := v; x != 0; x >>= 2 {
+		parity += x & 3
+	}
+	if parity&3 != checksum {
+		return otp, false
+	}
+	binary.BigEndian.PutUint64(otp[:], v)
+	return otp, true
+}
+
+// otpWordIndex returns the index of word in the dictionary, or -1 if it's not
+// in the dictionary.
+func otpWordIndex(word string) int {
+	// The dictionary contains words of up to three letters first, followed by
+	// words of four letters. Both parts are sorted alphabetically.
+	lo, hi := 0, len(otpWords)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		w := otpWords[mid]
+		if len(w) < 4 && len(word) == 4 || (len(w) == 4) == (len(word) == 4) && w < word {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo < len(otpWords) && otpWords[lo] == word {
+		return lo
+	}
+	return -1
+}
+
+// otpClientMech is a ClientMech implementation of the OTP mechanism.
+type otpClientMech struct {
+	authz      string
+	authn      string
+	passphrase []byte
+	enc        OtpEncoding
+	dataFn     func([]byte) ([]byte, error)
+	completed  bool
+	aborted    bool
+}
+
+// OtpClient returns a ClientMech implementation for the OTP mechanism, as
+// specified in [RFC 2444], using one-time passwords as specified in
+// [RFC 2289]. The enc argument selects how the one-time password is encoded
+// in the response. Returns ErrInvalidPassphrase if the passphrase is shorter
+// than 10, or longer than 63 characters.
+//
+// [RFC 2444]: https://tools.ietf.org/html/rfc2444
+// [RFC 2289]: https://tools.ietf.org/html/rfc2289
+func OtpClient(authz, authn string, passphrase []byte, enc OtpEncoding) (ClientMech, error) {
+	if len(passphrase) < 10 || len(passphrase) > 63 {
+		return nil, ErrInvalidPassphrase
+	}
+	m := &otpClientMech{authz: authz, authn: authn, passphrase: passphrase, enc: enc}
+	m.dataFn = m.initialResponse
+	return m, nil
+}
+
+// Mech returns name OTP, and true for client-first.
+func (*otpClientMech) Mech() (string, bool) {
+	return "OTP", true
+}
+
+// Data returns the authz and authn on the first call, and returns the
+// one-time password requested by the challenge on the second call.
+func (m *otpClientMech) Data(challenge []byte) ([]byte, error) {
+	if m.dataFn == nil {
+		return nil, ErrInvalidState
+	}
+	return m.dataFn(challenge)
+}
+
+// Success accepts the success indication once the one-time password has been
+// sent, and returns ErrInvalidState otherwise.
+func (m *otpClientMech) Success(data []byte) error {
+	if m.aborted {
+		return ErrAborted
+	}
+	return clientSuccess(data, m.Data, &m.completed)
+}
+
+// Abort drops the passphrase.
+func (m *otpClientMech) Abort() {
+	m.passphrase = nil
+	m.dataFn = clientAborted
+	m.completed = false
+	m.aborted = true
+}
+
+// initialResponse returns the authz and authn, separated by a NUL byte.
+func (m *otpClientMech) initialResponse(challenge []byte) ([]byte, error) {
+	if len(challenge) > 0 {
+		m.dataFn = m.failed
+		m.passphrase = nil
+		return nil, ErrInvalidMessage
+	}
+	m.dataFn = m.respondToChallenge
+	ir := make([]byte, len(m.authz)+len(m.authn)+1)
+	copy(ir, m.authz)
+	copy(ir[len(m.authz)+1:], m.authn)
+	return ir, nil
+}
+
+// respondToChallenge parses the OTP challenge, and returns the extended
+// response containing the requested one-time password.
+func (m *otpClientMech) respondToChallenge(challenge []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	passphrase := m.passphrase
+	m.passphrase = nil
+	fields := strings.Fields(string(challenge))
+	if len(fields) < 4 || !strings.HasPrefix(fields[0], "otp-") || !strings.HasPrefix(fields[3], "ext") {
+		return nil, ErrInvalidMessage
+	}
+	seq, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, ErrInvalidMessage
+	}
+	otp, err := otpCompute(fields[0][4:], passphrase, fields[2], seq)
+	if err == ErrInvalidOtpParams {
+		return nil, ErrInvalidMessage
+	} else if err != nil {
+		return nil, err
+	}
+	m.completed = true
+	if m.enc == OtpWords {
+		return []byte("word:" + otpToWords(otp)), nil
+	}
+	return []byte("hex:" + hex.EncodeToString(otp[:])), nil
+}
+
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *otpClientMech) failed(challenge []byte) ([]byte, error) {
+	return nil, ErrInvalidState
+}
+
+// OtpState contains the state that is stored by the server for each user of
+// the OTP mechanism. Algorithm is either "md5" or "sha1". Otp is the last
+// one-time password that was accepted, or the initial one-time password, and
+// Sequence is its sequence number. The next one-time password that will be
+// requested has sequence number Sequence-1.
+type OtpState struct {
+	Algorithm string
+	Sequence  int
+	Seed      string
+	Otp       [8]byte
+}
+
+// NewOtpState computes the initial OtpState from a passphrase, to be stored by
+// the server. Returns ErrInvalidPassphrase if the passphrase is shorter than
+// 10, or longer than 63 characters, or ErrInvalidOtpParams if the algorithm,
+// sequence number, or seed is invalid.
+func NewOtpState(alg string, passphrase []byte, seed string, seq int) (OtpState, error) {
+	otp, err := otpCompute(alg, passphrase, seed, seq)
+	if err != nil {
+		return OtpState{}, err
+	}
+	return OtpState{Algorithm: alg, Sequence: seq, Seed: seed, Otp: otp}, nil
+}
+
+// OtpAuthenticator is supplied to [OtpServer] to implement storage of the OTP
+// state, authz derivation and authorization checking.
+type OtpAuthenticator interface {
+	// GetState returns the current OTP state for an authn, or an error if the
+	// state could not be retrieved.
+	GetState(authn string) (OtpState, error)
+	// AdvanceState replaces the OTP state for an authn with next, but only if
+	// the stored state is still equal to prev. The comparison and replacement
+	// must be atomic, to prevent the same one-time password from being accepted
+	// by concurrent authentications. Return false to fail authentication.
+	AdvanceState(authn string, prev, next OtpState) bool
+	// DeriveAuthz derives an authz from an authn. It is only called when no
+	// authz has been requested by the client. Return the empty string if no
+	// authz can be derived from the supplied authn.
+	DeriveAuthz(authn string) string
+	// Authorize verifies whether an authn is authorized to use the requested or
+	// derived authz. Return false to fail authorization.
+	Authorize(authz, authn string) bool
+}
+
+// OtpContextAuthenticator is the context-aware variant of [OtpAuthenticator],
+// which is supplied to [OtpServerContext]. The context is the one passed to
+// DataContext.
+type OtpContextAuthenticator interface {
+	// GetStateContext is the context-aware variant of GetState.
+	GetStateContext(ctx context.Context, authn string) (OtpState, error)
+	// AdvanceStateContext is the context-aware variant of AdvanceState.
+	AdvanceStateContext(ctx context.Context, authn string, prev, next OtpState) bool
+	// DeriveAuthzContext is the context-aware variant of DeriveAuthz.
+	DeriveAuthzContext(ctx context.Context, authn string) string
+	// AuthorizeContext is the context-aware variant of Authorize.
+	AuthorizeContext(ctx context.Context, authz, authn string) bool
+}
+
+// otpContextAdapter adapts an OtpAuthenticator to an OtpContextAuthenticator
+// that ignores the context.
+type otpContextAdapter struct {
+	auth OtpAuthenticator
+}
+
+func (a otpContextAdapter) GetStateContext(_ context.Context, authn string) (OtpState, error) {
+	return a.auth.GetState(authn)
+}
+
+func (a otpContextAdapter) AdvanceStateContext(_ context.Context, authn string, prev, next OtpState) bool {
+	return a.auth.AdvanceState(authn, prev, next)
+}
+
+func (a otpContextAdapter) DeriveAuthzContext(_ context.Context, authn string) string {
+	return a.auth.DeriveAuthz(authn)
+}
+
+func (a otpContextAdapter) AuthorizeContext(_ context.Context, authz, authn string) bool {
+	return a.auth.Authorize(authz, authn)
+}
+
+// otpServerMech is a ServerMech implementation of the OTP mechanism.
+type otpServerMech struct {
+	authz     string
+	authn     string
+	state     OtpState
+	completed bool
+	succeeded bool
+	aborted   bool
+	auth      OtpContextAuthenticator
+	dataFn    func(context.Context, []byte) ([]byte, error)
+}
+
+// OtpServer returns a ServerMech implementation for the OTP mechanism, as
+// specified in [RFC 2444], using one-time passwords as specified in
+// [RFC 2289]. Both hexadecimal and six-word extended responses are accepted.
+// Reinitialization of the OTP sequence is not supported.
+//
+// [RFC 2444]: https://tools.ietf.org/html/rfc2444
+// [RFC 2289]: https://tools.ietf.org/html/rfc2289
+func OtpServer(auth OtpAuthenticator) ServerMech {
+	return OtpServerContext(otpContextAdapter{auth})
+}
+
+// OtpServerContext is like [OtpServer], but uses a context-aware
+// authenticator.
+func OtpServerContext(auth OtpContextAuthenticator) ServerMech {
+	m := &otpServerMech{auth: auth}
+	m.dataFn = m.createChallenge
+	return m
+}
+
+// Mech returns name OTP, and true for client-first.
+func (*otpServerMech) Mech() (string, bool) {
+	return "OTP", true
+}
+
+// Data returns an OTP challenge on the first call, and verifies the one-time
+// password on the second call.
+func (m *otpServerMech) Data(data []byte) ([]byte, error) {
+	return m.DataContext(context.Background(), data)
+}
+
+// DataContext is like Data, but passes ctx on to the authenticator.
+func (m *otpServerMech) DataContext(ctx context.Context, data []byte) ([]byte, error) {
+	return serverDataContext(ctx, m, m.dataFn, data)
+}
+
+// createChallenge parses the authz and authn, and returns a challenge for the
+// next one-time password of the authn.
+func (m *otpServerMech) createChallenge(ctx context.Context, ir []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	m.completed = true
+	delim := bytes.IndexByte(ir, 0)
+	if delim == -1 {
+		return nil, newError("OTP", "initial response", ErrInvalidMessage, ReasonMalformedMessage)
+	}
+	m.authz = string(ir[:delim])
+	m.authn = string(ir[delim+1:])
+	if m.authn == "" {
+		return nil, malformedAttribute("OTP", "initial response", "authcid")
+	}
+	state, err := m.auth.GetStateContext(ctx, m.authn)
+	if err != nil {
+		return nil, wrapError("OTP", "initial response", ErrAuthenticationFailed, ReasonUnknownUser, err)
+	}
+	if state.Sequence < 1 {
+		return nil, newError("OTP", "initial response", ErrAuthenticationFailed, ReasonExpiredToken)
+	}
+	m.state = state
+	challenge := "otp-" + state.Algorithm + " " + strconv.Itoa(state.Sequence-1) + " " + state.Seed + " ext"
+	m.dataFn = m.verifyResponse
+	m.completed = false
+	return []byte(challenge), nil
+}
+
+// verifyResponse parses the extended response, and verifies the one-time
+// password it contains.
+func (m *otpServerMech) verifyResponse(ctx context.Context, data []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	m.completed = true
+	otp, err := m.parseResponse(string(data))
+	if err != nil {
+		return nil, err
+	}
+	next, err := otpFold(m.state.Algorithm, otp[:])
+	if err != nil {
+		return nil, wrapError("OTP", "response", ErrAuthenticationFailed, ReasonBadProof, err)
+	}
+	if next != m.state.Otp {
+		return nil, newError("OTP", "response", ErrAuthenticationFailed, ReasonBadProof)
+	}
+	advanced := m.state
+	advanced.Sequence--
+	advanced.Otp = otp
+	if !m.auth.AdvanceStateContext(ctx, m.authn, m.state, advanced) {
+		return nil, newError("OTP", "response", ErrAuthenticationFailed, ReasonBadProof)
+	}
+	if m.authz == "" {
+		m.authz = m.auth.DeriveAuthzContext(ctx, m.authn)
+		if m.authz == "" {
+			return nil, newError("OTP", "response", ErrAuthenticationFailed, ReasonNoAuthz)
+		}
+	}
+	if !m.auth.AuthorizeContext(ctx, m.authz, m.authn) {
+		m.authz = ""
+		return nil, newError("OTP", "response", ErrUnauthorized, ReasonUnauthorized)
+	}
+	m.succeeded = true
+	return nil, nil
+}
+
+// parseResponse parses an extended response containing a one-time password in
+// hexadecimal or six-word format.
+func (m *otpServerMech) parseResponse(resp string) ([8]byte, error) {
+	var otp [8]byte
+	typ, value, found := strings.Cut(resp, ":")
+	if !found {
+		return otp, malformedAttribute("OTP", "response", "type")
+	}
+	switch strings.ToLower(typ) {
+	case "hex":
+		digits := strings.Join(strings.Fields(value), "")
+		if len(digits) != 2*len(otp) {
+			return otp, malformedAttribute("OTP", "response", "hex")
+		}
+		if _, err := hex.Decode(otp[:], []byte(digits)); err != nil {
+			return otp, malformedAttribute("OTP", "response", "hex")
+		}
+	case "word":
+		var ok bool
+		otp, ok = otpFromWords(value)
+		if !ok {
+			return otp, malformedAttribute("OTP", "response", "word")
+		}
+	default:
+		return otp, malformedAttribute("OTP", "response", "type")
+	}
+	return otp, nil
+}
+
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *otpServerMech) failed(ctx context.Context, data []byte) ([]byte, error) {
+	return nil, ErrInvalidState
+}
+
+// HasCompleted returns true if authentication has finished, and if true, it
+// also returns the authorized authz, if any.
+func (m *otpServerMech) HasCompleted() (bool, string) {
+	switch {
+	case !m.completed:
+		return false, ""
+	case !m.succeeded:
+		return true, ""
+	}
+	return true, m.authz
+}
+
+// Result returns the authn whose one-time password was verified.
+func (m *otpServerMech) Result() *Result {
+	completed, authz := m.HasCompleted()
+	if !completed {
+		return nil
+	}
+	return &Result{Mech: "OTP", Succeeded: m.succeeded, Aborted: m.aborted, Authn: m.authn, Authz: authz}
+}
+
+// Abort zeroes the stored one-time password, if authentication is still in
+// progress.
+func (m *otpServerMech) Abort() {
+	if m.completed {
+		return
+	}
+	m.state = OtpState{}
+	m.authz = ""
+	m.dataFn = serverAborted
+	m.completed = true
+	m.aborted = true
+}
@@ -0,0 +1,332 @@
+package sasler
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// otpTestVectors contains the test vectors from RFC 2289, appendix C.
+var otpTestVectors = []struct {
+	alg        string
+	passphrase string
+	seed       string
+	seq        int
+	hex        string
+	words      string
+}{
+	{"md5", "This is a test.", "TeSt", 0, "9e876134d90499dd", "INCH SEA ANNE LONG AHEM TOUR"},
+	{"md5", "This is a test.", "TeSt", 1, "7965e05436f5029f", "EASE OIL FUM CURE AWRY AVIS"},
+	{"md5", "This is a test.", "TeSt", 99, "50fe1962c4965880", "BAIL TUFT BITS GANG CHEF THY"},
+	{"md5", "AbCdEfGhIjK", "alpha1", 0, "87066dd9644bf206", "FULL PEW DOWN ONCE MORT ARC"},
+	{"md5", "AbCdEfGhIjK", "alpha1", 1, "7cd34c1040add14b", "FACT HOOF AT FIST SITE KENT"},
+	{"md5", "AbCdEfGhIjK", "alpha1", 99, "5aa37a81f212146c", "BODE HOP JAKE STOW JUT RAP"},
+	{"md5", "OTP's are good", "correct", 0, "f205753943de4cf9", "ULAN NEW ARMY FUSE SUIT EYED"},
+	{"md5", "OTP's are good", "correct", 1, "ddcdac956f234937", "SKIM CULT LOB SLAM POE HOWL"},
+	{"md5", "OTP's are good", "correct", 99, "b203e28fa525be47", "LONG IVY JULY AJAR BOND LEE"},
+	{"sha1", "This is a test.", "TeSt", 0, "bb9e6ae1979d8ff4", "MILT VARY MAST OK SEES WENT"},
+	{"sha1", "This is a test.", "TeSt", 1, "63d936639734385b", "CART OTTO HIVE ODE VAT NUT"},
+	{"sha1", "This is a test.", "TeSt", 99, "87fec7768b73ccf9", "GAFF WAIT SKID GIG SKY EYED"},
+	{"sha1", "AbCdEfGhIjK", "alpha1", 0, "ad85f658ebe383c9", "LEST OR HEEL SCOT ROB SUIT"},
+	{"sha1", "AbCdEfGhIjK", "alpha1", 1, "d07ce229b5cf119b", "RITE TAKE GELD COST TUNE RECK"},
+	{"sha1", "AbCdEfGhIjK", "alpha1", 99, "27bc71035aaf3dc6", "MAY STAR TIN LYON VEDA STAN"},
+	{"sha1", "OTP's are good", "correct", 0, "d51f3e99bf8e6f0b", "RUST WELT KICK FELL TAIL FRAU"},
+	{"sha1", "OTP's are good", "correct", 1, "82aeb52d943774e4", "FLIT DOSE ALSO MEW DRUM DEFY"},
+	{"sha1", "OTP's are good", "correct", 99, "4f296a74fe1567ec", "AURA ALOE HURL WING BERG WAIT"},
+}
+
+func TestOtpCompute(t *testing.T) {
+	for _, v := range otpTestVectors {
+		otp, err := otpCompute(v.alg, []byte(v.passphrase), v.seed, v.seq)
+		if err != nil {
+			t.Fatalf(`otpCompute("%s", "%s", "%s", %d) returned error: %v`, v.alg, v.passphrase, v.seed, v.seq, err)
+		}
+		if gotHex := hex.EncodeToString(otp[:]); gotHex != v.hex {
+			t.Fatalf(`otpCompute("%s", "%s", "%s", %d) returned %s; expected %s`, v.alg, v.passphrase, v.seed, v.seq, gotHex, v.hex)
+		}
+		if gotWords := otpToWords(otp); gotWords != v.words {
+			t.Fatalf(`otpToWords(%s) returned "%s"; expected "%s"`, v.hex, gotWords, v.words)
+		}
+		gotOtp, ok := otpFromWords(strings.ToLower(v.words))
+		if !ok || gotOtp != otp {
+			t.Fatalf(`otpFromWords("%s") returned (%x, %v); expected (%s, true)`, v.words, gotOtp, ok, v.hex)
+		}
+	}
+}
+
+func TestOtpFromWords_InvalidChecksum(t *testing.T) {
+	words := "INCH SEA ANNE LONG AHEM TOFU"
+	if _, ok := otpFromWords(words); ok {
+		t.Fatalf(`otpFromWords("%s") returned true; expected false`, words)
+	}
+	words = "INCH SEA ANNE LONG AHEM TOWN"
+	if _, ok := otpFromWords(words); ok {
+		t.Fatalf(`otpFromWords("%s") returned true; expected false`, words)
+	}
+}
+
+func TestOtpClient(t *testing.T) {
+	auth, err := OtpClient("", "user", []byte("This is a test."), OtpHex)
+	if err != nil {
+		t.Fatalf(`OtpClient() returned error: %v`, err)
+	}
+
+	gotName, gotClientFirst := auth.Mech()
+	expectedName := "OTP"
+	if gotName != expectedName || !gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", true)`, gotName, gotClientFirst, expectedName)
+	}
+
+	gotIR, err := auth.Data(nil)
+	expectedIR := []byte("\x00user")
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.Equal(gotIR, expectedIR) {
+		t.Fatalf(`Data(nil) returned %q; expected %q`, gotIR, expectedIR)
+	}
+
+	challenge := []byte("otp-md5 99 TeSt ext")
+	gotResponse, err := auth.Data(challenge)
+	expectedResponse := []byte("hex:50fe1962c4965880")
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, challenge, err)
+	}
+	if !bytes.Equal(gotResponse, expectedResponse) {
+		t.Fatalf(`Data("%s") returned %s; expected %s`, challenge, gotResponse, expectedResponse)
+	}
+
+	_, err = auth.Data(nil)
+	if !errors.Is(err, ErrInvalidState) {
+		t.Fatalf(`Data returned error: %v; expected ErrInvalidState`, err)
+	}
+}
+
+func TestOtpClient_Words(t *testing.T) {
+	auth, err := OtpClient("RequestedAuthz", "user", []byte("OTP's are good"), OtpWords)
+	if err != nil {
+		t.Fatalf(`OtpClient() returned error: %v`, err)
+	}
+
+	gotIR, err := auth.Data(nil)
+	expectedIR := []byte("RequestedAuthz\x00user")
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.Equal(gotIR, expectedIR) {
+		t.Fatalf(`Data(nil) returned %q; expected %q`, gotIR, expectedIR)
+	}
+
+	challenge := []byte("otp-sha1 1 correct ext")
+	gotResponse, err := auth.Data(challenge)
+	expectedResponse := []byte("word:FLIT DOSE ALSO MEW DRUM DEFY")
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, challenge, err)
+	}
+	if !bytes.Equal(gotResponse, expectedResponse) {
+		t.Fatalf(`Data("%s") returned %s; expected %s`, challenge, gotResponse, expectedResponse)
+	}
+}
+
+func TestOtpClient_ShortPassphrase(t *testing.T) {
+	_, err := OtpClient("", "user", []byte("short"), OtpHex)
+	if !errors.Is(err, ErrInvalidPassphrase) {
+		t.Fatalf(`OtpClient() returned error: %v; expected ErrInvalidPassphrase`, err)
+	}
+}
+
+func TestOtpServer_DeriveAuthz(t *testing.T) {
+	auth := &fakeOtpAuthenticator{}
+	auth.state, _ = NewOtpState("md5", []byte("This is a test."), "TeSt", 100)
+	mech := OtpServer(auth)
+
+	gotName, gotClientFirst := mech.Mech()
+	expectedName := "OTP"
+	if gotName != expectedName || !gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", true)`, gotName, gotClientFirst, expectedName)
+	}
+
+	ir := []byte("\x00user")
+	gotChallenge, err := mech.Data(ir)
+	expectedChallenge := []byte("otp-md5 99 TeSt ext")
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+	if !bytes.Equal(gotChallenge, expectedChallenge) {
+		t.Fatalf(`Data("%s") returned %s; expected %s`, ir, gotChallenge, expectedChallenge)
+	}
+
+	response := []byte("word:bail tuft bits gang chef thy")
+	gotChallenge, err = mech.Data(response)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, nil)`, response, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := mech.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+
+	if auth.state.Sequence != 99 || hex.EncodeToString(auth.state.Otp[:]) != "50fe1962c4965880" {
+		t.Fatalf(`AdvanceState() stored sequence %d and OTP %x; expected 99 and 50fe1962c4965880`, auth.state.Sequence, auth.state.Otp)
+	}
+}
+
+func TestOtpServerContext(t *testing.T) {
+	auth := &fakeOtpContextAuthenticator{}
+	auth.state, _ = NewOtpState("md5", []byte("This is a test."), "TeSt", 100)
+	mech := OtpServerContext(auth)
+
+	ctx := context.WithValue(context.Background(), fakeOtpContextKey{}, "C")
+	ir := []byte("\x00user")
+	if _, err := mech.DataContext(ctx, ir); err != nil {
+		t.Fatalf(`DataContext("%s") returned error: %v`, ir, err)
+	}
+
+	response := []byte("word:bail tuft bits gang chef thy")
+	gotChallenge, err := mech.DataContext(ctx, response)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`DataContext("%s") returned ("%s", %v); expected (nil, nil)`, response, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := mech.HasCompleted()
+	expectedAuthz := "userC"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+	if auth.state.Sequence != 99 {
+		t.Fatalf(`AdvanceStateContext() stored sequence %d; expected 99`, auth.state.Sequence)
+	}
+}
+
+func TestOtpServer_ReplayedOtp(t *testing.T) {
+	auth := &fakeOtpAuthenticator{}
+	auth.state, _ = NewOtpState("sha1", []byte("This is a test."), "TeSt", 1)
+	mech := OtpServer(auth)
+
+	ir := []byte("RequestedAuthz\x00user")
+	if _, err := mech.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	response := []byte("hex:BB9E 6AE1 979D 8FF4")
+	gotChallenge, err := mech.Data(response)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, nil)`, response, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := mech.HasCompleted()
+	expectedAuthz := "RequestedAuthz"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+
+	mech = OtpServer(auth)
+	gotChallenge, err = mech.Data(ir)
+	if gotChallenge != nil || !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, ErrAuthenticationFailed)`, ir, gotChallenge, err)
+	}
+}
+
+func TestOtpServer_WrongOtp(t *testing.T) {
+	auth := &fakeOtpAuthenticator{}
+	auth.state, _ = NewOtpState("md5", []byte("This is a test."), "TeSt", 100)
+	mech := OtpServer(auth)
+
+	ir := []byte("\x00user")
+	if _, err := mech.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	response := []byte("hex:7965e05436f5029f")
+	gotChallenge, err := mech.Data(response)
+	if gotChallenge != nil || !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, ErrAuthenticationFailed)`, response, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := mech.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+	if auth.state.Sequence != 100 {
+		t.Fatalf(`AdvanceState() stored sequence %d; expected 100`, auth.state.Sequence)
+	}
+}
+
+func TestOtpServer_Unauthorized(t *testing.T) {
+	auth := &fakeOtpAuthenticator{}
+	auth.state, _ = NewOtpState("md5", []byte("This is a test."), "TeSt", 100)
+	mech := OtpServer(auth)
+
+	ir := []byte("InvalidAuthz\x00user")
+	if _, err := mech.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	response := []byte("hex:50fe1962c4965880")
+	gotChallenge, err := mech.Data(response)
+	if gotChallenge != nil || !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, ErrUnauthorized)`, response, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := mech.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+type fakeOtpAuthenticator struct {
+	state OtpState
+}
+
+func (f *fakeOtpAuthenticator) GetState(authn string) (OtpState, error) {
+	return f.state, nil
+}
+
+func (f *fakeOtpAuthenticator) AdvanceState(authn string, prev, next OtpState) bool {
+	if f.state != prev {
+		return false
+	}
+	f.state = next
+	return true
+}
+
+func (*fakeOtpAuthenticator) DeriveAuthz(authn string) string {
+	return authn + "Z"
+}
+
+func (*fakeOtpAuthenticator) Authorize(authz, authn string) bool {
+	return authz == authn+"Z" || authz == "RequestedAuthz"
+}
+
+// fakeOtpContextKey is the context key of the value that
+// fakeOtpContextAuthenticator uses as authz suffix.
+type fakeOtpContextKey struct{}
+
+type fakeOtpContextAuthenticator struct {
+	fakeOtpAuthenticator
+}
+
+func (f *fakeOtpContextAuthenticator) GetStateContext(ctx context.Context, authn string) (OtpState, error) {
+	return f.GetState(authn)
+}
+
+func (f *fakeOtpContextAuthenticator) AdvanceStateContext(ctx context.Context, authn string, prev, next OtpState) bool {
+	return f.AdvanceState(authn, prev, next)
+}
+
+func (*fakeOtpContextAuthenticator) DeriveAuthzContext(ctx context.Context, authn string) string {
+	suffix, _ := ctx.Value(fakeOtpContextKey{}).(string)
+	return authn + suffix
+}
+
+func (*fakeOtpContextAuthenticator) AuthorizeContext(ctx context.Context, authz, authn string) bool {
+	suffix, _ := ctx.Value(fakeOtpContextKey{}).(string)
+	return authn+suffix == authz
+}
@@ -2,6 +2,7 @@ package sasler
 
 import (
 	"bytes"
+	"context"
 
 	"github.com/xdg-go/stringprep"
 )
@@ -33,43 +34,85 @@ type PlainAuthenticator interface {
 	Authorize(authz, authn string) bool
 }
 
+// PlainContextAuthenticator is the context-aware variant of
+// [PlainAuthenticator], which is supplied to [PlainServerContext]. The context
+// is the one passed to DataContext.
+type PlainContextAuthenticator interface {
+	// VerifyPasswdContext is the context-aware variant of VerifyPasswd.
+	VerifyPasswdContext(ctx context.Context, authn string, passwd []byte) bool
+	// DeriveAuthzContext is the context-aware variant of DeriveAuthz.
+	DeriveAuthzContext(ctx context.Context, authn string) string
+	// AuthorizeContext is the context-aware variant of Authorize.
+	AuthorizeContext(ctx context.Context, authz, authn string) bool
+}
+
+// plainContextAdapter adapts a PlainAuthenticator to a
+// PlainContextAuthenticator that ignores the context.
+type plainContextAdapter struct {
+	auth PlainAuthenticator
+}
+
+func (a plainContextAdapter) VerifyPasswdContext(_ context.Context, authn string, passwd []byte) bool {
+	return a.auth.VerifyPasswd(authn, passwd)
+}
+
+func (a plainContextAdapter) DeriveAuthzContext(_ context.Context, authn string) string {
+	return a.auth.DeriveAuthz(authn)
+}
+
+func (a plainContextAdapter) AuthorizeContext(_ context.Context, authz, authn string) bool {
+	return a.auth.Authorize(authz, authn)
+}
+
 // PlainServer returns a ServerMech implementation for the PLAIN mechanism, as
 // specified in [RFC 4616].
 //
 // [RFC 4616]: https://tools.ietf.org/html/rfc4616.
 func PlainServer(auth PlainAuthenticator) ServerMech {
-	cb := func(ir []byte) (string, error) {
+	return PlainServerContext(plainContextAdapter{auth})
+}
+
+// PlainServerContext is like [PlainServer], but uses a context-aware
+// authenticator.
+func PlainServerContext(auth PlainContextAuthenticator) ServerMech {
+	cb := func(ctx context.Context, ir []byte, result *Result) error {
 		delim := bytes.IndexByte(ir, 0)
 		if delim == -1 {
-			return "", ErrInvalidMessage
+			return newError("PLAIN", "initial response", ErrInvalidMessage, ReasonMalformedMessage)
 		}
 		authz := string(ir[:delim])
 		ir = ir[delim+1:]
 		delim = bytes.IndexByte(ir, 0)
 		if delim == -1 {
-			return "", ErrInvalidMessage
+			return newError("PLAIN", "initial response", ErrInvalidMessage, ReasonMalformedMessage)
 		}
 		authn, err := stringprep.SASLprep.Prepare(string(ir[:delim]))
 		if err != nil {
-			return "", ErrInvalidMessage
+			e := malformedAttribute("PLAIN", "initial response", "authcid")
+			e.Err = err
+			return e
 		}
+		result.Authn = authn
 		passwd, err := stringprep.SASLprep.Prepare(string(ir[delim+1:]))
 		if err != nil {
-			return "", ErrInvalidMessage
+			e := malformedAttribute("PLAIN", "initial response", "passwd")
+			e.Err = err
+			return e
 		}
-		if !auth.VerifyPasswd(authn, []byte(passwd)) {
-			return "", ErrAuthenticationFailed
+		if !auth.VerifyPasswdContext(ctx, authn, []byte(passwd)) {
+			return newError("PLAIN", "initial response", ErrAuthenticationFailed, ReasonBadCredentials)
 		}
 		if authz == "" {
-			authz = auth.DeriveAuthz(authn)
+			authz = auth.DeriveAuthzContext(ctx, authn)
 			if authz == "" {
-				return "", ErrAuthenticationFailed
+				return newError("PLAIN", "initial response", ErrAuthenticationFailed, ReasonNoAuthz)
 			}
 		}
-		if !auth.Authorize(authz, authn) {
-			return "", ErrUnauthorized
+		if !auth.AuthorizeContext(ctx, authz, authn) {
+			return newError("PLAIN", "initial response", ErrUnauthorized, ReasonUnauthorized)
 		}
-		return authz, nil
+		result.Authz = authz
+		return nil
 	}
 	return &singleMessageServer{name: "PLAIN", cb: cb}
 }
@@ -2,7 +2,9 @@ package sasler_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"reflect"
 	"testing"
 
 	"github.com/phedny/sasler"
@@ -27,11 +29,46 @@ func TestPlainClient(t *testing.T) {
 	}
 
 	_, err = auth.Data(nil)
-	if err != sasler.ErrInvalidState {
+	if !errors.Is(err, sasler.ErrInvalidState) {
 		t.Fatalf(`Data returned error: %v; expected ErrInvalidState`, err)
 	}
 }
 
+func TestPlainClient_Success(t *testing.T) {
+	auth := sasler.PlainClient("", "user", []byte("password"))
+
+	err := auth.Success(nil)
+	if !errors.Is(err, sasler.ErrInvalidState) {
+		t.Fatalf(`Success(nil) returned error: %v; expected ErrInvalidState`, err)
+	}
+
+	if _, err := auth.Data(nil); err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+
+	data := []byte("unexpected")
+	err = auth.Success(data)
+	if !errors.Is(err, sasler.ErrInvalidMessage) {
+		t.Fatalf(`Success("%s") returned error: %v; expected ErrInvalidMessage`, data, err)
+	}
+
+	if err := auth.Success(nil); err != nil {
+		t.Fatalf(`Success(nil) returned error: %v`, err)
+	}
+}
+
+func TestPlainClient_Abort(t *testing.T) {
+	auth := sasler.PlainClient("", "user", []byte("password"))
+	auth.Abort()
+
+	if _, err := auth.Data(nil); !errors.Is(err, sasler.ErrAborted) {
+		t.Fatalf(`Data(nil) returned error: %v; expected ErrAborted`, err)
+	}
+	if err := auth.Success(nil); !errors.Is(err, sasler.ErrAborted) {
+		t.Fatalf(`Success(nil) returned error: %v; expected ErrAborted`, err)
+	}
+}
+
 func TestPlainServer_DeriveAuthz(t *testing.T) {
 	auth := sasler.PlainServer(&FakePlainAuthenticator{})
 
@@ -76,6 +113,38 @@ func TestPlainServer_WrongPasswd(t *testing.T) {
 	}
 }
 
+func TestPlainServer_WrongPasswdError(t *testing.T) {
+	auth := sasler.PlainServer(&FakePlainAuthenticator{})
+
+	ir := []byte("\x00user\x00wrong-password")
+	_, err := auth.Data(ir)
+	var gotErr *sasler.Error
+	if !errors.As(err, &gotErr) {
+		t.Fatalf(`Data("%s") returned error %v; expected *Error`, ir, err)
+	}
+	if gotErr.Mech != "PLAIN" || gotErr.Reason != sasler.ReasonBadCredentials || gotErr.Kind != sasler.ErrAuthenticationFailed {
+		t.Fatalf(`Data("%s") returned error %#v; expected PLAIN bad credentials`, ir, gotErr)
+	}
+	expectedMessage := "sasler: PLAIN initial response: bad credentials"
+	if err.Error() != expectedMessage {
+		t.Fatalf(`Error() returned "%s"; expected "%s"`, err.Error(), expectedMessage)
+	}
+}
+
+func TestPlainServer_MalformedAuthcid(t *testing.T) {
+	auth := sasler.PlainServer(&FakePlainAuthenticator{})
+
+	ir := []byte("\x00user\u0007\x00password")
+	_, err := auth.Data(ir)
+	var gotErr *sasler.Error
+	if !errors.As(err, &gotErr) || !errors.Is(err, sasler.ErrInvalidMessage) {
+		t.Fatalf(`Data("%s") returned error %v; expected *Error of kind ErrInvalidMessage`, ir, err)
+	}
+	if gotErr.Reason != sasler.ReasonMalformedAttribute || gotErr.Attribute != "authcid" || gotErr.Err == nil {
+		t.Fatalf(`Data("%s") returned error %#v; expected malformed authcid with cause`, ir, gotErr)
+	}
+}
+
 func TestPlainServer_RequestedAuthz(t *testing.T) {
 	auth := sasler.PlainServer(&FakePlainAuthenticator{})
 
@@ -120,6 +189,104 @@ func TestPlainServer_Unauthorized(t *testing.T) {
 	}
 }
 
+func TestPlainServer_Result(t *testing.T) {
+	auth := sasler.PlainServer(&FakePlainAuthenticator{})
+
+	ir := []byte("\x00user\x00password")
+	if result := auth.Result(); result != nil {
+		t.Fatalf(`Result() returned %+v before Data("%s"); expected nil`, result, ir)
+	}
+	_, err := auth.Data(ir)
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	result := auth.Result()
+	expected := sasler.Result{Mech: "PLAIN", Succeeded: true, Authn: "user", Authz: "userZ"}
+	if result == nil || !reflect.DeepEqual(*result, expected) {
+		t.Fatalf(`Result() returned %+v; expected %+v`, result, expected)
+	}
+}
+
+func TestPlainServer_ResultWrongPasswd(t *testing.T) {
+	auth := sasler.PlainServer(&FakePlainAuthenticator{})
+
+	ir := []byte("userZ\x00user\x00wrong-password")
+	_, err := auth.Data(ir)
+	if !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Data("%s") returned error %v; expected ErrAuthenticationFailed`, ir, err)
+	}
+
+	result := auth.Result()
+	expected := sasler.Result{Mech: "PLAIN", Authn: "user"}
+	if result == nil || !reflect.DeepEqual(*result, expected) {
+		t.Fatalf(`Result() returned %+v; expected %+v`, result, expected)
+	}
+}
+
+func TestPlainServer_Abort(t *testing.T) {
+	auth := sasler.PlainServer(&FakePlainAuthenticator{})
+
+	if result := auth.Result(); result != nil {
+		t.Fatalf(`Result() returned %+v; expected nil`, result)
+	}
+
+	auth.Abort()
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	if !gotCompleted || gotAuthz != "" {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "")`, gotCompleted, gotAuthz)
+	}
+
+	gotResult := auth.Result()
+	expectedResult := &sasler.Result{Mech: "PLAIN", Aborted: true}
+	if !reflect.DeepEqual(gotResult, expectedResult) {
+		t.Fatalf(`Result() returned %+v; expected %+v`, gotResult, expectedResult)
+	}
+
+	ir := []byte("\x00user\x00password")
+	if _, err := auth.Data(ir); !errors.Is(err, sasler.ErrAborted) {
+		t.Fatalf(`Data("%s") returned error: %v; expected ErrAborted`, ir, err)
+	}
+}
+
+func TestPlainServer_AbortAfterCompletion(t *testing.T) {
+	auth := sasler.PlainServer(&FakePlainAuthenticator{})
+
+	ir := []byte("\x00user\x00password")
+	if _, err := auth.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	auth.Abort()
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+	if result := auth.Result(); result.Aborted || !result.Succeeded {
+		t.Fatalf(`Result() returned %+v; expected succeeded result`, result)
+	}
+}
+
+func TestPlainServerContext(t *testing.T) {
+	auth := sasler.PlainServerContext(&FakePlainContextAuthenticator{})
+
+	ctx := context.WithValue(context.Background(), fakePlainContextKey{}, "Z")
+	ir := []byte("\x00user\x00password")
+	gotChallenge, err := auth.DataContext(ctx, ir)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`DataContext("%s") returned ("%s", %v); expected (nil, nil)`, ir, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
 type FakePlainAuthenticator struct{}
 
 func (*FakePlainAuthenticator) VerifyPasswd(authn string, passwd []byte) bool {
@@ -139,3 +306,23 @@ func (*FakePlainAuthenticator) DeriveAuthz(authn string) string {
 func (*FakePlainAuthenticator) Authorize(authz, authn string) bool {
 	return authn == "admin" || authn+"Z" == authz
 }
+
+// fakePlainContextKey is the context key of the value that
+// FakePlainContextAuthenticator uses as authz suffix.
+type fakePlainContextKey struct{}
+
+type FakePlainContextAuthenticator struct{}
+
+func (*FakePlainContextAuthenticator) VerifyPasswdContext(ctx context.Context, authn string, passwd []byte) bool {
+	return (&FakePlainAuthenticator{}).VerifyPasswd(authn, passwd)
+}
+
+func (*FakePlainContextAuthenticator) DeriveAuthzContext(ctx context.Context, authn string) string {
+	suffix, _ := ctx.Value(fakePlainContextKey{}).(string)
+	return authn + suffix
+}
+
+func (*FakePlainContextAuthenticator) AuthorizeContext(ctx context.Context, authz, authn string) bool {
+	suffix, _ := ctx.Value(fakePlainContextKey{}).(string)
+	return authn+suffix == authz
+}
@@ -0,0 +1,93 @@
+package sasler
+
+import "bytes"
+
+// redirectClient is used for the client-side implementation of mechanisms that
+// send an identifier to the server, receive a URL to redirect the user agent
+// to, and then respond with an empty message once the user agent has finished
+// authentication with a third party. If errorPrefix is set, the server may
+// report a failure in a message starting with errorPrefix, to which the client
+// responds with an empty message.
+type redirectClient struct {
+	name        string
+	ir          []byte
+	errorPrefix string
+	redirect    func(redirectURL string) error
+	dataFn      func([]byte) ([]byte, error)
+	completed   bool
+	aborted     bool
+}
+
+// Mech returns the mechanism name, and true for client-first.
+func (m *redirectClient) Mech() (string, bool) {
+	return m.name, true
+}
+
+// Data returns the initial response on the first call, and on the second call
+// redirects the user agent to the URL in the challenge.
+func (m *redirectClient) Data(challenge []byte) ([]byte, error) {
+	if m.dataFn == nil {
+		return nil, ErrInvalidState
+	}
+	return m.dataFn(challenge)
+}
+
+// Success accepts the success indication once the user agent has been
+// redirected, and returns ErrInvalidState otherwise.
+func (m *redirectClient) Success(data []byte) error {
+	if m.aborted {
+		return ErrAborted
+	}
+	return clientSuccess(data, m.Data, &m.completed)
+}
+
+// Abort stops the mechanism, as it holds no secrets.
+func (m *redirectClient) Abort() {
+	m.dataFn = clientAborted
+	m.completed = false
+	m.aborted = true
+}
+
+// initialResponse returns the initial response.
+func (m *redirectClient) initialResponse(challenge []byte) ([]byte, error) {
+	if len(challenge) > 0 {
+		m.dataFn = m.failed
+		return nil, ErrInvalidMessage
+	}
+	m.dataFn = m.redirectUserAgent
+	return m.ir, nil
+}
+
+// redirectUserAgent calls the redirect function with the URL received from the
+// server, and returns an empty response when it returns without error.
+func (m *redirectClient) redirectUserAgent(challenge []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	if len(challenge) == 0 {
+		return nil, ErrInvalidMessage
+	}
+	if err := m.redirect(string(challenge)); err != nil {
+		return nil, err
+	}
+	if m.errorPrefix != "" {
+		m.dataFn = m.serverError
+	}
+	m.completed = true
+	return []byte{}, nil
+}
+
+// serverError accepts a message starting with errorPrefix, which the server
+// sends to report a failed authentication, and returns an empty response.
+func (m *redirectClient) serverError(challenge []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	m.completed = false
+	if !bytes.HasPrefix(challenge, []byte(m.errorPrefix)) {
+		return nil, ErrInvalidMessage
+	}
+	return []byte{}, nil
+}
+
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *redirectClient) failed(challenge []byte) ([]byte, error) {
+	return nil, ErrInvalidState
+}
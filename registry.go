@@ -0,0 +1,115 @@
+package sasler
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrUnknownMechanism is returned by a [Registry] when a mechanism is requested
+// that hasn't been registered.
+var ErrUnknownMechanism = errors.New("sasler: unknown mechanism")
+
+// ClientFactory returns a new ClientMech for a single authentication exchange.
+type ClientFactory func() (ClientMech, error)
+
+// ServerFactory returns a new ServerMech for a single authentication exchange.
+type ServerFactory func() (ServerMech, error)
+
+// Registry maps mechanism names to factories that create client-side and
+// server-side mechanism implementations. Applications register the mechanisms
+// they support, with their credentials or authenticators captured by the
+// factories, and create a mechanism once its name has been negotiated. Any
+// mechanism can be registered, including third-party mechanisms. A Registry
+// is safe for concurrent use. The zero value is an empty Registry.
+type Registry struct {
+	mu      sync.RWMutex
+	clients map[string]ClientFactory
+	servers map[string]ServerFactory
+}
+
+// RegisterClient registers a factory for the client-side implementation of
+// the mechanism with the supplied name, replacing any factory that was
+// registered for the same name.
+func (r *Registry) RegisterClient(name string, factory ClientFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.clients == nil {
+		r.clients = make(map[string]ClientFactory)
+	}
+	r.clients[name] = factory
+}
+
+// RegisterServer registers a factory for the server-side implementation of
+// the mechanism with the supplied name, replacing any factory that was
+// registered for the same name.
+func (r *Registry) RegisterServer(name string, factory ServerFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.servers == nil {
+		r.servers = make(map[string]ServerFactory)
+	}
+	r.servers[name] = factory
+}
+
+// Unregister removes the client-side and server-side factories of the
+// mechanism with the supplied name.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, name)
+	delete(r.servers, name)
+}
+
+// ClientMechs returns the sorted names of the mechanisms for which a
+// client-side factory has been registered.
+func (r *Registry) ClientMechs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.clients))
+	for name := range r.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ServerMechs returns the sorted names of the mechanisms for which a
+// server-side factory has been registered, which are the mechanisms a server
+// advertises to clients.
+func (r *Registry) ServerMechs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.servers))
+	for name := range r.servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewClient returns a new ClientMech for the mechanism with the supplied name.
+// Returns ErrUnknownMechanism if no client-side factory has been registered for
+// name, or the error returned by the factory.
+func (r *Registry) NewClient(name string) (ClientMech, error) {
+	r.mu.RLock()
+	factory, ok := r.clients[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownMechanism
+	}
+	return factory()
+}
+
+// NewServer returns a new ServerMech for the mechanism with the supplied name.
+// Returns ErrUnknownMechanism if no server-side factory has been registered for
+// name, or the error returned by the factory.
+func (r *Registry) NewServer(name string) (ServerMech, error) {
+	r.mu.RLock()
+	factory, ok := r.servers[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownMechanism
+	}
+	return factory()
+}
@@ -0,0 +1,39 @@
+package sasler_test
+
+import (
+	"fmt"
+
+	"github.com/phedny/sasler"
+)
+
+func ExampleRegistry() {
+	// Register the mechanisms the server supports, together with the
+	// authenticators they use.
+	var registry sasler.Registry
+	registry.RegisterServer("PLAIN", func() (sasler.ServerMech, error) {
+		return sasler.PlainServer(&myPlainAuthenticator{user: "user", passwd: []byte("pencil")}), nil
+	})
+	registry.RegisterServer("SCRAM-SHA-1", func() (sasler.ServerMech, error) {
+		return sasler.ScramSha1Server(&myScramAuthenticator{})
+	})
+
+	// Advertise the registered mechanisms to the client
+	fmt.Println("Mechanisms:", registry.ServerMechs())
+
+	// Create the mechanism that has been requested by the client
+	mech, err := registry.NewServer("PLAIN")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if _, err := mech.Data([]byte("\x00user\x00pencil")); err != nil {
+		fmt.Println(err)
+		return
+	}
+	_, authz := mech.HasCompleted()
+	fmt.Println("Authorized identity:", authz)
+
+	// Output:
+	// Mechanisms: [PLAIN SCRAM-SHA-1]
+	// Authorized identity: user
+}
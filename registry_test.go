@@ -0,0 +1,78 @@
+package sasler_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/phedny/sasler"
+)
+
+func TestRegistry(t *testing.T) {
+	var registry sasler.Registry
+	registry.RegisterClient("PLAIN", func() (sasler.ClientMech, error) {
+		return sasler.PlainClient("", "user", []byte("password")), nil
+	})
+	registry.RegisterClient("SCRAM-SHA-256", func() (sasler.ClientMech, error) {
+		return sasler.ScramSha256Client("", "user", []byte("password"))
+	})
+	registry.RegisterServer("PLAIN", func() (sasler.ServerMech, error) {
+		return sasler.PlainServer(&FakePlainAuthenticator{}), nil
+	})
+
+	gotClientMechs := registry.ClientMechs()
+	expectedClientMechs := []string{"PLAIN", "SCRAM-SHA-256"}
+	if !reflect.DeepEqual(gotClientMechs, expectedClientMechs) {
+		t.Fatalf(`ClientMechs() returned %v; expected %v`, gotClientMechs, expectedClientMechs)
+	}
+	gotServerMechs := registry.ServerMechs()
+	expectedServerMechs := []string{"PLAIN"}
+	if !reflect.DeepEqual(gotServerMechs, expectedServerMechs) {
+		t.Fatalf(`ServerMechs() returned %v; expected %v`, gotServerMechs, expectedServerMechs)
+	}
+
+	client, err := registry.NewClient("PLAIN")
+	if err != nil {
+		t.Fatalf(`NewClient("PLAIN") returned error: %v`, err)
+	}
+	server, err := registry.NewServer("PLAIN")
+	if err != nil {
+		t.Fatalf(`NewServer("PLAIN") returned error: %v`, err)
+	}
+	ir, err := client.Data(nil)
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if _, err := server.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+	gotCompleted, gotAuthz := server.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+
+	if _, err := registry.NewServer("SCRAM-SHA-256"); !errors.Is(err, sasler.ErrUnknownMechanism) {
+		t.Fatalf(`NewServer("SCRAM-SHA-256") returned error: %v; expected ErrUnknownMechanism`, err)
+	}
+
+	registry.Unregister("PLAIN")
+	if _, err := registry.NewClient("PLAIN"); !errors.Is(err, sasler.ErrUnknownMechanism) {
+		t.Fatalf(`NewClient("PLAIN") returned error: %v; expected ErrUnknownMechanism`, err)
+	}
+	if _, err := registry.NewServer("PLAIN"); !errors.Is(err, sasler.ErrUnknownMechanism) {
+		t.Fatalf(`NewServer("PLAIN") returned error: %v; expected ErrUnknownMechanism`, err)
+	}
+}
+
+func TestRegistry_FactoryError(t *testing.T) {
+	factoryErr := errors.New("factory failed")
+	var registry sasler.Registry
+	registry.RegisterServer("CUSTOM", func() (sasler.ServerMech, error) {
+		return nil, factoryErr
+	})
+
+	if _, err := registry.NewServer("CUSTOM"); err != factoryErr {
+		t.Fatalf(`NewServer("CUSTOM") returned error: %v; expected factory error`, err)
+	}
+}
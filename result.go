@@ -0,0 +1,38 @@
+package sasler
+
+import "crypto"
+
+// Result describes the outcome of an authentication exchange, as returned by
+// ServerMech.Result once the exchange has completed. It can be used for audit
+// logging, or to learn which identity authenticated when acting on behalf of
+// the authz.
+type Result struct {
+	// Mech is the name of the mechanism.
+	Mech string
+	// Succeeded is true if authentication has succeeded.
+	Succeeded bool
+	// Aborted is true if the client aborted the authentication process before
+	// it completed. Succeeded is false for an aborted process.
+	Aborted bool
+	// Authn is the identity whose credentials have been verified. If
+	// authentication has failed, it is the identity the client tried to
+	// authenticate as, if known. It is empty for mechanisms that don't transfer
+	// an authn, such as ANONYMOUS and OAUTHBEARER, and for EXTERNAL unless the
+	// authenticator implements ExternalIdentifier.
+	Authn string
+	// Authz is the authorized identity, as returned by HasCompleted. It is
+	// empty if authentication has failed.
+	Authz string
+	// ChannelBinding is true if authentication was bound to the channel with
+	// the client, such as with the -PLUS variants of GS2 mechanisms.
+	ChannelBinding bool
+	// Claims contains the claims of the bearer token of OAUTHBEARER, as
+	// reported by an authenticator that implements OAuthBearerClaimer.
+	Claims map[string]interface{}
+	// PublicKey is the public key the client has proven possession of, as an
+	// *ecdsa.PublicKey for ECDSA-NIST256P-CHALLENGE and the 9798 mechanisms, or
+	// as an *ecdh.PublicKey for ECDH-X25519-CHALLENGE.
+	PublicKey crypto.PublicKey
+	// Trace is the trace information supplied by the client with ANONYMOUS.
+	Trace string
+}
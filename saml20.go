@@ -0,0 +1,218 @@
+package sasler
+
+import "context"
+
+// Saml20Client returns a ClientMech implementation for the SAML20 mechanism,
+// as specified in [RFC 6595]. The idp argument identifies the SAML identity
+// provider, either as a URI or as a user@domain value. The redirect function
+// is called with the URL the server redirects the user agent to. It must
+// return after the user agent has completed authentication with the identity
+// provider, or return an error to abort authentication.
+//
+// [RFC 6595]: https://tools.ietf.org/html/rfc6595
+func Saml20Client(authz, idp string, redirect func(redirectURL string) error) ClientMech {
+	header := gs2Header{cbFlag: gs2NoChannelBinding, authz: authz}
+	m := &redirectClient{
+		name:     "SAML20",
+		ir:       append(header.marshal(), idp...),
+		redirect: redirect,
+	}
+	m.dataFn = m.initialResponse
+	return m
+}
+
+// Saml20Authenticator is supplied to [Saml20Server] to implement the creation
+// of SAML authentication requests, validation of SAML responses, authz
+// derivation and authorization checking.
+type Saml20Authenticator interface {
+	// CreateRequest creates a SAML authentication request for the identity
+	// provider identified by idp, which is either a URI or a user@domain value
+	// supplied by the client. It returns the URL the user agent of the client is
+	// redirected to, and an identifier of the request that is later passed to
+	// VerifyResponse. Return an error to fail authentication.
+	CreateRequest(idp string) (redirectURL, requestID string, err error)
+	// VerifyResponse is called when the client indicates that its user agent
+	// has completed authentication with the identity provider. It returns the
+	// authn asserted by the validated SAML response to the request identified by
+	// requestID, or an error if no valid SAML response has been received.
+	VerifyResponse(requestID string) (authn string, err error)
+	// DeriveAuthz derives an authz from an authn. It is only called when no
+	// authz has been requested by the client. Return the empty string if no
+	// authz can be derived from the supplied authn.
+	DeriveAuthz(authn string) string
+	// Authorize verifies whether an authn is authorized to use the requested or
+	// derived authz. Return false to fail authorization.
+	Authorize(authz, authn string) bool
+}
+
+// Saml20ContextAuthenticator is the context-aware variant of
+// [Saml20Authenticator], which is supplied to [Saml20ServerContext]. The
+// context is the one passed to DataContext.
+type Saml20ContextAuthenticator interface {
+	// CreateRequestContext is the context-aware variant of CreateRequest.
+	CreateRequestContext(ctx context.Context, idp string) (redirectURL, requestID string, err error)
+	// VerifyResponseContext is the context-aware variant of VerifyResponse.
+	VerifyResponseContext(ctx context.Context, requestID string) (authn string, err error)
+	// DeriveAuthzContext is the context-aware variant of DeriveAuthz.
+	DeriveAuthzContext(ctx context.Context, authn string) string
+	// AuthorizeContext is the context-aware variant of Authorize.
+	AuthorizeContext(ctx context.Context, authz, authn string) bool
+}
+
+// saml20ContextAdapter adapts a Saml20Authenticator to a
+// Saml20ContextAuthenticator that ignores the context.
+type saml20ContextAdapter struct {
+	auth Saml20Authenticator
+}
+
+func (a saml20ContextAdapter) CreateRequestContext(_ context.Context, idp string) (string, string, error) {
+	return a.auth.CreateRequest(idp)
+}
+
+func (a saml20ContextAdapter) VerifyResponseContext(_ context.Context, requestID string) (string, error) {
+	return a.auth.VerifyResponse(requestID)
+}
+
+func (a saml20ContextAdapter) DeriveAuthzContext(_ context.Context, authn string) string {
+	return a.auth.DeriveAuthz(authn)
+}
+
+func (a saml20ContextAdapter) AuthorizeContext(_ context.Context, authz, authn string) bool {
+	return a.auth.Authorize(authz, authn)
+}
+
+// saml20ServerMech is a ServerMech implementation of the SAML20 mechanism.
+type saml20ServerMech struct {
+	authz     string
+	authn     string
+	requestID string
+	completed bool
+	succeeded bool
+	aborted   bool
+	auth      Saml20ContextAuthenticator
+	dataFn    func(context.Context, []byte) ([]byte, error)
+}
+
+// Saml20Server returns a ServerMech implementation for the SAML20 mechanism,
+// as specified in [RFC 6595].
+//
+// [RFC 6595]: https://tools.ietf.org/html/rfc6595
+func Saml20Server(auth Saml20Authenticator) ServerMech {
+	return Saml20ServerContext(saml20ContextAdapter{auth})
+}
+
+// Saml20ServerContext is like [Saml20Server], but uses a context-aware
+// authenticator.
+func Saml20ServerContext(auth Saml20ContextAuthenticator) ServerMech {
+	m := &saml20ServerMech{auth: auth}
+	m.dataFn = m.createRequest
+	return m
+}
+
+// Mech returns name SAML20, and true for client-first.
+func (*saml20ServerMech) Mech() (string, bool) {
+	return "SAML20", true
+}
+
+// Data returns the redirect URL on the first call, and verifies that a valid
+// SAML response has been received on the second call.
+func (m *saml20ServerMech) Data(data []byte) ([]byte, error) {
+	return m.DataContext(context.Background(), data)
+}
+
+// DataContext is like Data, but passes ctx on to the authenticator.
+func (m *saml20ServerMech) DataContext(ctx context.Context, data []byte) ([]byte, error) {
+	return serverDataContext(ctx, m, m.dataFn, data)
+}
+
+// createRequest parses the initial response, and returns the URL the user
+// agent of the client must be redirected to.
+func (m *saml20ServerMech) createRequest(ctx context.Context, ir []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	m.completed = true
+	header, idp, err := parseGs2Header(ir)
+	if err != nil {
+		return nil, malformedAttribute("SAML20", "initial response", "gs2-header")
+	}
+	if header.nonStd || len(idp) == 0 {
+		return nil, malformedAttribute("SAML20", "initial response", "idp")
+	}
+	if err := header.checkChannelBinding(nil, false); err != nil {
+		return nil, newError("SAML20", "initial response", err, ReasonChannelBinding)
+	}
+	m.authz = header.authz
+	redirectURL, requestID, err := m.auth.CreateRequestContext(ctx, string(idp))
+	if err != nil {
+		return nil, wrapError("SAML20", "initial response", ErrAuthenticationFailed, ReasonBadCredentials, err)
+	}
+	m.requestID = requestID
+	m.dataFn = m.verifyResponse
+	m.completed = false
+	return []byte(redirectURL), nil
+}
+
+// verifyResponse accepts the empty response of the client, and checks the
+// SAML response that has been received for the request.
+func (m *saml20ServerMech) verifyResponse(ctx context.Context, data []byte) ([]byte, error) {
+	m.dataFn = m.failed
+	m.completed = true
+	if len(data) > 0 {
+		return nil, newError("SAML20", "empty response", ErrInvalidMessage, ReasonMalformedMessage)
+	}
+	authn, err := m.auth.VerifyResponseContext(ctx, m.requestID)
+	if err != nil || authn == "" {
+		return nil, wrapError("SAML20", "empty response", ErrAuthenticationFailed, ReasonBadCredentials, err)
+	}
+	m.authn = authn
+	if m.authz == "" {
+		m.authz = m.auth.DeriveAuthzContext(ctx, m.authn)
+		if m.authz == "" {
+			return nil, newError("SAML20", "empty response", ErrAuthenticationFailed, ReasonNoAuthz)
+		}
+	}
+	if !m.auth.AuthorizeContext(ctx, m.authz, m.authn) {
+		m.authz = ""
+		return nil, newError("SAML20", "empty response", ErrUnauthorized, ReasonUnauthorized)
+	}
+	m.succeeded = true
+	return nil, nil
+}
+
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *saml20ServerMech) failed(ctx context.Context, data []byte) ([]byte, error) {
+	return nil, ErrInvalidState
+}
+
+// HasCompleted returns true if authentication has finished, and if true, it
+// also returns the authorized authz, if any.
+func (m *saml20ServerMech) HasCompleted() (bool, string) {
+	switch {
+	case !m.completed:
+		return false, ""
+	case !m.succeeded:
+		return true, ""
+	}
+	return true, m.authz
+}
+
+// Result returns the authn asserted by the SAML response.
+func (m *saml20ServerMech) Result() *Result {
+	completed, authz := m.HasCompleted()
+	if !completed {
+		return nil
+	}
+	return &Result{Mech: "SAML20", Succeeded: m.succeeded, Aborted: m.aborted, Authn: m.authn, Authz: authz}
+}
+
+// Abort drops the request ID, if authentication is still in progress.
+func (m *saml20ServerMech) Abort() {
+	if m.completed {
+		return
+	}
+	m.requestID = ""
+	m.authz = ""
+	m.dataFn = serverAborted
+	m.completed = true
+	m.aborted = true
+}
@@ -0,0 +1,86 @@
+package sasler_test
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/phedny/sasler"
+)
+
+func ExampleSaml20Authenticator() {
+	auth := mySaml20Authenticator{responses: map[string]string{}}
+	mech := sasler.Saml20Server(&auth)
+
+	// SAML20 expects an initial response containing the identity provider
+	redirectURL, err := mech.Data([]byte("n,,https://idp.example.com/"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("Redirect to:", string(redirectURL))
+
+	// ReceiveSamlResponse() represents the assertion consumer service of the
+	// application receiving a SAML response from the identity provider, while
+	// the client waits for its user agent.
+	auth.ReceiveSamlResponse("request-1", "user")
+
+	// The client responds with an empty message when its user agent is done
+	if _, err := mech.Data(nil); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// Retrieve authorized identity
+	completed, authz := mech.HasCompleted()
+	if completed {
+		fmt.Println("Authorized identity:", authz)
+	}
+
+	// Output:
+	// Redirect to: https://idp.example.com/sso?RelayState=request-1
+	// Authorized identity: user
+}
+
+// mySaml20Authenticator is an example Saml20Authenticator that stores the
+// authn asserted by validated SAML responses in a map.
+type mySaml20Authenticator struct {
+	lastID    int
+	responses map[string]string
+}
+
+// CreateRequest creates a redirect URL that contains the request ID as relay
+// state. A real implementation would also include a signed SAMLRequest.
+func (a *mySaml20Authenticator) CreateRequest(idp string) (string, string, error) {
+	a.lastID++
+	requestID := "request-" + strconv.Itoa(a.lastID)
+	return idp + "sso?RelayState=" + requestID, requestID, nil
+}
+
+// ReceiveSamlResponse stores the authn of a SAML response, after it has been
+// validated by the assertion consumer service.
+func (a *mySaml20Authenticator) ReceiveSamlResponse(requestID, authn string) {
+	a.responses[requestID] = authn
+}
+
+// VerifyResponse returns the authn from the SAML response that was received
+// for the request.
+func (a *mySaml20Authenticator) VerifyResponse(requestID string) (string, error) {
+	authn, ok := a.responses[requestID]
+	if !ok {
+		return "", errors.New("no SAML response received")
+	}
+	delete(a.responses, requestID)
+	return authn, nil
+}
+
+// DeriveAuthz derives an authz from an authn.
+func (a *mySaml20Authenticator) DeriveAuthz(authn string) string {
+	return authn
+}
+
+// Authorize checks whether the authn is authorized to act on behalf of the
+// authz.
+func (a *mySaml20Authenticator) Authorize(authz, authn string) bool {
+	return authz == authn
+}
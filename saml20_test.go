@@ -0,0 +1,232 @@
+package sasler_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/phedny/sasler"
+)
+
+func TestSaml20Client(t *testing.T) {
+	var gotURL string
+	redirect := func(redirectURL string) error {
+		gotURL = redirectURL
+		return nil
+	}
+	auth := sasler.Saml20Client("", "https://saml.example.org/", redirect)
+
+	gotName, gotClientFirst := auth.Mech()
+	expectedName := "SAML20"
+	if gotName != expectedName || !gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", true)`, gotName, gotClientFirst, expectedName)
+	}
+
+	gotIR, err := auth.Data(nil)
+	expectedIR := []byte("n,,https://saml.example.org/")
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.Equal(gotIR, expectedIR) {
+		t.Fatalf(`Data(nil) returned %s; expected %s`, gotIR, expectedIR)
+	}
+
+	challenge := []byte("https://saml.example.org/SAML/Browser?SAMLRequest=PHNhbWxwOl")
+	gotResponse, err := auth.Data(challenge)
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, challenge, err)
+	}
+	if gotResponse == nil || len(gotResponse) != 0 {
+		t.Fatalf(`Data("%s") returned %v; expected empty response`, challenge, gotResponse)
+	}
+	if gotURL != string(challenge) {
+		t.Fatalf(`redirect() called with "%s"; expected "%s"`, gotURL, challenge)
+	}
+
+	_, err = auth.Data(nil)
+	if !errors.Is(err, sasler.ErrInvalidState) {
+		t.Fatalf(`Data returned error: %v; expected ErrInvalidState`, err)
+	}
+}
+
+func TestSaml20Client_RedirectFailed(t *testing.T) {
+	redirectErr := errors.New("user agent closed")
+	redirect := func(redirectURL string) error {
+		return redirectErr
+	}
+	auth := sasler.Saml20Client("RequestedAuthz", "user@example.org", redirect)
+
+	gotIR, err := auth.Data(nil)
+	expectedIR := []byte("n,a=RequestedAuthz,user@example.org")
+	if err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	if !bytes.Equal(gotIR, expectedIR) {
+		t.Fatalf(`Data(nil) returned %s; expected %s`, gotIR, expectedIR)
+	}
+
+	challenge := []byte("https://saml.example.org/SAML/Browser?SAMLRequest=PHNhbWxwOl")
+	gotResponse, err := auth.Data(challenge)
+	if gotResponse != nil || err != redirectErr {
+		t.Fatalf(`Data("%s") returned (%q, %v); expected (nil, %v)`, challenge, gotResponse, err, redirectErr)
+	}
+}
+
+func TestSaml20Server_DeriveAuthz(t *testing.T) {
+	auth := sasler.Saml20Server(&fakeSaml20Authenticator{})
+
+	gotName, gotClientFirst := auth.Mech()
+	expectedName := "SAML20"
+	if gotName != expectedName || !gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", true)`, gotName, gotClientFirst, expectedName)
+	}
+
+	ir := []byte("n,,https://saml.example.org/")
+	gotChallenge, err := auth.Data(ir)
+	expectedChallenge := []byte("https://saml.example.org/SAML/Browser?ID=request-1")
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+	if !bytes.Equal(gotChallenge, expectedChallenge) {
+		t.Fatalf(`Data("%s") returned %s; expected %s`, ir, gotChallenge, expectedChallenge)
+	}
+
+	gotChallenge, err = auth.Data(nil)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data(nil) returned ("%s", %v); expected (nil, nil)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestSaml20Server_RequestedAuthz(t *testing.T) {
+	auth := sasler.Saml20Server(&fakeSaml20Authenticator{})
+
+	ir := []byte("y,a=RequestedAuthz,https://saml.example.org/")
+	if _, err := auth.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	gotChallenge, err := auth.Data(nil)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data(nil) returned ("%s", %v); expected (nil, nil)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "RequestedAuthz"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestSaml20Server_NoResponse(t *testing.T) {
+	auth := sasler.Saml20Server(&fakeSaml20Authenticator{})
+
+	ir := []byte("n,,https://other.example.org/")
+	if _, err := auth.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	gotChallenge, err := auth.Data(nil)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Data(nil) returned ("%s", %v); expected (nil, ErrAuthenticationFailed)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestSaml20Server_ChannelBinding(t *testing.T) {
+	auth := sasler.Saml20Server(&fakeSaml20Authenticator{})
+
+	ir := []byte("p=tls-unique,,https://saml.example.org/")
+	gotChallenge, err := auth.Data(ir)
+	if gotChallenge != nil || !errors.Is(err, sasler.ErrInvalidMessage) {
+		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, ErrInvalidMessage)`, ir, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := ""
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestSaml20ServerContext(t *testing.T) {
+	auth := sasler.Saml20ServerContext(&fakeSaml20ContextAuthenticator{})
+
+	ctx := context.WithValue(context.Background(), fakeSaml20ContextKey{}, "C")
+	ir := []byte("n,,https://saml.example.org/")
+	if _, err := auth.DataContext(ctx, ir); err != nil {
+		t.Fatalf(`DataContext("%s") returned error: %v`, ir, err)
+	}
+
+	gotChallenge, err := auth.DataContext(ctx, nil)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`DataContext(nil) returned ("%s", %v); expected (nil, nil)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "userC"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+type fakeSaml20Authenticator struct {
+	idp string
+}
+
+func (f *fakeSaml20Authenticator) CreateRequest(idp string) (string, string, error) {
+	f.idp = idp
+	return idp + "SAML/Browser?ID=request-1", "request-1", nil
+}
+
+func (f *fakeSaml20Authenticator) VerifyResponse(requestID string) (string, error) {
+	if f.idp != "https://saml.example.org/" || requestID != "request-1" {
+		return "", errors.New("no SAML response received")
+	}
+	return "user", nil
+}
+
+func (*fakeSaml20Authenticator) DeriveAuthz(authn string) string {
+	return authn + "Z"
+}
+
+func (*fakeSaml20Authenticator) Authorize(authz, authn string) bool {
+	return authz == authn+"Z" || authz == "RequestedAuthz"
+}
+
+// fakeSaml20ContextKey is the context key of the value that
+// fakeSaml20ContextAuthenticator uses as authz suffix.
+type fakeSaml20ContextKey struct{}
+
+type fakeSaml20ContextAuthenticator struct {
+	fakeSaml20Authenticator
+}
+
+func (f *fakeSaml20ContextAuthenticator) CreateRequestContext(ctx context.Context, idp string) (string, string, error) {
+	return f.CreateRequest(idp)
+}
+
+func (f *fakeSaml20ContextAuthenticator) VerifyResponseContext(ctx context.Context, requestID string) (string, error) {
+	return f.VerifyResponse(requestID)
+}
+
+func (*fakeSaml20ContextAuthenticator) DeriveAuthzContext(ctx context.Context, authn string) string {
+	suffix, _ := ctx.Value(fakeSaml20ContextKey{}).(string)
+	return authn + suffix
+}
+
+func (*fakeSaml20ContextAuthenticator) AuthorizeContext(ctx context.Context, authz, authn string) bool {
+	suffix, _ := ctx.Value(fakeSaml20ContextKey{}).(string)
+	return authn+suffix == authz
+}
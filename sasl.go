@@ -1,6 +1,10 @@
 // Package sasler contains client-side and server-side implementations for the
-// following SASL mechanisms: ANONYMOUS, ECDSA-NIST256P-CHALLENGE, EXTERNAL,
-// OAUTHBEARER, PLAIN, SCRAM-SHA-1, and SCRAM-SHA-256.
+// following SASL mechanisms: 9798-M-ECDSA-SHA256, 9798-U-ECDSA-SHA256,
+// ANONYMOUS, ECDH-X25519-CHALLENGE, ECDSA-NIST256P-CHALLENGE, EXTERNAL, GSSAPI,
+// HT-SHA-256-ENDP, HT-SHA-256-EXPR, HT-SHA-256-NONE, HT-SHA-256-UNIQ, NTLM,
+// OAUTHBEARER, OPENID20, OTP, PLAIN, SAML20, SCRAM-SHA-1, and SCRAM-SHA-256.
+// It also contains a bridge that exposes any GSS-API mechanism as a mechanism
+// of the GS2 family, such as GS2-KRB5 and GS2-KRB5-PLUS.
 //
 // # Client-side usage
 //
@@ -14,7 +18,8 @@
 //     the server.
 //  4. When the server indicates authentication has finished, you're done.
 //     However, when Data() returns an error, the authentication process has
-//     failed and must be aborted.
+//     failed and must be aborted. Call Abort() when you abort the process for
+//     any other reason, so that the mechanism zeroes its secrets.
 //
 // The [ClientMech] documentation contains an example that demonstrates the
 // process described above.
@@ -26,6 +31,7 @@
 //     user and/or credential storage.
 //  2. When a client request SASL authentication, call the appropriate function
 //     that returns the [ServerMech] implementation requested by the client.
+//     A [Registry] can be used to map mechanism names to these functions.
 //  3. Send a message to the client to acknowledge SASL authentication has been
 //     started.
 //  4. Relay messages between the ServerMech and the client, using appropriate
@@ -34,17 +40,55 @@
 //     or will be sent by the client.
 //  5. Whenever Data() returns an error, the authentication process has failed
 //     and must be aborted. Send a message to the client notifying it about the
-//     abortion.
+//     abortion. Use [errors.As] to obtain the [*Error] that describes the
+//     failure, such as for logging or rate limiting. When the client aborts the
+//     authentication process, call Abort() instead.
 //  6. After each call to Data(), call HasCompleted() to check whether the
 //     authentication process has been completed. If it returned true, it also
-//     returned the authorized identity.
+//     returned the authorized identity. Call Result() for further details, such
+//     as the authenticated identity.
 //
 // The [ServerMech] documentation contains an example that demonstrates the
 // process described above. The documentation for each authenticator interface
 // contains an example implementation.
+//
+// Each authenticator interface has a context-aware variant, such as
+// [PlainContextAuthenticator], that is supplied to the matching *Context
+// function, such as [PlainServerContext]. Call DataContext() instead of Data()
+// to pass a context to the authenticator, so that lookups in databases or
+// remote services are cancelled when the client disconnects or a deadline
+// passes.
+//
+// # Transports
+//
+// Instead of relaying messages by hand, implement [ClientTransport] or
+// [ServerTransport] for the protocol, and call [Authenticate] or [Serve] to
+// run the whole exchange, including aborting the mechanism when it fails.
+//
+// # Stateless servers
+//
+// When each message of the client may be handled by another server instance,
+// such as with an HTTP-based endpoint behind a load balancer, use a
+// [StateSealer] to seal the state of the ServerMech after sending a challenge,
+// and to restore it on a newly created ServerMech on the next instance.
+//
+// # Security layers
+//
+// Some mechanisms, such as GSSAPI, can negotiate a security layer that
+// protects the data exchanged after authentication. Call [SecurityLayerOf]
+// once authentication has completed successfully, and if it returns a
+// [SecurityLayer], use [NewSecurityLayerConn] to protect the connection.
+//
+// # Protocols without initial response
+//
+// Some protocols don't allow the client to send data along with its request to
+// start SASL authentication, and instead have the server send an empty
+// challenge first. Wrap a client-first mechanism using [ServerFirstClient] or
+// [ServerFirstServer] to use it with such a protocol.
 package sasler
 
 import (
+	"context"
 	"errors"
 )
 
@@ -61,6 +105,9 @@ var (
 	// ErrUnauthorized can be returns by a server-side implementation to signal
 	// that the authenticated authn is not authorized to use the requested authz.
 	ErrUnauthorized = errors.New("sasler: unauthorized")
+	// ErrAborted is returned by a mechanism implementation if it is used after
+	// the authentication process has been aborted.
+	ErrAborted = errors.New("sasler: authentication aborted")
 )
 
 // ClientMech describes the functions that are implemented by the client-side
@@ -74,12 +121,24 @@ type ClientMech interface {
 	// providing the bytes of the message. It returns the bytes of the message
 	// that must be returned to the other party, or an error when authentication
 	// failed and must be aborted. If the returned []byte is nil and no error is
-	// returned, authentication has finished successfully.
+	// returned, authentication has finished successfully. If the server sent
+	// the message as a challenge, respond with an empty message.
 	//
 	// On a client-first mechanism, the first call to Data must be done with nil
 	// or zero length slice. On a server-first mechanism, the first call to Data
 	// must be done with the initial challenge received from the server.
 	Data(data []byte) ([]byte, error)
+	// Success must be called when the server indicates that authentication
+	// has succeeded, providing the additional data that was sent along with the
+	// success indication, or nil if there is none. It returns an error if the
+	// additional data is invalid, or if the mechanism expected more data from
+	// the server, such as proof that the server is authentic. If an error is
+	// returned, authentication must be considered to have failed.
+	Success(data []byte) error
+	// Abort must be called when the client aborts the authentication process
+	// before it has completed. It zeroes the secrets held by the mechanism,
+	// after which Data and Success return ErrAborted.
+	Abort()
 }
 
 // ServerMech describes the functions that are implemented by the server-side
@@ -95,12 +154,96 @@ type ServerMech interface {
 	// failed and must be aborted. If the returned []byte is nil and no error is
 	// returned, authentication has finished successfully.
 	//
+	// If HasCompleted returns true after Data returned a non-nil message and no
+	// error, that message is additional data that must be sent along with the
+	// success indication. If the protocol can't carry additional data with the
+	// success indication, send the message as a challenge instead, and pass the
+	// empty response of the client to Data, which then returns nil.
+	//
 	// On a client-first mechanism, the first call to Data must be done with the
 	// initial response received from the client. On a server-first mechanism,
 	// the first call to Data must be done with nil or a zero length slice.
 	Data(data []byte) ([]byte, error)
+	// DataContext is like Data, but passes ctx on to the authenticator of the
+	// mechanism, so that lookups can be cancelled. If ctx is done before or
+	// while the message is processed, the error of ctx is returned, and the
+	// authentication process is aborted in both cases, such that HasCompleted
+	// returns (true, "") and the message can't be retried.
+	DataContext(ctx context.Context, data []byte) ([]byte, error)
 	// HasCompleted returns (true, authz) if the authentication proccess has
 	// completed successfully, or (true, "") if it has failed, or (false, "") if
 	// it's still in progress.
 	HasCompleted() (bool, string)
+	// Result returns the details of the authentication process once it has
+	// completed, whether it has succeeded or failed, or nil if it's still in
+	// progress.
+	Result() *Result
+	// Abort must be called when the client aborts the authentication process,
+	// such as by sending "*" in IMAP. It zeroes the secrets held by the
+	// mechanism, after which Data returns ErrAborted, HasCompleted returns
+	// (true, "") and Result reports the process as aborted. Abort has no effect
+	// on the outcome of a process that has already completed.
+	Abort()
+}
+
+// clientSuccess implements Success for ClientMech implementations that set
+// completed once they expect no more data from the server. Non-empty
+// additional data is passed to dataFn as the final message of the server,
+// which must not result in a response. Returns ErrInvalidState if the
+// mechanism hasn't completed.
+func clientSuccess(data []byte, dataFn func([]byte) ([]byte, error), completed *bool) error {
+	if len(data) > 0 {
+		resp, err := dataFn(data)
+		if err != nil {
+			return err
+		}
+		if resp != nil {
+			*completed = false
+			return ErrInvalidMessage
+		}
+	}
+	if !*completed {
+		return ErrInvalidState
+	}
+	return nil
+}
+
+// zeroBytes overwrites b with zeros, so that secrets don't linger in memory.
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// clientAborted is installed as the data function of a ClientMech
+// implementation once it has been aborted, and always returns ErrAborted.
+func clientAborted(data []byte) ([]byte, error) {
+	return nil, ErrAborted
+}
+
+// serverAborted is installed as the data function of a ServerMech
+// implementation once it has been aborted, and always returns ErrAborted.
+func serverAborted(ctx context.Context, data []byte) ([]byte, error) {
+	return nil, ErrAborted
+}
+
+// serverDataContext implements DataContext for ServerMech implementations,
+// by calling dataFn. Returns the error of ctx if it's done before dataFn is
+// called, or if dataFn fails after ctx is done, as cancellation may have
+// caused the failure. In both cases, m is aborted, so that it has completed
+// regardless of when ctx was cancelled.
+func serverDataContext(ctx context.Context, m ServerMech, dataFn func(context.Context, []byte) ([]byte, error), data []byte) ([]byte, error) {
+	if dataFn == nil {
+		return nil, ErrInvalidState
+	}
+	if err := ctx.Err(); err != nil {
+		m.Abort()
+		return nil, err
+	}
+	resp, err := dataFn(ctx, data)
+	if err != nil && ctx.Err() != nil {
+		m.Abort()
+		return nil, ctx.Err()
+	}
+	return resp, err
 }
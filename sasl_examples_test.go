@@ -7,6 +7,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"errors"
 	"fmt"
 
 	"github.com/phedny/sasler"
@@ -36,6 +37,11 @@ func ExampleClientMech() {
 		command, data := server.Read()
 		switch command {
 		case Success:
+			// Verify the additional data sent along with the success indication
+			if err := mech.Success(data); err != nil {
+				fmt.Println("Authentication failed.", err)
+				return
+			}
 			fmt.Println("Authentication succeeded.")
 			return
 		case Failure:
@@ -96,25 +102,25 @@ func ExampleServerMech() {
 		data, err := mech.Data(data)
 		if err != nil {
 			conn.Write(Failure, nil)
-			switch err {
-			case sasler.ErrAuthenticationFailed:
+			switch {
+			case errors.Is(err, sasler.ErrAuthenticationFailed):
 				fmt.Println("Authentication failed.")
-			case sasler.ErrUnauthorized:
+			case errors.Is(err, sasler.ErrUnauthorized):
 				fmt.Println("Unauthorized.")
 			default:
 				fmt.Println("Authentication aborted.", err)
 			}
 			return
 		}
-		if data != nil {
-			conn.Write(Data, data)
-		}
 		completed, authz := mech.HasCompleted()
 		if completed {
-			conn.Write(Success, nil)
+			// Send the additional data returned by mech, if any, along with the
+			// success indication
+			conn.Write(Success, data)
 			fmt.Println("Authentication succeeded, authorised id:", authz)
 			return
 		}
+		conn.Write(Data, data)
 	}
 
 	// Output: Authentication succeeded, authorised id: username
@@ -169,7 +175,9 @@ func (c *exampleClient2) Read() []byte {
 
 func (*exampleClient2) Abort() {}
 
-func (*exampleClient2) Success() {}
+func (c *exampleClient2) Success(data []byte) {
+	c.mech.Success(data)
+}
 
 func ScramClientConn() exampleClient2 {
 	mech, _ := sasler.ScramSha1Client("", "user", []byte("pencil"))
@@ -0,0 +1,86 @@
+package sasler
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"testing"
+)
+
+func TestServerDataContext_Canceled(t *testing.T) {
+	key, err := GenerateEcdsaKey()
+	if err != nil {
+		t.Fatalf(`GenerateEcdsaKey() returned error: %v`, err)
+	}
+	tests := []struct {
+		name string
+		mech func() (ServerMech, error)
+	}{
+		{"ANONYMOUS", func() (ServerMech, error) { return AnonymousServer("", nil), nil }},
+		{"ECDH-X25519-CHALLENGE", func() (ServerMech, error) { return EcdhX25519ChallengeServer(nil), nil }},
+		{"ECDSA-NIST256P-CHALLENGE", func() (ServerMech, error) { return EcdsaNist256pChallengeServer(nil), nil }},
+		{"EXTERNAL", func() (ServerMech, error) { return ExternalServer(nil), nil }},
+		{"GS2", func() (ServerMech, error) {
+			return Gs2Server(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 1, 1}, nil, nil, nil, false)
+		}},
+		{"GSSAPI", func() (ServerMech, error) { return GssapiServer(nil, GssapiNoSecurityLayer, nil), nil }},
+		{"HT-SHA-256-NONE", func() (ServerMech, error) { return HtSha256Server(nil, nil) }},
+		{"9798-U-ECDSA-SHA256", func() (ServerMech, error) { return Iso9798UEcdsaSha256Server(nil), nil }},
+		{"9798-M-ECDSA-SHA256", func() (ServerMech, error) {
+			return Iso9798MEcdsaSha256Server(nil, key, []*x509.Certificate{})
+		}},
+		{"NTLM", func() (ServerMech, error) { return NtlmServer("", nil), nil }},
+		{"OAUTHBEARER", func() (ServerMech, error) { return OAuthBearerServer(nil), nil }},
+		{"OPENID20", func() (ServerMech, error) { return Openid20Server(nil), nil }},
+		{"OTP", func() (ServerMech, error) { return OtpServer(nil), nil }},
+		{"PLAIN", func() (ServerMech, error) { return PlainServer(nil), nil }},
+		{"SAML20", func() (ServerMech, error) { return Saml20Server(nil), nil }},
+		{"SCRAM-SHA-1", func() (ServerMech, error) { return ScramSha1Server(nil) }},
+		{"SCRAM-SHA-256", func() (ServerMech, error) { return ScramSha256Server(nil) }},
+		{"server-first PLAIN", func() (ServerMech, error) { return ServerFirstServer(PlainServer(nil)), nil }},
+	}
+	for _, test := range tests {
+		mech, err := test.mech()
+		if err != nil {
+			t.Fatalf(`%s: constructor returned error: %v`, test.name, err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		gotChallenge, err := mech.DataContext(ctx, []byte("user"))
+		if gotChallenge != nil || !errors.Is(err, context.Canceled) {
+			t.Fatalf(`%s: DataContext("user") returned (%q, %v); expected (nil, context.Canceled)`, test.name, gotChallenge, err)
+		}
+
+		gotCompleted, gotAuthz := mech.HasCompleted()
+		if !gotCompleted || gotAuthz != "" {
+			t.Fatalf(`%s: HasCompleted() returned (%v, "%s"); expected (true, "")`, test.name, gotCompleted, gotAuthz)
+		}
+		if result := mech.Result(); result == nil || !result.Aborted {
+			t.Fatalf(`%s: Result() returned %+v; expected aborted result`, test.name, result)
+		}
+	}
+}
+
+func TestServerDataContext_CanceledDuringData(t *testing.T) {
+	mech := PlainServer(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	dataFn := func(ctx context.Context, data []byte) ([]byte, error) {
+		cancel()
+		return nil, ErrAuthenticationFailed
+	}
+
+	gotChallenge, err := serverDataContext(ctx, mech, dataFn, []byte("user"))
+	if gotChallenge != nil || !errors.Is(err, context.Canceled) {
+		t.Fatalf(`serverDataContext() returned (%q, %v); expected (nil, context.Canceled)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := mech.HasCompleted()
+	if !gotCompleted || gotAuthz != "" {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "")`, gotCompleted, gotAuthz)
+	}
+	if result := mech.Result(); result == nil || !result.Aborted {
+		t.Fatalf(`Result() returned %+v; expected aborted result`, result)
+	}
+}
@@ -17,7 +17,6 @@ type scramMech struct {
 	gs2Header    []byte
 	saltedPasswd []byte
 	authMessage  bytes.Buffer
-	dataFn       func([]byte) ([]byte, error)
 }
 
 // computeSaltedPassword computes the salted password, using the plaintext
@@ -63,8 +62,9 @@ func (m *scramMech) serverSignature() []byte {
 	return mac.Sum(nil)
 }
 
-// failed always returns ErrInvalidState and is installed in scramMech after a
-// failed or completed authentication.
-func (m *scramMech) failed(challenge []byte) ([]byte, error) {
-	return nil, ErrInvalidState
+// zero zeroes the salted password, and discards the auth message.
+func (m *scramMech) zero() {
+	zeroBytes(m.saltedPasswd)
+	m.saltedPasswd = nil
+	m.authMessage.Reset()
 }
@@ -7,7 +7,6 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"strconv"
-	"strings"
 
 	"github.com/xdg-go/stringprep"
 )
@@ -20,9 +19,12 @@ const (
 // mechanisms.
 type scramClientMech struct {
 	scramMech
-	authz  string
-	authn  string
-	passwd []byte
+	authz     string
+	authn     string
+	passwd    []byte
+	completed bool
+	aborted   bool
+	dataFn    func([]byte) ([]byte, error)
 }
 
 // ScramSha1Client returns a ClientMech implementation for the SCRAM-SHA-1
@@ -135,6 +137,26 @@ func (m *scramClientMech) Data(challenge []byte) ([]byte, error) {
 	return m.dataFn(challenge)
 }
 
+// Success verifies the server signature if it's sent along with the success
+// indication. Returns ErrInvalidState if the server signature hasn't been
+// verified, as the server must prove that it knows the password.
+func (m *scramClientMech) Success(data []byte) error {
+	if m.aborted {
+		return ErrAborted
+	}
+	return clientSuccess(data, m.Data, &m.completed)
+}
+
+// Abort zeroes the password and the salted password.
+func (m *scramClientMech) Abort() {
+	zeroBytes(m.passwd)
+	m.passwd = nil
+	m.zero()
+	m.dataFn = clientAborted
+	m.completed = false
+	m.aborted = true
+}
+
 // initialResponse returns the initial response to send to the server.
 func (m *scramClientMech) initialResponse(challenge []byte) ([]byte, error) {
 	if len(challenge) > 0 {
@@ -142,16 +164,12 @@ func (m *scramClientMech) initialResponse(challenge []byte) ([]byte, error) {
 		return nil, ErrInvalidMessage
 	}
 	m.dataFn = m.respondToChallenge
+	header := gs2Header{cbFlag: gs2NoChannelBinding, authz: m.authz}
+	m.gs2Header = header.marshal()
 	var ir bytes.Buffer
-	ir.WriteString("n,")
-	if m.authz != "" {
-		ir.WriteString("a=")
-		ir.WriteString(m.escapeValue(m.authz))
-	}
-	ir.WriteByte(',')
-	m.gs2Header = ir.Bytes()
+	ir.Write(m.gs2Header)
 	m.authMessage.WriteString("n=")
-	m.authMessage.WriteString(m.escapeValue(m.authn))
+	m.authMessage.WriteString(escapeSaslname(m.authn))
 	m.authMessage.WriteString(",r=")
 	m.authMessage.Write(m.clientNonce)
 	ir.Write(m.authMessage.Bytes())
@@ -251,13 +269,12 @@ func (m *scramClientMech) verifyServerSignature(challenge []byte) ([]byte, error
 	if !bytes.Equal(m.serverSignature(), serverSignature) {
 		return nil, ErrAuthenticationFailed
 	}
+	m.completed = true
 	return nil, nil
 }
 
-// escapeValue escapes a string value, so it can be included in a
-// comma-separated message.
-func (m *scramClientMech) escapeValue(s string) string {
-	s = strings.ReplaceAll(s, "=", "=3D")
-	s = strings.ReplaceAll(s, ",", "=2C")
-	return s
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *scramClientMech) failed(challenge []byte) ([]byte, error) {
+	return nil, ErrInvalidState
 }
@@ -2,12 +2,14 @@ package sasler
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"strconv"
-	"strings"
 
 	"github.com/xdg-go/stringprep"
 )
@@ -35,15 +37,49 @@ type ScramAuthenticator interface {
 	Authorize(authz, authn string) bool
 }
 
+// ScramContextAuthenticator is the context-aware variant of
+// [ScramAuthenticator], which is passed to [ScramSha1ServerContext] or
+// [ScramSha256ServerContext]. The context is the one passed to DataContext.
+type ScramContextAuthenticator interface {
+	// GetCredentialsContext is the context-aware variant of GetCredentials.
+	GetCredentialsContext(ctx context.Context, authn string) (passwd []byte, isSalted bool, salt []byte, iCount int, err error)
+	// DeriveAuthzContext is the context-aware variant of DeriveAuthz.
+	DeriveAuthzContext(ctx context.Context, authn string) string
+	// AuthorizeContext is the context-aware variant of Authorize.
+	AuthorizeContext(ctx context.Context, authz, authn string) bool
+}
+
+// scramContextAdapter adapts a ScramAuthenticator to a
+// ScramContextAuthenticator that ignores the context.
+type scramContextAdapter struct {
+	auth ScramAuthenticator
+}
+
+func (a scramContextAdapter) GetCredentialsContext(_ context.Context, authn string) ([]byte, bool, []byte, int, error) {
+	return a.auth.GetCredentials(authn)
+}
+
+func (a scramContextAdapter) DeriveAuthzContext(_ context.Context, authn string) string {
+	return a.auth.DeriveAuthz(authn)
+}
+
+func (a scramContextAdapter) AuthorizeContext(_ context.Context, authz, authn string) bool {
+	return a.auth.Authorize(authz, authn)
+}
+
 // scramServerMech is a ServerMech implementation of the SCRAM-* family of
 // mechanisms.
 type scramServerMech struct {
 	scramMech
+	storedKey []byte
+	serverKey []byte
 	authz     string
 	authn     string
 	completed bool
 	succeeded bool
-	auth      ScramAuthenticator
+	aborted   bool
+	auth      ScramContextAuthenticator
+	dataFn    func(context.Context, []byte) ([]byte, error)
 }
 
 // ScramSha1Server returns a server-side SaslMech implementation for the
@@ -52,6 +88,12 @@ type scramServerMech struct {
 //
 // [RFC 5802]: https://tools.ietf.org/html/rfc5802
 func ScramSha1Server(auth ScramAuthenticator) (ServerMech, error) {
+	return ScramSha1ServerContext(scramContextAdapter{auth})
+}
+
+// ScramSha1ServerContext is like [ScramSha1Server], but uses a context-aware
+// authenticator.
+func ScramSha1ServerContext(auth ScramContextAuthenticator) (ServerMech, error) {
 	m := &scramServerMech{
 		scramMech: scramMech{
 			newHash:  sha1.New,
@@ -71,6 +113,12 @@ func ScramSha1Server(auth ScramAuthenticator) (ServerMech, error) {
 //
 // [RFC 7677]: https://tools.ietf.org/html/rfc7677
 func ScramSha256Server(auth ScramAuthenticator) (ServerMech, error) {
+	return ScramSha256ServerContext(scramContextAdapter{auth})
+}
+
+// ScramSha256ServerContext is like [ScramSha256Server], but uses a
+// context-aware authenticator.
+func ScramSha256ServerContext(auth ScramContextAuthenticator) (ServerMech, error) {
 	m := &scramServerMech{
 		scramMech: scramMech{
 			newHash:  sha256.New,
@@ -115,28 +163,33 @@ func (m *scramServerMech) Mech() (string, bool) {
 // of the SCRAM authentication process. Returns ErrInvalidMessage if any of the
 // expected values are incorrect, such as an incorrect nonce or client proof.
 func (m *scramServerMech) Data(challenge []byte) ([]byte, error) {
-	if m.dataFn == nil {
-		return nil, ErrInvalidState
-	}
-	return m.dataFn(challenge)
+	return m.DataContext(context.Background(), challenge)
+}
+
+// DataContext is like Data, but passes ctx on to the authenticator.
+func (m *scramServerMech) DataContext(ctx context.Context, data []byte) ([]byte, error) {
+	return serverDataContext(ctx, m, m.dataFn, data)
 }
 
 // createChallenge return the challenge message to send to the client.
-func (m *scramServerMech) createChallenge(ir []byte) ([]byte, error) {
+func (m *scramServerMech) createChallenge(ctx context.Context, ir []byte) ([]byte, error) {
 	m.dataFn = m.failed
 	m.completed = true
 	if err := m.parseIR(ir); err != nil {
 		return nil, err
 	}
-	passwd, isSalted, salt, iCount, err := m.auth.GetCredentials(m.authn)
+	passwd, isSalted, salt, iCount, err := m.auth.GetCredentialsContext(ctx, m.authn)
 	if err != nil {
-		return nil, ErrAuthenticationFailed
+		name, _ := m.Mech()
+		return nil, wrapError(name, "client-first-message", ErrAuthenticationFailed, ReasonUnknownUser, err)
 	}
 	if isSalted {
-		m.saltedPasswd = passwd
+		// copy, so that zeroing doesn't wipe the credentials of the authenticator
+		m.saltedPasswd = append([]byte(nil), passwd...)
 	} else {
 		m.computeSaltedPassword(passwd, salt, iCount)
 	}
+	m.computeServerKeys()
 	var challenge bytes.Buffer
 	challenge.WriteString("r=")
 	challenge.Write(m.clientNonce)
@@ -156,48 +209,39 @@ func (m *scramServerMech) createChallenge(ir []byte) ([]byte, error) {
 
 // parseIR parses the initial response from the client.
 func (m *scramServerMech) parseIR(ir []byte) error {
-	gs2Header := ir
-	if len(ir) < 2 || ir[0] != 'n' || ir[1] != ',' {
-		return ErrInvalidMessage
+	name, _ := m.Mech()
+	header, rest, err := parseGs2Header(ir)
+	if err != nil {
+		return malformedAttribute(name, "client-first-message", "gs2-header")
 	}
-	ir = ir[2:]
-	if len(ir) < 2 {
-		return ErrInvalidMessage
-	}
-	if ir[0] == 'a' && ir[1] == '=' {
-		ir = ir[2:]
-		comma := bytes.IndexByte(ir, ',')
-		if comma == -1 {
-			return ErrInvalidMessage
-		}
-		m.authz = string(ir[:comma])
-		ir = ir[comma:]
-		if len(ir) < 2 {
-			return ErrInvalidMessage
-		}
+	if header.nonStd {
+		return malformedAttribute(name, "client-first-message", "gs2-header")
 	}
-	if ir[0] != ',' {
-		return ErrInvalidMessage
+	if header.cbFlag == gs2ChannelBinding {
+		return newError(name, "client-first-message", ErrInvalidMessage, ReasonChannelBinding)
 	}
-	ir = ir[1:]
-	m.gs2Header = gs2Header[:len(gs2Header)-len(ir)]
+	m.authz = header.authz
+	m.gs2Header = ir[:len(ir)-len(rest)]
+	ir = rest
 	m.authMessage.Write(ir)
 	if len(ir) < 2 || ir[0] != 'n' || ir[1] != '=' {
-		return ErrInvalidMessage
+		return malformedAttribute(name, "client-first-message", "n")
 	}
 	ir = ir[2:]
 	comma := bytes.IndexByte(ir, ',')
 	if comma == -1 {
-		return ErrInvalidMessage
+		return malformedAttribute(name, "client-first-message", "n")
 	}
-	authn, err := stringprep.SASLprep.Prepare(m.unescapeValue(string(ir[:comma])))
+	authn, err := stringprep.SASLprep.Prepare(unescapeSaslname(string(ir[:comma])))
 	if err != nil {
-		return ErrInvalidMessage
+		e := malformedAttribute(name, "client-first-message", "n")
+		e.Err = err
+		return e
 	}
 	m.authn = authn
 	ir = ir[comma+1:]
 	if len(ir) < 2 || ir[0] != 'r' || ir[1] != '=' {
-		return ErrInvalidMessage
+		return malformedAttribute(name, "client-first-message", "r")
 	}
 	ir = ir[2:]
 	m.clientNonce = ir
@@ -206,26 +250,29 @@ func (m *scramServerMech) parseIR(ir []byte) error {
 
 // verifyClientProof verifies the provided client proof and returns a server
 // signature if the client proof was correct.
-func (m *scramServerMech) verifyClientProof(b []byte) ([]byte, error) {
+func (m *scramServerMech) verifyClientProof(ctx context.Context, b []byte) ([]byte, error) {
 	m.dataFn = m.failed
 	m.completed = true
 	clientProof, err := m.parseClientProof(b)
 	if err != nil {
-		return nil, ErrInvalidMessage
+		return nil, err
 	}
-	if !bytes.Equal(clientProof, m.clientProof()) {
-		return nil, ErrAuthenticationFailed
+	name, _ := m.Mech()
+	if !m.verifyProof(clientProof) {
+		return nil, newError(name, "client-final-message", ErrAuthenticationFailed, ReasonBadProof)
 	}
 	if m.authz == "" {
-		m.authz = m.auth.DeriveAuthz(m.authn)
+		m.authz = m.auth.DeriveAuthzContext(ctx, m.authn)
 		if m.authz == "" {
-			return nil, ErrAuthenticationFailed
+			return nil, newError(name, "client-final-message", ErrAuthenticationFailed, ReasonNoAuthz)
 		}
 	}
-	if !m.auth.Authorize(m.authz, m.authn) {
-		return nil, ErrUnauthorized
+	if !m.auth.AuthorizeContext(ctx, m.authz, m.authn) {
+		return nil, newError(name, "client-final-message", ErrUnauthorized, ReasonUnauthorized)
 	}
-	serverSignature := m.serverSignature()
+	mac := hmac.New(m.newHash, m.serverKey)
+	mac.Write(m.authMessage.Bytes())
+	serverSignature := mac.Sum(nil)
 	signatureMessage := make([]byte, 2+base64.StdEncoding.EncodedLen(len(serverSignature)))
 	signatureMessage[0] = 'v'
 	signatureMessage[1] = '='
@@ -235,71 +282,104 @@ func (m *scramServerMech) verifyClientProof(b []byte) ([]byte, error) {
 	return signatureMessage, nil
 }
 
+// computeServerKeys derives the StoredKey and ServerKey from the salted
+// password, and zeroes the salted password, which isn't needed any further.
+func (m *scramServerMech) computeServerKeys() {
+	mac := hmac.New(m.newHash, m.saltedPasswd)
+	mac.Write([]byte("Client Key"))
+	clientKey := mac.Sum(nil)
+	h := m.newHash()
+	h.Write(clientKey)
+	m.storedKey = h.Sum(nil)
+	mac = hmac.New(m.newHash, m.saltedPasswd)
+	mac.Write([]byte("Server Key"))
+	m.serverKey = mac.Sum(nil)
+	zeroBytes(clientKey)
+	zeroBytes(m.saltedPasswd)
+	m.saltedPasswd = nil
+}
+
+// verifyProof recovers the ClientKey from the client proof, and returns true if
+// its hash matches the StoredKey.
+func (m *scramServerMech) verifyProof(clientProof []byte) bool {
+	mac := hmac.New(m.newHash, m.storedKey)
+	mac.Write(m.authMessage.Bytes())
+	clientSignature := mac.Sum(nil)
+	if len(clientProof) != len(clientSignature) {
+		return false
+	}
+	clientKey := make([]byte, len(clientSignature))
+	subtle.XORBytes(clientKey, clientProof, clientSignature)
+	h := m.newHash()
+	h.Write(clientKey)
+	return hmac.Equal(h.Sum(nil), m.storedKey)
+}
+
+// parseClientProof parses the client-final-message, checks the channel binding
+// and nonce, and returns the client proof.
 func (m *scramServerMech) parseClientProof(b []byte) ([]byte, error) {
+	name, _ := m.Mech()
 	withoutProof := b
 	if len(b) < 2 || b[0] != 'c' || b[1] != '=' {
-		return nil, ErrInvalidMessage
+		return nil, malformedAttribute(name, "client-final-message", "c")
 	}
 	b = b[2:]
 	comma := bytes.IndexByte(b, ',')
 	if comma == -1 {
-		return nil, ErrInvalidMessage
+		return nil, malformedAttribute(name, "client-final-message", "c")
 	}
 	encodedGs2Header := b[:comma]
 	b = b[comma+1:]
 	decodedGs2Header := make([]byte, base64.StdEncoding.DecodedLen(len(encodedGs2Header)))
 	n, err := base64.StdEncoding.Decode(decodedGs2Header, encodedGs2Header)
-	if err != nil || !bytes.Equal(m.gs2Header, decodedGs2Header[:n]) {
-		return nil, ErrInvalidMessage
+	if err != nil {
+		e := malformedAttribute(name, "client-final-message", "c")
+		e.Err = err
+		return nil, e
+	}
+	if !bytes.Equal(m.gs2Header, decodedGs2Header[:n]) {
+		return nil, newError(name, "client-final-message", ErrInvalidMessage, ReasonChannelBinding)
 	}
 	if len(b) < 2 || b[0] != 'r' || b[1] != '=' {
-		return nil, ErrInvalidMessage
+		return nil, malformedAttribute(name, "client-final-message", "r")
 	}
 	b = b[2:]
-	if len(b) < len(m.clientNonce) {
-		return nil, ErrInvalidMessage
+	if len(b) < len(m.clientNonce)+len(m.serverNonce) {
+		return nil, newError(name, "client-final-message", ErrInvalidMessage, ReasonNonceMismatch)
 	}
 	if !bytes.Equal(b[:len(m.clientNonce)], m.clientNonce) {
-		return nil, ErrAuthenticationFailed
+		return nil, newError(name, "client-final-message", ErrInvalidMessage, ReasonNonceMismatch)
 	}
 	b = b[len(m.clientNonce):]
-	if len(b) < len(m.serverNonce) {
-		return nil, ErrInvalidMessage
-	}
 	if !bytes.Equal(b[:len(m.serverNonce)], m.serverNonce) {
-		return nil, ErrAuthenticationFailed
+		return nil, newError(name, "client-final-message", ErrInvalidMessage, ReasonNonceMismatch)
 	}
 	b = b[len(m.serverNonce):]
 	m.authMessage.WriteByte(',')
 	m.authMessage.Write(withoutProof[:len(withoutProof)-len(b)])
 	if len(b) < 3 || b[0] != ',' || b[1] != 'p' || b[2] != '=' {
-		return nil, ErrInvalidMessage
+		return nil, malformedAttribute(name, "client-final-message", "p")
 	}
 	b = b[3:]
 	receivedClientProof := make([]byte, base64.StdEncoding.DecodedLen(len(b)))
 	n, err = base64.StdEncoding.Decode(receivedClientProof, b)
 	if err != nil {
-		return nil, ErrInvalidMessage
+		e := malformedAttribute(name, "client-final-message", "p")
+		e.Err = err
+		return nil, e
 	}
 	return receivedClientProof[:n], nil
 }
 
-func (m *scramServerMech) ignoreOneMessage(data []byte) ([]byte, error) {
+func (m *scramServerMech) ignoreOneMessage(ctx context.Context, data []byte) ([]byte, error) {
 	m.dataFn = m.failed
 	if len(data) > 0 {
-		return nil, ErrInvalidMessage
+		name, _ := m.Mech()
+		return nil, newError(name, "empty response", ErrInvalidMessage, ReasonMalformedMessage)
 	}
 	return nil, nil
 }
 
-// unescapeValue unescapes a string value after it was extracted from a
-// comma-separated message.
-func (m *scramServerMech) unescapeValue(s string) string {
-	s = strings.ReplaceAll(s, "=2C", ",")
-	s = strings.ReplaceAll(s, "=3D", "=")
-	return s
-}
-
 // HasCompleted returns true if authentication has finished, and if true, it
 // also returns the authorized authz, if any.
 func (m *scramServerMech) HasCompleted() (bool, string) {
@@ -311,3 +391,102 @@ func (m *scramServerMech) HasCompleted() (bool, string) {
 	}
 	return true, m.authz
 }
+
+// Result returns the authn whose client proof was verified.
+func (m *scramServerMech) Result() *Result {
+	completed, authz := m.HasCompleted()
+	if !completed {
+		return nil
+	}
+	name, _ := m.Mech()
+	return &Result{Mech: name, Succeeded: m.succeeded, Aborted: m.aborted, Authn: m.authn, Authz: authz}
+}
+
+// Abort zeroes the StoredKey and ServerKey, if authentication is still in
+// progress.
+func (m *scramServerMech) Abort() {
+	if m.completed {
+		return
+	}
+	m.zero()
+	zeroBytes(m.storedKey)
+	zeroBytes(m.serverKey)
+	m.storedKey = nil
+	m.serverKey = nil
+	m.authz = ""
+	m.dataFn = serverAborted
+	m.completed = true
+	m.aborted = true
+}
+
+// failed always returns ErrInvalidState and is installed after a failed or
+// completed authentication.
+func (m *scramServerMech) failed(ctx context.Context, data []byte) ([]byte, error) {
+	return nil, ErrInvalidState
+}
+
+// exportState returns the nonces, the GS2 header, the StoredKey and ServerKey,
+// the auth message and the identities, once the challenge has been sent.
+func (m *scramServerMech) exportState() ([]byte, error) {
+	switch {
+	case m.completed:
+		return nil, ErrInvalidState
+	case m.authMessage.Len() == 0:
+		return []byte{statePhaseInitial}, nil
+	}
+	state := []byte{statePhaseInProgress}
+	state = appendStateField(state, m.gs2Header)
+	state = appendStateField(state, m.clientNonce)
+	state = appendStateField(state, m.serverNonce)
+	state = appendStateField(state, m.storedKey)
+	state = appendStateField(state, m.serverKey)
+	state = appendStateField(state, m.authMessage.Bytes())
+	state = appendStateField(state, []byte(m.authn))
+	state = appendStateField(state, []byte(m.authz))
+	return state, nil
+}
+
+// importState restores a state returned by exportState, after which the
+// mechanism expects the client-final-message.
+func (m *scramServerMech) importState(state []byte) error {
+	if m.completed || m.authMessage.Len() > 0 {
+		return ErrInvalidState
+	}
+	if len(state) == 0 {
+		return ErrInvalidSealedState
+	}
+	switch state[0] {
+	case statePhaseInitial:
+		if len(state) > 1 {
+			return ErrInvalidSealedState
+		}
+		return nil
+	case statePhaseInProgress:
+	default:
+		return ErrInvalidSealedState
+	}
+	d := stateDecoder{state: state[1:]}
+	gs2Header := d.field()
+	clientNonce := d.field()
+	serverNonce := d.field()
+	storedKey := d.field()
+	serverKey := d.field()
+	authMessage := d.field()
+	authn := d.field()
+	authz := d.field()
+	if d.err != nil || d.more() {
+		zeroBytes(storedKey)
+		zeroBytes(serverKey)
+		return ErrInvalidSealedState
+	}
+	m.gs2Header = gs2Header
+	m.clientNonce = clientNonce
+	m.serverNonce = serverNonce
+	m.storedKey = storedKey
+	m.serverKey = serverKey
+	m.authMessage.Write(authMessage)
+	m.authn = string(authn)
+	m.authz = string(authz)
+	m.dataFn = m.verifyClientProof
+	return nil
+}
@@ -2,8 +2,10 @@ package sasler
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"testing"
+	"time"
 )
 
 func TestScramSha1Client(t *testing.T) {
@@ -173,6 +175,88 @@ func TestScramSha1Client_InvalidServerSignature(t *testing.T) {
 	}
 }
 
+func TestScramSha1Client_SuccessWithServerSignature(t *testing.T) {
+	auth, err := ScramSha1Client("", "user", []byte("pencil"))
+	if err != nil {
+		t.Fatalf(`ScramSha1Client("", "user", "pencil") returned error: %v`, err)
+	}
+
+	// overwrite generated nonce to make the test deterministic
+	auth.(*scramClientMech).clientNonce = []byte("fyko+d2lbbFgONRv9qkxdawL")
+
+	if _, err := auth.Data(nil); err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+
+	challenge := []byte("r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096")
+	if _, err := auth.Data(challenge); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, challenge, err)
+	}
+
+	err = auth.Success(nil)
+	if !errors.Is(err, ErrInvalidState) {
+		t.Fatalf(`Success(nil) returned error: %v; expected ErrInvalidState`, err)
+	}
+
+	data := []byte("v=rmF9pqV8S7suAoZWja4dJRkFsKQ=")
+	if err := auth.Success(data); err != nil {
+		t.Fatalf(`Success("%s") returned error: %v`, data, err)
+	}
+}
+
+func TestScramSha1Client_SuccessAfterServerSignature(t *testing.T) {
+	auth, err := ScramSha1Client("", "user", []byte("pencil"))
+	if err != nil {
+		t.Fatalf(`ScramSha1Client("", "user", "pencil") returned error: %v`, err)
+	}
+
+	// overwrite generated nonce to make the test deterministic
+	auth.(*scramClientMech).clientNonce = []byte("fyko+d2lbbFgONRv9qkxdawL")
+
+	if _, err := auth.Data(nil); err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+
+	challenge := []byte("r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096")
+	if _, err := auth.Data(challenge); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, challenge, err)
+	}
+
+	challenge = []byte("v=rmF9pqV8S7suAoZWja4dJRkFsKQ=")
+	if _, err := auth.Data(challenge); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, challenge, err)
+	}
+
+	if err := auth.Success(nil); err != nil {
+		t.Fatalf(`Success(nil) returned error: %v`, err)
+	}
+}
+
+func TestScramSha1Client_SuccessWithInvalidServerSignature(t *testing.T) {
+	auth, err := ScramSha1Client("", "user", []byte("pencil"))
+	if err != nil {
+		t.Fatalf(`ScramSha1Client("", "user", "pencil") returned error: %v`, err)
+	}
+
+	// overwrite generated nonce to make the test deterministic
+	auth.(*scramClientMech).clientNonce = []byte("fyko+d2lbbFgONRv9qkxdawL")
+
+	if _, err := auth.Data(nil); err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+
+	challenge := []byte("r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096")
+	if _, err := auth.Data(challenge); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, challenge, err)
+	}
+
+	data := []byte("v=RMF9pqV8S7suAoZWja4dJRkFsKQ=")
+	err = auth.Success(data)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf(`Success("%s") returned error: %v; expected ErrAuthenticationFailed`, data, err)
+	}
+}
+
 func TestScramSha256Client(t *testing.T) {
 	auth, err := ScramSha256Client("", "user", []byte("pencil"))
 	if err != nil {
@@ -348,7 +432,7 @@ func TestScramSha1Server_InvalidClientProof(t *testing.T) {
 
 	response := []byte("c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=V0x8v3Bz2T0CJGbJQyF0X+HI4Ts=")
 	gotServerSignature, err := auth.Data(response)
-	if gotServerSignature != nil || err != ErrAuthenticationFailed {
+	if gotServerSignature != nil || !errors.Is(err, ErrAuthenticationFailed) {
 		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, ErrAuthenticationFailed)`, response, gotServerSignature, err)
 	}
 
@@ -383,7 +467,7 @@ func TestScramSha1Server_ModifiedClientNonce(t *testing.T) {
 
 	response := []byte("c=biws,r=FYko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=")
 	gotServerSignature, err := auth.Data(response)
-	if gotServerSignature != nil || err != ErrInvalidMessage {
+	if gotServerSignature != nil || !errors.Is(err, ErrInvalidMessage) {
 		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, ErrInvalidMessage)`, response, gotServerSignature, err)
 	}
 
@@ -394,6 +478,31 @@ func TestScramSha1Server_ModifiedClientNonce(t *testing.T) {
 	}
 }
 
+func TestScramSha1Server_ModifiedClientNonceError(t *testing.T) {
+	auth, err := ScramSha1Server(&FakeScramAuthenticator{false, false})
+	if err != nil {
+		t.Fatalf(`ScramSha1Server(...) returned error: %v`, err)
+	}
+
+	// overwrite generated nonce to make the test deterministic
+	auth.(*scramServerMech).serverNonce = []byte("3rfcNHYJY1ZVvWVs7j")
+
+	ir := []byte("n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL")
+	if _, err := auth.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	response := []byte("c=biws,r=FYko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=")
+	_, err = auth.Data(response)
+	var gotErr *Error
+	if !errors.As(err, &gotErr) {
+		t.Fatalf(`Data("%s") returned error %v; expected *Error`, response, err)
+	}
+	if gotErr.Mech != "SCRAM-SHA-1" || gotErr.Step != "client-final-message" || gotErr.Reason != ReasonNonceMismatch || gotErr.Kind != ErrInvalidMessage {
+		t.Fatalf(`Data("%s") returned error %#v; expected SCRAM-SHA-1 client-final-message nonce mismatch`, response, gotErr)
+	}
+}
+
 func TestScramSha1Server_ModifiedServerNonce(t *testing.T) {
 	auth, err := ScramSha1Server(&FakeScramAuthenticator{false, false})
 	if err != nil {
@@ -418,7 +527,7 @@ func TestScramSha1Server_ModifiedServerNonce(t *testing.T) {
 
 	response := []byte("c=biws,r=fyko+d2lbbFgONRv9qkxdawL3RFcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=")
 	gotServerSignature, err := auth.Data(response)
-	if gotServerSignature != nil || err != ErrInvalidMessage {
+	if gotServerSignature != nil || !errors.Is(err, ErrInvalidMessage) {
 		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, ErrInvalidMessage)`, response, gotServerSignature, err)
 	}
 
@@ -429,6 +538,186 @@ func TestScramSha1Server_ModifiedServerNonce(t *testing.T) {
 	}
 }
 
+func TestScramSha1Client_Abort(t *testing.T) {
+	auth, err := ScramSha1Client("", "user", []byte("pencil"))
+	if err != nil {
+		t.Fatalf(`ScramSha1Client(...) returned error: %v`, err)
+	}
+
+	if _, err := auth.Data(nil); err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+
+	passwd := auth.(*scramClientMech).passwd
+	auth.Abort()
+
+	if !bytes.Equal(passwd, make([]byte, len(passwd))) {
+		t.Fatalf(`Abort() left password %q; expected zeroes`, passwd)
+	}
+	challenge := []byte("r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096")
+	if _, err := auth.Data(challenge); !errors.Is(err, ErrAborted) {
+		t.Fatalf(`Data("%s") returned error: %v; expected ErrAborted`, challenge, err)
+	}
+}
+
+func TestScramSha1Server_Abort(t *testing.T) {
+	auth, err := ScramSha1Server(&FakeScramAuthenticator{false, false})
+	if err != nil {
+		t.Fatalf(`ScramSha1Server(...) returned error: %v`, err)
+	}
+
+	ir := []byte("n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL")
+	if _, err := auth.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+
+	storedKey := auth.(*scramServerMech).storedKey
+	serverKey := auth.(*scramServerMech).serverKey
+	auth.Abort()
+
+	if !bytes.Equal(storedKey, make([]byte, len(storedKey))) {
+		t.Fatalf(`Abort() left StoredKey %x; expected zeroes`, storedKey)
+	}
+	if !bytes.Equal(serverKey, make([]byte, len(serverKey))) {
+		t.Fatalf(`Abort() left ServerKey %x; expected zeroes`, serverKey)
+	}
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	if !gotCompleted || gotAuthz != "" {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "")`, gotCompleted, gotAuthz)
+	}
+	if result := auth.Result(); !result.Aborted || result.Succeeded || result.Authn != "user" {
+		t.Fatalf(`Result() returned %+v; expected aborted result for user`, result)
+	}
+	response := []byte("c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=")
+	if _, err := auth.Data(response); !errors.Is(err, ErrAborted) {
+		t.Fatalf(`Data("%s") returned error: %v; expected ErrAborted`, response, err)
+	}
+}
+
+func TestScramSha1Server_AbortKeepsStoredCredentials(t *testing.T) {
+	stored := &memoryScramAuthenticator{
+		saltedPasswd: []byte("\x1d\x96\xee:R\x9bZ_\x9eG\xc0\x1f\"\x9a,\xb8\xa6\xe1_}"),
+		salt:         []byte("A%\xc2G\xe4:\xb1\xe9<m\xffv"),
+	}
+	expectedSaltedPasswd := bytes.Clone(stored.saltedPasswd)
+
+	aborted, err := ScramSha1Server(stored)
+	if err != nil {
+		t.Fatalf(`ScramSha1Server(...) returned error: %v`, err)
+	}
+	ir := []byte("n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL")
+	if _, err := aborted.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+	aborted.Abort()
+
+	if !bytes.Equal(stored.saltedPasswd, expectedSaltedPasswd) {
+		t.Fatalf(`Abort() changed stored salted password to %x; expected %x`, stored.saltedPasswd, expectedSaltedPasswd)
+	}
+
+	auth, err := ScramSha1Server(stored)
+	if err != nil {
+		t.Fatalf(`ScramSha1Server(...) returned error: %v`, err)
+	}
+	auth.(*scramServerMech).serverNonce = []byte("3rfcNHYJY1ZVvWVs7j")
+	if _, err := auth.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+	response := []byte("c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=")
+	gotServerSignature, err := auth.Data(response)
+	expectedServerSignature := []byte("v=rmF9pqV8S7suAoZWja4dJRkFsKQ=")
+	if !bytes.Equal(gotServerSignature, expectedServerSignature) || err != nil {
+		t.Fatalf(`Data("%s") returned ("%s", %v); expected ("%s", nil)`, response, gotServerSignature, err, expectedServerSignature)
+	}
+}
+
+func TestScramSha1Server_SealedState(t *testing.T) {
+	sealer, err := NewStateSealer([]byte("0123456789abcdef"), time.Minute)
+	if err != nil {
+		t.Fatalf(`NewStateSealer(...) returned error: %v`, err)
+	}
+	first, err := ScramSha1Server(&FakeScramAuthenticator{false, false})
+	if err != nil {
+		t.Fatalf(`ScramSha1Server(...) returned error: %v`, err)
+	}
+	first.(*scramServerMech).serverNonce = []byte("3rfcNHYJY1ZVvWVs7j")
+
+	ir := []byte("n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL")
+	if _, err := first.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+	state, err := first.(*scramServerMech).exportState()
+	if err != nil {
+		t.Fatalf(`exportState() returned error: %v`, err)
+	}
+	if saltedPasswd := []byte("\x1d\x96\xee:R\x9bZ_\x9eG\xc0\x1f\"\x9a,\xb8\xa6\xe1_}"); bytes.Contains(state, saltedPasswd) {
+		t.Fatalf(`exportState() returned %x; expected it not to contain the salted password`, state)
+	}
+	sealed, err := sealer.Seal(first)
+	if err != nil {
+		t.Fatalf(`Seal() returned error: %v`, err)
+	}
+	first.Abort()
+
+	second, err := ScramSha1Server(&FakeScramAuthenticator{false, false})
+	if err != nil {
+		t.Fatalf(`ScramSha1Server(...) returned error: %v`, err)
+	}
+	if err := sealer.Open(second, sealed); err != nil {
+		t.Fatalf(`Open() returned error: %v`, err)
+	}
+
+	response := []byte("c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=")
+	gotServerSignature, err := second.Data(response)
+	expectedServerSignature := []byte("v=rmF9pqV8S7suAoZWja4dJRkFsKQ=")
+	if !bytes.Equal(gotServerSignature, expectedServerSignature) || err != nil {
+		t.Fatalf(`Data("%s") returned ("%s", %v); expected ("%s", nil)`, response, gotServerSignature, err, expectedServerSignature)
+	}
+
+	if _, err := sealer.Seal(second); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf(`Seal() after completion returned error: %v; expected ErrInvalidState`, err)
+	}
+
+	replayed, err := ScramSha1Server(&FakeScramAuthenticator{false, false})
+	if err != nil {
+		t.Fatalf(`ScramSha1Server(...) returned error: %v`, err)
+	}
+	if err := sealer.Open(replayed, sealed); !errors.Is(err, ErrStateConsumed) {
+		t.Fatalf(`Open() of opened state returned error: %v; expected ErrStateConsumed`, err)
+	}
+}
+
+func TestScramSha1ServerContext(t *testing.T) {
+	auth, err := ScramSha1ServerContext(&FakeScramContextAuthenticator{})
+	if err != nil {
+		t.Fatalf(`ScramSha1ServerContext(...) returned error: %v`, err)
+	}
+
+	// overwrite generated nonce to make the test deterministic
+	auth.(*scramServerMech).serverNonce = []byte("3rfcNHYJY1ZVvWVs7j")
+
+	ctx := context.WithValue(context.Background(), fakeScramContextKey{}, "C")
+	ir := []byte("n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL")
+	gotChallenge, err := auth.DataContext(ctx, ir)
+	expectedChallenge := []byte("r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096")
+	if !bytes.Equal(gotChallenge, expectedChallenge) || err != nil {
+		t.Fatalf(`DataContext("%s") returned ("%s", %v); expected ("%s", nil)`, ir, gotChallenge, err, expectedChallenge)
+	}
+
+	response := []byte("c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=")
+	gotServerSignature, err := auth.DataContext(ctx, response)
+	expectedServerSignature := []byte("v=rmF9pqV8S7suAoZWja4dJRkFsKQ=")
+	if !bytes.Equal(gotServerSignature, expectedServerSignature) || err != nil {
+		t.Fatalf(`DataContext("%s") returned ("%s", %v); expected ("%s", nil)`, response, gotServerSignature, err, expectedServerSignature)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	expectedAuthz := "userC"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
 func TestScramSha256Server_DeriveAuthz(t *testing.T) {
 	auth, err := ScramSha256Server(&FakeScramAuthenticator{true, false})
 	if err != nil {
@@ -491,3 +780,42 @@ func (*FakeScramAuthenticator) DeriveAuthz(authn string) string {
 func (*FakeScramAuthenticator) Authorize(authz, authn string) bool {
 	return authz == authn+"Z" || authz == "RequestedAuthz"
 }
+
+// fakeScramContextKey is the context key of the value that
+// FakeScramContextAuthenticator uses as authz suffix.
+type fakeScramContextKey struct{}
+
+type FakeScramContextAuthenticator struct{}
+
+func (*FakeScramContextAuthenticator) GetCredentialsContext(ctx context.Context, authn string) ([]byte, bool, []byte, int, error) {
+	return (&FakeScramAuthenticator{false, false}).GetCredentials(authn)
+}
+
+func (*FakeScramContextAuthenticator) DeriveAuthzContext(ctx context.Context, authn string) string {
+	suffix, _ := ctx.Value(fakeScramContextKey{}).(string)
+	return authn + suffix
+}
+
+func (*FakeScramContextAuthenticator) AuthorizeContext(ctx context.Context, authz, authn string) bool {
+	suffix, _ := ctx.Value(fakeScramContextKey{}).(string)
+	return authn+suffix == authz
+}
+
+// memoryScramAuthenticator serves the same salted password slice on every
+// call, like an authenticator that keeps its credentials in memory.
+type memoryScramAuthenticator struct {
+	saltedPasswd []byte
+	salt         []byte
+}
+
+func (m *memoryScramAuthenticator) GetCredentials(authn string) ([]byte, bool, []byte, int, error) {
+	return m.saltedPasswd, true, m.salt, 4096, nil
+}
+
+func (*memoryScramAuthenticator) DeriveAuthz(authn string) string {
+	return authn
+}
+
+func (*memoryScramAuthenticator) Authorize(authz, authn string) bool {
+	return authz == authn
+}
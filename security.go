@@ -0,0 +1,107 @@
+package sasler
+
+import "strings"
+
+// SecurityFlags describes the security properties of a mechanism, modeled on
+// the security flags of Cyrus SASL.
+type SecurityFlags uint
+
+const (
+	// SecNoPlaintext is set for mechanisms that don't send plaintext
+	// credentials, and are therefore not susceptible to passive attacks.
+	SecNoPlaintext SecurityFlags = 1 << iota
+	// SecNoActive is set for mechanisms that are not susceptible to active
+	// attacks, other than dictionary attacks, during authentication.
+	SecNoActive
+	// SecNoDictionary is set for mechanisms that are not susceptible to
+	// passive dictionary attacks.
+	SecNoDictionary
+	// SecForwardSecrecy is set for mechanisms that provide forward secrecy
+	// between sessions, such that breaking one session doesn't help breaking
+	// the next.
+	SecForwardSecrecy
+	// SecNoAnonymous is set for mechanisms that don't permit anonymous logins.
+	SecNoAnonymous
+	// SecPassCredentials is set for mechanisms that pass client credentials to
+	// the server, which may use them to act on behalf of the client.
+	SecPassCredentials
+	// SecMutualAuth is set for mechanisms that authenticate the server to the
+	// client.
+	SecMutualAuth
+	// SecChannelBinding is set for mechanisms that bind authentication to the
+	// underlying TLS channel.
+	SecChannelBinding
+)
+
+// Has returns whether all flags in required are set in f.
+func (f SecurityFlags) Has(required SecurityFlags) bool {
+	return f&required == required
+}
+
+// mechSecurityFlags contains the security flags of the mechanisms that are
+// implemented by this package.
+var mechSecurityFlags = map[string]SecurityFlags{
+	"9798-M-ECDSA-SHA256":      SecNoPlaintext | SecNoActive | SecNoDictionary | SecNoAnonymous | SecMutualAuth,
+	"9798-U-ECDSA-SHA256":      SecNoPlaintext | SecNoDictionary | SecNoAnonymous,
+	"ANONYMOUS":                SecNoPlaintext,
+	"ECDH-X25519-CHALLENGE":    SecNoPlaintext | SecNoDictionary | SecNoAnonymous,
+	"ECDSA-NIST256P-CHALLENGE": SecNoPlaintext | SecNoDictionary | SecNoAnonymous,
+	"EXTERNAL":                 SecNoPlaintext | SecNoDictionary | SecNoAnonymous,
+	"GS2-KRB5":                 SecNoPlaintext | SecNoActive | SecNoAnonymous | SecMutualAuth,
+	"GS2-KRB5-PLUS":            SecNoPlaintext | SecNoActive | SecNoAnonymous | SecMutualAuth | SecChannelBinding,
+	"GSSAPI":                   SecNoPlaintext | SecNoActive | SecNoAnonymous | SecMutualAuth,
+	"HT-SHA-256-ENDP":          SecNoPlaintext | SecNoActive | SecNoDictionary | SecNoAnonymous | SecMutualAuth | SecChannelBinding,
+	"HT-SHA-256-EXPR":          SecNoPlaintext | SecNoActive | SecNoDictionary | SecNoAnonymous | SecMutualAuth | SecChannelBinding,
+	"HT-SHA-256-NONE":          SecNoPlaintext | SecNoDictionary | SecNoAnonymous | SecMutualAuth,
+	"HT-SHA-256-UNIQ":          SecNoPlaintext | SecNoActive | SecNoDictionary | SecNoAnonymous | SecMutualAuth | SecChannelBinding,
+	"NTLM":                     SecNoPlaintext | SecNoAnonymous,
+	"OAUTHBEARER":              SecNoAnonymous | SecPassCredentials,
+	"OPENID20":                 SecNoPlaintext | SecNoAnonymous,
+	"OTP":                      SecNoPlaintext | SecForwardSecrecy | SecNoAnonymous,
+	"PLAIN":                    SecNoAnonymous | SecPassCredentials,
+	"SAML20":                   SecNoPlaintext | SecNoAnonymous,
+	"SCRAM-SHA-1":              SecNoPlaintext | SecNoActive | SecNoAnonymous | SecMutualAuth,
+	"SCRAM-SHA-256":            SecNoPlaintext | SecNoActive | SecNoAnonymous | SecMutualAuth,
+}
+
+// SecurityFlagger can be implemented by third-party ClientMech and ServerMech
+// implementations to report their security flags.
+type SecurityFlagger interface {
+	// SecurityFlags returns the security flags of the mechanism.
+	SecurityFlags() SecurityFlags
+}
+
+// MechSecurityFlags returns the security flags of the mechanism with the
+// supplied name, which is compared case-insensitively. Returns false if the
+// mechanism is not implemented by this package.
+func MechSecurityFlags(name string) (SecurityFlags, bool) {
+	flags, ok := mechSecurityFlags[strings.ToUpper(name)]
+	return flags, ok
+}
+
+// SecurityFlagsOf returns the security flags of a ClientMech or ServerMech. If
+// mech implements SecurityFlagger, its flags are returned. Otherwise, the
+// flags are looked up by the name of the mechanism, and no flags are returned
+// if the mechanism is not implemented by this package.
+func SecurityFlagsOf(mech interface{ Mech() (string, bool) }) SecurityFlags {
+	if f, ok := mech.(SecurityFlagger); ok {
+		return f.SecurityFlags()
+	}
+	name, _ := mech.Mech()
+	flags, _ := MechSecurityFlags(name)
+	return flags
+}
+
+// FilterMechs returns the names of the mechanisms in names that have all flags
+// in required set, preserving their order. This can be used by a server to
+// hide mechanisms that send plaintext credentials on connections that are not
+// protected by TLS.
+func FilterMechs(names []string, required SecurityFlags) []string {
+	var filtered []string
+	for _, name := range names {
+		if flags, _ := MechSecurityFlags(name); flags.Has(required) {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered
+}
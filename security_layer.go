@@ -0,0 +1,138 @@
+package sasler
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// ErrBufferTooLarge is returned by a connection returned by
+// [NewSecurityLayerConn] if a wrapped buffer that is received or sent exceeds
+// the largest buffer size that mechanisms can announce.
+var ErrBufferTooLarge = errors.New("sasler: security layer buffer too large")
+
+// maxWrappedBufSize is the largest buffer size that can be announced by the
+// mechanisms, which use three bytes to announce it.
+const maxWrappedBufSize = 0xffffff
+
+// SecurityLayer protects the messages that are exchanged after authentication
+// has completed, as described in [RFC 4422, section 3.7].
+//
+// [RFC 4422, section 3.7]: https://tools.ietf.org/html/rfc4422#section-3.7
+type SecurityLayer interface {
+	// Wrap protects msg for integrity, and possibly for confidentiality, and
+	// returns the buffer that must be sent to the other party.
+	Wrap(msg []byte) ([]byte, error)
+	// Unwrap verifies a buffer received from the other party, and returns the
+	// message it contains.
+	Unwrap(buf []byte) ([]byte, error)
+	// MaxBufSize returns the maximum size of a message that can be passed to
+	// Wrap, such that the wrapped buffer doesn't exceed the maximum buffer size
+	// announced by the other party. Zero means there is no limit.
+	MaxBufSize() int
+}
+
+// SecurityLayerNegotiator is implemented by ClientMech and ServerMech
+// implementations that can negotiate a security layer, such as GSSAPI.
+type SecurityLayerNegotiator interface {
+	// SecurityLayer returns the negotiated security layer once authentication
+	// has completed successfully, or nil if no security layer has been
+	// negotiated.
+	SecurityLayer() SecurityLayer
+}
+
+// SecurityLayerOf returns the security layer negotiated by a ClientMech or
+// ServerMech, or nil if mech doesn't implement SecurityLayerNegotiator, or if
+// no security layer has been negotiated. On the client-side, the security
+// layer must only be used after Success returned without error.
+func SecurityLayerOf(mech interface{ Mech() (string, bool) }) SecurityLayer {
+	if n, ok := mech.(SecurityLayerNegotiator); ok {
+		return n.SecurityLayer()
+	}
+	return nil
+}
+
+// securityLayerConn is a net.Conn that protects the data sent over the
+// underlying connection using a security layer.
+type securityLayerConn struct {
+	net.Conn
+	layer   SecurityLayer
+	readMu  sync.Mutex
+	readBuf []byte
+	writeMu sync.Mutex
+}
+
+// NewSecurityLayerConn returns a net.Conn that wraps data written to it using
+// layer, and unwraps data read from it. Each wrapped buffer is prefixed with
+// its length as a four-octet unsigned integer in network byte order, as
+// described in [RFC 4422, section 3.7]. Writes are split into messages of at
+// most MaxBufSize bytes.
+//
+// [RFC 4422, section 3.7]: https://tools.ietf.org/html/rfc4422#section-3.7
+func NewSecurityLayerConn(conn net.Conn, layer SecurityLayer) net.Conn {
+	return &securityLayerConn{Conn: conn, layer: layer}
+}
+
+// Read reads a wrapped buffer from the underlying connection if no unwrapped
+// data is pending, and returns the unwrapped data.
+func (c *securityLayerConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for len(c.readBuf) == 0 {
+		var length [4]byte
+		if _, err := io.ReadFull(c.Conn, length[:]); err != nil {
+			return 0, err
+		}
+		size := binary.BigEndian.Uint32(length[:])
+		if size > maxWrappedBufSize {
+			return 0, ErrBufferTooLarge
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(c.Conn, buf); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		msg, err := c.layer.Unwrap(buf)
+		if err != nil {
+			return 0, err
+		}
+		c.readBuf = msg
+	}
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+// Write wraps p, split into messages of at most MaxBufSize bytes, and writes
+// the wrapped buffers to the underlying connection.
+func (c *securityLayerConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	maxBufSize := c.layer.MaxBufSize()
+	var n int
+	for n < len(p) {
+		msg := p[n:]
+		if maxBufSize > 0 && len(msg) > maxBufSize {
+			msg = msg[:maxBufSize]
+		}
+		buf, err := c.layer.Wrap(msg)
+		if err != nil {
+			return n, err
+		}
+		if len(buf) > maxWrappedBufSize {
+			return n, ErrBufferTooLarge
+		}
+		frame := make([]byte, 4+len(buf))
+		binary.BigEndian.PutUint32(frame, uint32(len(buf)))
+		copy(frame[4:], buf)
+		if _, err := c.Conn.Write(frame); err != nil {
+			return n, err
+		}
+		n += len(msg)
+	}
+	return n, nil
+}
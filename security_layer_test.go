@@ -0,0 +1,139 @@
+package sasler_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/phedny/sasler"
+)
+
+func TestSecurityLayerConn(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	layer := &fakeSecurityLayer{maxBufSize: 4}
+	client := sasler.NewSecurityLayerConn(clientConn, layer)
+
+	msg := []byte("hello world")
+	go func() {
+		if _, err := client.Write(msg); err != nil {
+			t.Errorf(`Write("%s") returned error: %v`, msg, err)
+		}
+	}()
+
+	var got []byte
+	for _, expected := range []string{"hell", "o wo", "rld"} {
+		var length [4]byte
+		if _, err := io.ReadFull(serverConn, length[:]); err != nil {
+			t.Fatalf(`reading length returned error: %v`, err)
+		}
+		buf := make([]byte, binary.BigEndian.Uint32(length[:]))
+		if _, err := io.ReadFull(serverConn, buf); err != nil {
+			t.Fatalf(`reading buffer returned error: %v`, err)
+		}
+		unwrapped, err := layer.Unwrap(buf)
+		if err != nil {
+			t.Fatalf(`Unwrap(%q) returned error: %v`, buf, err)
+		}
+		if string(unwrapped) != expected {
+			t.Fatalf(`received message %q; expected %q`, unwrapped, expected)
+		}
+		got = append(got, unwrapped...)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf(`received %q; expected %q`, got, msg)
+	}
+}
+
+func TestSecurityLayerConn_RoundTrip(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	client := sasler.NewSecurityLayerConn(clientConn, &fakeSecurityLayer{maxBufSize: 3})
+	server := sasler.NewSecurityLayerConn(serverConn, &fakeSecurityLayer{})
+
+	msg := []byte("protected message")
+	go func() {
+		n, err := client.Write(msg)
+		if n != len(msg) || err != nil {
+			t.Errorf(`Write("%s") returned (%d, %v); expected (%d, nil)`, msg, n, err, len(msg))
+		}
+	}()
+
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Fatalf(`ReadFull() returned error: %v`, err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf(`ReadFull() read %q; expected %q`, got, msg)
+	}
+}
+
+func TestSecurityLayerConn_InvalidBuffer(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	server := sasler.NewSecurityLayerConn(serverConn, &fakeSecurityLayer{})
+
+	go clientConn.Write([]byte("\x00\x00\x00\x03bad"))
+
+	buf := make([]byte, 16)
+	if _, err := server.Read(buf); !errors.Is(err, errFakeUnwrap) {
+		t.Fatalf(`Read() returned error: %v; expected errFakeUnwrap`, err)
+	}
+}
+
+func TestSecurityLayerConn_BufferTooLarge(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	server := sasler.NewSecurityLayerConn(serverConn, &fakeSecurityLayer{})
+
+	go clientConn.Write([]byte("\x01\x00\x00\x00"))
+
+	buf := make([]byte, 16)
+	if _, err := server.Read(buf); !errors.Is(err, sasler.ErrBufferTooLarge) {
+		t.Fatalf(`Read() returned error: %v; expected ErrBufferTooLarge`, err)
+	}
+}
+
+func TestSecurityLayerOf(t *testing.T) {
+	if layer := sasler.SecurityLayerOf(sasler.PlainClient("", "user", []byte("password"))); layer != nil {
+		t.Fatalf(`SecurityLayerOf(PLAIN) returned %v; expected nil`, layer)
+	}
+}
+
+var errFakeUnwrap = errors.New("invalid buffer")
+
+// fakeSecurityLayer is a SecurityLayer that inverts the bits of a message, and
+// prefixes it with a marker.
+type fakeSecurityLayer struct {
+	maxBufSize int
+}
+
+func (*fakeSecurityLayer) Wrap(msg []byte) ([]byte, error) {
+	buf := []byte("W")
+	for _, b := range msg {
+		buf = append(buf, ^b)
+	}
+	return buf, nil
+}
+
+func (*fakeSecurityLayer) Unwrap(buf []byte) ([]byte, error) {
+	if len(buf) == 0 || buf[0] != 'W' {
+		return nil, errFakeUnwrap
+	}
+	msg := make([]byte, 0, len(buf)-1)
+	for _, b := range buf[1:] {
+		msg = append(msg, ^b)
+	}
+	return msg, nil
+}
+
+func (l *fakeSecurityLayer) MaxBufSize() int {
+	return l.maxBufSize
+}
@@ -0,0 +1,67 @@
+package sasler_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/phedny/sasler"
+)
+
+func TestMechSecurityFlags(t *testing.T) {
+	flags, ok := sasler.MechSecurityFlags("scram-sha-256")
+	if !ok || !flags.Has(sasler.SecNoPlaintext|sasler.SecMutualAuth) || flags.Has(sasler.SecChannelBinding) {
+		t.Fatalf(`MechSecurityFlags("scram-sha-256") returned (%b, %v); expected no plaintext, mutual auth and no channel binding`, flags, ok)
+	}
+
+	flags, ok = sasler.MechSecurityFlags("PLAIN")
+	if !ok || flags.Has(sasler.SecNoPlaintext) || !flags.Has(sasler.SecPassCredentials) {
+		t.Fatalf(`MechSecurityFlags("PLAIN") returned (%b, %v); expected plaintext and pass credentials`, flags, ok)
+	}
+
+	if flags, ok := sasler.MechSecurityFlags("X-UNKNOWN"); ok || flags != 0 {
+		t.Fatalf(`MechSecurityFlags("X-UNKNOWN") returned (%b, %v); expected (0, false)`, flags, ok)
+	}
+
+	if flags, ok := sasler.MechSecurityFlags("SCRAM-SHA-256-PLUS"); ok || flags != 0 {
+		t.Fatalf(`MechSecurityFlags("SCRAM-SHA-256-PLUS") returned (%b, %v); expected (0, false)`, flags, ok)
+	}
+}
+
+func TestSecurityFlagsOf(t *testing.T) {
+	mech, err := sasler.AnonymousClient("trace")
+	if err != nil {
+		t.Fatalf(`AnonymousClient("trace") returned error: %v`, err)
+	}
+	if flags := sasler.SecurityFlagsOf(mech); flags.Has(sasler.SecNoAnonymous) {
+		t.Fatalf(`SecurityFlagsOf(AnonymousClient()) returned %b; expected anonymous`, flags)
+	}
+
+	server := sasler.PlainServer(&FakePlainAuthenticator{})
+	if flags := sasler.SecurityFlagsOf(server); !flags.Has(sasler.SecNoAnonymous | sasler.SecPassCredentials) {
+		t.Fatalf(`SecurityFlagsOf(PlainServer()) returned %b; expected no anonymous and pass credentials`, flags)
+	}
+
+	custom := &fakeFlaggedMech{}
+	if flags := sasler.SecurityFlagsOf(custom); flags != sasler.SecMutualAuth {
+		t.Fatalf(`SecurityFlagsOf(custom) returned %b; expected SecMutualAuth`, flags)
+	}
+}
+
+func TestFilterMechs(t *testing.T) {
+	names := []string{"PLAIN", "SCRAM-SHA-256", "OAUTHBEARER", "EXTERNAL", "X-UNKNOWN"}
+	got := sasler.FilterMechs(names, sasler.SecNoPlaintext)
+	expected := []string{"SCRAM-SHA-256", "EXTERNAL"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf(`FilterMechs(%v, SecNoPlaintext) returned %v; expected %v`, names, got, expected)
+	}
+}
+
+type fakeFlaggedMech struct{}
+
+func (*fakeFlaggedMech) Mech() (string, bool) {
+	return "X-CUSTOM", true
+}
+
+func (*fakeFlaggedMech) SecurityFlags() sasler.SecurityFlags {
+	return sasler.SecMutualAuth
+}
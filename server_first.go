@@ -0,0 +1,213 @@
+package sasler
+
+import "context"
+
+// serverFirstClient adapts a client-first ClientMech for use with protocols
+// that don't support an initial response, where the server starts the
+// authentication exchange by sending an empty challenge.
+type serverFirstClient struct {
+	mech   ClientMech
+	dataFn func([]byte) ([]byte, error)
+}
+
+// ServerFirstClient returns a ClientMech that drives mech in "no initial
+// response" mode, as described in [RFC 4422, section 5]. The returned
+// ClientMech is server-first: the first call to Data must be done with the
+// empty challenge received from the server, and returns the initial response
+// of mech. Subsequent calls are passed to mech. If mech is already
+// server-first, it is returned unchanged.
+//
+// [RFC 4422, section 5]: https://tools.ietf.org/html/rfc4422#section-5
+func ServerFirstClient(mech ClientMech) ClientMech {
+	if _, clientFirst := mech.Mech(); !clientFirst {
+		return mech
+	}
+	m := &serverFirstClient{mech: mech}
+	m.dataFn = m.emptyChallenge
+	return m
+}
+
+// Mech returns the name of the adapted mechanism, and false for server-first.
+func (m *serverFirstClient) Mech() (string, bool) {
+	name, _ := m.mech.Mech()
+	return name, false
+}
+
+// Data accepts the empty challenge on the first call and returns the initial
+// response of the adapted mechanism, and passes subsequent calls on to the
+// adapted mechanism.
+func (m *serverFirstClient) Data(challenge []byte) ([]byte, error) {
+	if m.dataFn == nil {
+		return nil, ErrInvalidState
+	}
+	return m.dataFn(challenge)
+}
+
+// emptyChallenge accepts the empty challenge sent by the server and returns
+// the initial response of the adapted mechanism.
+func (m *serverFirstClient) emptyChallenge(challenge []byte) ([]byte, error) {
+	if len(challenge) > 0 {
+		m.dataFn = nil
+		return nil, ErrInvalidMessage
+	}
+	m.dataFn = m.mech.Data
+	return m.mech.Data(nil)
+}
+
+// Success passes the success indication on to the adapted mechanism.
+func (m *serverFirstClient) Success(data []byte) error {
+	return m.mech.Success(data)
+}
+
+// SecurityLayer returns the security layer negotiated by the adapted
+// mechanism, if any.
+func (m *serverFirstClient) SecurityLayer() SecurityLayer {
+	return SecurityLayerOf(m.mech)
+}
+
+// Abort aborts the adapted mechanism, and passes subsequent calls on to it.
+func (m *serverFirstClient) Abort() {
+	m.mech.Abort()
+	m.dataFn = m.mech.Data
+}
+
+// serverFirstServer adapts a client-first ServerMech for use with protocols
+// that don't support an initial response, where the server starts the
+// authentication exchange by sending an empty challenge.
+type serverFirstServer struct {
+	mech    ServerMech
+	started bool
+	dataFn  func(context.Context, []byte) ([]byte, error)
+}
+
+// ServerFirstServer returns a ServerMech that drives mech in "no initial
+// response" mode, as described in [RFC 4422, section 5]. The returned
+// ServerMech is server-first: the first call to Data must be done with nil or
+// a zero length slice, and returns the empty challenge that must be sent to
+// the client. The response of the client is passed to mech as its initial
+// response, and subsequent calls are passed to mech. If mech is already
+// server-first, it is returned unchanged.
+//
+// [RFC 4422, section 5]: https://tools.ietf.org/html/rfc4422#section-5
+func ServerFirstServer(mech ServerMech) ServerMech {
+	if _, clientFirst := mech.Mech(); !clientFirst {
+		return mech
+	}
+	m := &serverFirstServer{mech: mech}
+	m.dataFn = m.emptyChallenge
+	return m
+}
+
+// Mech returns the name of the adapted mechanism, and false for server-first.
+func (m *serverFirstServer) Mech() (string, bool) {
+	name, _ := m.mech.Mech()
+	return name, false
+}
+
+// Data returns the empty challenge on the first call, and passes subsequent
+// calls on to the adapted mechanism.
+func (m *serverFirstServer) Data(data []byte) ([]byte, error) {
+	return m.DataContext(context.Background(), data)
+}
+
+// DataContext is like Data, but passes ctx on to the adapted mechanism.
+func (m *serverFirstServer) DataContext(ctx context.Context, data []byte) ([]byte, error) {
+	return serverDataContext(ctx, m, m.dataFn, data)
+}
+
+// emptyChallenge returns the empty challenge that invites the client to send
+// its initial response.
+func (m *serverFirstServer) emptyChallenge(ctx context.Context, data []byte) ([]byte, error) {
+	if len(data) > 0 {
+		m.dataFn = nil
+		name, _ := m.mech.Mech()
+		return nil, newError(name, "empty response", ErrInvalidMessage, ReasonMalformedMessage)
+	}
+	m.started = true
+	m.dataFn = m.mech.DataContext
+	return []byte{}, nil
+}
+
+// HasCompleted returns false until the empty challenge has been sent, and
+// otherwise returns the result of the adapted mechanism.
+func (m *serverFirstServer) HasCompleted() (bool, string) {
+	switch {
+	case m.started:
+		return m.mech.HasCompleted()
+	case m.dataFn == nil:
+		return true, ""
+	}
+	return false, ""
+}
+
+// Result returns nil until the empty challenge has been sent, and otherwise
+// returns the result of the adapted mechanism.
+func (m *serverFirstServer) Result() *Result {
+	switch {
+	case m.started:
+		return m.mech.Result()
+	case m.dataFn == nil:
+		name, _ := m.mech.Mech()
+		return &Result{Mech: name}
+	}
+	return nil
+}
+
+// SecurityLayer returns the security layer negotiated by the adapted
+// mechanism, if any.
+func (m *serverFirstServer) SecurityLayer() SecurityLayer {
+	return SecurityLayerOf(m.mech)
+}
+
+// Abort aborts the adapted mechanism, and passes subsequent calls on to it,
+// unless the client already sent an invalid response to the empty challenge.
+func (m *serverFirstServer) Abort() {
+	if !m.started {
+		if m.dataFn == nil {
+			return
+		}
+		m.started = true
+		m.dataFn = m.mech.DataContext
+	}
+	m.mech.Abort()
+}
+
+// exportState returns whether the empty challenge has been sent, followed by
+// the state of the adapted mechanism. Returns ErrInvalidState if the adapted
+// mechanism doesn't support sealing.
+func (m *serverFirstServer) exportState() ([]byte, error) {
+	e, ok := m.mech.(stateExporter)
+	if !ok || (!m.started && m.dataFn == nil) {
+		return nil, ErrInvalidState
+	}
+	state, err := e.exportState()
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(state)
+	phase := statePhaseInitial
+	if m.started {
+		phase = statePhaseInProgress
+	}
+	return append([]byte{phase}, state...), nil
+}
+
+// importState restores a state returned by exportState, on both the adapter
+// and the adapted mechanism.
+func (m *serverFirstServer) importState(state []byte) error {
+	e, ok := m.mech.(stateExporter)
+	if !ok || m.started || m.dataFn == nil {
+		return ErrInvalidState
+	}
+	if len(state) == 0 || state[0] > statePhaseInProgress {
+		return ErrInvalidSealedState
+	}
+	if err := e.importState(state[1:]); err != nil {
+		return err
+	}
+	if state[0] == statePhaseInProgress {
+		m.started = true
+		m.dataFn = m.mech.DataContext
+	}
+	return nil
+}
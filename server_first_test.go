@@ -0,0 +1,132 @@
+package sasler_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/phedny/sasler"
+)
+
+func TestServerFirstClient(t *testing.T) {
+	auth := sasler.ServerFirstClient(sasler.PlainClient("", "user", []byte("password")))
+
+	gotName, gotClientFirst := auth.Mech()
+	expectedName := "PLAIN"
+	if gotName != expectedName || gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", false)`, gotName, gotClientFirst, expectedName)
+	}
+
+	gotResponse, err := auth.Data([]byte{})
+	expectedResponse := []byte("\x00user\x00password")
+	if err != nil {
+		t.Fatalf(`Data("") returned error: %v`, err)
+	}
+	if !bytes.Equal(gotResponse, expectedResponse) {
+		t.Fatalf(`Data("") returned %s; expected %s`, gotResponse, expectedResponse)
+	}
+
+	_, err = auth.Data(nil)
+	if !errors.Is(err, sasler.ErrInvalidState) {
+		t.Fatalf(`Data returned error: %v; expected ErrInvalidState`, err)
+	}
+}
+
+func TestServerFirstClient_NonEmptyChallenge(t *testing.T) {
+	auth := sasler.ServerFirstClient(sasler.PlainClient("", "user", []byte("password")))
+
+	challenge := []byte("challenge")
+	gotResponse, err := auth.Data(challenge)
+	if gotResponse != nil || !errors.Is(err, sasler.ErrInvalidMessage) {
+		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, ErrInvalidMessage)`, challenge, gotResponse, err)
+	}
+}
+
+func TestServerFirstServer(t *testing.T) {
+	auth := sasler.ServerFirstServer(sasler.PlainServer(&FakePlainAuthenticator{}))
+
+	gotName, gotClientFirst := auth.Mech()
+	expectedName := "PLAIN"
+	if gotName != expectedName || gotClientFirst {
+		t.Fatalf(`Name() returned ("%s", %v); expected ("%s", false)`, gotName, gotClientFirst, expectedName)
+	}
+
+	gotChallenge, err := auth.Data(nil)
+	if gotChallenge == nil || len(gotChallenge) > 0 || err != nil {
+		t.Fatalf(`Data(nil) returned ("%s", %v); expected ("", nil)`, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	if gotCompleted || gotAuthz != "" {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (false, "")`, gotCompleted, gotAuthz)
+	}
+
+	response := []byte("\x00user\x00password")
+	gotChallenge, err = auth.Data(response)
+	if gotChallenge != nil || err != nil {
+		t.Fatalf(`Data("%s") returned ("%s", %v); expected (nil, nil)`, response, gotChallenge, err)
+	}
+
+	gotCompleted, gotAuthz = auth.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestServerFirstServer_Abort(t *testing.T) {
+	auth := sasler.ServerFirstServer(sasler.PlainServer(&FakePlainAuthenticator{}))
+
+	if _, err := auth.Data(nil); err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+
+	auth.Abort()
+
+	gotCompleted, gotAuthz := auth.HasCompleted()
+	if !gotCompleted || gotAuthz != "" {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "")`, gotCompleted, gotAuthz)
+	}
+	if result := auth.Result(); result == nil || !result.Aborted {
+		t.Fatalf(`Result() returned %+v; expected aborted result`, result)
+	}
+	response := []byte("\x00user\x00password")
+	if _, err := auth.Data(response); !errors.Is(err, sasler.ErrAborted) {
+		t.Fatalf(`Data("%s") returned error: %v; expected ErrAborted`, response, err)
+	}
+}
+
+func TestServerFirst_ClientAndServer(t *testing.T) {
+	client := sasler.ServerFirstClient(sasler.PlainClient("", "user", []byte("password")))
+	server := sasler.ServerFirstServer(sasler.PlainServer(&FakePlainAuthenticator{}))
+
+	challenge, err := server.Data(nil)
+	for challenge != nil && err == nil {
+		var response []byte
+		response, err = client.Data(challenge)
+		if err != nil {
+			t.Fatalf(`client.Data("%s") returned error: %v`, challenge, err)
+		}
+		challenge, err = server.Data(response)
+	}
+	if err != nil {
+		t.Fatalf(`server.Data() returned error: %v`, err)
+	}
+
+	gotCompleted, gotAuthz := server.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestServerFirst_AlreadyServerFirst(t *testing.T) {
+	client := sasler.ServerFirstClient(sasler.PlainClient("", "user", []byte("password")))
+	if sasler.ServerFirstClient(client) != client {
+		t.Fatalf(`ServerFirstClient() didn't return server-first client unchanged`)
+	}
+	server := sasler.ServerFirstServer(sasler.PlainServer(&FakePlainAuthenticator{}))
+	if sasler.ServerFirstServer(server) != server {
+		t.Fatalf(`ServerFirstServer() didn't return server-first server unchanged`)
+	}
+}
@@ -1,11 +1,14 @@
 package sasler
 
+import "context"
+
 // singleMessageClient is used for the client-side implementation of mechanisms
 // that only send a single message from client to server, and don't expect a
 // challenge as reply.
 type singleMessageClient struct {
-	name string
-	ir   []byte
+	name    string
+	ir      []byte
+	aborted bool
 }
 
 // Mech returns the mechanism name, and true for client-first.
@@ -16,6 +19,9 @@ func (m *singleMessageClient) Mech() (string, bool) {
 // Data returns the initial response when first called, and returns the
 // ErrInvalidState error on subsequents call.
 func (m *singleMessageClient) Data(challenge []byte) ([]byte, error) {
+	if m.aborted {
+		return nil, ErrAborted
+	}
 	if m.ir == nil {
 		return nil, ErrInvalidState
 	}
@@ -27,13 +33,35 @@ func (m *singleMessageClient) Data(challenge []byte) ([]byte, error) {
 	return ir, nil
 }
 
+// Success accepts the success indication after the initial response has been
+// sent, and returns ErrInvalidMessage if it carries additional data.
+func (m *singleMessageClient) Success(data []byte) error {
+	if m.aborted {
+		return ErrAborted
+	}
+	if m.ir != nil {
+		return ErrInvalidState
+	}
+	if len(data) > 0 {
+		return ErrInvalidMessage
+	}
+	return nil
+}
+
+// Abort zeroes the initial response, as it may contain a password.
+func (m *singleMessageClient) Abort() {
+	zeroBytes(m.ir)
+	m.ir = nil
+	m.aborted = true
+}
+
 // singleMessageServer is used for the server-side implementation of mechanisms
 // that only send a single message from client to server, and don't expect a
 // challenge as reply.
 type singleMessageServer struct {
-	name  string
-	authz string
-	cb    func([]byte) (string, error)
+	name   string
+	result Result
+	cb     func(context.Context, []byte, *Result) error
 }
 
 // Mech returns the mechanism name, and true for client-first.
@@ -45,12 +73,28 @@ func (m *singleMessageServer) Mech() (string, bool) {
 // the cb callback function, and returns the ErrInvalidState error on
 // subsequent calls.
 func (m *singleMessageServer) Data(ir []byte) ([]byte, error) {
+	return m.DataContext(context.Background(), ir)
+}
+
+// DataContext is like Data, but passes ctx on to the cb callback function.
+func (m *singleMessageServer) DataContext(ctx context.Context, ir []byte) ([]byte, error) {
+	return serverDataContext(ctx, m, m.verify, ir)
+}
+
+// verify checks the initial response using the cb callback function, which
+// fills in the result.
+func (m *singleMessageServer) verify(ctx context.Context, ir []byte) ([]byte, error) {
+	if m.result.Aborted {
+		return nil, ErrAborted
+	}
 	if m.cb == nil {
 		return nil, ErrInvalidState
 	}
-	authz, err := m.cb(ir)
-	if err == nil {
-		m.authz = authz
+	err := m.cb(ctx, ir, &m.result)
+	m.result.Mech = m.name
+	m.result.Succeeded = err == nil
+	if err != nil {
+		m.result.Authz = ""
 	}
 	m.cb = nil
 	return nil, err
@@ -62,5 +106,23 @@ func (m *singleMessageServer) HasCompleted() (bool, string) {
 	if m.cb != nil {
 		return false, ""
 	}
-	return true, m.authz
+	return true, m.result.Authz
+}
+
+// Result returns the result filled in by the cb callback function, once Data
+// has been called.
+func (m *singleMessageServer) Result() *Result {
+	if m.cb != nil {
+		return nil
+	}
+	result := m.result
+	return &result
+}
+
+// Abort fails the authentication process if Data hasn't been called yet.
+func (m *singleMessageServer) Abort() {
+	if m.cb != nil {
+		m.cb = nil
+		m.result = Result{Mech: m.name, Aborted: true}
+	}
 }
@@ -0,0 +1,221 @@
+package sasler
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidSealedState is returned by [StateSealer.Open] if the sealed
+	// state can't be decrypted, has been modified, or has been sealed for
+	// another mechanism.
+	ErrInvalidSealedState = errors.New("sasler: invalid sealed state")
+	// ErrStateExpired is returned by [StateSealer.Open] if the sealed state has
+	// expired.
+	ErrStateExpired = errors.New("sasler: sealed state expired")
+	// ErrStateConsumed is returned by [StateSealer.Open] if the sealed state has
+	// already been opened.
+	ErrStateConsumed = errors.New("sasler: sealed state already opened")
+)
+
+const (
+	// sealedStateVersion is the version of the format of sealed states.
+	sealedStateVersion = 1
+	// sealedStateIDLen is the length of the random ID of a sealed state.
+	sealedStateIDLen = 16
+)
+
+// Phases of an exported mechanism state.
+const (
+	// statePhaseInitial is the phase of a mechanism that hasn't received any
+	// data yet.
+	statePhaseInitial byte = iota
+	// statePhaseInProgress is the phase of a mechanism that awaits the next
+	// message of the client.
+	statePhaseInProgress
+)
+
+// stateExporter is implemented by ServerMech implementations whose state can
+// be sealed by a StateSealer.
+type stateExporter interface {
+	// exportState returns the state of the mechanism. Returns ErrInvalidState
+	// if authentication has completed.
+	exportState() ([]byte, error)
+	// importState restores a state returned by exportState. Returns
+	// ErrInvalidState if the mechanism has already received data.
+	importState(state []byte) error
+}
+
+// StateSealer seals the state of a ServerMech into an encrypted and
+// authenticated blob, and restores it on another ServerMech, such that a
+// multi-step authentication exchange can be spread over multiple server
+// instances, such as for an HTTP-based endpoint behind a load balancer.
+//
+// The SCRAM-* and ECDSA-NIST256P-CHALLENGE mechanisms support sealing, also
+// when adapted by [ServerFirstServer]. Each sealed state carries a random ID,
+// and can only be opened once, so that the next message of the client can't
+// be replayed against the same state. When sealed states are opened by
+// multiple server instances, Consume must be set.
+//
+// A StateSealer must be created with [NewStateSealer], after which Consume may
+// be set. Seal and Open of a StateSealer that hasn't been created that way,
+// such as the zero value, return ErrInvalidState.
+type StateSealer struct {
+	// Consume is called by Open with the ID of the sealed state, and must
+	// return true only the first time it's called with that ID, such as by
+	// atomically inserting the ID into a store that is shared by all server
+	// instances. The ID must be remembered at least until the sealed state
+	// expires. If Consume is nil, Open remembers the IDs in memory, which only
+	// prevents a sealed state from being opened twice on the same instance.
+	Consume func(id []byte) bool
+
+	aead     cipher.AEAD
+	ttl      time.Duration
+	mu       sync.Mutex
+	consumed map[string]time.Time
+}
+
+// NewStateSealer returns a StateSealer that seals states using AES-GCM with
+// the supplied key, which must be 16, 24 or 32 bytes long, and shared by all
+// server instances. Sealed states expire after ttl. Returns ErrInvalidKey if
+// the key has an invalid length.
+func NewStateSealer(key []byte, ttl time.Duration) (*StateSealer, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &StateSealer{aead: aead, ttl: ttl}, nil
+}
+
+// Seal returns the sealed state of mech, which must be passed to Open on the
+// instance that receives the next message of the client. Returns
+// ErrInvalidState if mech doesn't support sealing, if authentication has
+// completed, or if s hasn't been created by NewStateSealer. Call Abort on mech if it isn't used after sealing, so that it
+// zeroes its secrets.
+func (s *StateSealer) Seal(mech ServerMech) ([]byte, error) {
+	e, ok := mech.(stateExporter)
+	if !ok || s.aead == nil {
+		return nil, ErrInvalidState
+	}
+	state, err := e.exportState()
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(state)
+	plaintext := make([]byte, 8+sealedStateIDLen, 8+sealedStateIDLen+len(state))
+	binary.BigEndian.PutUint64(plaintext, uint64(time.Now().Add(s.ttl).Unix()))
+	if _, err := rand.Read(plaintext[8:]); err != nil {
+		return nil, err
+	}
+	plaintext = append(plaintext, state...)
+	defer zeroBytes(plaintext)
+	sealed := make([]byte, 1+s.aead.NonceSize(), 1+s.aead.NonceSize()+len(plaintext)+s.aead.Overhead())
+	sealed[0] = sealedStateVersion
+	if _, err := rand.Read(sealed[1:]); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(sealed, sealed[1:], plaintext, sealedStateAdditionalData(mech)), nil
+}
+
+// Open restores the sealed state on mech, which must be a newly created
+// ServerMech for the same mechanism as the one that was sealed, and that uses
+// the same authenticator. A sealed state can only be opened once. Returns
+// ErrInvalidSealedState if the sealed state is invalid, ErrStateExpired if it
+// has expired, ErrStateConsumed if it has already been opened, and
+// ErrInvalidState if mech doesn't support sealing, has already received data,
+// or if s hasn't been created by NewStateSealer.
+func (s *StateSealer) Open(mech ServerMech, sealed []byte) error {
+	e, ok := mech.(stateExporter)
+	if !ok || s.aead == nil {
+		return ErrInvalidState
+	}
+	if len(sealed) < 1+s.aead.NonceSize() || sealed[0] != sealedStateVersion {
+		return ErrInvalidSealedState
+	}
+	nonce := sealed[1 : 1+s.aead.NonceSize()]
+	plaintext, err := s.aead.Open(nil, nonce, sealed[1+len(nonce):], sealedStateAdditionalData(mech))
+	if err != nil || len(plaintext) < 8+sealedStateIDLen {
+		return ErrInvalidSealedState
+	}
+	defer zeroBytes(plaintext)
+	expires := time.Unix(int64(binary.BigEndian.Uint64(plaintext)), 0)
+	if time.Now().After(expires) {
+		return ErrStateExpired
+	}
+	if !s.consume(plaintext[8:8+sealedStateIDLen], expires) {
+		return ErrStateConsumed
+	}
+	return e.importState(plaintext[8+sealedStateIDLen:])
+}
+
+// consume passes id to Consume, or if it's nil, records id in memory until the
+// sealed state expires. Returns false if id has already been consumed.
+func (s *StateSealer) consume(id []byte, expires time.Time) bool {
+	if s.Consume != nil {
+		return s.Consume(append([]byte(nil), id...))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for consumedID, consumedExpires := range s.consumed {
+		if now.After(consumedExpires) {
+			delete(s.consumed, consumedID)
+		}
+	}
+	if _, ok := s.consumed[string(id)]; ok {
+		return false
+	}
+	if s.consumed == nil {
+		s.consumed = make(map[string]time.Time)
+	}
+	s.consumed[string(id)] = expires
+	return true
+}
+
+// sealedStateAdditionalData returns the additional data that binds a sealed
+// state to the format version and the mechanism name.
+func sealedStateAdditionalData(mech ServerMech) []byte {
+	name, _ := mech.Mech()
+	return append([]byte{sealedStateVersion}, name...)
+}
+
+// appendStateField appends a length-prefixed field to an exported state.
+func appendStateField(state, field []byte) []byte {
+	state = binary.AppendUvarint(state, uint64(len(field)))
+	return append(state, field...)
+}
+
+// stateDecoder reads the length-prefixed fields of an exported state.
+type stateDecoder struct {
+	state []byte
+	err   error
+}
+
+// field returns a copy of the next field, or nil if the state is exhausted or
+// malformed, in which case err is set to ErrInvalidSealedState.
+func (d *stateDecoder) field() []byte {
+	length, n := binary.Uvarint(d.state)
+	if n <= 0 || length > uint64(len(d.state)-n) {
+		d.err = ErrInvalidSealedState
+		d.state = nil
+		return nil
+	}
+	field := make([]byte, length)
+	copy(field, d.state[n:])
+	d.state = d.state[n+int(length):]
+	return field
+}
+
+// more returns true if the state contains more fields.
+func (d *stateDecoder) more() bool {
+	return d.err == nil && len(d.state) > 0
+}
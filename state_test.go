@@ -0,0 +1,220 @@
+package sasler_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/phedny/sasler"
+)
+
+var fakeSealerKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestStateSealer(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(elliptic.P256(), rand.Reader) returned error: %v`, err)
+	}
+	sealer, err := sasler.NewStateSealer(fakeSealerKey, time.Minute)
+	if err != nil {
+		t.Fatalf(`NewStateSealer(...) returned error: %v`, err)
+	}
+	auth := &fakeEcdsaAuthenticator{key: &privateKey.PublicKey}
+
+	first := sasler.EcdsaNist256pChallengeServer(auth)
+	ir := []byte("user")
+	challenge, err := first.Data(ir)
+	if err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+	sealed, err := sealer.Seal(first)
+	if err != nil {
+		t.Fatalf(`Seal() returned error: %v`, err)
+	}
+	first.Abort()
+
+	second := sasler.EcdsaNist256pChallengeServer(auth)
+	if err := sealer.Open(second, sealed); err != nil {
+		t.Fatalf(`Open() returned error: %v`, err)
+	}
+	sig, err := ecdsa.SignASN1(rand.Reader, privateKey, challenge)
+	if err != nil {
+		t.Fatalf(`SignASN1() returned error: %v`, err)
+	}
+	if _, err := second.Data(sig); err != nil {
+		t.Fatalf(`Data(sig) returned error: %v`, err)
+	}
+
+	gotCompleted, gotAuthz := second.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestStateSealer_ServerFirst(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(elliptic.P256(), rand.Reader) returned error: %v`, err)
+	}
+	sealer, err := sasler.NewStateSealer(fakeSealerKey, time.Minute)
+	if err != nil {
+		t.Fatalf(`NewStateSealer(...) returned error: %v`, err)
+	}
+	auth := &fakeEcdsaAuthenticator{key: &privateKey.PublicKey}
+
+	first := sasler.ServerFirstServer(sasler.EcdsaNist256pChallengeServer(auth))
+	if _, err := first.Data(nil); err != nil {
+		t.Fatalf(`Data(nil) returned error: %v`, err)
+	}
+	sealed, err := sealer.Seal(first)
+	if err != nil {
+		t.Fatalf(`Seal() returned error: %v`, err)
+	}
+
+	second := sasler.ServerFirstServer(sasler.EcdsaNist256pChallengeServer(auth))
+	if err := sealer.Open(second, sealed); err != nil {
+		t.Fatalf(`Open() returned error: %v`, err)
+	}
+	ir := []byte("user")
+	if _, err := second.Data(ir); err != nil {
+		t.Fatalf(`Data("%s") returned error: %v`, ir, err)
+	}
+}
+
+func TestStateSealer_Replayed(t *testing.T) {
+	sealer, err := sasler.NewStateSealer(fakeSealerKey, time.Minute)
+	if err != nil {
+		t.Fatalf(`NewStateSealer(...) returned error: %v`, err)
+	}
+	sealed, err := sealer.Seal(sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{}))
+	if err != nil {
+		t.Fatalf(`Seal() returned error: %v`, err)
+	}
+
+	if err := sealer.Open(sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{}), sealed); err != nil {
+		t.Fatalf(`Open() returned error: %v`, err)
+	}
+	err = sealer.Open(sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{}), sealed)
+	if !errors.Is(err, sasler.ErrStateConsumed) {
+		t.Fatalf(`Open() of opened state returned error: %v; expected ErrStateConsumed`, err)
+	}
+}
+
+func TestStateSealer_Consume(t *testing.T) {
+	consumed := make(map[string]bool)
+	consume := func(id []byte) bool {
+		if consumed[string(id)] {
+			return false
+		}
+		consumed[string(id)] = true
+		return true
+	}
+	sealer, err := sasler.NewStateSealer(fakeSealerKey, time.Minute)
+	if err != nil {
+		t.Fatalf(`NewStateSealer(...) returned error: %v`, err)
+	}
+	sealer.Consume = consume
+	otherSealer, err := sasler.NewStateSealer(fakeSealerKey, time.Minute)
+	if err != nil {
+		t.Fatalf(`NewStateSealer(...) returned error: %v`, err)
+	}
+	otherSealer.Consume = consume
+
+	sealed, err := sealer.Seal(sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{}))
+	if err != nil {
+		t.Fatalf(`Seal() returned error: %v`, err)
+	}
+	other, err := sealer.Seal(sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{}))
+	if err != nil {
+		t.Fatalf(`Seal() returned error: %v`, err)
+	}
+
+	if err := sealer.Open(sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{}), sealed); err != nil {
+		t.Fatalf(`Open() returned error: %v`, err)
+	}
+	err = otherSealer.Open(sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{}), sealed)
+	if !errors.Is(err, sasler.ErrStateConsumed) {
+		t.Fatalf(`Open() of state opened on other instance returned error: %v; expected ErrStateConsumed`, err)
+	}
+	if err := otherSealer.Open(sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{}), other); err != nil {
+		t.Fatalf(`Open() of other state returned error: %v`, err)
+	}
+	if len(consumed) != 2 {
+		t.Fatalf(`Consume() was called with %d distinct IDs; expected 2`, len(consumed))
+	}
+}
+
+func TestStateSealer_Expired(t *testing.T) {
+	sealer, err := sasler.NewStateSealer(fakeSealerKey, -time.Minute)
+	if err != nil {
+		t.Fatalf(`NewStateSealer(...) returned error: %v`, err)
+	}
+	sealed, err := sealer.Seal(sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{}))
+	if err != nil {
+		t.Fatalf(`Seal() returned error: %v`, err)
+	}
+
+	err = sealer.Open(sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{}), sealed)
+	if !errors.Is(err, sasler.ErrStateExpired) {
+		t.Fatalf(`Open() returned error: %v; expected ErrStateExpired`, err)
+	}
+}
+
+func TestStateSealer_Invalid(t *testing.T) {
+	sealer, err := sasler.NewStateSealer(fakeSealerKey, time.Minute)
+	if err != nil {
+		t.Fatalf(`NewStateSealer(...) returned error: %v`, err)
+	}
+	sealed, err := sealer.Seal(sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{}))
+	if err != nil {
+		t.Fatalf(`Seal() returned error: %v`, err)
+	}
+
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	err = sealer.Open(sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{}), tampered)
+	if !errors.Is(err, sasler.ErrInvalidSealedState) {
+		t.Fatalf(`Open(tampered) returned error: %v; expected ErrInvalidSealedState`, err)
+	}
+
+	otherSealer, err := sasler.NewStateSealer([]byte("fedcba9876543210fedcba9876543210"), time.Minute)
+	if err != nil {
+		t.Fatalf(`NewStateSealer(...) returned error: %v`, err)
+	}
+	err = otherSealer.Open(sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{}), sealed)
+	if !errors.Is(err, sasler.ErrInvalidSealedState) {
+		t.Fatalf(`Open() with other key returned error: %v; expected ErrInvalidSealedState`, err)
+	}
+
+	other := sasler.ServerFirstServer(sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{}))
+	if err := sealer.Open(other, sealed); !errors.Is(err, sasler.ErrInvalidSealedState) {
+		t.Fatalf(`Open() with other mechanism returned error: %v; expected ErrInvalidSealedState`, err)
+	}
+}
+
+func TestStateSealer_Unsupported(t *testing.T) {
+	sealer, err := sasler.NewStateSealer(fakeSealerKey, time.Minute)
+	if err != nil {
+		t.Fatalf(`NewStateSealer(...) returned error: %v`, err)
+	}
+	if _, err := sealer.Seal(sasler.PlainServer(&FakePlainAuthenticator{})); !errors.Is(err, sasler.ErrInvalidState) {
+		t.Fatalf(`Seal(PLAIN) returned error: %v; expected ErrInvalidState`, err)
+	}
+
+	zero := &sasler.StateSealer{Consume: func([]byte) bool { return true }}
+	if _, err := zero.Seal(sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{})); !errors.Is(err, sasler.ErrInvalidState) {
+		t.Fatalf(`Seal() on zero StateSealer returned error: %v; expected ErrInvalidState`, err)
+	}
+	if err := zero.Open(sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{}), []byte{1}); !errors.Is(err, sasler.ErrInvalidState) {
+		t.Fatalf(`Open() on zero StateSealer returned error: %v; expected ErrInvalidState`, err)
+	}
+
+	if _, err := sasler.NewStateSealer([]byte("short"), time.Minute); !errors.Is(err, sasler.ErrInvalidKey) {
+		t.Fatalf(`NewStateSealer("short", ...) returned error: %v; expected ErrInvalidKey`, err)
+	}
+}
@@ -0,0 +1,190 @@
+package sasler
+
+import (
+	"context"
+	"errors"
+)
+
+// MessageKind identifies the kind of a message received by a [Transport].
+type MessageKind int
+
+const (
+	// MessageData is a challenge sent by the server, or a response sent by the
+	// client.
+	MessageData MessageKind = iota
+	// MessageSuccess is the success indication sent by the server, which may
+	// carry additional data.
+	MessageSuccess
+	// MessageFailure is the failure indication sent by the server.
+	MessageFailure
+	// MessageAbort is the abort indication sent by the client, such as "*" in
+	// IMAP.
+	MessageAbort
+)
+
+// Transport carries the messages of an authentication exchange over a
+// protocol, such as IMAP, SMTP or LDAP, encoding them as the protocol
+// requires.
+type Transport interface {
+	// Send sends a response to the server, or a challenge to the client.
+	Send(ctx context.Context, data []byte) error
+	// Receive returns the next message received from the other party. The data
+	// is the challenge, response, or additional data sent along with the
+	// success indication.
+	Receive(ctx context.Context) (MessageKind, []byte, error)
+}
+
+// ClientTransport is the Transport used by [Authenticate].
+type ClientTransport interface {
+	Transport
+	// Start requests the server to start authentication using the mechanism
+	// with the supplied name. The ir argument is the initial response, which
+	// is nil for server-first mechanisms. An empty initial response is
+	// non-nil, and must be encoded as the protocol requires, such as "=".
+	Start(ctx context.Context, mech string, ir []byte) error
+	// Abort sends the abort indication to the server, such as "*" in IMAP, and
+	// consumes the failure indication the server responds with.
+	Abort(ctx context.Context) error
+}
+
+// ServerTransport is the Transport used by [Serve].
+type ServerTransport interface {
+	Transport
+	// Success sends the success indication to the client, along with the
+	// additional data, or nil if there is none. If the protocol can't carry
+	// additional data, it must send the data as a challenge, and receive the
+	// empty response of the client before sending the success indication.
+	Success(ctx context.Context, data []byte) error
+	// Failure sends the failure indication to the client. The err argument is
+	// the error returned by the mechanism, which may be used to select a
+	// protocol-specific failure code.
+	Failure(ctx context.Context, err error) error
+}
+
+// Authenticate performs an authentication exchange with the server using mech
+// over t, relaying messages until the server indicates success or failure.
+// Returns nil if the server indicated success and mech accepted the
+// additional data, if any. Returns ErrAuthenticationFailed if the server
+// indicated failure. If mech or t fails, the exchange is aborted, and the
+// error is returned. If sending the abort indication fails too, that error is
+// joined with the returned error, as the exchange is then out of sync with the
+// server. Except on success, mech is aborted so that it zeroes its secrets.
+func Authenticate(ctx context.Context, mech ClientMech, t ClientTransport) error {
+	name, clientFirst := mech.Mech()
+	var ir []byte
+	if clientFirst {
+		data, err := mech.Data(nil)
+		if err != nil {
+			mech.Abort()
+			return err
+		}
+		ir = data
+	}
+	if err := t.Start(ctx, name, ir); err != nil {
+		mech.Abort()
+		return err
+	}
+	for {
+		kind, data, err := t.Receive(ctx)
+		if err != nil {
+			mech.Abort()
+			return err
+		}
+		switch kind {
+		case MessageSuccess:
+			if err := mech.Success(data); err != nil {
+				mech.Abort()
+				return err
+			}
+			return nil
+		case MessageFailure:
+			mech.Abort()
+			return ErrAuthenticationFailed
+		case MessageData:
+			resp, err := mech.Data(data)
+			if err != nil {
+				mech.Abort()
+				return joinTransportError(err, t.Abort(ctx))
+			}
+			if resp == nil {
+				resp = []byte{}
+			}
+			if err := t.Send(ctx, resp); err != nil {
+				mech.Abort()
+				return err
+			}
+		default:
+			mech.Abort()
+			return joinTransportError(ErrInvalidMessage, t.Abort(ctx))
+		}
+	}
+}
+
+// Serve performs an authentication exchange with the client using mech over
+// t, relaying messages until mech has completed. Returns nil if
+// authentication has succeeded, after which HasCompleted and Result of mech
+// report the authorized authz. If mech fails, the failure indication is sent
+// and the error of mech is returned. Returns ErrAborted if the client aborted
+// the exchange, after sending the failure indication. If t fails, mech is
+// aborted, and the error is returned. If sending the failure indication fails,
+// that error is joined with the returned error.
+func Serve(ctx context.Context, mech ServerMech, t ServerTransport) error {
+	var data []byte
+	if _, clientFirst := mech.Mech(); clientFirst {
+		ir, err := serveReceive(ctx, mech, t)
+		if err != nil {
+			return err
+		}
+		data = ir
+	}
+	for {
+		challenge, err := mech.DataContext(ctx, data)
+		if err != nil {
+			if completed, _ := mech.HasCompleted(); !completed {
+				mech.Abort()
+			}
+			return joinTransportError(err, t.Failure(ctx, err))
+		}
+		if completed, _ := mech.HasCompleted(); completed {
+			return t.Success(ctx, challenge)
+		}
+		if err := t.Send(ctx, challenge); err != nil {
+			mech.Abort()
+			return err
+		}
+		if data, err = serveReceive(ctx, mech, t); err != nil {
+			return err
+		}
+	}
+}
+
+// serveReceive receives the next response of the client. The mechanism is
+// aborted if the client aborted the exchange, or if receiving fails. The
+// failure indication is sent in response to an abort indication, as required
+// by [RFC 4422, section 3.5].
+//
+// [RFC 4422, section 3.5]: https://tools.ietf.org/html/rfc4422#section-3.5
+func serveReceive(ctx context.Context, mech ServerMech, t ServerTransport) ([]byte, error) {
+	kind, data, err := t.Receive(ctx)
+	switch {
+	case err != nil:
+		mech.Abort()
+		return nil, err
+	case kind == MessageAbort:
+		mech.Abort()
+		return nil, joinTransportError(ErrAborted, t.Failure(ctx, ErrAborted))
+	case kind != MessageData:
+		mech.Abort()
+		return nil, joinTransportError(ErrInvalidMessage, t.Failure(ctx, ErrInvalidMessage))
+	}
+	return data, nil
+}
+
+// joinTransportError returns err, joined with the error tErr of sending the
+// abort or failure indication, if any.
+func joinTransportError(err, tErr error) error {
+	if tErr == nil {
+		return err
+	}
+	return errors.Join(err, tErr)
+}
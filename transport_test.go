@@ -0,0 +1,241 @@
+package sasler_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/phedny/sasler"
+)
+
+func TestAuthenticateServe(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`GenerateKey(elliptic.P256(), rand.Reader) returned error: %v`, err)
+	}
+	client, err := sasler.EcdsaNist256pChallengeClient("", "user", privateKey)
+	if err != nil {
+		t.Fatalf(`EcdsaNist256pChallengeClient(...) returned error: %v`, err)
+	}
+	server := sasler.EcdsaNist256pChallengeServer(&fakeEcdsaAuthenticator{key: &privateKey.PublicKey})
+
+	clientErr, serverErr, gotMech := runExchange(client, server)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf(`Authenticate() and Serve() returned errors (%v, %v); expected (nil, nil)`, clientErr, serverErr)
+	}
+	if gotMech != "ECDSA-NIST256P-CHALLENGE" {
+		t.Fatalf(`Start() was called with mechanism "%s"; expected "ECDSA-NIST256P-CHALLENGE"`, gotMech)
+	}
+
+	gotCompleted, gotAuthz := server.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestAuthenticateServe_WrongPasswd(t *testing.T) {
+	client := sasler.PlainClient("", "user", []byte("wrong-password"))
+	server := sasler.PlainServer(&FakePlainAuthenticator{})
+
+	clientErr, serverErr, _ := runExchange(client, server)
+	if !errors.Is(clientErr, sasler.ErrAuthenticationFailed) {
+		t.Fatalf(`Authenticate() returned error: %v; expected ErrAuthenticationFailed`, clientErr)
+	}
+	var gotErr *sasler.Error
+	if !errors.As(serverErr, &gotErr) || gotErr.Reason != sasler.ReasonBadCredentials {
+		t.Fatalf(`Serve() returned error: %v; expected bad credentials`, serverErr)
+	}
+}
+
+func TestAuthenticateServe_ServerFirst(t *testing.T) {
+	client := sasler.ServerFirstClient(sasler.PlainClient("", "user", []byte("password")))
+	server := sasler.ServerFirstServer(sasler.PlainServer(&FakePlainAuthenticator{}))
+
+	clientErr, serverErr, _ := runExchange(client, server)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf(`Authenticate() and Serve() returned errors (%v, %v); expected (nil, nil)`, clientErr, serverErr)
+	}
+
+	gotCompleted, gotAuthz := server.HasCompleted()
+	expectedAuthz := "userZ"
+	if !gotCompleted || gotAuthz != expectedAuthz {
+		t.Fatalf(`HasCompleted() returned (%v, "%s"); expected (true, "%s")`, gotCompleted, gotAuthz, expectedAuthz)
+	}
+}
+
+func TestServe_Abort(t *testing.T) {
+	server := sasler.ServerFirstServer(sasler.PlainServer(&FakePlainAuthenticator{}))
+	transport := newFakeServerTransport()
+	transport.toServer <- fakeMessage{kind: sasler.MessageAbort}
+
+	err := sasler.Serve(context.Background(), server, transport)
+	if !errors.Is(err, sasler.ErrAborted) {
+		t.Fatalf(`Serve() returned error: %v; expected ErrAborted`, err)
+	}
+
+	if msg := <-transport.toClient; msg.kind != sasler.MessageData {
+		t.Fatalf(`Serve() sent message of kind %d; expected empty challenge`, msg.kind)
+	}
+	if msg := <-transport.toClient; msg.kind != sasler.MessageFailure {
+		t.Fatalf(`Serve() sent message of kind %d; expected failure`, msg.kind)
+	}
+	if result := server.Result(); result == nil || !result.Aborted {
+		t.Fatalf(`Result() returned %+v; expected aborted result`, result)
+	}
+}
+
+func TestAuthenticate_InvalidChallenge(t *testing.T) {
+	client := sasler.PlainClient("", "user", []byte("password"))
+	transport := newFakeClientTransport()
+	transport.toClient <- fakeMessage{kind: sasler.MessageData, data: []byte("unexpected")}
+	transport.toClient <- fakeMessage{kind: sasler.MessageFailure}
+
+	err := sasler.Authenticate(context.Background(), client, transport)
+	if !errors.Is(err, sasler.ErrInvalidState) {
+		t.Fatalf(`Authenticate() returned error: %v; expected ErrInvalidState`, err)
+	}
+
+	<-transport.toServer
+	if msg := <-transport.toServer; msg.kind != sasler.MessageAbort {
+		t.Fatalf(`Authenticate() sent message of kind %d; expected abort`, msg.kind)
+	}
+}
+
+func TestAuthenticate_AbortFailed(t *testing.T) {
+	client := sasler.PlainClient("", "user", []byte("password"))
+	transport := newFakeClientTransport()
+	transport.abortErr = errors.New("connection closed")
+	transport.toClient <- fakeMessage{kind: sasler.MessageData, data: []byte("unexpected")}
+
+	err := sasler.Authenticate(context.Background(), client, transport)
+	if !errors.Is(err, sasler.ErrInvalidState) || !errors.Is(err, transport.abortErr) {
+		t.Fatalf(`Authenticate() returned error: %v; expected ErrInvalidState joined with abort error`, err)
+	}
+}
+
+func TestServe_FailureFailed(t *testing.T) {
+	server := sasler.PlainServer(&FakePlainAuthenticator{})
+	transport := newFakeServerTransport()
+	transport.failureErr = errors.New("connection closed")
+	transport.toServer <- fakeMessage{kind: sasler.MessageData, data: []byte("\x00user\x00wrong-password")}
+
+	err := sasler.Serve(context.Background(), server, transport)
+	if !errors.Is(err, sasler.ErrAuthenticationFailed) || !errors.Is(err, transport.failureErr) {
+		t.Fatalf(`Serve() returned error: %v; expected ErrAuthenticationFailed joined with failure error`, err)
+	}
+}
+
+func TestAuthenticate_PrematureSuccess(t *testing.T) {
+	client, err := sasler.ScramSha256Client("", "user", []byte("pencil"))
+	if err != nil {
+		t.Fatalf(`ScramSha256Client(...) returned error: %v`, err)
+	}
+	transport := newFakeClientTransport()
+	transport.toClient <- fakeMessage{kind: sasler.MessageSuccess}
+
+	err = sasler.Authenticate(context.Background(), client, transport)
+	if !errors.Is(err, sasler.ErrInvalidState) {
+		t.Fatalf(`Authenticate() returned error: %v; expected ErrInvalidState`, err)
+	}
+
+	if _, err := client.Data(nil); !errors.Is(err, sasler.ErrAborted) {
+		t.Fatalf(`Data(nil) returned error: %v; expected ErrAborted`, err)
+	}
+}
+
+// runExchange runs Authenticate and Serve against each other, and returns
+// their errors, and the mechanism name passed to Start.
+func runExchange(client sasler.ClientMech, server sasler.ServerMech) (error, error, string) {
+	clientTransport := newFakeClientTransport()
+	serverTransport := &fakeServerTransport{toClient: clientTransport.toClient, toServer: clientTransport.toServer}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- sasler.Serve(context.Background(), server, serverTransport)
+	}()
+	clientErr := sasler.Authenticate(context.Background(), client, clientTransport)
+	return clientErr, <-serverErr, clientTransport.mech
+}
+
+type fakeMessage struct {
+	kind sasler.MessageKind
+	data []byte
+}
+
+// fakeClientTransport is a ClientTransport that exchanges messages over
+// channels.
+type fakeClientTransport struct {
+	mech     string
+	abortErr error
+	toClient chan fakeMessage
+	toServer chan fakeMessage
+}
+
+func newFakeClientTransport() *fakeClientTransport {
+	return &fakeClientTransport{toClient: make(chan fakeMessage, 8), toServer: make(chan fakeMessage, 8)}
+}
+
+func (f *fakeClientTransport) Start(ctx context.Context, mech string, ir []byte) error {
+	f.mech = mech
+	if ir != nil {
+		f.toServer <- fakeMessage{kind: sasler.MessageData, data: ir}
+	}
+	return nil
+}
+
+func (f *fakeClientTransport) Send(ctx context.Context, data []byte) error {
+	f.toServer <- fakeMessage{kind: sasler.MessageData, data: data}
+	return nil
+}
+
+func (f *fakeClientTransport) Receive(ctx context.Context) (sasler.MessageKind, []byte, error) {
+	msg := <-f.toClient
+	return msg.kind, msg.data, nil
+}
+
+func (f *fakeClientTransport) Abort(ctx context.Context) error {
+	if f.abortErr != nil {
+		return f.abortErr
+	}
+	f.toServer <- fakeMessage{kind: sasler.MessageAbort}
+	<-f.toClient
+	return nil
+}
+
+// fakeServerTransport is a ServerTransport that exchanges messages over
+// channels.
+type fakeServerTransport struct {
+	failureErr error
+	toClient   chan fakeMessage
+	toServer   chan fakeMessage
+}
+
+func newFakeServerTransport() *fakeServerTransport {
+	return &fakeServerTransport{toClient: make(chan fakeMessage, 8), toServer: make(chan fakeMessage, 8)}
+}
+
+func (f *fakeServerTransport) Send(ctx context.Context, data []byte) error {
+	f.toClient <- fakeMessage{kind: sasler.MessageData, data: data}
+	return nil
+}
+
+func (f *fakeServerTransport) Receive(ctx context.Context) (sasler.MessageKind, []byte, error) {
+	msg := <-f.toServer
+	return msg.kind, msg.data, nil
+}
+
+func (f *fakeServerTransport) Success(ctx context.Context, data []byte) error {
+	f.toClient <- fakeMessage{kind: sasler.MessageSuccess, data: data}
+	return nil
+}
+
+func (f *fakeServerTransport) Failure(ctx context.Context, err error) error {
+	if f.failureErr != nil {
+		return f.failureErr
+	}
+	f.toClient <- fakeMessage{kind: sasler.MessageFailure}
+	return nil
+}